@@ -1,10 +1,14 @@
 package pq
 
 import (
+	"bytes"
+	"database/sql"
 	"database/sql/driver"
 	"fmt"
 	"github.com/gregb/pq/oid"
+	"net"
 	"reflect"
+	"time"
 	"unicode"
 )
 
@@ -43,9 +47,14 @@ func (c *arrayConverter) decode(s []byte) (interface{}, error) {
 		return nil, fmt.Errorf("Malformed array string: Should end with '}', but found %s instead", string(s[length-1]))
 	}
 
+	// a multi-dimensional array is an array of arrays
+	if length > 2 && s[1] == '{' {
+		return c.decodeNested(s)
+	}
+
 	// get the element type for this array type, and it's delimiter
 	elementTyp := c.ArrayTyp.ElementType()
-	delimiter := elementTyp.Delimiter()
+	delimiter := c.delimiter()
 
 	// states for the decoder
 	const (
@@ -59,6 +68,21 @@ func (c *arrayConverter) decode(s []byte) (interface{}, error) {
 	strings := make([][]byte, 0, 0)
 	current := make([]byte, 0, 0)
 
+	// an unquoted NULL is a null element, a quoted one is the string "NULL"
+	quoted := false
+	nulls := make([]bool, 0, 0)
+	hasNulls := false
+	endElement := func() {
+		isNull := !quoted && len(current) == 4 && bytes.EqualFold(current, []byte("NULL"))
+		if isNull {
+			hasNulls = true
+		}
+		strings = append(strings, current)
+		nulls = append(nulls, isNull)
+		current = make([]byte, 0, 0)
+		quoted = false
+	}
+
 	// loop through all chars except just-tested braces
 	for i := 0; i < length; i++ {
 		c := s[i]
@@ -77,25 +101,24 @@ func (c *arrayConverter) decode(s []byte) (interface{}, error) {
 				// starting a quoted element
 				// throw the quote away, but remember we are quoted
 				state = q_opened
+				quoted = true
 			case '}':
 				// array closer -- end of elements
 
 				// TODO: Find a better way...?
 				if length > 2 {
 					// avoids adding an element if the empty array is present
-					strings = append(strings, current)
+					endElement()
 				}
 
 				//log.Printf("Done with element <%s>. Strings = %v", string(current), strings)
 				//log.Printf("Done with array")
-				current = make([]byte, 0, 0)
 				state = done
 			case delimiter:
 				// an element just ended. record it
-				strings = append(strings, current)
+				endElement()
 
 				//log.Printf("Done with element <%s>. Strings = %v", string(current), strings)
-				current = make([]byte, 0, 0)
 				state = ready
 			default:
 				// any other char is the part of a non-quoted element; include it
@@ -134,22 +157,497 @@ func (c *arrayConverter) decode(s []byte) (interface{}, error) {
 	}
 
 	// determine the Go type of elements
-	goElementType := elementTyp.GoType()
+	goElementType := elementGoType(elementTyp)
+	if c.parameterStatus.decodesAsString(elementTyp) {
+		goElementType = stringType
+	}
 
-	// then make a slice of that
+	// then make a slice of that; if there are NULL elements, it has to be
+	// a slice of pointers so they can be told apart
 	sliceType := reflect.SliceOf(goElementType)
+	if hasNulls {
+		sliceType = reflect.SliceOf(reflect.PtrTo(goElementType))
+	}
 	elements := reflect.MakeSlice(sliceType, 0, len(strings))
 
 	// and populate it
-	for _, v := range strings {
-		// decode individually and add to slice
-		element := decode(c.parameterStatus, v, elementTyp)
-		elements = reflect.Append(elements, reflect.ValueOf(element))
+	for i, v := range strings {
+		if nulls[i] {
+			elements = reflect.Append(elements, reflect.Zero(sliceType.Elem()))
+			continue
+		}
+
+		// decode individually and add to slice; intervals are left as text
+		// by decode, so that they can be scanned into strings, but an
+		// interval array's elements are parsed
+		var element reflect.Value
+		if elementTyp == oid.T_interval {
+			iv, err := parseInterval(string(v))
+			if err != nil {
+				return nil, err
+			}
+			element = reflect.ValueOf(iv)
+		} else {
+			element = reflect.ValueOf(decode(c.parameterStatus, v, elementTyp))
+		}
+
+		// decode widens some types (e.g. all integers are int64), so
+		// narrow them back down to the element type of the slice
+		if element.Type() != goElementType {
+			if !element.Type().ConvertibleTo(goElementType) {
+				return nil, fmt.Errorf("cannot decode array element of type %s into %s", element.Type(), goElementType)
+			}
+			element = element.Convert(goElementType)
+		}
+		if hasNulls {
+			ptr := reflect.New(goElementType)
+			ptr.Elem().Set(element)
+			element = ptr
+		}
+		elements = reflect.Append(elements, element)
+	}
+
+	return elements.Interface(), nil
+}
+
+// decodeNested decodes a multi-dimensional array into a slice of the slices
+// its sub-arrays decode to, e.g. {{1,2},{3,4}} into [][]int64.
+func (c *arrayConverter) decodeNested(s []byte) (interface{}, error) {
+	var subs []reflect.Value
+	depth := 0
+	start := 0
+	quoted := false
+	for i := 1; i < len(s)-1; i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case quoted:
+		case s[i] == '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case s[i] == '}':
+			depth--
+			if depth == 0 {
+				sub, err := c.decode(s[start : i+1])
+				if err != nil {
+					return nil, err
+				}
+				subs = append(subs, reflect.ValueOf(sub))
+			}
+		}
+	}
+	if depth != 0 || quoted {
+		return nil, fmt.Errorf("Malformed array string: %s", s)
 	}
 
+	// sub-arrays with NULLs decode to slices of pointers; if only some do,
+	// the others are made slices of pointers too
+	subType := subs[0].Type()
+	for _, sub := range subs[1:] {
+		if _, ok := withPointers(sub, subType); !ok {
+			subType = sub.Type()
+		}
+	}
+	for i, sub := range subs {
+		var ok bool
+		if subs[i], ok = withPointers(sub, subType); !ok {
+			return nil, fmt.Errorf("Malformed array string: sub-arrays of different dimensions in %s", s)
+		}
+	}
+
+	elements := reflect.MakeSlice(reflect.SliceOf(subType), 0, len(subs))
+	elements = reflect.Append(elements, subs...)
 	return elements.Interface(), nil
 }
 
+// withPointers converts the decoded sub-array v to type t, which is either
+// v's own type, or that of the same slice with pointers to its elements, as
+// a sub-array with NULLs decodes to.  It reports false if it is neither.
+func withPointers(v reflect.Value, t reflect.Type) (reflect.Value, bool) {
+	if v.Type() == t {
+		return v, true
+	}
+	if v.Kind() != reflect.Slice || t.Kind() != reflect.Slice {
+		return v, false
+	}
+	result := reflect.MakeSlice(t, v.Len(), v.Len())
+	for i := 0; i < v.Len(); i++ {
+		e := v.Index(i)
+		if t.Elem() == reflect.PtrTo(e.Type()) {
+			ptr := reflect.New(e.Type())
+			ptr.Elem().Set(e)
+			result.Index(i).Set(ptr)
+			continue
+		}
+		sub, ok := withPointers(e, t.Elem())
+		if !ok {
+			return v, false
+		}
+		result.Index(i).Set(sub)
+	}
+	return result, true
+}
+
+// defaultArrayParameterStatus is used to decode and encode arrays outside of
+// a connection.  It assumes a server that understands hex bytea, and UTC
+// timestamps.
+var defaultArrayParameterStatus = parameterStatus{serverVersion: 90000}
+
+// DecodeArray parses the Postgres text representation of an array of type
+// arrayOID, such as {1,2,3} for oid.T__int8, into a slice of the Go type of
+// the array's elements.  Arrays containing NULL decode to a slice of
+// pointers, and multi-dimensional arrays to nested slices.
+func DecodeArray(b []byte, arrayOID oid.Oid) (_ interface{}, err error) {
+	if !arrayOID.IsArray() {
+		return nil, fmt.Errorf("pq: %d is not an array type", arrayOID)
+	}
+	defer errRecover(&err)
+	ps := defaultArrayParameterStatus
+	c := &arrayConverter{ArrayTyp: arrayOID, parameterStatus: &ps}
+	return c.decode(b)
+}
+
+// EncodeArray produces the Postgres text representation of slice, which
+// may be nested for a multi-dimensional array, as an array of type arrayOID.
+func EncodeArray(slice interface{}, arrayOID oid.Oid) (_ []byte, err error) {
+	if !arrayOID.IsArray() {
+		return nil, fmt.Errorf("pq: %d is not an array type", arrayOID)
+	}
+	defer errRecover(&err)
+	ps := defaultArrayParameterStatus
+	c := &arrayConverter{ArrayTyp: arrayOID, parameterStatus: &ps}
+	return c.encode(slice)
+}
+
+// Array returns a driver.Valuer and sql.Scanner for a, which must be a slice
+// or a pointer to a slice.  It is needed when scanning into slice types other
+// than the ones the driver decodes arrays into, such as named slice types
+// (type Tags []string) or slices of custom scalar types (type ID int64).
+//
+//	var ids []ID
+//	err := db.QueryRow("SELECT ids FROM t").Scan(pq.Array(&ids))
+func Array(a interface{}) interface {
+	driver.Valuer
+	sql.Scanner
+} {
+	return GenericArray{A: a}
+}
+
+// GenericArray implements the driver.Valuer and sql.Scanner interfaces for
+// a slice of any element type.
+type GenericArray struct {
+	A interface{}
+}
+
+// Scan implements the sql.Scanner interface.  A must be a pointer to a slice
+// whose element type the decoded elements can be assigned to, or converted
+// to without loss, such as a named type of the same kind or a wider number.
+// A NULL array sets the slice to nil, and an empty one, {}, to an empty
+// slice that isn't nil, so the two can be told apart.
+func (a GenericArray) Scan(src interface{}) error {
+	dv := reflect.ValueOf(a.A)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() || dv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("pq: cannot scan an array into %T; a pointer to a slice is required", a.A)
+	}
+	dv = dv.Elem()
+
+	if src == nil {
+		dv.Set(reflect.Zero(dv.Type()))
+		return nil
+	}
+
+	// arrays of types the driver doesn't know about reach us as raw text;
+	// parse those as text[] and let the conversion below sort out the rest
+	if b, ok := src.([]byte); ok {
+		var err error
+		ac := &arrayConverter{ArrayTyp: oid.T__text, parameterStatus: &parameterStatus{}}
+		if src, err = ac.decode(b); err != nil {
+			return err
+		}
+	}
+
+	sv := reflect.ValueOf(src)
+	if sv.Kind() != reflect.Slice {
+		return fmt.Errorf("pq: cannot scan %T into an array", src)
+	}
+
+	result := reflect.MakeSlice(dv.Type(), sv.Len(), sv.Len())
+	for i := 0; i < sv.Len(); i++ {
+		if err := scanArrayElement(result.Index(i), sv.Index(i)); err != nil {
+			return err
+		}
+	}
+
+	dv.Set(result)
+	return nil
+}
+
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+// scanArrayElement stores the decoded element ev into dest.  ev is a pointer
+// if the array had NULL elements, in which case nil means NULL, and the
+// slice of a sub-array for a multi-dimensional array.  A NULL can
+// only be stored in pointers and sql.Scanners, such as sql.NullString, and
+// in net.IP and net.IPNet, which take the elements of inet and cidr arrays.
+func scanArrayElement(dest, ev reflect.Value) error {
+	isNull := false
+	if ev.Kind() == reflect.Interface {
+		ev = ev.Elem()
+		isNull = !ev.IsValid()
+	}
+	if !isNull && ev.Kind() == reflect.Ptr && ev.Type().Elem() != dest.Type() {
+		isNull = ev.IsNil()
+		if !isNull {
+			ev = ev.Elem()
+		}
+	}
+
+	if reflect.PtrTo(dest.Type()).Implements(scannerType) {
+		var src interface{}
+		if !isNull {
+			src = ev.Interface()
+		}
+		return dest.Addr().Interface().(sql.Scanner).Scan(src)
+	}
+
+	if dest.Kind() == reflect.Ptr {
+		if isNull {
+			dest.Set(reflect.Zero(dest.Type()))
+			return nil
+		}
+		if ev.Type() != dest.Type() {
+			dest.Set(reflect.New(dest.Type().Elem()))
+			dest = dest.Elem()
+		}
+	}
+
+	// the text of an inet or cidr element is parsed as Inet would, which
+	// also stores a NULL
+	if t := dest.Type(); t == ipType || t == ipNetType {
+		var src interface{}
+		if !isNull {
+			src = ev.Interface()
+		}
+		return Inet(dest.Addr().Interface()).Scan(src)
+	}
+
+	switch {
+	case isNull:
+		return fmt.Errorf("pq: cannot scan NULL array element into %s", dest.Type())
+	case ev.Type().AssignableTo(dest.Type()):
+		dest.Set(ev)
+	case losslessConversion(ev.Type(), dest.Type()):
+		dest.Set(ev.Convert(dest.Type()))
+	case ev.Kind() == reflect.Slice && dest.Kind() == reflect.Slice:
+		// a sub-array of a multi-dimensional array
+		sub := reflect.MakeSlice(dest.Type(), ev.Len(), ev.Len())
+		for i := 0; i < ev.Len(); i++ {
+			if err := scanArrayElement(sub.Index(i), ev.Index(i)); err != nil {
+				return err
+			}
+		}
+		dest.Set(sub)
+	default:
+		return fmt.Errorf("pq: cannot scan array element of type %s into %s", ev.Type(), dest.Type())
+	}
+	return nil
+}
+
+// losslessConversion reports whether every value of type from converts to
+// type to and back unchanged: between types of the same kind, such as a
+// string and a named string type, or from a number to a wider one.  It rules
+// out the conversions Go allows that lose or change values, such as int64 to
+// string, which makes 65 "A", or float64 to int64.
+func losslessConversion(from, to reflect.Type) bool {
+	if !from.ConvertibleTo(to) {
+		return false
+	}
+	if from.Kind() == to.Kind() {
+		return true
+	}
+	fbits, tbits := from.Bits, to.Bits
+	switch {
+	case isSignedKind(from.Kind()) && isSignedKind(to.Kind()),
+		isUnsignedKind(from.Kind()) && isUnsignedKind(to.Kind()),
+		isFloatKind(from.Kind()) && isFloatKind(to.Kind()):
+		return tbits() >= fbits()
+	case isUnsignedKind(from.Kind()) && isSignedKind(to.Kind()):
+		return tbits() > fbits()
+	case (isSignedKind(from.Kind()) || isUnsignedKind(from.Kind())) && isFloatKind(to.Kind()):
+		// the mantissa of a float32 has 24 bits, that of a float64 53
+		mantissa := 24
+		if to.Kind() == reflect.Float64 {
+			mantissa = 53
+		}
+		return fbits() <= mantissa
+	}
+	return false
+}
+
+func isSignedKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isUnsignedKind(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uintptr
+}
+
+func isFloatKind(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+// Value implements the driver.Valuer interface.  The Postgres array type is
+// chosen from the Go element type; use an explicit cast in the query
+// ($1::int4[]) if the server should see something more specific.
+func (a GenericArray) Value() (driver.Value, error) {
+	val := reflect.ValueOf(a.A)
+	if val.Kind() == reflect.Ptr {
+		val = reflect.Indirect(val)
+	}
+	if val.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("pq: cannot use %T as an array; a slice is required", a.A)
+	}
+	if val.IsNil() {
+		return nil, nil
+	}
+
+	typ, ok := arrayTypeOf(val.Type().Elem())
+	if !ok {
+		return nil, fmt.Errorf("pq: cannot determine the array type of %s", val.Type())
+	}
+
+	ac := &arrayConverter{ArrayTyp: typ, parameterStatus: &parameterStatus{}}
+	return ac.encode(val.Interface())
+}
+
+// ByteaArray represents a one-dimensional bytea[].  A [][]byte passed to
+// Array could be either a bytea[] or a text[], and so is refused; ByteaArray
+// always encodes its elements as bytea, whatever type the server inferred
+// for the parameter.  nil elements are NULL.
+type ByteaArray [][]byte
+
+// Scan implements the sql.Scanner interface.  NULL elements are scanned as
+// nil.
+func (a *ByteaArray) Scan(src interface{}) error {
+	if b, ok := src.([]byte); ok {
+		var err error
+		ac := &arrayConverter{ArrayTyp: oid.T__bytea, parameterStatus: &parameterStatus{}}
+		if src, err = ac.decode(b); err != nil {
+			return err
+		}
+	}
+
+	switch v := src.(type) {
+	case nil:
+		*a = nil
+	case [][]byte:
+		// without NULLs, a nil element can only be an empty bytea
+		result := make(ByteaArray, len(v))
+		for i, e := range v {
+			result[i] = nonNilBytes(e)
+		}
+		*a = result
+	case []*[]byte:
+		result := make(ByteaArray, len(v))
+		for i, e := range v {
+			if e != nil {
+				result[i] = nonNilBytes(*e)
+			}
+		}
+		*a = result
+	default:
+		return fmt.Errorf("pq: cannot scan %T into a ByteaArray", src)
+	}
+	return nil
+}
+
+func nonNilBytes(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
+
+// Value implements the driver.Valuer interface.
+func (a ByteaArray) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+
+	elements := make([]*[]byte, len(a))
+	for i := range a {
+		if a[i] != nil {
+			elements[i] = &a[i]
+		}
+	}
+	ac := &arrayConverter{ArrayTyp: oid.T__bytea, parameterStatus: &parameterStatus{}}
+	return ac.encode(elements)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var stringType = reflect.TypeOf("")
+var intervalType = reflect.TypeOf(Interval{})
+var ipType = reflect.TypeOf(net.IP(nil))
+var ipNetType = reflect.TypeOf(net.IPNet{})
+
+// elementGoType is typ.GoType(), but also knows the types that decode to
+// types of this package, which the oid package can't refer to.
+func elementGoType(typ oid.Oid) reflect.Type {
+	if typ == oid.T_interval {
+		return intervalType
+	}
+	return typ.GoType()
+}
+
+// nullArrayTypes are the array types for slices of database/sql's nullable
+// types, whose NULLs become NULL elements.
+var nullArrayTypes = map[reflect.Type]oid.Oid{
+	reflect.TypeOf(sql.NullBool{}):    oid.T__bool,
+	reflect.TypeOf(sql.NullInt64{}):   oid.T__int8,
+	reflect.TypeOf(sql.NullFloat64{}): oid.T__float8,
+	reflect.TypeOf(sql.NullString{}):  oid.T__text,
+}
+
+// arrayTypeOf picks the Postgres array type best suited for a slice of
+// elements of Go type t.
+func arrayTypeOf(t reflect.Type) (oid.Oid, bool) {
+	if t == timeType {
+		return oid.T__timestamptz, true
+	}
+	if t == intervalType {
+		return oid.T__interval, true
+	}
+	if t == ipType || t == ipNetType {
+		return oid.T__inet, true
+	}
+	if typ, ok := nullArrayTypes[t]; ok {
+		return typ, true
+	}
+	if t.Kind() == reflect.Ptr {
+		return arrayTypeOf(t.Elem())
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return oid.T__bool, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return oid.T__int8, true
+	case reflect.Float32:
+		return oid.T__float4, true
+	case reflect.Float64:
+		return oid.T__float8, true
+	case reflect.String:
+		return oid.T__text, true
+	}
+
+	return 0, false
+}
+
 func (c *arrayConverter) encode(sliceAsIface interface{}) ([]byte, error) {
 	val := reflect.ValueOf(sliceAsIface)
 
@@ -161,32 +659,93 @@ func (c *arrayConverter) encode(sliceAsIface interface{}) ([]byte, error) {
 		return nil, fmt.Errorf("arrayConverter.ConvertValue expects a slice parameter; received %v instead", val.Kind())
 	}
 
-	length := val.Len()
+	bytes, _, err := c.appendArray(nil, val)
+	return bytes, err
+}
 
-	// Dumb guess; underestimate at 2 braces plus 3 chars per element
-	strLenEstimate := 2 + length*3
-	bytes := make([]byte, 0, strLenEstimate)
+// sliceDepth is the number of slice levels in t, e.g. 2 for [][]int64.
+func sliceDepth(t reflect.Type) int {
+	depth := 0
+	for t.Kind() == reflect.Slice {
+		depth++
+		t = t.Elem()
+	}
+	return depth
+}
 
-	bytes = append(bytes, '{')
+// appendArray appends the array text for the slice val to bytes.  Slices
+// nested deeper than the element type are the sub-arrays of a
+// multi-dimensional array.  Postgres requires these to be rectangular, so
+// appendArray returns the dimensions of val to check them against its
+// siblings'.
+func (c *arrayConverter) appendArray(bytes []byte, val reflect.Value) ([]byte, []int, error) {
+	length := val.Len()
 
 	elementType := c.ArrayTyp.ElementType()
-	delimiter := elementType.Delimiter()
+	delimiter := c.delimiter()
+
+	nested := sliceDepth(val.Type().Elem()) > sliceDepth(elementType.GoType())
+	var subDims []int
+
+	bytes = append(bytes, '{')
 
 	var elementBytes []byte
 
 	// append items
 	for i := 0; i < length; i++ {
+		if i > 0 {
+			bytes = append(bytes, delimiter)
+		}
+
+		if nested {
+			var dims []int
+			var err error
+			bytes, dims, err = c.appendArray(bytes, val.Index(i))
+			if err != nil {
+				return nil, nil, err
+			}
+			if i > 0 && !reflect.DeepEqual(dims, subDims) {
+				return nil, nil, fmt.Errorf("pq: multi-dimensional array has sub-arrays of different sizes %v and %v", subDims, dims)
+			}
+			subDims = dims
+			continue
+		}
+
 		element := val.Index(i).Interface()
 
+		// net.IP is a []byte, which would otherwise be taken for a bytea
+		if v, ok, err := inetValue(element); ok {
+			if err != nil {
+				return nil, nil, err
+			}
+			element = v
+		}
+
+		// normalize named types (type ID int64) to the basic ones encode
+		// knows; geometric types are encoded from their []float64 form
+		if _, ok := element.([]float64); !ok {
+			var err error
+			element, err = driver.DefaultParameterConverter.ConvertValue(element)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		// nil pointers, nil []byte and invalid sql.NullXxx values are NULL
+		// elements
+		if isNull(element) {
+			bytes = append(bytes, "NULL"...)
+			continue
+		}
+
 		// have to treat certain strings specially...
 		if elementType.Category() == oid.C_string {
 			elementBytes = encodeArrayString(element.(string), rune(delimiter))
 		} else {
+			// other types can contain special characters too, such as the
+			// comma in a point
 			elementBytes = encode(c.parameterStatus, element, elementType)
-		}
-
-		if i > 0 {
-			bytes = append(bytes, delimiter)
+			elementBytes = encodeArrayString(string(elementBytes), rune(delimiter))
 		}
 
 		bytes = append(bytes, elementBytes...)
@@ -194,12 +753,28 @@ func (c *arrayConverter) encode(sliceAsIface interface{}) ([]byte, error) {
 
 	bytes = append(bytes, '}')
 
-	return bytes, nil
+	return bytes, append([]int{length}, subDims...), nil
+}
+
+// delimiter returns the character separating the elements of the array.
+// Postgres stores it (typdelim) with the element type, which is what makes
+// box[] use ';': its elements contain commas.  Note that this is the element
+// of the array type, not the type a box itself can be subscripted into
+// (point), whose delimiter would be ','.
+func (c *arrayConverter) delimiter() byte {
+	return c.ArrayTyp.ElementType().Delimiter()
 }
 
 // Implements driver.ValueConverter: ConvertValue(v interface{}) (Value, error)
 func (c *arrayConverter) ConvertValue(sliceAsIface interface{}) (driver.Value, error) {
 
+	// values from a driver.Valuer, such as Array, are already in array
+	// text form
+	switch v := sliceAsIface.(type) {
+	case nil, []byte, string:
+		return v, nil
+	}
+
 	bytes, err := c.encode(sliceAsIface)
 
 	if err != nil {
@@ -234,7 +809,7 @@ func encodeArrayString(s string, delimiter rune) []byte {
 	} else {
 		// else check internally
 		for _, r := range runes {
-			if r == '"' || r == '\\' || r == delimiter {
+			if r == '"' || r == '\\' || r == '{' || r == '}' || r == delimiter {
 				needsEscaping = true
 				break
 			}
@@ -255,7 +830,7 @@ func encodeArrayString(s string, delimiter rune) []byte {
 		if r == '"' || r == '\\' {
 			modified = append(modified, '\\')
 		}
-		modified = append(modified, byte(r))
+		modified = append(modified, string(r)...)
 	}
 
 	modified = append(modified, '"')
@@ -1,8 +1,11 @@
 package pq
 
 import (
+	"database/sql"
 	"github.com/gregb/pq/oid"
+	"reflect"
 	"testing"
+	"time"
 )
 
 // Does not access database, simply tests the parser
@@ -255,3 +258,727 @@ func TestFloatArrayRoundtrip(t *testing.T) {
 		}
 	}
 }
+
+type Tags []string
+type ID int64
+
+// Does not access database, simply tests the reflection
+func TestGenericArrayScan(t *testing.T) {
+	var tags Tags
+	err := Array(&tags).Scan([]string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, Tags{"a", "b", "c"}) {
+		t.Errorf("Expected %v, got %v", Tags{"a", "b", "c"}, tags)
+	}
+
+	var ids []ID
+	err = Array(&ids).Scan([]int64{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []ID{1, 2, 3}) {
+		t.Errorf("Expected %v, got %v", []ID{1, 2, 3}, ids)
+	}
+
+	// raw array text is parsed as text[]
+	err = Array(&tags).Scan([]byte(`{x,"y z"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, Tags{"x", "y z"}) {
+		t.Errorf("Expected %v, got %v", Tags{"x", "y z"}, tags)
+	}
+
+	var times []time.Time
+	err = Array(&times).Scan([]int64{1})
+	if err == nil {
+		t.Error("Expected an error scanning []int64 into []time.Time")
+	}
+
+	err = Array(tags).Scan([]string{"a"})
+	if err == nil {
+		t.Error("Expected an error scanning into a non-pointer")
+	}
+
+	// numbers are only widened
+	var wide []float64
+	err = Array(&wide).Scan([]int32{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(wide, []float64{1, 2}) {
+		t.Errorf("Expected %v, got %v", []float64{1, 2}, wide)
+	}
+	var narrow []int32
+	if err := Array(&narrow).Scan([]int64{1}); err == nil {
+		t.Error("Expected an error scanning []int64 into []int32")
+	}
+	var ints []int64
+	if err := Array(&ints).Scan([]float64{1.5}); err == nil {
+		t.Error("Expected an error scanning []float64 into []int64")
+	}
+	var strs []string
+	if err := Array(&strs).Scan([]int64{65}); err == nil {
+		t.Error("Expected an error scanning []int64 into []string")
+	}
+}
+
+func TestGenericArrayScanNull(t *testing.T) {
+	ids := []int64{1}
+	if err := Array(&ids).Scan(nil); err != nil {
+		t.Fatal(err)
+	}
+	if ids != nil {
+		t.Errorf("Expected a nil slice for NULL, got %#v", ids)
+	}
+
+	iface, err := DecodeArray([]byte(`{}`), oid.T__int4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Array(&ids).Scan(iface); err != nil {
+		t.Fatal(err)
+	}
+	if ids == nil || len(ids) != 0 {
+		t.Errorf("Expected an empty, non-nil slice for {}, got %#v", ids)
+	}
+}
+
+func TestNullAndEmptyArrayFromDb(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	null, empty := []int64{1}, []int64(nil)
+	err := db.QueryRow("SELECT NULL::int[], '{}'::int[]").Scan(Array(&null), Array(&empty))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if null != nil {
+		t.Errorf("Expected a nil slice for NULL, got %#v", null)
+	}
+	if empty == nil || len(empty) != 0 {
+		t.Errorf("Expected an empty, non-nil slice for {}, got %#v", empty)
+	}
+}
+
+func TestGenericArrayValue(t *testing.T) {
+	tests := []struct {
+		in       interface{}
+		expected string
+	}{
+		{Tags{"a", "b,c"}, `{a,"b,c"}`},
+		{[]ID{1, 2, 3}, `{1,2,3}`},
+		{&[]bool{true, false}, `{true,false}`},
+		{[]float64{1.5}, `{1.5}`},
+	}
+
+	for _, tt := range tests {
+		v, err := Array(tt.in).Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(v.([]byte)) != tt.expected {
+			t.Errorf("Expected %s, got %s", tt.expected, v)
+		}
+	}
+
+	v, err := Array([]string(nil)).Value()
+	if err != nil || v != nil {
+		t.Errorf("Expected a nil slice to be NULL, got %v (%v)", v, err)
+	}
+}
+
+// Does not access database, simply tests the encoder and parser
+func TestByteaArray(t *testing.T) {
+	in := ByteaArray{{0, '"', '\\', 0xff}, nil, {}}
+	v, err := in.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out ByteaArray
+	if err := out.Scan(v); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("Expected %v, got %v", in, out)
+	}
+
+	if err := out.Scan([][]byte{{1}}); err != nil || !reflect.DeepEqual(out, ByteaArray{{1}}) {
+		t.Errorf("Expected %v, got %v (%v)", ByteaArray{{1}}, out, err)
+	}
+	if err := out.Scan([]string{"a"}); err == nil {
+		t.Error("Expected an error scanning a text[] into a ByteaArray")
+	}
+	if err := out.Scan(nil); err != nil || out != nil {
+		t.Errorf("Expected NULL to scan as nil, got %v (%v)", out, err)
+	}
+
+	v, err = ByteaArray(nil).Value()
+	if err != nil || v != nil {
+		t.Errorf("Expected a nil ByteaArray to be NULL, got %v (%v)", v, err)
+	}
+}
+
+func TestByteaArrayRoundtrip(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	in := ByteaArray{[]byte("\\x00"), nil, {0, 1, 2}}
+	var out ByteaArray
+	err := db.QueryRow("SELECT $1::bytea[]", in).Scan(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("Expected %v, got %v", in, out)
+	}
+}
+
+func TestScanNamedSliceFromDb(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	var tags Tags
+	err := db.QueryRow(`SELECT '{go,postgres,"with space"}'::text[]`).Scan(Array(&tags))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Tags{"go", "postgres", "with space"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("Expected %v, got %v", expected, tags)
+	}
+
+	var ids []ID
+	err = db.QueryRow(`SELECT '{4,5,6}'::int4[]`).Scan(Array(&ids))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []ID{4, 5, 6}) {
+		t.Errorf("Expected %v, got %v", []ID{4, 5, 6}, ids)
+	}
+}
+
+// Does not access database, simply tests the parser
+func TestDecodeBoxArray(t *testing.T) {
+	if d := oid.T_box.Delimiter(); d != ';' {
+		t.Fatalf("Expected box elements to be delimited by ';', got %q", d)
+	}
+
+	ac := arrayConverter{ArrayTyp: oid.T__box}
+	iface, err := ac.decode([]byte(`{(3,4),(1,2);(5.5,6),(-7,8)}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]float64{{3, 4, 1, 2}, {5.5, 6, -7, 8}}
+	if !reflect.DeepEqual(iface, expected) {
+		t.Errorf("Expected %v, got %v", expected, iface)
+	}
+
+	b, err := ac.encode(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{(3,4),(1,2);(5.5,6),(-7,8)}` {
+		t.Errorf("Unexpected encoding of box[]: %s", b)
+	}
+}
+
+func TestEncodeArraySpecialCharacters(t *testing.T) {
+	ac := arrayConverter{ArrayTyp: oid.T__point}
+	b, err := ac.encode([][]float64{{1, 2}, {3, 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"(1,2)","(3,4)"}` {
+		t.Errorf("Unexpected encoding of point[]: %s", b)
+	}
+
+	ac = arrayConverter{ArrayTyp: oid.T__text}
+	b, err = ac.encode([]string{"{braces}", `"€"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"{braces}","\"€\""}` {
+		t.Errorf("Unexpected encoding of text[]: %s", b)
+	}
+}
+
+func TestEncodeGeometryLength(t *testing.T) {
+	for _, typ := range []oid.Oid{oid.T_point, oid.T_lseg, oid.T_box, oid.T_line, oid.T_circle, oid.T_path} {
+		if _, err := encodeGeometry([]float64{1}, typ); err == nil {
+			t.Errorf("type %d: expected an error for too few coordinates", typ)
+		}
+	}
+}
+
+func TestBoxArrayRoundtrip(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	// boxes are stored as (upper right),(lower left), so keep the input in
+	// that form to get the same values back
+	expectedArray := [][]float64{{3, 4, 1, 2}, {5.5, 8, -7, 6}}
+
+	var gotArray [][]float64
+	err := db.QueryRow("SELECT $1::box[]", expectedArray).Scan(&gotArray)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(gotArray, expectedArray) {
+		t.Errorf("Expected %v, got %v", expectedArray, gotArray)
+	}
+}
+
+// Does not access database, simply tests the encoder and parser
+func TestArrayNullElements(t *testing.T) {
+	ptr := func(s string) *string { return &s }
+
+	ac := arrayConverter{ArrayTyp: oid.T__text}
+	b, err := ac.encode([]*string{ptr("a"), nil, ptr("c"), ptr("NULL")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{a,NULL,c,"NULL"}` {
+		t.Fatalf("Unexpected encoding of text[] with NULLs: %s", b)
+	}
+
+	iface, err := ac.decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := iface.([]*string)
+	if !ok {
+		t.Fatalf("Expected []*string, got %T", iface)
+	}
+	if len(got) != 4 || *got[0] != "a" || got[1] != nil || *got[2] != "c" || *got[3] != "NULL" {
+		t.Errorf("Unexpected decoding of %s: %v", b, got)
+	}
+
+	var strs []*string
+	if err := Array(&strs).Scan(iface); err != nil {
+		t.Fatal(err)
+	}
+	if len(strs) != 4 || *strs[0] != "a" || strs[1] != nil {
+		t.Errorf("Unexpected scan result %v", strs)
+	}
+
+	var nulls []sql.NullString
+	if err := Array(&nulls).Scan(iface); err != nil {
+		t.Fatal(err)
+	}
+	expected := []sql.NullString{{String: "a", Valid: true}, {}, {String: "c", Valid: true}, {String: "NULL", Valid: true}}
+	if !reflect.DeepEqual(nulls, expected) {
+		t.Errorf("Expected %v, got %v", expected, nulls)
+	}
+
+	var plain []string
+	if err := Array(&plain).Scan(iface); err == nil {
+		t.Error("Expected an error scanning a NULL element into []string")
+	}
+
+	b, err = ac.encode([]sql.NullString{{String: "x", Valid: true}, {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{x,NULL}` {
+		t.Errorf("Unexpected encoding of []sql.NullString: %s", b)
+	}
+}
+
+func TestArrayNullRoundtrip(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	a, c := "a", "c"
+	var got []*string
+	err := db.QueryRow("SELECT $1::text[]", []*string{&a, nil, &c}).Scan(Array(&got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0] == nil || *got[0] != "a" || got[1] != nil || got[2] == nil || *got[2] != "c" {
+		t.Errorf("Unexpected result %v", got)
+	}
+}
+
+// Does not access database, simply tests the parser
+func TestMultiDimensionalArrayNullElements(t *testing.T) {
+	ac := arrayConverter{ArrayTyp: oid.T__int8}
+	iface, err := ac.decode([]byte(`{{1,2},{NULL,4}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := iface.([][]*int64)
+	if !ok {
+		t.Fatalf("Expected [][]*int64, got %T", iface)
+	}
+	if len(got) != 2 || *got[0][0] != 1 || *got[0][1] != 2 || got[1][0] != nil || *got[1][1] != 4 {
+		t.Errorf("Unexpected decoding: %v", got)
+	}
+
+	var nulls [][]sql.NullInt64
+	if err := Array(&nulls).Scan(iface); err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]sql.NullInt64{{{Int64: 1, Valid: true}, {Int64: 2, Valid: true}}, {{}, {Int64: 4, Valid: true}}}
+	if !reflect.DeepEqual(nulls, expected) {
+		t.Errorf("Expected %v, got %v", expected, nulls)
+	}
+
+	if _, err := ac.decode([]byte(`{{1,2},{{3,4}}}`)); err == nil {
+		t.Error("Expected an error for sub-arrays of different dimensions")
+	}
+}
+
+func TestArrayNullElementsFromDb(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	// without NULLs, arrays scan straight into plain slices
+	var plain []int64
+	if err := db.QueryRow("SELECT '{1,2}'::int8[]").Scan(&plain); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(plain, []int64{1, 2}) {
+		t.Errorf("Expected [1 2], got %v", plain)
+	}
+
+	// with them, they can't
+	if err := db.QueryRow("SELECT '{1,NULL}'::int8[]").Scan(&plain); err == nil {
+		t.Error("Expected an error scanning a NULL element into []int64")
+	}
+
+	var ptrs []*int64
+	var nulls []sql.NullInt64
+	err := db.QueryRow("SELECT '{1,NULL}'::int8[], '{1,NULL}'::int8[]").Scan(Array(&ptrs), Array(&nulls))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ptrs) != 2 || ptrs[0] == nil || *ptrs[0] != 1 || ptrs[1] != nil {
+		t.Errorf("Unexpected result %v", ptrs)
+	}
+	if expected := []sql.NullInt64{{Int64: 1, Valid: true}, {}}; !reflect.DeepEqual(nulls, expected) {
+		t.Errorf("Expected %v, got %v", expected, nulls)
+	}
+}
+
+// Does not access database, simply tests the parser and encoder
+func TestNullBoolArray(t *testing.T) {
+	ac := arrayConverter{ArrayTyp: oid.T__bool}
+	iface, err := ac.decode([]byte(`{t,NULL,f}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var flags []sql.NullBool
+	if err := Array(&flags).Scan(iface); err != nil {
+		t.Fatal(err)
+	}
+	expected := []sql.NullBool{{Bool: true, Valid: true}, {}, {Bool: false, Valid: true}}
+	if !reflect.DeepEqual(flags, expected) {
+		t.Errorf("Expected %v, got %v", expected, flags)
+	}
+
+	v, err := Array(flags).Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v.([]byte)) != `{true,NULL,false}` {
+		t.Errorf("Unexpected encoding of []sql.NullBool: %s", v)
+	}
+
+	// as a parameter, the encoded value is passed through as is
+	cv, err := ac.ConvertValue(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cv, v) {
+		t.Errorf("Expected %s to be passed through, got %v", v, cv)
+	}
+}
+
+func TestNullBoolArrayRoundtrip(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	expected := []sql.NullBool{{Bool: true, Valid: true}, {}, {Bool: false, Valid: true}}
+	var flags []sql.NullBool
+	err := db.QueryRow("SELECT $1::bool[]", Array(expected)).Scan(Array(&flags))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flags, expected) {
+		t.Errorf("Expected %v, got %v", expected, flags)
+	}
+
+	err = db.QueryRow(`SELECT '{t,NULL,f}'::bool[]`).Scan(Array(&flags))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flags, expected) {
+		t.Errorf("Expected %v, got %v", expected, flags)
+	}
+}
+
+// Does not access database, simply tests the encoder and parser
+func TestMultiDimensionalArray(t *testing.T) {
+	ac := arrayConverter{ArrayTyp: oid.T__int8}
+	b, err := ac.encode([][]int64{{1, 2, 3}, {4, 5, 6}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{{1,2,3},{4,5,6}}` {
+		t.Errorf("Unexpected encoding of [][]int64: %s", b)
+	}
+
+	iface, err := ac.decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(iface, [][]int64{{1, 2, 3}, {4, 5, 6}}) {
+		t.Errorf("Unexpected decoding of %s: %v", b, iface)
+	}
+
+	_, err = ac.encode([][]int64{{1, 2}, {3}})
+	if err == nil {
+		t.Error("Expected an error encoding a ragged array")
+	}
+	_, err = ac.encode([][][]int64{{{1}, {2}}, {{3, 4}, {5, 6}}})
+	if err == nil {
+		t.Error("Expected an error encoding a ragged 3-dimensional array")
+	}
+
+	// braces and delimiters in quoted elements don't start sub-arrays
+	ac = arrayConverter{ArrayTyp: oid.T__text}
+	expected := [][]string{{"{a}", `b"}`}, {"c,d", ""}}
+	b, err = ac.encode(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface, err = ac.decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(iface, expected) {
+		t.Errorf("Expected %v, got %v from %s", expected, iface, b)
+	}
+
+	// box[] elements are []float64 already, so [][]float64 isn't nested
+	ac = arrayConverter{ArrayTyp: oid.T__box}
+	b, err = ac.encode([][][]float64{{{3, 4, 1, 2}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{{(3,4),(1,2)}}` {
+		t.Errorf("Unexpected encoding of a 2-dimensional box[]: %s", b)
+	}
+}
+
+func TestMultiDimensionalArrayRoundtrip(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	expectedArray := [][]int64{{1, 2, 3}, {4, 5, 6}}
+
+	var gotArray [][]int64
+	err := db.QueryRow("SELECT $1::int8[][]", expectedArray).Scan(&gotArray)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(gotArray, expectedArray) {
+		t.Errorf("Expected %v, got %v", expectedArray, gotArray)
+	}
+}
+
+func TestDecodeEncodeArray(t *testing.T) {
+	iface, err := DecodeArray([]byte(`{1,NULL,3}`), oid.T__int4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := iface.([]*int32)
+	if len(got) != 3 || *got[0] != 1 || got[1] != nil || *got[2] != 3 {
+		t.Errorf("Unexpected decoding: %v", got)
+	}
+
+	b, err := EncodeArray([][]string{{"a", "b c"}, {`"`, "NULL"}}, oid.T__text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{{a,b c},{"\"","NULL"}}` {
+		t.Errorf("Unexpected encoding: %s", b)
+	}
+
+	b, err = EncodeArray([][]byte{{0, 255}}, oid.T__bytea)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"\\x00ff"}` {
+		t.Errorf("Unexpected encoding of bytea[]: %s", b)
+	}
+
+	if _, err := DecodeArray([]byte(`{1}`), oid.T_int4); err == nil {
+		t.Error("Expected an error decoding with a non-array type")
+	}
+	if _, err := EncodeArray(1, oid.T__int4); err == nil {
+		t.Error("Expected an error encoding a non-slice")
+	}
+	if _, err := DecodeArray([]byte(`{x}`), oid.T__int4); err == nil {
+		t.Error("Expected an error decoding a malformed int4[]")
+	}
+}
+
+// Does not access database, simply tests the parser
+func TestDecodeCatalogArrays(t *testing.T) {
+	iface, err := DecodeArray([]byte(`{i,o,b,"\\200","\\",""}`), oid.T__char)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"i", "o", "b", "\x80", `\`, ""}
+	if got, ok := iface.([]string); !ok || !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %q, got %#v", expected, iface)
+	}
+
+	iface, err = DecodeArray([]byte(`{pg_catalog,public,"Tenant A"}`), oid.T__name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"pg_catalog", "public", "Tenant A"}
+	if got, ok := iface.([]string); !ok || !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %q, got %#v", expected, iface)
+	}
+}
+
+// Does not access database, simply tests the parser
+func TestDecodeNumericArrays(t *testing.T) {
+	iface, err := DecodeArray([]byte(`{1.10,-123456789012345678901234567890.000000001,NaN}`), oid.T__numeric)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"1.10", "-123456789012345678901234567890.000000001", "NaN"}
+	if got, ok := iface.([]string); !ok || !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %q, got %#v", expected, iface)
+	}
+
+	// money elements with thousands separators are quoted, so their commas
+	// aren't taken for delimiters
+	iface, err = DecodeArray([]byte(`{"$1,000.50",$2.00,NULL,"-$12,345,678.90"}`), oid.T__money)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := func(s string) *string { return &s }
+	expectedPtrs := []*string{s("$1,000.50"), s("$2.00"), nil, s("-$12,345,678.90")}
+	if got, ok := iface.([]*string); !ok || !reflect.DeepEqual(got, expectedPtrs) {
+		t.Errorf("Expected %v, got %#v", expectedPtrs, iface)
+	}
+}
+
+func TestNumericArrayRoundtrip(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	in := []string{"1.10", "-123456789012345678901234567890.000000001", "NaN"}
+	var out []string
+	if err := db.QueryRow("SELECT $1::numeric[]", in).Scan(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("Expected %q, got %q", in, out)
+	}
+
+	// money is written the way lc_monetary says, so compare with the
+	// server's own text of each element
+	var money []string
+	var first, second string
+	err := db.QueryRow(`SELECT a, a[1]::text, a[2]::text
+		FROM (SELECT ARRAY[1000.5, -12345678.9]::money[] AS a) m`).Scan(&money, &first, &second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{first, second}; !reflect.DeepEqual(money, expected) {
+		t.Errorf("Expected %q, got %q", expected, money)
+	}
+	if err := db.QueryRow("SELECT $1::money[]", money).Scan(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, money) {
+		t.Errorf("Expected %q back, got %q", money, out)
+	}
+}
+
+func TestCatalogArraysFromDb(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	var modes []string
+	err := db.QueryRow(`SELECT proargmodes FROM pg_catalog.pg_proc
+		WHERE proname = 'pg_stat_get_activity' AND proargmodes IS NOT NULL LIMIT 1`).Scan(&modes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modes) == 0 || modes[0] != "i" || modes[len(modes)-1] != "o" {
+		t.Errorf("Unexpected proargmodes %q", modes)
+	}
+
+	var schemas []string
+	err = db.QueryRow("SELECT current_schemas(true)").Scan(&schemas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schemas) == 0 || schemas[0] != "pg_catalog" {
+		t.Errorf("Unexpected schemas %q", schemas)
+	}
+
+	var names []string
+	err = db.QueryRow(`SELECT ARRAY['a', 'Tenant A', 'x,y']::name[]`).Scan(&names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"a", "Tenant A", "x,y"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %q, got %q", expected, names)
+	}
+}
+
+// Does not access database, simply tests the parser
+func TestDecodeJsonbArray(t *testing.T) {
+	// braces, brackets, commas and quotes inside the JSON are quoted, so
+	// they must not be taken for the array's own
+	for _, typ := range []oid.Oid{oid.T__jsonb, oid.T__json} {
+		iface, err := DecodeArray([]byte(`{"{\"a\": [1, 2], \"b\": \"x,}\\\"{\"}","[{\"c\": null}]",3,NULL}`), typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := iface.([]*string)
+		if len(got) != 4 || *got[0] != `{"a": [1, 2], "b": "x,}\"{"}` || *got[1] != `[{"c": null}]` || *got[2] != "3" || got[3] != nil {
+			texts := make([]string, len(got))
+			for i, p := range got {
+				texts[i] = "NULL"
+				if p != nil {
+					texts[i] = *p
+				}
+			}
+			t.Errorf("Unexpected decoding of %v: %q", typ, texts)
+		}
+	}
+}
+
+func TestJsonbArrayRoundtrip(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	expected := []string{`{"a": [1, 2], "b": "x,}\"{"}`, `[{"c": null}]`, `"s"`, `3`}
+
+	var got []string
+	err := db.QueryRow("SELECT $1::jsonb[]", Array(expected)).Scan(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"database/sql"
 	"database/sql/driver"
+	"fmt"
 	"github.com/gregb/pq/oid"
 	"io"
 	"net"
@@ -292,6 +293,49 @@ func BenchmarkEncodeFloat64(b *testing.B) {
 	}
 }
 
+func BenchmarkEncodeInt64Binary(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		encodeBinary(int64(1234), oid.T_int8)
+	}
+}
+
+func BenchmarkEncodeFloat64Binary(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		encodeBinary(3.14159, oid.T_float8)
+	}
+}
+
+// BenchmarkInsertParameters inserts rows of numbers with their parameters
+// sent as text, and in binary with binary_parameters.
+func BenchmarkInsertParameters(b *testing.B) {
+	for _, binary := range []bool{false, true} {
+		b.Run(fmt.Sprintf("binary_parameters=%t", binary), func(b *testing.B) {
+			db, err := openTestConnConninfo(fmt.Sprintf("user=pqgotest password=pqgotest binary_parameters=%t", binary))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+
+			if _, err := db.Exec("CREATE TEMP TABLE bench_numbers (a int8, b float8, c int4, d bool)"); err != nil {
+				b.Fatal(err)
+			}
+			stmt, err := db.Prepare("INSERT INTO bench_numbers VALUES ($1, $2, $3, $4)")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer stmt.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := stmt.Exec(int64(i)*1e12, float64(i)/3, i, i%2 == 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 var testByteString = []byte("abcdefghijklmnopqrstuvwxyz")
 
 func BenchmarkEncodeByteaHex(b *testing.B) {
@@ -0,0 +1,75 @@
+package pq
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// bigIntValue returns the decimal text of a big.Int or *big.Int parameter,
+// which the server takes as a numeric, or as an int8 where it fits.  ok is
+// false for values of other types.
+func bigIntValue(x interface{}) (v driver.Value, ok bool, err error) {
+	switch x := x.(type) {
+	case *big.Int:
+		if x == nil {
+			return nil, true, nil
+		}
+		return x.String(), true, nil
+	case big.Int:
+		return x.String(), true, nil
+	}
+	return nil, false, nil
+}
+
+// BigInt returns a sql.Scanner for scanning an integral numeric, or any
+// integer, into dest.  NULL sets *dest to nil; otherwise *dest is set to a
+// new big.Int.  A numeric with a fraction other than zeros can't be
+// scanned.
+//
+//	var n *big.Int
+//	err := db.QueryRow("SELECT total FROM counters WHERE id = $1", id).Scan(pq.BigInt(&n))
+//
+// big.Int and *big.Int values are passed as parameters as they are.
+func BigInt(dest **big.Int) sql.Scanner {
+	return bigIntScanner{dest}
+}
+
+type bigIntScanner struct {
+	dest **big.Int
+}
+
+// Scan implements the sql.Scanner interface.
+func (s bigIntScanner) Scan(src interface{}) error {
+	var text string
+	switch src := src.(type) {
+	case []byte:
+		text = string(src)
+	case string:
+		text = src
+	case int64:
+		*s.dest = big.NewInt(src)
+		return nil
+	case nil:
+		*s.dest = nil
+		return nil
+	default:
+		return fmt.Errorf("pq: cannot convert %T to a big.Int", src)
+	}
+
+	// a numeric's scale can leave zeros after the point
+	if i := strings.IndexByte(text, '.'); i >= 0 {
+		if strings.Trim(text[i+1:], "0") != "" {
+			return fmt.Errorf("pq: cannot scan %s, which is not an integer, into a big.Int", text)
+		}
+		text = text[:i]
+	}
+	n, ok := new(big.Int).SetString(text, 10)
+	if !ok {
+		return fmt.Errorf("pq: cannot scan %q into a big.Int", text)
+	}
+	*s.dest = n
+	return nil
+}
@@ -0,0 +1,89 @@
+package pq
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestBigIntValue(t *testing.T) {
+	n, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	tests := []struct {
+		in       interface{}
+		expected interface{}
+	}{
+		{n, "-123456789012345678901234567890"},
+		{*big.NewInt(42), "42"},
+		{(*big.Int)(nil), nil},
+	}
+	for _, tt := range tests {
+		v, ok, err := bigIntValue(tt.in)
+		if !ok || err != nil {
+			t.Errorf("%v: unexpected result %v, %v", tt.in, ok, err)
+			continue
+		}
+		if v != tt.expected {
+			t.Errorf("%v: expected %v, got %v", tt.in, tt.expected, v)
+		}
+	}
+	if _, ok, _ := bigIntValue(int64(1)); ok {
+		t.Error("expected int64 not to be a big.Int")
+	}
+}
+
+func TestBigIntScan(t *testing.T) {
+	tests := []struct {
+		src      interface{}
+		expected string
+	}{
+		{[]byte("123456789012345678901234567890"), "123456789012345678901234567890"},
+		{"-42", "-42"},
+		{[]byte("1000.000"), "1000"},
+		{int64(-7), "-7"},
+	}
+	for _, tt := range tests {
+		var n *big.Int
+		if err := BigInt(&n).Scan(tt.src); err != nil {
+			t.Errorf("%v: %v", tt.src, err)
+			continue
+		}
+		if n == nil || n.String() != tt.expected {
+			t.Errorf("%v: expected %s, got %v", tt.src, tt.expected, n)
+		}
+	}
+
+	n := big.NewInt(1)
+	if err := BigInt(&n).Scan(nil); err != nil || n != nil {
+		t.Errorf("unexpected result %v, %v", n, err)
+	}
+	for _, src := range []interface{}{[]byte("1.5"), []byte("NaN"), []byte("abc"), 1.0} {
+		if err := BigInt(&n).Scan(src); err == nil {
+			t.Errorf("%v: expected an error", src)
+		}
+	}
+}
+
+func TestBigIntRoundtrip(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	in, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	var out, out8 *big.Int
+	err := db.QueryRow("SELECT $1::numeric, $2::int8", in, big.NewInt(-9000000000)).Scan(BigInt(&out), BigInt(&out8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Cmp(in) != 0 {
+		t.Errorf("expected %v, got %v", in, out)
+	}
+	if out8.Int64() != -9000000000 {
+		t.Errorf("unexpected int8 %v", out8)
+	}
+
+	var null *big.Int
+	if err := db.QueryRow("SELECT $1::numeric", null).Scan(BigInt(&out)); err != nil {
+		t.Fatal(err)
+	}
+	if out != nil {
+		t.Errorf("expected NULL, got %v", out)
+	}
+}
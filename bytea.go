@@ -0,0 +1,47 @@
+package pq
+
+import (
+	"fmt"
+	"io"
+)
+
+// ByteaScanner is the sql.Scanner ByteaWriter returns.
+type ByteaScanner struct {
+	w io.Writer
+
+	// N is the number of bytes the last Scan wrote.
+	N int64
+
+	// Valid is false if the last value scanned was NULL.
+	Valid bool
+}
+
+// ByteaWriter returns a sql.Scanner that writes a bytea to w, such as a
+// file, instead of keeping it in a []byte of the caller's, for exporting a
+// large value:
+//
+//	f, err := os.Create("photo.jpg")
+//	...
+//	bw := pq.ByteaWriter(f)
+//	err = db.QueryRow("SELECT data FROM photos WHERE id = $1", id).Scan(bw)
+//	log.Printf("wrote %d bytes", bw.N)
+//
+// The value has been decoded, from hex or escape format, by the time Scan is
+// called.  NULL writes nothing and leaves Valid false.
+func ByteaWriter(w io.Writer) *ByteaScanner {
+	return &ByteaScanner{w: w}
+}
+
+// Scan implements the sql.Scanner interface.
+func (s *ByteaScanner) Scan(src interface{}) error {
+	s.N, s.Valid = 0, false
+	switch src := src.(type) {
+	case []byte:
+		n, err := s.w.Write(src)
+		s.N, s.Valid = int64(n), true
+		return err
+	case nil:
+		return nil
+	}
+	return fmt.Errorf("pq: cannot convert %T to a bytea", src)
+}
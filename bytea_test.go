@@ -0,0 +1,57 @@
+package pq
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestByteaWriterScan(t *testing.T) {
+	var buf bytes.Buffer
+	bw := ByteaWriter(&buf)
+	if err := bw.Scan([]byte{0, 1, 0xff}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), []byte{0, 1, 0xff}) || bw.N != 3 || !bw.Valid {
+		t.Errorf("unexpected result %q, %d, %v", buf.Bytes(), bw.N, bw.Valid)
+	}
+
+	buf.Reset()
+	if err := bw.Scan(nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 || bw.N != 0 || bw.Valid {
+		t.Errorf("unexpected result for NULL %q, %d, %v", buf.Bytes(), bw.N, bw.Valid)
+	}
+
+	if err := bw.Scan(int64(1)); err == nil {
+		t.Error("expected an error scanning an int64")
+	}
+	if err := ByteaWriter(failingWriter{}).Scan([]byte("abc")); err == nil {
+		t.Error("expected the writer's error")
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestByteaWriterFromDb(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	in := bytes.Repeat([]byte{0, '\\', 'x', 0xff}, 1<<16)
+	for _, format := range []string{"hex", "escape"} {
+		if _, err := db.Exec("SET bytea_output TO " + format); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		bw := ByteaWriter(&buf)
+		if err := db.QueryRow("SELECT $1::bytea", in).Scan(bw); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), in) || bw.N != int64(len(in)) {
+			t.Errorf("%s: got %d bytes back, expected %d", format, bw.N, len(in))
+		}
+	}
+}
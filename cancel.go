@@ -0,0 +1,90 @@
+package pq
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const defaultCancelGracePeriod = 10 * time.Second
+
+// cancel asks the server to cancel the query running on cn.  As the
+// protocol requires, the request is sent over a new connection, which the
+// server closes without a reply.  It gives up after the cancel grace period,
+// so that a server that never closes the connection can't keep it open.
+func (cn *conn) cancel() (err error) {
+	defer errRecover(&err)
+
+	netw, addr := network(cn.opts)
+	c, err := net.DialTimeout(netw, addr, cn.cancelGracePeriod)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if cn.cancelGracePeriod > 0 {
+		c.SetDeadline(time.Now().Add(cn.cancelGracePeriod))
+	}
+
+	can := &conn{c: c}
+	can.ssl(cn.opts)
+
+	w := can.writeBuf(0)
+	w.int32(80877102) // cancel request code
+	w.int32(cn.processID)
+	w.int32(cn.secretKey)
+	can.send(w)
+
+	// wait for the server to close the connection, so the cancel has been
+	// processed by the time this returns
+	var b [1]byte
+	c.Read(b[:])
+	return nil
+}
+
+// watchCancel watches ctx while an operation runs on cn.  If ctx is done
+// first, the query is cancelled.  If the server hasn't given up on it after
+// the cancel grace period, e.g. because it is stuck in an extension that
+// ignores cancels, the connection is closed as a last resort, so that the
+// operation fails instead of blocking forever.
+//
+// The returned function must be called when the operation has finished.  If
+// the connection was closed, it marks it bad and returns ctx's error, which
+// the operation should return in place of its own.
+func (cn *conn) watchCancel(ctx context.Context) func() error {
+	if ctx.Done() == nil {
+		return func() error { return nil }
+	}
+
+	finished := make(chan struct{})
+	exited := make(chan struct{})
+	var abandoned int32
+	go func() {
+		defer close(exited)
+		select {
+		case <-finished:
+			return
+		case <-ctx.Done():
+		}
+
+		// the cancel itself may hang, so don't wait for it
+		go cn.cancel()
+
+		select {
+		case <-finished:
+		case <-time.After(cn.cancelGracePeriod):
+			atomic.StoreInt32(&abandoned, 1)
+			cn.c.Close()
+		}
+	}()
+
+	return func() error {
+		close(finished)
+		<-exited
+		if atomic.LoadInt32(&abandoned) != 0 {
+			cn.bad = ctx.Err()
+			return ctx.Err()
+		}
+		return nil
+	}
+}
@@ -3,21 +3,28 @@ package pq
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/binary"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"github.com/gregb/pq/message"
 	"github.com/gregb/pq/oid"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"os"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -48,28 +55,43 @@ type parameterStatus struct {
 	// the current location based on the TimeZone value of the session, if
 	// available
 	currentLocation *time.Location
+
+	// whether text_as_string is set, so that values of every text type are
+	// decoded as strings; it's not reported by the server, but decode
+	// needs it along with the rest
+	textAsString bool
+
+	// the OID of the hstore extension's type, looked up when connecting if
+	// the hstore setting is on, or 0
+	hstoreOid oid.Oid
+
+	// whether truncate_timestamps is set, so that time.Time parameters are
+	// sent truncated to microseconds
+	truncateTimestamps bool
 }
 
-type transactionStatus byte
+// TransactionStatus is a connection's transaction status, as last reported
+// by the server.
+type TransactionStatus byte
 
 const (
-	txnStatusIdle                transactionStatus = 'I'
-	txnStatusIdleInTransaction   transactionStatus = 'T'
-	txnStatusInFailedTransaction transactionStatus = 'E'
+	TxnStatusIdle                TransactionStatus = 'I'
+	TxnStatusIdleInTransaction   TransactionStatus = 'T'
+	TxnStatusInFailedTransaction TransactionStatus = 'E'
 )
 
-func (s transactionStatus) String() string {
+func (s TransactionStatus) String() string {
 	switch s {
-	case txnStatusIdle:
+	case TxnStatusIdle:
 		return "idle"
-	case txnStatusIdleInTransaction:
+	case TxnStatusIdleInTransaction:
 		return "idle in transaction"
-	case txnStatusInFailedTransaction:
+	case TxnStatusInFailedTransaction:
 		return "in a failed transaction"
 	default:
-		return "unknown transactionStatus " + string(s)
+		// used in error messages, so it mustn't fail on a corrupt status
+		return fmt.Sprintf("unknown(%d)", byte(s))
 	}
-	panic("not reached")
 }
 
 type conn struct {
@@ -77,10 +99,150 @@ type conn struct {
 	buf               *bufio.Reader
 	namei             int
 	scratch           [512]byte
-	txnStatus         transactionStatus
+	rhdr              [5]byte
+	rbuf              []byte
+	txnStatus         TransactionStatus
 	parameterStatus   parameterStatus
+	runtimeParams     map[string]string
 	saveMessageType   message.Backend
 	saveMessageBuffer *readBuf
+
+	// bytes received from the server, for QueryStats
+	bytesReceived int64
+
+	// backend process ID and secret key from BackendKeyData, and the
+	// settings the connection was opened with, for sending cancel requests
+	processID int
+	secretKey int
+	opts      values
+
+	// how long to wait for a cancelled query to stop before giving up on
+	// the connection
+	cancelGracePeriod time.Duration
+
+	// whether BEGIN, COMMIT and ROLLBACK may complete with other command
+	// tags, as they may behind poolers that rewrite or absorb them
+	relaxedCommandTags bool
+
+	// whether statements are run with the simple query protocol, with
+	// their parameters interpolated, instead of being prepared
+	simpleProtocol bool
+
+	// whether rows keep the raw values of the current row, for RawValue
+	keepRawValues bool
+
+	// whether the columns of types with a binary decoder are read in
+	// binary format
+	binaryResults bool
+
+	// whether the parameters encodeBinary can encode are sent in binary
+	// format
+	binaryParameters bool
+
+	// the SET statements for the role, search_path, lock_timeout and
+	// idle_in_transaction_session_timeout settings, run after startup and
+	// by ResetSession, if any are set
+	setSession string
+
+	// the prefix of the names of the statements prepared on the server, from
+	// statement_name_prefix; see gname
+	stmtNamePrefix string
+
+	// whether ResetSession runs DISCARD ALL before setting the session up
+	// again
+	discardOnReset bool
+
+	// whether the hstore extension's type is looked up when connecting
+	lookupHstoreType bool
+
+	// the application_name the server reported at startup, which
+	// ResetSession restores if SetApplicationName changed it
+	defaultApplicationName string
+
+	hooks *Hooks
+
+	// called with the notifications received, if set; see
+	// SetNotificationHandler
+	notificationHandler func(*Notification)
+
+	// named statements prepared on this connection and not closed yet
+	stmts map[string]*stmt
+
+	// when the server last became ready for a query, and how long the
+	// connection may be idle before ResetSession checks it's still alive
+	lastUsed    time.Time
+	maxIdleTime time.Duration
+
+	// bad is set, to the reason why, once the connection can no longer be
+	// used.  Operations fail with it, and IsValid reports false so that
+	// database/sql discards the connection.
+	bad error
+}
+
+// IsValid implements driver.Validator.
+func (cn *conn) IsValid() bool {
+	return cn.bad == nil
+}
+
+// Ping checks that the connection is still alive with an empty query.  It
+// implements driver.Pinger.
+func (cn *conn) Ping(ctx context.Context) error {
+	if cn.bad != nil {
+		return driver.ErrBadConn
+	}
+	if _, _, err := cn.simpleExec(";"); err != nil {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// ResetSession is called by database/sql before a pooled connection is
+// reused.  With discard_on_reset, DISCARD ALL is run first.  The role,
+// search_path, lock_timeout and idle_in_transaction_session_timeout settings
+// are set again, and an application_name changed by SetApplicationName is
+// restored.  Otherwise, a connection that has been idle for longer than
+// max_idle_time is pinged first, so that one the server or a firewall has
+// dropped in the meantime is replaced rather than failing the next query.
+// It implements driver.SessionResetter.
+func (cn *conn) ResetSession(ctx context.Context) error {
+	if cn.bad != nil {
+		return driver.ErrBadConn
+	}
+	if cn.discardOnReset {
+		// DISCARD ALL can't be run with other statements, which would put it
+		// in a transaction block
+		if _, _, err := cn.simpleExec("DISCARD ALL"); err != nil {
+			return driver.ErrBadConn
+		}
+	}
+	set := cn.setSession
+	if cn.runtimeParams["application_name"] != cn.defaultApplicationName {
+		restore := "SET application_name TO " + QuoteLiteral(cn.defaultApplicationName)
+		if set != "" {
+			set = restore + "; " + set
+		} else {
+			set = restore
+		}
+	}
+	if set != "" {
+		// the previous user of the connection may have changed them; this
+		// checks the connection is still alive as well
+		if _, _, err := cn.simpleExec(set); err != nil {
+			return driver.ErrBadConn
+		}
+		return nil
+	}
+	if cn.maxIdleTime > 0 && time.Since(cn.lastUsed) > cn.maxIdleTime {
+		return cn.Ping(ctx)
+	}
+	return nil
+}
+
+// checkBad fails an operation on a connection that can no longer be used.
+func (cn *conn) checkBad() {
+	if cn.bad != nil {
+		panic(cn.bad)
+	}
 }
 
 func (c *conn) writeMessageType(b message.Frontend) *writeBuf {
@@ -106,6 +268,15 @@ func (c *conn) writeBuf(b byte) *writeBuf {
 }
 
 func Open(name string) (_ driver.Conn, err error) {
+	return open(context.Background(), name, nil)
+}
+
+func open(ctx context.Context, name string, hooks *Hooks) (_ driver.Conn, err error) {
+	defer func() {
+		if err != nil {
+			hooks.error(err)
+		}
+	}()
 	defer errRecover(&err)
 
 	o := make(values)
@@ -114,6 +285,7 @@ func Open(name string) (_ driver.Conn, err error) {
 	//
 	// * Very low precedence defaults applied in every situation
 	// * Environment variables
+	// * The settings of the connection service, if one is named
 	// * Explicitly passed connection information
 	o.Set("host", "localhost")
 	o.Set("port", "5432")
@@ -130,9 +302,26 @@ func Open(name string) (_ driver.Conn, err error) {
 			return nil, err
 		}
 	}
-	if err := parseOpts(name, o); err != nil {
+	explicit := make(values)
+	if err := parseOpts(name, explicit); err != nil {
 		return nil, err
 	}
+	service := o.Get("service")
+	if s, ok := explicit["service"]; ok {
+		service = s
+	}
+	if service != "" {
+		so, err := serviceOptions(service)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range so {
+			o.Set(k, v)
+		}
+	}
+	for k, v := range explicit {
+		o.Set(k, v)
+	}
 	// We can't work with any client_encoding other than UTF-8 currently.
 	// However, we have historically allowed the user to set it to UTF-8
 	// explicitly, and there's no reason to break such programs, so allow that.
@@ -153,6 +342,21 @@ func Open(name string) (_ driver.Conn, err error) {
 	} else {
 		o.Set("datestyle", "ISO, MDY")
 	}
+	// And so does IntervalStyle, since intervals are decoded into Interval.
+	if intervalstyle := o.Get("intervalstyle"); intervalstyle != "" {
+		if intervalstyle != "postgres" {
+			panic(fmt.Sprintf("setting intervalstyle must be absent or %v; got %v",
+				"postgres", intervalstyle))
+		}
+	} else {
+		o.Set("intervalstyle", "postgres")
+	}
+
+	// The session defaults are sent with the other run-time parameters, so
+	// every connection starts out with them, and RESET ALL or DISCARD ALL
+	// go back to them rather than to the server's.  Check them here so a
+	// typo doesn't only show up as the server refusing each connection.
+	checkSessionDefaults(o)
 
 	// If a user is not provided by any other means, the last
 	// resort is to use the current operating system provided user
@@ -166,43 +370,247 @@ func Open(name string) (_ driver.Conn, err error) {
 		}
 	}
 
-	c, err := net.Dial(network(o))
+	if !isTargetSessionAttrs[o.Get("target_session_attrs")] {
+		errorf("invalid target_session_attrs %q", o.Get("target_session_attrs"))
+	}
+
+	// cn holds the settings for the connection to each host tried
+	cn := &conn{opts: o, hooks: hooks, cancelGracePeriod: defaultCancelGracePeriod}
+	if v := o.Get("max_idle_time"); v != "" {
+		cn.maxIdleTime = parseDurationSetting("max_idle_time", v)
+	}
+	if v := o.Get("cancel_grace_period"); v != "" {
+		cn.cancelGracePeriod = parseDurationSetting("cancel_grace_period", v)
+	}
+	if v := o.Get("strict_command_tags"); v != "" {
+		cn.relaxedCommandTags = !parseBoolSetting("strict_command_tags", v)
+	}
+	if v := o.Get("prefer_simple_protocol"); v != "" {
+		cn.simpleProtocol = parseBoolSetting("prefer_simple_protocol", v)
+	}
+	if v := o.Get("binary_results"); v != "" {
+		cn.binaryResults = parseBoolSetting("binary_results", v)
+	}
+	if v := o.Get("binary_parameters"); v != "" {
+		cn.binaryParameters = parseBoolSetting("binary_parameters", v)
+	}
+	if v := o.Get("discard_on_reset"); v != "" {
+		cn.discardOnReset = parseBoolSetting("discard_on_reset", v)
+	}
+	if v := o.Get("statement_name_prefix"); v != "" {
+		if len(v) > maxStmtNamePrefixLen || strings.IndexByte(v, 0) >= 0 {
+			errorf("invalid statement_name_prefix %q; expected at most %d bytes without a NUL", v, maxStmtNamePrefixLen)
+		}
+		cn.stmtNamePrefix = v
+	}
+	if v := o.Get("keep_raw_values"); v != "" {
+		cn.keepRawValues = parseBoolSetting("keep_raw_values", v)
+	}
+	if v := o.Get("hstore"); v != "" {
+		cn.lookupHstoreType = parseBoolSetting("hstore", v)
+	}
+	if v := o.Get("text_as_string"); v != "" {
+		cn.parameterStatus.textAsString = parseBoolSetting("text_as_string", v)
+	}
+	if v := o.Get("truncate_timestamps"); v != "" {
+		cn.parameterStatus.truncateTimestamps = parseBoolSetting("truncate_timestamps", v)
+	}
+	var set []string
+	if v := o.Get("role"); v != "" {
+		if strings.IndexByte(v, 0) >= 0 {
+			errorf("invalid role %q: a role name can't contain a NUL byte", v)
+		}
+		set = append(set, "SET ROLE "+QuoteIdentifier(v))
+	}
+	if v := o.Get("search_path"); v != "" {
+		set = append(set, "SET search_path TO "+strings.Join(parseSearchPath(v), ", "))
+	}
+	for _, k := range []string{"lock_timeout", "idle_in_transaction_session_timeout"} {
+		if v := o.Get(k); v != "" {
+			ms, err := strconv.Atoi(v)
+			if err != nil || ms < 0 {
+				errorf("invalid %s %q; expected a number of milliseconds", k, v)
+			}
+			set = append(set, fmt.Sprintf("SET %s TO %d", k, ms))
+		}
+	}
+	cn.setSession = strings.Join(set, "; ")
+
+	cn, err = cn.connectTarget(ctx)
 	if err != nil {
 		return nil, err
 	}
-
-	cn := &conn{c: c}
-	cn.ssl(o)
-	cn.buf = bufio.NewReader(cn.c)
-	cn.startup(o)
+	hooks.connect(cn.processID)
 	return cn, nil
 }
 
+// TransactionStatus returns the connection's transaction status.  Like the
+// other methods of Conn, it's reached through sql.Conn.Raw.
+func (cn *conn) TransactionStatus() TransactionStatus {
+	return cn.txnStatus
+}
+
+// BackendPID returns the process ID of the connection's server backend, as
+// used by pg_cancel_backend and seen in pg_stat_activity.
+func (cn *conn) BackendPID() int {
+	return cn.processID
+}
+
+// RuntimeParameter returns the value of a run-time parameter the server
+// reports to clients, such as server_version, TimeZone or
+// standard_conforming_strings, as last reported.  ok is false for
+// parameters the server hasn't reported.
+func (cn *conn) RuntimeParameter(name string) (value string, ok bool) {
+	value, ok = cn.runtimeParams[name]
+	return value, ok
+}
+
+// SetApplicationName sets the session's application_name, as shown in
+// pg_stat_activity and the server log, so that a long-lived connection can
+// be tagged with what it is being used for.  RuntimeParameter reports the
+// new name.  ResetSession restores the name the connection started with, so
+// a tag doesn't outlast the sql.Conn it was set through.
+func (cn *conn) SetApplicationName(name string) error {
+	if strings.IndexByte(name, 0) >= 0 {
+		return fmt.Errorf("pq: invalid application_name %q", name)
+	}
+	_, _, err := cn.simpleExec("SET application_name TO " + QuoteLiteral(name))
+	return err
+}
+
+// SetNotificationHandler sets a function to call with the notifications
+// that arrive on the connection, for the channels it has run LISTEN for,
+// instead of dropping them.  A nil handler drops them again.
+//
+// Notifications only arrive while the connection is being used: h is
+// called synchronously from the goroutine reading a query's results, so it
+// should return quickly and must not use the connection.  For a connection
+// given over to waiting for notifications, use a Listener.
+func (cn *conn) SetNotificationHandler(h func(*Notification)) {
+	cn.notificationHandler = h
+}
+
+// Conn is implemented by pq's driver connections, for using its methods
+// through sql.Conn.Raw:
+//
+//	err := c.Raw(func(driverConn interface{}) error {
+//		pid = driverConn.(pq.Conn).BackendPID()
+//		return nil
+//	})
+//
+// Functions such as FlushStatements and CopyInRaw take the sql.Conn
+// themselves.
+type Conn interface {
+	driver.Conn
+
+	BackendPID() int
+	RuntimeParameter(name string) (value string, ok bool)
+	SetApplicationName(name string) error
+	SetNotificationHandler(h func(*Notification))
+	TransactionStatus() TransactionStatus
+}
+
+var _ Conn = (*conn)(nil)
+
 func (cn *conn) isInTransaction() bool {
-	return cn.txnStatus == txnStatusIdleInTransaction ||
-		cn.txnStatus == txnStatusInFailedTransaction
+	return cn.txnStatus == TxnStatusIdleInTransaction ||
+		cn.txnStatus == TxnStatusInFailedTransaction
 }
 func (cn *conn) checkIsInTransaction(intxn bool) {
 	if cn.isInTransaction() != intxn {
 		errorf("unexpected transaction status %v", cn.txnStatus)
 	}
 }
+
+// CommandTagError is returned when the server completes a transaction
+// control statement with a command tag other than the statement's own.  The
+// connection is out of step with the server, or something between them is
+// rewriting statements, so it is not used again.
+type CommandTagError struct {
+	Tag      string
+	Expected string
+}
+
+func (e CommandTagError) Error() string {
+	return fmt.Sprintf(`pq: unexpected command tag "%s"; expected %s`, e.Tag, e.Expected)
+}
+
+// checkCommandTag fails with a CommandTagError, and marks the connection bad,
+// if tag isn't the expected one, unless strict_command_tags is off.  The
+// transaction status is checked either way.
+func (cn *conn) checkCommandTag(tag, expected string) error {
+	if tag == expected || cn.relaxedCommandTags {
+		return nil
+	}
+	err := CommandTagError{Tag: tag, Expected: expected}
+	cn.bad = err
+	return err
+}
+
 func (cn *conn) Begin() (_ driver.Tx, err error) {
+	return cn.begin("")
+}
+
+// begin begins a transaction with modes, as made by txModes.
+func (cn *conn) begin(modes string) (_ driver.Tx, err error) {
 	defer errRecover(&err)
 	cn.checkIsInTransaction(false)
-	_, commandTag, err := cn.simpleExec("BEGIN")
+	_, commandTag, err := cn.simpleExec("BEGIN" + modes)
 	if err != nil {
 		return nil, err
 	}
-	if commandTag != "BEGIN" {
-		return nil, fmt.Errorf(`unexpected command tag "%s"; expected BEGIN`, commandTag)
+	if err := cn.checkCommandTag(commandTag, "BEGIN"); err != nil {
+		return nil, err
 	}
-	if cn.txnStatus != txnStatusIdleInTransaction {
+	if cn.txnStatus != TxnStatusIdleInTransaction {
 		return nil, fmt.Errorf("unexpected transaction status %v", cn.txnStatus)
 	}
 	return cn, nil
 }
 
+// BeginTx implements driver.ConnBeginTx.  The transaction begins with the
+// isolation level and access mode of opts.  If ctx is done before the
+// transaction has begun, BEGIN is cancelled; database/sql rolls the
+// transaction back if ctx is done later.
+func (cn *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	modes, err := txModes(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	finish := cn.watchCancel(ctx)
+	tx, err := cn.begin(modes)
+	if ctxErr := finish(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	return tx, err
+}
+
+// txModes returns the transaction modes to append to BEGIN for opts.
+// sql.LevelSnapshot is taken to be REPEATABLE READ, which is snapshot
+// isolation in Postgres.  Levels Postgres has nothing like, such as
+// sql.LevelLinearizable, are refused.
+func txModes(opts driver.TxOptions) (string, error) {
+	modes := ""
+	switch sql.IsolationLevel(opts.Isolation) {
+	case sql.LevelDefault:
+	case sql.LevelReadUncommitted:
+		modes = " ISOLATION LEVEL READ UNCOMMITTED"
+	case sql.LevelReadCommitted:
+		modes = " ISOLATION LEVEL READ COMMITTED"
+	case sql.LevelRepeatableRead, sql.LevelSnapshot:
+		modes = " ISOLATION LEVEL REPEATABLE READ"
+	case sql.LevelSerializable:
+		modes = " ISOLATION LEVEL SERIALIZABLE"
+	default:
+		return "", fmt.Errorf("pq: unsupported isolation level: %v", sql.IsolationLevel(opts.Isolation))
+	}
+	if opts.ReadOnly {
+		modes += " READ ONLY"
+	}
+	return modes, nil
+}
+
 func (cn *conn) Commit() (err error) {
 	defer errRecover(&err)
 	cn.checkIsInTransaction(true)
@@ -212,7 +620,7 @@ func (cn *conn) Commit() (err error) {
 	// pool so we have to abort the current transaction here.  Note that you
 	// would get the same behaviour if you issued a COMMIT in a failed
 	// transaction, so it's also the least surprising thing to do here.
-	if cn.txnStatus == txnStatusInFailedTransaction {
+	if cn.txnStatus == TxnStatusInFailedTransaction {
 		if err := cn.Rollback(); err != nil {
 			return err
 		}
@@ -222,8 +630,8 @@ func (cn *conn) Commit() (err error) {
 	if err != nil {
 		return err
 	}
-	if commandTag != "COMMIT" {
-		return fmt.Errorf(`unexpected command tag "%s"; expected COMMIT`, commandTag)
+	if err := cn.checkCommandTag(commandTag, "COMMIT"); err != nil {
+		return err
 	}
 	cn.checkIsInTransaction(false)
 	return nil
@@ -236,26 +644,41 @@ func (cn *conn) Rollback() (err error) {
 	if err != nil {
 		return err
 	}
-	if commandTag != "ROLLBACK" {
-		return fmt.Errorf(`unexpected command tag "%s"; expected ROLLBACK`, commandTag)
+	if err := cn.checkCommandTag(commandTag, "ROLLBACK"); err != nil {
+		return err
 	}
 	cn.checkIsInTransaction(false)
 	return nil
 }
 
+// maxStmtNamePrefixLen is the longest statement_name_prefix that leaves room
+// for any count in a name the server won't truncate to NAMEDATALEN-1 bytes,
+// which would make names collide.
+const maxStmtNamePrefixLen = 63 - 19
+
+// gname returns a name for a statement to be prepared on the server: the
+// connection's statement_name_prefix, if any, followed by a count of the
+// statements it has prepared.  The server keeps each one until its stmt is
+// closed, or the connection is.
 func (cn *conn) gname() string {
 	cn.namei++
-	return strconv.FormatInt(int64(cn.namei), 10)
+	return cn.stmtNamePrefix + strconv.FormatInt(int64(cn.namei), 10)
 }
 
 func (cn *conn) simpleExec(q string) (res driver.Result, commandTag string, err error) {
+	defer cn.hooks.queryEnd(q, cn.hooks.queryStart(q), &err)
 	defer errRecover(&err)
+	cn.checkBad()
 
 	st := &stmt{cn: cn, name: "", query: q}
 	b := cn.writeMessageType(message.Query)
 	b.string(q)
 	cn.send(b)
 
+	// q may hold several statements, each ending in its own CommandComplete;
+	// the result comes from the last one that modified data, failing that
+	// the last one that reported a row count at all
+	resPrecedence := 0
 	for {
 		t, r := cn.recv1()
 		switch t {
@@ -263,11 +686,13 @@ func (cn *conn) simpleExec(q string) (res driver.Result, commandTag string, err
 			var rowsAffected int64
 			rowsAffected, commandTag = parseComplete(r.string())
 
-			if st.rowData != nil {
-				res = createResult(rowsAffected, st.rowData)
-			} else {
-				res = driver.RowsAffected(rowsAffected)
+			if p := resultPrecedence(commandTag); p >= resPrecedence {
+				resPrecedence = p
+				res = createResult(commandTag, rowsAffected, st.cols, st.returned)
 			}
+			cn.checkDeallocated(commandTag)
+			// rows belong to the statement that just completed
+			st.returned = nil
 		case message.ReadyForQuery:
 			cn.processReadyForQuery(r)
 			// done
@@ -277,9 +702,13 @@ func (cn *conn) simpleExec(q string) (res driver.Result, commandTag string, err
 		case message.RowDescription:
 			st.parseRowDesciption(r)
 		case message.DataRow:
-			l := len(st.cols)
-			st.rowData = make([]driver.Value, l, l)
-			st.parseDataRow(r, st.rowData)
+			row := make([]driver.Value, len(st.cols))
+			st.parseDataRow(r, row)
+			st.returned = append(st.returned, row)
+		case message.EmptyQueryResponse:
+			if res == nil {
+				res = driver.RowsAffected(0)
+			}
 		default:
 			errorf("unknown response for simple query: %q", t)
 		}
@@ -288,9 +717,12 @@ func (cn *conn) simpleExec(q string) (res driver.Result, commandTag string, err
 }
 
 func (cn *conn) simpleQuery(q string) (res driver.Rows, err error) {
+	defer cn.hooks.queryEnd(q, cn.hooks.queryStart(q), &err)
 	defer errRecover(&err)
+	cn.checkBad()
 
 	st := &stmt{cn: cn, name: "", query: q}
+	startBytes := cn.bytesReceived
 	b := cn.writeMessageType(message.Query)
 	b.string(q)
 	cn.send(b)
@@ -321,7 +753,7 @@ func (cn *conn) simpleQuery(q string) (res driver.Rows, err error) {
 			st.parseRowDesciption(r)
 
 			// After we get the meta, we want to kick out to Next()
-			res = &rows{st: st, done: false}
+			res = &rows{st: st, done: false, startBytes: startBytes}
 			return
 		default:
 			errorf("unknown response for simple query: %q", t)
@@ -331,65 +763,110 @@ func (cn *conn) simpleQuery(q string) (res driver.Rows, err error) {
 }
 
 func (cn *conn) prepareTo(q, stmtName string) (_ driver.Stmt, err error) {
-	return cn.prepareToSimpleStmt(q, stmtName)
+	return cn.prepareToSimpleStmt(q, stmtName, nil)
 }
-func (cn *conn) prepareToSimpleStmt(q, stmtName string) (_ *stmt, err error) {
+func (cn *conn) prepareToSimpleStmt(q, stmtName string, paramTypHints []oid.Oid) (_ *stmt, err error) {
 	defer errRecover(&err)
+	cn.checkBad()
 
-	st := &stmt{cn: cn, name: stmtName, query: q}
+	st := &stmt{cn: cn, name: stmtName, query: q, paramTypHints: paramTypHints}
+	st.prepare()
+	if st.name != "" {
+		if cn.stmts == nil {
+			cn.stmts = make(map[string]*stmt)
+		}
+		cn.stmts[st.name] = st
+	}
+	return st, nil
+}
 
-	b := cn.writeMessageType(message.Parse)
-	b.string(st.name)
-	b.string(q)
-	b.int16(0)
-	cn.send(b)
+// flushStatements closes all named statements on the server, so that the
+// next use of each re-prepares it against the current schema.
+func (cn *conn) flushStatements() (err error) {
+	defer errRecover(&err)
+	cn.checkBad()
 
-	b = cn.writeMessageType(message.Describe)
-	b.byte('S') // statement
-	b.string(st.name)
-	cn.send(b)
+	if len(cn.stmts) == 0 {
+		return nil
+	}
 
+	for name, st := range cn.stmts {
+		w := cn.writeMessageType(message.Close)
+		w.byte('S')
+		w.string(name)
+		cn.send(w)
+		st.stale = true
+	}
 	cn.send(cn.writeMessageType(message.Sync))
 
 	for {
 		t, r := cn.recv1()
 		switch t {
-		case message.ParseComplete:
+		case message.CloseComplete:
 			// ignore
-		case message.ParameterDescription:
-			nparams := int(r.int16())
-			st.paramTyps = make([]oid.Oid, nparams)
-
-			for i := range st.paramTyps {
-				st.paramTyps[i] = r.oid()
-			}
-		case message.RowDescription:
-			st.parseRowDesciption(r)
-		case message.NoData:
-			// no data
-		case message.ReadyForQuery:
-			cn.processReadyForQuery(r)
-			return st, err
 		case message.Error:
 			err = parseError(r)
-		case message.CommandComplete:
-			// command complete
-			return st, err
+		case message.ReadyForQuery:
+			cn.processReadyForQuery(r)
+			return err
 		default:
-			errorf("unexpected describe rows response: %q", t)
+			errorf("unexpected close response: %q", t)
 		}
 	}
+}
 
-	panic("not reached")
+// checkDeallocated marks the named statements stale if a command tag, as
+// returned by parseComplete, is that of DISCARD ALL or DEALLOCATE ALL, which
+// deallocate every prepared statement on the server.  Each is prepared again
+// the next time it is used, rather than failing with "prepared statement
+// does not exist".
+func (cn *conn) checkDeallocated(commandTag string) {
+	switch commandTag {
+	case "DISCARD ALL", "DEALLOCATE ALL":
+		for _, st := range cn.stmts {
+			st.stale = true
+		}
+	}
+}
+
+// FlushStatements closes the statements prepared on c's underlying
+// connection, e.g. after a schema change has made their plans invalid.  The
+// statements stay usable: each is prepared again the next time it is used.
+func FlushStatements(c *sql.Conn) error {
+	return c.Raw(func(driverConn interface{}) error {
+		cn, ok := driverConn.(*conn)
+		if !ok {
+			return fmt.Errorf("pq: FlushStatements called on a %T connection", driverConn)
+		}
+		return cn.flushStatements()
+	})
 }
 
 func (cn *conn) Prepare(q string) (driver.Stmt, error) {
 	if len(q) >= 4 && strings.EqualFold(q[:4], "COPY") {
 		return cn.prepareCopyIn(q)
 	}
+	q = cn.hooks.rewriteQuery(q)
+	if cn.simpleProtocol {
+		return &stmt{cn: cn, query: q, simple: true}, nil
+	}
 	return cn.prepareTo(q, cn.gname())
 }
 
+// PrepareContext implements driver.ConnPrepareContext.  The statement is
+// prepared with the parameter types set on ctx by WithParameterTypes, if
+// any.
+func (cn *conn) PrepareContext(ctx context.Context, q string) (driver.Stmt, error) {
+	if len(q) >= 4 && strings.EqualFold(q[:4], "COPY") {
+		return cn.prepareCopyIn(q)
+	}
+	q = cn.hooks.rewriteQuery(q)
+	if cn.simpleProtocol {
+		return &stmt{cn: cn, query: q, simple: true}, nil
+	}
+	return cn.prepareToSimpleStmt(q, cn.gname(), parameterTypes(ctx))
+}
+
 func (cn *conn) Close() (err error) {
 	defer errRecover(&err)
 	cn.send(cn.writeMessageType(message.Terminate))
@@ -400,7 +877,10 @@ func (cn *conn) Close() (err error) {
 // Let's NOT implement the "Queryer" interface...
 // It interferes with array parameter preparation
 // which is only available on statements (and Query()
-// does not use a statement)
+// does not use a statement).  The same goes for
+// QueryerContext: database/sql converts the arguments
+// before it could return driver.ErrSkip.  Queries are
+// prepared instead, and stmt.QueryContext cancels them.
 /*
 func (cn *conn) Query(query string, args []driver.Value) (_ driver.Rows, err error) {
 	defer errRecover(&err)
@@ -411,7 +891,7 @@ func (cn *conn) Query(query string, args []driver.Value) (_ driver.Rows, err err
 		return cn.simpleQuery(query)
 	}
 
-	st, err := cn.prepareToSimpleStmt(query, "")
+	st, err := cn.prepareToSimpleStmt(query, "", nil)
 
 	if err != nil {
 		panic(err)
@@ -425,6 +905,11 @@ func (cn *conn) Query(query string, args []driver.Value) (_ driver.Rows, err err
 
 func (cn *conn) Exec(query string, args []driver.Value) (_ driver.Result, err error) {
 	defer errRecover(&err)
+	if len(query) < 4 || !strings.EqualFold(query[:4], "COPY") {
+		query = cn.hooks.rewriteQuery(query)
+	} else if source, binary, ok := copyOutSource(query); ok && len(args) == 0 {
+		return cn.prepareCopyOut(query, source, binary).Exec(nil)
+	}
 
 	// Check to see if we can use the "simpleExec" interface, which is
 	// *much* faster than going through prepare/exec
@@ -433,6 +918,9 @@ func (cn *conn) Exec(query string, args []driver.Value) (_ driver.Result, err er
 		r, _, err := cn.simpleExec(query)
 		return r, err
 	}
+	if cn.simpleProtocol {
+		return (&stmt{cn: cn, query: query, simple: true}).simpleExec(args)
+	}
 
 	// Use the unnamed statement to defer planning until bind
 	// time, or else value-based selectivity estimates cannot be
@@ -450,6 +938,99 @@ func (cn *conn) Exec(query string, args []driver.Value) (_ driver.Result, err er
 	return r, err
 }
 
+// ExecContext implements driver.ExecerContext.  If ctx is done before the
+// statement has completed, it is cancelled, as with stmt.ExecContext.  With
+// parameter types set on ctx by WithParameterTypes, it returns
+// driver.ErrSkip, for database/sql to prepare the statement with them.
+func (cn *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if parameterTypes(ctx) != nil {
+		return nil, driver.ErrSkip
+	}
+	v, err := namedValuesToValues(args)
+	if err != nil {
+		return nil, err
+	}
+
+	finish := cn.watchCancel(ctx)
+	res, err := cn.Exec(query, v)
+	if ctxErr := finish(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	return res, err
+}
+
+// CheckNamedValue implements driver.NamedValueChecker.  It rejects argument
+// types that pq has no way of sending to the server while the arguments are
+// being bound, rather than letting them fail inside encode at execution time.
+// Everything else is left to the default conversion (or, for array
+// parameters, the statement's ColumnConverter) by returning driver.ErrSkip.
+// With prefer_simple_protocol, checkSimpleValue converts them instead.
+// net.IP and net.IPNet are converted to the text of an inet either way,
+// map[string]string and map[string]sql.NullString to that of an hstore,
+// json.RawMessage and composite json.Marshalers to their JSON, and [16]byte
+// to the text of a uuid.
+func (cn *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	// net.IP is a []byte, which would otherwise be sent as a bytea
+	if v, ok, err := inetValue(nv.Value); ok {
+		nv.Value = v
+		return err
+	}
+	if v, ok, err := bigIntValue(nv.Value); ok {
+		nv.Value = v
+		return err
+	}
+	if v, ok, err := hstoreValue(nv.Value); ok {
+		nv.Value = v
+		return err
+	}
+	if v, ok, err := jsonValue(nv.Value); ok {
+		nv.Value = v
+		return err
+	}
+	if v, ok, err := uuidValue(nv.Value); ok {
+		nv.Value = v
+		return err
+	}
+	if cn.simpleProtocol {
+		return cn.checkSimpleValue(nv)
+	}
+	if _, ok := nv.Value.(driver.Valuer); ok {
+		return driver.ErrSkip
+	}
+	if driver.IsValue(nv.Value) {
+		return nil
+	}
+
+	if canConvertType(reflect.TypeOf(nv.Value)) {
+		return driver.ErrSkip
+	}
+
+	return fmt.Errorf("pq: cannot convert %T to a Postgres type for parameter $%d", nv.Value, nv.Ordinal)
+}
+
+// canConvertType reports whether values of type t can be converted into
+// something encode understands, either by database/sql's default conversion
+// or, for slices, by an arrayConverter.
+func canConvertType(t reflect.Type) bool {
+	if t == timeType || t.Implements(valuerType) {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return canConvertType(t.Elem())
+	}
+
+	return false
+}
+
+var valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
 // Assumes len(*m) is > 5
 func (cn *conn) send(m *writeBuf) {
 	b := (*m)[1:]
@@ -460,7 +1041,12 @@ func (cn *conn) send(m *writeBuf) {
 	}
 
 	if TrafficLogging {
-		log.Printf("Sending : (%c) %q", (*m)[0], b)
+		if message.Frontend((*m)[0]) == message.Password {
+			// never log credentials, not even md5-hashed ones
+			log.Printf("Sending : (%c) [password redacted]", (*m)[0])
+		} else {
+			log.Printf("Sending : (%c) %q", (*m)[0], b)
+		}
 	}
 
 	_, err := cn.c.Write(*m)
@@ -469,6 +1055,25 @@ func (cn *conn) send(m *writeBuf) {
 	}
 }
 
+// maxRetainedReadBuffer bounds the read buffer a connection keeps between
+// messages, so that one huge row doesn't pin its memory for the life of the
+// connection.
+const maxRetainedReadBuffer = 1 << 20
+
+// readBuffer returns a buffer of n bytes for the body of the next message.
+// The buffer is reused by the following message, so it grows to fit the
+// widest message seen, up to maxRetainedReadBuffer.
+func (cn *conn) readBuffer(n int) []byte {
+	if n <= cap(cn.rbuf) {
+		return cn.rbuf[:n]
+	}
+	y := make([]byte, n)
+	if n <= maxRetainedReadBuffer {
+		cn.rbuf = y
+	}
+	return y
+}
+
 // recvMessage receives any message from the backend, or returns an error if
 // a problem occurred while reading the message.
 func (cn *conn) recvMessage() (message.Backend, *readBuf, error) {
@@ -485,7 +1090,7 @@ func (cn *conn) recvMessage() (message.Backend, *readBuf, error) {
 		return t, r, nil
 	}
 
-	x := cn.scratch[:5]
+	x := cn.rhdr[:]
 	_, err := io.ReadFull(cn.buf, x)
 	if err != nil {
 		return 0, nil, err
@@ -494,19 +1099,13 @@ func (cn *conn) recvMessage() (message.Backend, *readBuf, error) {
 
 	b := readBuf(x[1:])
 
-
-
 	n := b.int32() - 4
-	var y []byte
-	if n <= len(cn.scratch) {
-		y = cn.scratch[:n]
-	} else {
-		y = make([]byte, n)
-	}
+	y := cn.readBuffer(n)
 	_, err = io.ReadFull(cn.buf, y)
 	if err != nil {
 		return 0, nil, err
 	}
+	cn.bytesReceived += int64(len(x) + n)
 
 	if TrafficLogging {
 		log.Printf("Received: (%c) [%d] %q", t, n, y)
@@ -517,8 +1116,8 @@ func (cn *conn) recvMessage() (message.Backend, *readBuf, error) {
 
 // recv receives a message from the backend, but if an error happened while
 // reading the message or the received message was an ErrorResponse, it panics.
-// NoticeResponses are ignored.  This function should generally be used only
-// during the startup sequence.
+// NoticeResponses are passed to the OnNotice hook.  This function should
+// generally be used only during the startup sequence.
 func (cn *conn) recv() (t message.Backend, r *readBuf) {
 	for {
 		var err error
@@ -530,7 +1129,7 @@ func (cn *conn) recv() (t message.Backend, r *readBuf) {
 		case message.Error:
 			panic(parseError(r))
 		case message.Notice:
-			// ignore
+			cn.hooks.notice(parseError(r))
 		default:
 			return
 		}
@@ -540,8 +1139,8 @@ func (cn *conn) recv() (t message.Backend, r *readBuf) {
 }
 
 // recv1 receives a message from the backend, panicking if an error occurs
-// while attempting to read it.  All asynchronous messages are ignored, with
-// the exception of ErrorResponse.
+// while attempting to read it.  Asynchronous messages are handled here
+// rather than returned, with the exception of ErrorResponse.
 func (cn *conn) recv1() (t message.Backend, r *readBuf) {
 	for {
 		var err error
@@ -551,10 +1150,24 @@ func (cn *conn) recv1() (t message.Backend, r *readBuf) {
 		}
 
 		switch t {
-		case message.NotificationResponse, message.Notice:
-			// ignore
+		case message.NotificationResponse:
+			if cn.notificationHandler != nil {
+				cn.notificationHandler(recvNotification(r))
+			}
+		case message.Notice:
+			cn.hooks.notice(parseError(r))
 		case message.ParameterStatus:
 			cn.processParameterStatus(r)
+		case message.Error:
+			// the server closes the connection after a FATAL error, such
+			// as an idle_in_transaction_session_timeout, without the
+			// ReadyForQuery the caller would wait for
+			rr := *r
+			if e := parseError(&rr); e.Fatal() {
+				cn.bad = driver.ErrBadConn
+				panic(e)
+			}
+			return
 		default:
 			return
 		}
@@ -564,16 +1177,9 @@ func (cn *conn) recv1() (t message.Backend, r *readBuf) {
 }
 
 func (cn *conn) ssl(o values) {
-	tlsConf := tls.Config{}
-	switch mode := o.Get("sslmode"); mode {
-	case "require", "":
-		tlsConf.InsecureSkipVerify = true
-	case "verify-full":
-		// fall out
-	case "disable":
+	tlsConf := sslConfig(o)
+	if tlsConf == nil {
 		return
-	default:
-		errorf(`unsupported sslmode %q; only "require" (default), "verify-full", and "disable" supported`, mode)
 	}
 
 	w := cn.writeBuf(0)
@@ -590,10 +1196,259 @@ func (cn *conn) ssl(o values) {
 		panic(ErrSSLNotSupported)
 	}
 
-	cn.c = tls.Client(cn.c, &tlsConf)
+	cn.c = tls.Client(cn.c, tlsConf)
+}
+
+// sslConfig returns the TLS configuration for the sslmode, tls_server_name,
+// sslcert, sslkey, sslrootcert and sslcrl settings, or nil if SSL is
+// disabled.
+//
+// tls_server_name only changes the name sent for SNI.  With verify-full, the
+// server's certificate is still verified against host.  verify-ca verifies
+// the certificate's chain, but not the name it is for.
+func sslConfig(o values) *tls.Config {
+	tlsConf := &tls.Config{}
+	serverName := o.Get("tls_server_name")
+	switch mode := o.Get("sslmode"); mode {
+	case "require", "":
+		tlsConf.InsecureSkipVerify = true
+		tlsConf.ServerName = serverName
+	case "verify-full":
+		tlsConf.RootCAs = sslRootCerts(o)
+		tlsConf.VerifyPeerCertificate = sslRevocationCheck(o)
+		host := o.Get("host")
+		tlsConf.ServerName = host
+		if serverName != "" && serverName != host {
+			// crypto/tls verifies against the SNI name, so take over the
+			// verification to keep checking the host
+			tlsConf.ServerName = serverName
+			tlsConf.InsecureSkipVerify = true
+			tlsConf.VerifyConnection = verifyCertificateHost(host, tlsConf.RootCAs)
+		}
+	case "verify-ca":
+		tlsConf.RootCAs = sslRootCerts(o)
+		tlsConf.VerifyPeerCertificate = sslRevocationCheck(o)
+		tlsConf.ServerName = o.Get("host")
+		if serverName != "" {
+			tlsConf.ServerName = serverName
+		}
+		// crypto/tls can't verify the chain alone, so take over the
+		// verification
+		tlsConf.InsecureSkipVerify = true
+		tlsConf.VerifyConnection = verifyCertificateHost("", tlsConf.RootCAs)
+	case "disable":
+		return nil
+	default:
+		errorf(`unsupported sslmode %q; only "require" (default), "verify-ca", "verify-full", and "disable" supported`, mode)
+	}
+	sslClientCert(tlsConf, o)
+	return tlsConf
+}
+
+// sslClientCert adds the client certificate in the sslcert and sslkey files,
+// if they are set, to tlsConf.
+func sslClientCert(tlsConf *tls.Config, o values) {
+	certFile, keyFile := o.Get("sslcert"), o.Get("sslkey")
+	if certFile == "" && keyFile == "" {
+		return
+	}
+	if certFile == "" || keyFile == "" {
+		errorf("sslcert and sslkey must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		panic(err)
+	}
+	tlsConf.Certificates = []tls.Certificate{cert}
+}
+
+// sslRootCerts returns the certificates in the sslrootcert file to verify
+// the server against, or nil for the system's if it isn't set.
+func sslRootCerts(o values) *x509.CertPool {
+	file := o.Get("sslrootcert")
+	if file == "" {
+		return nil
+	}
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		panic(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		errorf("no certificates found in sslrootcert %q", file)
+	}
+	return roots
+}
+
+// sslRootCertList returns the certificates in the sslrootcert file, parsed,
+// or nil if it isn't set.
+func sslRootCertList(o values) []*x509.Certificate {
+	file := o.Get("sslrootcert")
+	if file == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		panic(err)
+	}
+	var certs []*x509.Certificate
+	for rest := b; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			errorf("could not parse sslrootcert %q: %v", file, err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs
+}
+
+// sslRevocationCheck returns a tls.Config VerifyPeerCertificate callback that
+// rejects server certificates revoked by the certificate revocation lists in
+// the sslcrl file, or nil if it isn't set.  The file holds either one CRL in
+// DER form or any number in PEM, such as one for each authority in the
+// server's chain.  A CRL is only used once its signature is verified with the
+// certificate of its issuer, from the server's chain or the sslrootcert file;
+// a certificate whose issuer has a CRL that can't be verified is rejected.
+func sslRevocationCheck(o values) func([][]byte, [][]*x509.Certificate) error {
+	file := o.Get("sslcrl")
+	if file == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		panic(err)
+	}
+	var crls []*x509.RevocationList
+	for rest := b; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "X509 CRL" {
+			errorf("sslcrl %q holds a %s, not a certificate revocation list", file, block.Type)
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			errorf("could not parse sslcrl %q: %v", file, err)
+		}
+		crls = append(crls, crl)
+	}
+	if crls == nil {
+		crl, err := x509.ParseRevocationList(b)
+		if err != nil {
+			errorf("could not parse sslcrl %q: %v", file, err)
+		}
+		crls = append(crls, crl)
+	}
+
+	roots := sslRootCertList(o)
+
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs[i] = cert
+		}
+		issuers := append(append([]*x509.Certificate(nil), certs...), roots...)
+		for _, cert := range certs {
+			for _, crl := range crls {
+				if !bytes.Equal(cert.RawIssuer, crl.RawIssuer) {
+					continue
+				}
+				if !crlSignedBy(crl, issuers) {
+					return fmt.Errorf("pq: could not verify the signature of the sslcrl revocation list of %q", crl.Issuer)
+				}
+				for _, revoked := range crl.RevokedCertificateEntries {
+					if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+						return fmt.Errorf("pq: server certificate %q has been revoked", cert.Subject)
+					}
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// crlSignedBy reports whether crl is signed by one of issuers.
+func crlSignedBy(crl *x509.RevocationList, issuers []*x509.Certificate) bool {
+	for _, issuer := range issuers {
+		if bytes.Equal(issuer.RawSubject, crl.RawIssuer) && crl.CheckSignatureFrom(issuer) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyCertificateHost returns a tls.Config VerifyConnection callback that
+// does the verification crypto/tls normally would, but for host, or with
+// host empty, for no name at all.  roots, if not nil, replaces the system's
+// root certificates.
+func verifyCertificateHost(host string, roots *x509.CertPool) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("pq: server sent no certificate")
+		}
+		opts := x509.VerifyOptions{
+			DNSName:       host,
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := cs.PeerCertificates[0].Verify(opts)
+		return err
+	}
+}
+
+// isDriverSetting holds the connection settings that are for the driver
+// itself, and so aren't sent to the server as run-time parameters.
+var isDriverSetting = map[string]bool{
+	"password":                            true,
+	"host":                                true,
+	"port":                                true,
+	"sslmode":                             true,
+	"tls_server_name":                     true,
+	"sslcert":                             true,
+	"sslkey":                              true,
+	"sslrootcert":                         true,
+	"sslcrl":                              true,
+	"max_idle_time":                       true,
+	"cancel_grace_period":                 true,
+	"strict_command_tags":                 true,
+	"prefer_simple_protocol":              true,
+	"target_session_attrs":                true,
+	"search_path":                         true,
+	"role":                                true,
+	"text_as_string":                      true,
+	"truncate_timestamps":                 true,
+	"keep_raw_values":                     true,
+	"binary_results":                      true,
+	"binary_parameters":                   true,
+	"lock_timeout":                        true,
+	"idle_in_transaction_session_timeout": true,
+	"discard_on_reset":                    true,
+	"statement_name_prefix":               true,
+	"service":                             true,
+	"hstore":                              true,
 }
 
 func (cn *conn) startup(o values) {
+	var sent map[string]string
+	if cn.hooks.wantStartup() {
+		sent = make(map[string]string)
+	}
+
 	w := cn.writeBuf(0)
 	w.int32(196608)
 	// Send the backend the name of the database we want to connect to, and the
@@ -602,8 +1457,7 @@ func (cn *conn) startup(o values) {
 	// doesn't recognize any of them, it will reply with an error.
 	for k, v := range o {
 		// skip options which can't be run-time parameters
-		if k == "password" || k == "host" ||
-			k == "port" || k == "sslmode" {
+		if isDriverSetting[k] {
 			continue
 		}
 		// The protocol requires us to supply the database name as "database"
@@ -613,6 +1467,9 @@ func (cn *conn) startup(o values) {
 		}
 		w.string(k)
 		w.string(v)
+		if sent != nil {
+			sent[k] = v
+		}
 	}
 	w.string("")
 	cn.send(w)
@@ -621,13 +1478,21 @@ func (cn *conn) startup(o values) {
 		t, r := cn.recv()
 		switch t {
 		case message.KeyData:
-			// ?
+			cn.processID = r.int32()
+			cn.secretKey = r.int32()
 		case message.ParameterStatus:
 			cn.processParameterStatus(r)
 		case message.Authenticate:
 			cn.auth(r, o)
 		case message.ReadyForQuery:
 			cn.processReadyForQuery(r)
+			if sent != nil {
+				reported := make(map[string]string, len(cn.runtimeParams))
+				for k, v := range cn.runtimeParams {
+					reported[k] = v
+				}
+				cn.hooks.startup(sent, reported)
+			}
 			return
 		default:
 			errorf("unknown response for startup: %q", t)
@@ -653,6 +1518,10 @@ func (cn *conn) auth(r *readBuf, o values) {
 			errorf("unexpected authentication response: %q", t)
 		}
 	case 5:
+		if md5Forbidden() {
+			// crypto/md5 would panic
+			errorf("md5 authentication unavailable in FIPS mode; use SCRAM")
+		}
 		s := string(r.next(4))
 		w := cn.writeMessageType(message.Password)
 		w.string("md5" + md5s(md5s(o.Get("password")+o.Get("user"))+s))
@@ -666,11 +1535,52 @@ func (cn *conn) auth(r *readBuf, o values) {
 		if r.int32() != 0 {
 			errorf("unexpected authentication response: %q", t)
 		}
+	case 10:
+		tlsConn, _ := cn.c.(*tls.Conn)
+		sc, mechanism, err := newSCRAMClient(saslMechanisms(r), o.Get("password"), tlsConn)
+		if err != nil {
+			panic(err)
+		}
+		clientFirst, err := sc.clientFirst()
+		if err != nil {
+			panic(err)
+		}
+		w := cn.writeMessageType(message.Password)
+		w.string(mechanism)
+		w.int32(len(clientFirst))
+		w.bytes(clientFirst)
+		cn.send(w)
+
+		clientFinal, err := sc.clientFinal(cn.recvSASL(11))
+		if err != nil {
+			panic(err)
+		}
+		w = cn.writeMessageType(message.Password)
+		w.bytes(clientFinal)
+		cn.send(w)
+
+		if err := sc.verifyServerFinal(cn.recvSASL(12)); err != nil {
+			panic(err)
+		}
+		// AuthenticationOk follows, and is handled by startup
 	default:
 		errorf("unknown authentication response: %d", code)
 	}
 }
 
+// recvSASL receives the next step of a SASL exchange, which must have the
+// given authentication code, and returns its data.
+func (cn *conn) recvSASL(code int) []byte {
+	t, r := cn.recv()
+	if t != message.Authenticate {
+		errorf("unexpected SASL response: %q", t)
+	}
+	if c := r.int32(); c != code {
+		errorf("unexpected authentication response: %d", c)
+	}
+	return *r
+}
+
 func md5s(s string) string {
 	h := md5.New()
 	h.Write([]byte(s))
@@ -678,32 +1588,253 @@ func md5s(s string) string {
 }
 
 func (c *conn) processParameterStatus(r *readBuf) {
-	var err error
 	param := r.string()
+	val := r.string()
+	if c.runtimeParams == nil {
+		c.runtimeParams = make(map[string]string)
+	}
+	c.runtimeParams[param] = val
+
 	switch param {
 	case "server_version":
-		var major1 int
-		var major2 int
-		var minor int
-		_, err = fmt.Sscanf(r.string(), "%d.%d.%d", &major1, &major2, &minor)
-		if err == nil {
-			c.parameterStatus.serverVersion = major1*10000 + major2*100 + minor
+		if version, ok := parseServerVersion(val); ok {
+			c.parameterStatus.serverVersion = version
+		}
+	case "client_encoding":
+		// encode and decode only know UTF-8, so anything else would turn
+		// strings into garbage from here on
+		if !isUTF8(val) {
+			c.bad = fmt.Errorf("pq: client_encoding was changed to %q; only UTF8 is supported", val)
 		}
 	case "TimeZone":
-		c.parameterStatus.currentLocation, err = time.LoadLocation(r.string())
+		loc, err := loadServerLocation(val)
 		if err != nil {
-			c.parameterStatus.currentLocation = nil
+			if _, warned := unloadableZones.LoadOrStore(val, true); !warned {
+				c.hooks.warning(err)
+			}
 		}
+		c.parameterStatus.currentLocation = loc
 	default:
 		if TrafficLogging {
-			val := r.string()
 			log.Printf("Unhandled parameter status: %s = %s", param, val)
 		}
 	}
 }
 
+// utcZones are names of UTC, which needn't be looked up in a time zone
+// database.
+var utcZones = map[string]bool{
+	"UTC": true, "Etc/UTC": true, "UCT": true, "Etc/UCT": true,
+	"GMT": true, "Etc/GMT": true, "Greenwich": true, "Etc/Greenwich": true,
+	"Universal": true, "Etc/Universal": true, "Zulu": true, "Etc/Zulu": true,
+}
+
+// unloadableZones holds the names of the time zones that couldn't be loaded
+// and have been warned about, so that each is only warned about once, rather
+// than on every new connection.
+var unloadableZones sync.Map
+
+// loadServerLocation returns the location of the server's TimeZone, for
+// timestamptz values to be in, or nil if it can't be loaded; they are then
+// in fixed zones of their UTC offsets.  That is usually because the system
+// has no time zone database, as in minimal containers, so the error says how
+// to embed one.
+func loadServerLocation(name string) (*time.Location, error) {
+	loc, err := time.LoadLocation(name)
+	if err == nil {
+		return loc, nil
+	}
+	if utcZones[name] {
+		return time.FixedZone(name, 0), nil
+	}
+	return nil, fmt.Errorf("pq: cannot load the server's time zone %q (%v), so timestamptz values will only have their UTC offsets; "+
+		"if the system has no time zone database, import time/tzdata or build with -tags timetzdata to embed one", name, err)
+}
+
+// parseServerVersion converts a server_version string into the numeric form
+// of server_version_num.  Before 10, versions have three components
+// ("9.4.1" is 90401); since 10 they have two ("10.3" is 100003), and
+// development builds may report just the major version ("14devel").  Anything
+// after the version itself, such as " (Debian 10.3-1)", is ignored.
+func parseServerVersion(s string) (int, bool) {
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		s = s[:i]
+	}
+
+	var nums []int
+	for _, part := range strings.SplitN(s, ".", 3) {
+		// only the leading digits count, so "11beta2" is 11
+		end := 0
+		for end < len(part) && part[end] >= '0' && part[end] <= '9' {
+			end++
+		}
+		if end == 0 {
+			break
+		}
+		n, _ := strconv.Atoi(part[:end])
+		nums = append(nums, n)
+		if end < len(part) {
+			break
+		}
+	}
+	if len(nums) == 0 {
+		return 0, false
+	}
+	nums = append(nums, 0, 0)
+
+	if nums[0] >= 10 {
+		return nums[0]*10000 + nums[1], true
+	}
+	return nums[0]*10000 + nums[1]*100 + nums[2], true
+}
+
+// serverVersionAtLeast reports whether the server is known to be at least
+// the given version, in server_version_num form.  An unknown version is
+// assumed to be older.
+func (p *parameterStatus) serverVersionAtLeast(version int) bool {
+	return p.serverVersion >= version
+}
+
 func (c *conn) processReadyForQuery(r *readBuf) {
-	c.txnStatus = transactionStatus(r.byte())
+	c.txnStatus = TransactionStatus(r.byte())
+	c.lastUsed = time.Now()
+	switch c.txnStatus {
+	case TxnStatusIdle, TxnStatusIdleInTransaction, TxnStatusInFailedTransaction:
+	default:
+		// the connection is out of step with the server, or its stream is
+		// corrupt, so nothing more it reads can be trusted
+		c.bad = fmt.Errorf("pq: unexpected transaction status %v", c.txnStatus)
+	}
+}
+
+// parseDurationSetting parses a duration connection setting, which is
+// either a number of seconds, like libpq's connect_timeout, or a Go duration
+// such as "90s".
+func parseDurationSetting(name, v string) time.Duration {
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		errorf("invalid %s %q: must be a number of seconds or a duration", name, v)
+	}
+	return d
+}
+
+// parseBoolSetting parses a boolean connection setting, which, as with the
+// server's own boolean settings, may be on or off and yes or no as well as
+// true or false, in any case, or 1 or 0.
+func parseBoolSetting(name, v string) bool {
+	switch strings.ToLower(v) {
+	case "true", "t", "yes", "on", "1":
+		return true
+	case "false", "f", "no", "off", "0":
+		return false
+	}
+	errorf("invalid %s %q; expected true or false, yes or no, or on or off", name, v)
+	return false
+}
+
+// sessionDefaultValues holds the values the server accepts for the session
+// default settings that pq checks before connecting.
+var sessionDefaultValues = map[string][]string{
+	"default_transaction_isolation":  {"serializable", "repeatable read", "read committed", "read uncommitted"},
+	"default_transaction_read_only":  {"on", "off", "true", "false", "yes", "no", "1", "0"},
+	"default_transaction_deferrable": {"on", "off", "true", "false", "yes", "no", "1", "0"},
+}
+
+// checkSessionDefaults fails if one of the settings in sessionDefaultValues
+// has a value the server would reject.
+func checkSessionDefaults(o values) {
+	for name, valid := range sessionDefaultValues {
+		v := o.Get(name)
+		if v == "" {
+			continue
+		}
+		ok := false
+		for _, s := range valid {
+			if strings.EqualFold(strings.TrimSpace(v), s) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			errorf("invalid %s %q: must be one of %s", name, v, strings.Join(valid, ", "))
+		}
+	}
+}
+
+// parseSearchPath parses the search_path setting, a comma-separated list of
+// schema names, and returns the names quoted.  Like identifiers in SQL,
+// unquoted names are folded to lower case, and double-quoted ones are kept
+// as they are.
+func parseSearchPath(v string) []string {
+	var schemas []string
+	for i := 0; ; {
+		for i < len(v) && v[i] == ' ' {
+			i++
+		}
+		var name string
+		if i < len(v) && v[i] == '"' {
+			var b strings.Builder
+			for i++; ; i++ {
+				if i == len(v) {
+					errorf("invalid search_path %q: unterminated quoted identifier", v)
+				}
+				if v[i] == '"' {
+					if i+1 < len(v) && v[i+1] == '"' {
+						b.WriteByte('"')
+						i++
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteByte(v[i])
+			}
+			name = b.String()
+		} else {
+			j := i
+			for j < len(v) && isIdentChar(v, j) {
+				j++
+			}
+			name = strings.Map(func(r rune) rune {
+				if r >= 'A' && r <= 'Z' {
+					return r + 'a' - 'A'
+				}
+				return r
+			}, v[i:j])
+			if name != "" && (name[0] == '$' || name[0] >= '0' && name[0] <= '9') {
+				errorf("invalid search_path %q: %q is not an identifier", v, name)
+			}
+			i = j
+		}
+		if name == "" {
+			errorf("invalid search_path %q: expected a schema name at offset %d", v, i)
+		}
+		schemas = append(schemas, QuoteIdentifier(name))
+
+		for i < len(v) && v[i] == ' ' {
+			i++
+		}
+		if i == len(v) {
+			return schemas
+		}
+		if v[i] != ',' {
+			errorf("invalid search_path %q: unexpected %q at offset %d", v, v[i], i)
+		}
+		i++
+	}
+}
+
+// isSettingError reports whether e is the server rejecting a run-time
+// parameter sent at startup, as an unknown setting or a bad value for one.
+func isSettingError(e *Error) bool {
+	switch e.Code.Name() {
+	case "undefined_object", "invalid_parameter_value", "cant_change_runtime_param":
+		return true
+	}
+	return false
 }
 
 // parseEnviron tries to mimic some of libpq's environment handling
@@ -746,7 +1877,11 @@ func parseEnviron(env []string) (out map[string]string) {
 			accrue("user")
 		case "PGPASSWORD":
 			accrue("password")
-		case "PGPASSFILE", "PGSERVICE", "PGSERVICEFILE", "PGREALM":
+		case "PGSERVICE":
+			accrue("service")
+		case "PGSERVICEFILE", "PGSYSCONFDIR":
+			// read by serviceOptions
+		case "PGPASSFILE", "PGREALM":
 			unsupported()
 		case "PGOPTIONS":
 			accrue("options")
@@ -754,8 +1889,19 @@ func parseEnviron(env []string) (out map[string]string) {
 			accrue("application_name")
 		case "PGSSLMODE":
 			accrue("sslmode")
-		case "PGREQUIRESSL", "PGSSLCERT", "PGSSLKEY", "PGSSLROOTCERT", "PGSSLCRL":
-			unsupported()
+		case "PGREQUIRESSL":
+			// deprecated in favor of PGSSLMODE, which wins if both are set
+			if parts[1] == "1" && out["sslmode"] == "" {
+				out["sslmode"] = "require"
+			}
+		case "PGSSLCERT":
+			accrue("sslcert")
+		case "PGSSLKEY":
+			accrue("sslkey")
+		case "PGSSLROOTCERT":
+			accrue("sslrootcert")
+		case "PGSSLCRL":
+			accrue("sslcrl")
 		case "PGREQUIREPEER":
 			unsupported()
 		case "PGKRBSRVNAME", "PGGSSLIB":
@@ -770,7 +1916,7 @@ func parseEnviron(env []string) (out map[string]string) {
 			accrue("timezone")
 		case "PGGEQO":
 			accrue("geqo")
-		case "PGSYSCONFDIR", "PGLOCALEDIR":
+		case "PGLOCALEDIR":
 			unsupported()
 		}
 	}
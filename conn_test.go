@@ -1,13 +1,30 @@
 package pq
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"database/sql"
 	"database/sql/driver"
+	"encoding/pem"
 	"fmt"
+	"github.com/gregb/pq/message"
 	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
 	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 )
@@ -98,7 +115,7 @@ func TestCommitInFailedTransaction(t *testing.T) {
 	}
 	err = txn.Commit()
 	if err != ErrInFailedTransaction {
-		t.Fatal("expected ErrInFailedTransaction; got %#v", err)
+		t.Fatalf("expected ErrInFailedTransaction; got %#v", err)
 	}
 }
 func TestOpenURL(t *testing.T) {
@@ -165,6 +182,40 @@ func TestExec(t *testing.T) {
 	}
 }
 
+func TestExecMultipleStatements(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	_, err := db.Exec("CREATE TEMP TABLE temp (a int)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the count comes from the UPDATE, not the INSERT before it or the SET
+	// after it
+	r, err := db.Exec("INSERT INTO temp SELECT generate_series(1, 5); " +
+		"UPDATE temp SET a = a + 1 WHERE a > 3; " +
+		"SET search_path TO public")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := r.RowsAffected(); n != 2 {
+		t.Fatalf("expected 2 rows affected, not %d", n)
+	}
+
+	// rows from an earlier SELECT don't leak into the result
+	r, err = db.Exec("SELECT 42; DELETE FROM temp WHERE a = 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := r.RowsAffected(); n != 1 {
+		t.Fatalf("expected 1 row affected, not %d", n)
+	}
+	if id, err := r.LastInsertId(); err == nil {
+		t.Fatalf("expected no last insert id, got %d", id)
+	}
+}
+
 func TestRowsCloseBeforeDone(t *testing.T) {
 	db := openTestConn(t)
 	defer db.Close()
@@ -626,6 +677,22 @@ var envParseTests = []struct {
 		Env:      []string{"PGDATESTYLE=ISO, MDY"},
 		Expected: map[string]string{"datestyle": "ISO, MDY"},
 	},
+	{
+		Env:      []string{"PGREQUIRESSL=1"},
+		Expected: map[string]string{"sslmode": "require"},
+	},
+	{
+		Env:      []string{"PGSSLMODE=disable", "PGREQUIRESSL=1"},
+		Expected: map[string]string{"sslmode": "disable"},
+	},
+	{
+		Env:      []string{"PGREQUIRESSL=1", "PGSSLMODE=verify-full"},
+		Expected: map[string]string{"sslmode": "verify-full"},
+	},
+	{
+		Env:      []string{"PGSSLCERT=/a.crt", "PGSSLKEY=/a.key", "PGSSLROOTCERT=/ca.crt", "PGSSLCRL=/ca.crl"},
+		Expected: map[string]string{"sslcert": "/a.crt", "sslkey": "/a.key", "sslrootcert": "/ca.crt", "sslcrl": "/ca.crl"},
+	},
 }
 
 func TestParseEnviron(t *testing.T) {
@@ -661,6 +728,7 @@ func TestParseComplete(t *testing.T) {
 	tpc("FETCH 100", "FETCH", 100, false)
 	// allow COPY (and others) without row count
 	tpc("COPY", "COPY", 0, false)
+	tpc("CALL", "CALL", 0, false)
 	// don't fail on command tags we don't recognize
 	tpc("UNKNOWNCOMMANDTAG", "UNKNOWNCOMMANDTAG", 0, false)
 	// failure cases
@@ -860,6 +928,84 @@ func TestParseOpts(t *testing.T) {
 	}
 }
 
+func TestReadService(t *testing.T) {
+	const file = `
+# services
+[other]
+host=other.example.com
+
+[reports]
+host = replica.example.com
+ dbname=sales
+[last]
+port=6543
+`
+	o, err := readService(strings.NewReader(file), "pg_service.conf", "reports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := values{"host": "replica.example.com", "dbname": "sales"}
+	if !reflect.DeepEqual(o, expected) {
+		t.Errorf("expected %v, got %v", expected, o)
+	}
+
+	if o, err := readService(strings.NewReader(file), "pg_service.conf", "missing"); o != nil || err != nil {
+		t.Errorf("expected nothing for a missing service, got %v, %v", o, err)
+	}
+	if _, err := readService(strings.NewReader("[a]\nhost\n"), "pg_service.conf", "a"); err == nil {
+		t.Error("expected a syntax error")
+	}
+	if _, err := readService(strings.NewReader("[a]\nservice=b\n"), "pg_service.conf", "a"); err == nil {
+		t.Error("expected an error for a nested service")
+	}
+}
+
+func TestServiceOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	userFile := filepath.Join(dir, "user.conf")
+	if err := ioutil.WriteFile(userFile, []byte("[a]\nhost=127.0.0.1\nport=1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "pg_service.conf"), []byte("[a]\nport=2\n[b]\nport=3\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{"PGSERVICEFILE": userFile, "PGSYSCONFDIR": dir, "PGSERVICE": ""} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+
+	// the user's file wins, and the system's is read for what it lacks
+	if o, err := serviceOptions("a"); err != nil || o.Get("port") != "1" {
+		t.Errorf("expected port 1, got %v, %v", o, err)
+	}
+	if o, err := serviceOptions("b"); err != nil || o.Get("port") != "3" {
+		t.Errorf("expected port 3, got %v, %v", o, err)
+	}
+	if _, err := serviceOptions("c"); err == nil {
+		t.Error("expected an error for a missing service")
+	}
+
+	// explicit settings win over the service's, which win over PGSERVICE
+	// naming it
+	_, err = open(context.Background(), "service=a port=4 sslmode=disable", nil)
+	if err == nil || !strings.Contains(err.Error(), "127.0.0.1:4") {
+		t.Errorf("expected an error connecting to port 4, got %v", err)
+	}
+	os.Setenv("PGSERVICE", "a")
+	_, err = open(context.Background(), "sslmode=disable", nil)
+	if err == nil || !strings.Contains(err.Error(), "127.0.0.1:1") {
+		t.Errorf("expected an error connecting to port 1, got %v", err)
+	}
+	if _, err := open(context.Background(), "service=c", nil); err == nil || !strings.Contains(err.Error(), `"c" not found`) {
+		t.Errorf("expected an error for a missing service, got %v", err)
+	}
+}
+
 func TestRuntimeParameters(t *testing.T) {
 	type RuntimeTestResult int
 	const (
@@ -875,7 +1021,7 @@ func TestRuntimeParameters(t *testing.T) {
 		expectedOutcome RuntimeTestResult
 	}{
 		// invalid parameter
-		{"user=pqgotest password=pqgotest DOESNOTEXIST=foo", "", "", ResultBadConn},
+		{"user=pqgotest password=pqgotest DOESNOTEXIST=foo", "", "", ResultError},
 		// we can only work with a specific value for these two
 		{"user=pqgotest password=pqgotest client_encoding=SQL_ASCII", "", "", ResultError},
 		{"user=pqgotest password=pqgotest datestyle='ISO, YDM'", "", "", ResultPanic},
@@ -887,6 +1033,12 @@ func TestRuntimeParameters(t *testing.T) {
 		{"user=pqgotest password=pqgotest client_encoding=UTF8", "client_encoding", "UTF8", ResultSuccess},
 		// test a runtime parameter not supported by libpq
 		{"user=pqgotest password=pqgotest work_mem='139kB'", "work_mem", "139kB", ResultSuccess},
+		// session defaults shared by every connection
+		{"user=pqgotest password=pqgotest default_transaction_isolation='repeatable read'", "default_transaction_isolation", "repeatable read", ResultSuccess},
+		{"user=pqgotest password=pqgotest default_transaction_read_only=on", "transaction_read_only", "on", ResultSuccess},
+		{"user=pqgotest password=pqgotest default_transaction_isolation=snapshot", "", "", ResultError},
+		// rejected by the server rather than pq
+		{"user=pqgotest password=pqgotest timezone=Nowhere/Special", "", "", ResultError},
 	}
 	for _, test := range tests {
 		db, err := openTestConnConninfo(test.conninfo)
@@ -927,6 +1079,119 @@ func TestRuntimeParameters(t *testing.T) {
 	}
 }
 
+func TestCheckSessionDefaults(t *testing.T) {
+	valid := []values{
+		{},
+		{"default_transaction_isolation": "Read Committed"},
+		{"default_transaction_read_only": "on", "default_transaction_deferrable": "FALSE"},
+	}
+	for _, o := range valid {
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					t.Errorf("%v: unexpected error %v", o, p)
+				}
+			}()
+			checkSessionDefaults(o)
+		}()
+	}
+
+	invalid := []values{
+		{"default_transaction_isolation": "snapshot"},
+		{"default_transaction_read_only": "maybe"},
+		{"default_transaction_deferrable": "2"},
+	}
+	for _, o := range invalid {
+		func() {
+			defer func() {
+				if p := recover(); p == nil {
+					t.Errorf("%v: expected an error", o)
+				}
+			}()
+			checkSessionDefaults(o)
+		}()
+	}
+}
+
+func TestIsSettingError(t *testing.T) {
+	for code, expected := range map[ErrorCode]bool{
+		"42704": true,  // unrecognized configuration parameter
+		"22023": true,  // invalid value for parameter
+		"55P02": true,  // parameter cannot be changed now
+		"28P01": false, // password authentication failed
+		"3D000": false, // database does not exist
+		"53300": false, // too many connections
+	} {
+		if got := isSettingError(&Error{Code: code}); got != expected {
+			t.Errorf("%s: expected %v, got %v", code, expected, got)
+		}
+	}
+}
+
+func TestParseSearchPath(t *testing.T) {
+	valid := []struct {
+		in       string
+		expected []string
+	}{
+		{"app", []string{`"app"`}},
+		{"Tenant_42 , public", []string{`"tenant_42"`, `"public"`}},
+		{`"Tenant A","$user",pg_temp`, []string{`"Tenant A"`, `"$user"`, `"pg_temp"`}},
+		{`"a""b, c"`, []string{`"a""b, c"`}},
+	}
+	for _, tt := range valid {
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					t.Errorf("%q: unexpected error %v", tt.in, p)
+				}
+			}()
+			if got := parseSearchPath(tt.in); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%q: expected %v, got %v", tt.in, tt.expected, got)
+			}
+		}()
+	}
+
+	for _, s := range []string{"a,", ",a", "a b", `"a`, `""`, "$user", "1a", "a; DROP TABLE t"} {
+		func() {
+			defer func() {
+				if p := recover(); p == nil {
+					t.Errorf("%q: expected an error", s)
+				}
+			}()
+			parseSearchPath(s)
+		}()
+	}
+}
+
+func TestSearchPath(t *testing.T) {
+	db, err := openTestConnConninfo(`user=pqgotest password=pqgotest search_path='Tenant_42, "$user", public'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	var searchPath string
+	if err := db.QueryRow("SHOW search_path").Scan(&searchPath); err != nil {
+		t.Fatal(err)
+	}
+	expected := `tenant_42, "$user", public`
+	if searchPath != expected {
+		t.Errorf("Expected search_path %q after connecting, got %q", expected, searchPath)
+	}
+
+	// the next user of the pooled connection gets the setting back
+	if _, err := db.Exec("SET search_path TO other_tenant"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("SHOW search_path").Scan(&searchPath); err != nil {
+		t.Fatal(err)
+	}
+	if searchPath != expected {
+		t.Errorf("Expected search_path %q after a reset, got %q", expected, searchPath)
+	}
+}
+
 func Test_ExecReturnId(t *testing.T) {
 	db := openTestConn(t)
 	defer db.Close()
@@ -968,12 +1233,165 @@ func Test_ExecReturnId(t *testing.T) {
 		t.Errorf("Wrong value returned from from LastInsertId(): %d", id4)
 	}
 
-	if id5 != 4 {
+	// more than one column; no telling which is the id
+	if id5 != 0 {
 		t.Errorf("Wrong value returned from from LastInsertId(): %d", id5)
 	}
 
 }
 
+func TestExecReturnIdNotFirstColumn(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	_, err := db.Exec(`create temp table b (n int8, id bigserial)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := db.Exec(`insert into b(n) values (7) returning *`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := res.LastInsertId(); err == nil {
+		t.Errorf("expected no LastInsertId for RETURNING *, got %d", id)
+	}
+
+	res, err = db.Exec(`insert into b(n) values (8) returning id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := res.LastInsertId(); err != nil || id != 2 {
+		t.Errorf("expected LastInsertId 2, got %d (%v)", id, err)
+	}
+
+	res, err = db.Exec(`insert into b(n, id) values (9, 9223372036854775807) returning id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := res.LastInsertId(); err != nil || id != 9223372036854775807 {
+		t.Errorf("expected LastInsertId 9223372036854775807, got %d (%v)", id, err)
+	}
+}
+
+func TestCreateResult(t *testing.T) {
+	tests := []struct {
+		commandTag string
+		id         int64
+		ok         bool
+	}{
+		{"INSERT", 7, true},
+		{"UPDATE", 7, true},
+		{"DELETE", 7, true},
+		{"SELECT", 0, false},
+		{"FETCH", 0, false},
+	}
+	for _, tt := range tests {
+		res := createResult(tt.commandTag, 1, []string{"id"}, [][]driver.Value{{int64(7)}})
+		id, err := res.LastInsertId()
+		if id != tt.id || (err == nil) != tt.ok {
+			t.Errorf("%s: expected %d, %v, got %d, %v", tt.commandTag, tt.id, tt.ok, id, err)
+		}
+	}
+}
+
+func TestReturningExecAndQuery(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = c.ExecContext(ctx, "CREATE TEMP TABLE returning (id serial, name text)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const q = "INSERT INTO returning (name) VALUES ('a'), ('b') RETURNING id, name"
+
+	var execCols []string
+	var execRows [][]driver.Value
+	err = c.Raw(func(driverConn interface{}) error {
+		// without parameters the statement is run with the simple query
+		// protocol; with them, it is bound and executed
+		for _, args := range [][]driver.Value{nil, {int64(0)}} {
+			query := q
+			if args != nil {
+				query += ", $1::int"
+			}
+			st, err := driverConn.(driver.Conn).Prepare(query)
+			if err != nil {
+				return err
+			}
+			res, err := st.Exec(args)
+			st.Close()
+			if err != nil {
+				return err
+			}
+			cols, rows := res.(Result).Returned()
+			if len(rows) != 2 {
+				t.Errorf("%s: expected 2 rows, got %v", query, rows)
+			}
+			if args == nil {
+				execCols, execRows = cols, rows
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := c.QueryContext(ctx, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	queryCols, err := rows.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(queryCols, execCols) {
+		t.Errorf("expected columns %v, got %v", queryCols, execCols)
+	}
+	for i := 0; rows.Next(); i++ {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		// the rows of the second INSERT follow those of the first
+		expected := []driver.Value{id - 4, []byte(name)}
+		if i >= len(execRows) || !reflect.DeepEqual(execRows[i], expected) {
+			t.Errorf("row %d: expected %v from Exec, got %v", i, expected, execRows)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExecSelectNoLastInsertId(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	for _, q := range []string{"SELECT 42::int8", "SELECT 42::int8; SELECT 43::int8"} {
+		res, err := db.Exec(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id, err := res.LastInsertId(); err == nil {
+			t.Errorf("%s: expected no LastInsertId, got %d", q, id)
+		}
+		if n, err := res.RowsAffected(); err != nil || n != 1 {
+			t.Errorf("%s: expected 1 row affected, got %d (%v)", q, n, err)
+		}
+	}
+}
+
 func TestIsUTF8(t *testing.T) {
 	var cases = []struct {
 		name string
@@ -997,3 +1415,1221 @@ func TestIsUTF8(t *testing.T) {
 		}
 	}
 }
+
+func TestTrafficLoggingRedactsPassword(t *testing.T) {
+	const password = "tr4ff1c-s3cr3t"
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go io.Copy(ioutil.Discard, server)
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	TrafficLogging = true
+	defer func() {
+		TrafficLogging = false
+		log.SetOutput(os.Stderr)
+	}()
+
+	cn := &conn{c: client}
+	w := cn.writeMessageType(message.Password)
+	w.string(password)
+	cn.send(w)
+
+	w = cn.writeMessageType(message.Password)
+	w.string("md5" + md5s(md5s(password+"pqgotest")+"salt"))
+	cn.send(w)
+
+	if logged.Len() == 0 {
+		t.Fatal("expected traffic to be logged")
+	}
+	if strings.Contains(logged.String(), password) || strings.Contains(logged.String(), "md5") {
+		t.Errorf("password leaked into traffic log: %s", logged.String())
+	}
+}
+
+func TestCheckNamedValue(t *testing.T) {
+	cn := &conn{c: nil}
+	var _ driver.NamedValueChecker = cn
+
+	type myString string
+	str := "ok"
+	accepted := []interface{}{nil, int64(1), 1.5, true, []byte("x"), "x", time.Now()}
+	skipped := []interface{}{1, uint32(1), myString("x"), &str, []int64{1}, &[]string{"a"}, NullTime{}}
+	rejected := []interface{}{complex(1, 2), []complex128{1}, map[string]int{}, make(chan int), struct{}{}}
+
+	for _, v := range accepted {
+		if err := cn.CheckNamedValue(&driver.NamedValue{Ordinal: 1, Value: v}); err != nil {
+			t.Errorf("%T: expected no error, got %v", v, err)
+		}
+	}
+	for _, v := range skipped {
+		if err := cn.CheckNamedValue(&driver.NamedValue{Ordinal: 1, Value: v}); err != driver.ErrSkip {
+			t.Errorf("%T: expected driver.ErrSkip, got %v", v, err)
+		}
+	}
+	for _, v := range rejected {
+		err := cn.CheckNamedValue(&driver.NamedValue{Ordinal: 2, Value: v})
+		if err == nil {
+			t.Errorf("%T: expected an error", v)
+			continue
+		}
+		if !strings.Contains(err.Error(), fmt.Sprintf("%T", v)) || !strings.Contains(err.Error(), "$2") {
+			t.Errorf("%T: error should name the type and parameter: %v", v, err)
+		}
+	}
+}
+
+func TestUnsupportedArgumentType(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	_, err := db.Exec("SELECT $1::text", complex(1, 2))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(err.Error(), "complex128") {
+		t.Errorf("expected error to mention complex128, got %v", err)
+	}
+
+	// the connection must still be usable
+	var n int
+	if err = db.QueryRow("SELECT 1").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadServerLocation(t *testing.T) {
+	if loc, err := loadServerLocation("UTC"); err != nil || loc == nil || loc.String() != "UTC" {
+		t.Errorf("unexpected location %v, %v for UTC", loc, err)
+	}
+	// needs no time zone database
+	if loc, err := loadServerLocation("Etc/UTC"); err != nil || loc == nil {
+		t.Errorf("expected a location for Etc/UTC, got %v", err)
+	} else if _, offset := time.Unix(0, 0).In(loc).Zone(); offset != 0 {
+		t.Errorf("unexpected offset %d for Etc/UTC", offset)
+	}
+
+	if loc, err := loadServerLocation("Nowhere/Atlantis"); loc != nil || err == nil {
+		t.Errorf("unexpected location %v, %v", loc, err)
+	}
+
+	// without hooks, the warning is logged, once for each zone however many
+	// connections see it
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	for i := 0; i < 2; i++ {
+		r := readBuf("TimeZone\x00Nowhere/Atlantis\x00")
+		(&conn{}).processParameterStatus(&r)
+	}
+	if n := strings.Count(logged.String(), `"Nowhere/Atlantis"`); n != 1 {
+		t.Errorf("expected a single warning, got %q", logged.String())
+	}
+
+	// with OnWarning set, it goes there instead, also just once
+	var warnings []error
+	hooks := &Hooks{OnWarning: func(err error) { warnings = append(warnings, err) }}
+	for i := 0; i < 2; i++ {
+		r := readBuf("TimeZone\x00Nowhere/Lemuria\x00")
+		(&conn{hooks: hooks}).processParameterStatus(&r)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Error(), `"Nowhere/Lemuria"`) {
+		t.Errorf("expected a single warning, got %v", warnings)
+	}
+	if strings.Contains(logged.String(), "Lemuria") {
+		t.Errorf("unexpected logged warning %q", logged.String())
+	}
+}
+
+func TestParseServerVersion(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected int
+		ok       bool
+	}{
+		{"9.4.1", 90401, true},
+		{"8.4", 80400, true},
+		{"10.3", 100003, true},
+		{"12", 120000, true},
+		{"15.2", 150002, true},
+		{"10.3 (Debian 10.3-1.pgdg90+1)", 100003, true},
+		{"11beta2", 110000, true},
+		{"14devel", 140000, true},
+		{"9.6rc1", 90600, true},
+		{"", 0, false},
+		{"x.y", 0, false},
+	}
+
+	for _, tt := range tests {
+		version, ok := parseServerVersion(tt.in)
+		if ok != tt.ok || version != tt.expected {
+			t.Errorf("parseServerVersion(%q) = %d, %v; expected %d, %v", tt.in, version, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+func TestRecvMessageReusesReadBuffer(t *testing.T) {
+	var in bytes.Buffer
+	for _, size := range []int{2000, 1000, maxRetainedReadBuffer + 1} {
+		w := writeBuf([]byte{byte(message.DataRow)})
+		w.int32(size + 4)
+		w.bytes(make([]byte, size))
+		in.Write(w)
+	}
+	cn := &conn{buf: bufio.NewReader(&in)}
+
+	_, r, err := cn.recvMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := &(*r)[0]
+
+	_, r, err = cn.recvMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*r) != 1000 {
+		t.Fatalf("Expected a 1000 byte message, got %d bytes", len(*r))
+	}
+	if &(*r)[0] != first {
+		t.Error("Expected the read buffer to be reused for a smaller message")
+	}
+
+	_, r, err = cn.recvMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*r) != maxRetainedReadBuffer+1 {
+		t.Fatalf("Expected a %d byte message, got %d bytes", maxRetainedReadBuffer+1, len(*r))
+	}
+	if cap(cn.rbuf) != 2000 {
+		t.Errorf("Expected a message over the limit not to be retained, buffer is %d bytes", cap(cn.rbuf))
+	}
+}
+
+func TestSSLConfig(t *testing.T) {
+	tlsConf := sslConfig(values{"sslmode": "disable", "tls_server_name": "sni.example.com"})
+	if tlsConf != nil {
+		t.Errorf("Expected no TLS config with sslmode=disable, got %+v", tlsConf)
+	}
+
+	tlsConf = sslConfig(values{"host": "db.example.com", "tls_server_name": "sni.example.com"})
+	if tlsConf.ServerName != "sni.example.com" || !tlsConf.InsecureSkipVerify {
+		t.Errorf("Unexpected TLS config for sslmode=require: %+v", tlsConf)
+	}
+
+	tlsConf = sslConfig(values{"host": "db.example.com", "sslmode": "verify-full"})
+	if tlsConf.ServerName != "db.example.com" || tlsConf.InsecureSkipVerify || tlsConf.VerifyConnection != nil {
+		t.Errorf("Unexpected TLS config for sslmode=verify-full: %+v", tlsConf)
+	}
+
+	// the certificate is verified against the host, not the SNI name
+	tlsConf = sslConfig(values{"host": "db.example.com", "sslmode": "verify-full", "tls_server_name": "sni.example.com"})
+	if tlsConf.ServerName != "sni.example.com" || tlsConf.VerifyConnection == nil {
+		t.Fatalf("Unexpected TLS config for sslmode=verify-full with tls_server_name: %+v", tlsConf)
+	}
+	if err := tlsConf.VerifyConnection(tls.ConnectionState{}); err == nil {
+		t.Error("Expected verification to fail without a certificate")
+	}
+}
+
+func TestSSLVerifyCA(t *testing.T) {
+	newCert := func(template, parent *x509.Certificate, key *ecdsa.PrivateKey) *x509.Certificate {
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pq test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	ca := newCert(caTemplate, caTemplate, key)
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "db.example.com"},
+		DNSNames:     []string{"db.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	server := newCert(serverTemplate, ca, key)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	untrusted := newCert(serverTemplate, serverTemplate, otherKey)
+
+	f, err := ioutil.TempFile("", "pq-sslrootcert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+	f.Close()
+
+	// the certificate is for another name than host, which verify-full
+	// would refuse
+	tlsConf := sslConfig(values{"host": "10.0.0.1", "sslmode": "verify-ca", "sslrootcert": f.Name()})
+	if !tlsConf.InsecureSkipVerify || tlsConf.VerifyConnection == nil {
+		t.Fatalf("Unexpected TLS config for sslmode=verify-ca: %+v", tlsConf)
+	}
+	if err := tlsConf.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{server}}); err != nil {
+		t.Errorf("Expected the certificate to be accepted, got %v", err)
+	}
+	if err := tlsConf.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{untrusted}}); err == nil {
+		t.Error("Expected a certificate from another authority to be rejected")
+	}
+	if err := verifyCertificateHost("10.0.0.1", tlsConf.RootCAs)(tls.ConnectionState{PeerCertificates: []*x509.Certificate{server}}); err == nil {
+		t.Error("Expected verify-full to reject the certificate for another name")
+	}
+}
+
+// Does not access database, simply tests that verify-full accepts a server
+// whose certificate is signed by the private CA in sslrootcert, and only
+// that one
+func TestSSLRootCertHandshake(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pq test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "db.example.com"},
+		DNSNames:     []string{"db.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeRoots := func(certs ...[]byte) string {
+		f, err := ioutil.TempFile("", "pq-sslrootcert")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, der := range certs {
+			pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+		}
+		f.Close()
+		return f.Name()
+	}
+	// a TCP connection rather than net.Pipe, whose unbuffered writes would
+	// deadlock the two ends once the client rejects the server
+	handshake := func(o values) error {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		go func() {
+			server, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer server.Close()
+			tls.Server(server, &tls.Config{
+				Certificates: []tls.Certificate{{Certificate: [][]byte{serverDER}, PrivateKey: key}},
+			}).Handshake()
+		}()
+		client, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		return tls.Client(client, sslConfig(o)).Handshake()
+	}
+
+	roots := writeRoots(caDER)
+	defer os.Remove(roots)
+	if err := handshake(values{"host": "db.example.com", "sslmode": "verify-full", "sslrootcert": roots}); err != nil {
+		t.Errorf("Expected the handshake to succeed, got %v", err)
+	}
+
+	// without the CA, the server isn't trusted
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &otherKey.PublicKey, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRoots := writeRoots(otherDER)
+	defer os.Remove(otherRoots)
+	if err := handshake(values{"host": "db.example.com", "sslmode": "verify-full", "sslrootcert": otherRoots}); err == nil {
+		t.Error("Expected the handshake to fail with another CA in sslrootcert")
+	}
+}
+
+func TestSSLConfigFiles(t *testing.T) {
+	expectPanic := func(o values) {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Expected %v to panic", o)
+			}
+		}()
+		sslConfig(o)
+	}
+	expectPanic(values{"sslcert": "/no/such/client.crt"})
+	expectPanic(values{"sslcert": "/no/such/client.crt", "sslkey": "/no/such/client.key"})
+	expectPanic(values{"sslmode": "verify-full", "sslrootcert": "/no/such/root.crt"})
+
+	f, err := ioutil.TempFile("", "pq-sslrootcert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+	expectPanic(values{"sslmode": "verify-full", "sslrootcert": f.Name()})
+
+	// sslrootcert only matters when verifying the server
+	tlsConf := sslConfig(values{"sslrootcert": f.Name()})
+	if tlsConf.RootCAs != nil {
+		t.Errorf("Unexpected root certificates with sslmode=require")
+	}
+}
+
+func TestSSLRevocationCheck(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pq test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCert := func(serial int64) []byte {
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "db.example.com"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}, ca, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+
+	crlDER, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		RevokedCertificateEntries: []x509.RevocationListEntry{{SerialNumber: big.NewInt(2), RevocationTime: time.Now()}},
+	}, ca, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "pq-sslcrl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	pem.Encode(f, &pem.Block{Type: "X509 CRL", Bytes: crlDER})
+	f.Close()
+
+	tlsConf := sslConfig(values{"host": "db.example.com", "sslmode": "verify-full", "sslcrl": f.Name()})
+	if tlsConf.VerifyPeerCertificate == nil {
+		t.Fatal("Expected a revocation check with sslcrl")
+	}
+	if err := tlsConf.VerifyPeerCertificate([][]byte{serverCert(2), caDER}, nil); err == nil {
+		t.Error("Expected the revoked certificate to be rejected")
+	}
+	if err := tlsConf.VerifyPeerCertificate([][]byte{serverCert(3), caDER}, nil); err != nil {
+		t.Errorf("Expected the certificate to be accepted, got %v", err)
+	}
+
+	// a file of several CRLs, the second of them from the server's CA, and
+	// one in DER form
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherTemplate := *caTemplate
+	otherTemplate.Subject = pkix.Name{CommonName: "pq other CA"}
+	otherDER, err := x509.CreateCertificate(rand.Reader, &otherTemplate, &otherTemplate, &otherKey.PublicKey, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := x509.ParseCertificate(otherDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCRLDER, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		RevokedCertificateEntries: []x509.RevocationListEntry{{SerialNumber: big.NewInt(3), RevocationTime: time.Now()}},
+	}, other, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var both bytes.Buffer
+	pem.Encode(&both, &pem.Block{Type: "X509 CRL", Bytes: otherCRLDER})
+	pem.Encode(&both, &pem.Block{Type: "X509 CRL", Bytes: crlDER})
+	for _, content := range [][]byte{both.Bytes(), crlDER} {
+		ioutil.WriteFile(f.Name(), content, 0600)
+		tlsConf = sslConfig(values{"host": "db.example.com", "sslmode": "verify-full", "sslcrl": f.Name()})
+		if err := tlsConf.VerifyPeerCertificate([][]byte{serverCert(2), caDER}, nil); err == nil {
+			t.Error("Expected the revoked certificate to be rejected")
+		}
+		// serial 3 is only revoked by the other CA
+		if err := tlsConf.VerifyPeerCertificate([][]byte{serverCert(3), caDER}, nil); err != nil {
+			t.Errorf("Expected the certificate to be accepted, got %v", err)
+		}
+	}
+
+	// a CRL claiming to be the CA's, but signed by another key, isn't
+	// trusted, and neither is the certificate it would be checked for
+	forgedTemplate := otherTemplate
+	forgedTemplate.Subject = caTemplate.Subject
+	forgedDER, err := x509.CreateCertificate(rand.Reader, &forgedTemplate, &forgedTemplate, &otherKey.PublicKey, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := x509.ParseCertificate(forgedDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgedCRLDER, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{Number: big.NewInt(2)}, forged, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(f.Name(), forgedCRLDER, 0600)
+	tlsConf = sslConfig(values{"host": "db.example.com", "sslmode": "verify-full", "sslcrl": f.Name()})
+	if err := tlsConf.VerifyPeerCertificate([][]byte{serverCert(3), caDER}, nil); err == nil {
+		t.Error("Expected a CRL with a bad signature to be refused")
+	}
+
+	// the CA that signed a CRL can also come from sslrootcert
+	ioutil.WriteFile(f.Name(), crlDER, 0600)
+	root, err := ioutil.TempFile("", "pq-sslrootcert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(root.Name())
+	pem.Encode(root, &pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	root.Close()
+	tlsConf = sslConfig(values{"host": "db.example.com", "sslmode": "verify-full", "sslcrl": f.Name(), "sslrootcert": root.Name()})
+	if err := tlsConf.VerifyPeerCertificate([][]byte{serverCert(2)}, nil); err == nil {
+		t.Error("Expected the revoked certificate to be rejected")
+	}
+	if err := tlsConf.VerifyPeerCertificate([][]byte{serverCert(3)}, nil); err != nil {
+		t.Errorf("Expected the certificate to be accepted, got %v", err)
+	}
+
+	// a CRL file that isn't one
+	ioutil.WriteFile(f.Name(), []byte("not a CRL"), 0600)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected an unparseable sslcrl to panic")
+			}
+		}()
+		sslConfig(values{"sslmode": "verify-full", "sslcrl": f.Name()})
+	}()
+}
+
+func TestClientEncodingChange(t *testing.T) {
+	cn := &conn{}
+	r := readBuf("client_encoding\x00UTF8\x00")
+	cn.processParameterStatus(&r)
+	if !cn.IsValid() {
+		t.Fatal("Expected the connection to be valid with UTF8")
+	}
+
+	r = readBuf("client_encoding\x00LATIN1\x00")
+	cn.processParameterStatus(&r)
+	if cn.IsValid() {
+		t.Fatal("Expected the connection to be invalid after a change to LATIN1")
+	}
+
+	// fails before anything is sent to the (missing) server
+	_, _, err := cn.simpleExec("SELECT 1")
+	if err == nil || !strings.Contains(err.Error(), "client_encoding") {
+		t.Errorf("Expected a client_encoding error, got %v", err)
+	}
+}
+
+func TestClientEncodingChangeFromDb(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	txn, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Rollback()
+
+	_, err = txn.Exec("SET client_encoding = 'LATIN1'")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s string
+	err = txn.QueryRow("SELECT 'Héllö'").Scan(&s)
+	if err == nil || !strings.Contains(err.Error(), `client_encoding was changed to "LATIN1"`) {
+		t.Fatalf("Expected a client_encoding error, got %v (%q)", err, s)
+	}
+}
+
+func TestParseDurationSetting(t *testing.T) {
+	if d := parseDurationSetting("max_idle_time", "30"); d != 30*time.Second {
+		t.Errorf("Expected 30s, got %v", d)
+	}
+	if d := parseDurationSetting("max_idle_time", "5m"); d != 5*time.Minute {
+		t.Errorf("Expected 5m, got %v", d)
+	}
+
+	var err error
+	func() {
+		defer errRecover(&err)
+		parseDurationSetting("max_idle_time", "soon")
+	}()
+	if err == nil {
+		t.Error("Expected an error for an invalid duration")
+	}
+}
+
+func TestParseBoolSetting(t *testing.T) {
+	for _, v := range []string{"true", "TRUE", "t", "yes", "Yes", "on", "1"} {
+		if !parseBoolSetting("binary_parameters", v) {
+			t.Errorf("Expected %q to be true", v)
+		}
+	}
+	for _, v := range []string{"false", "f", "no", "NO", "off", "0"} {
+		if parseBoolSetting("binary_parameters", v) {
+			t.Errorf("Expected %q to be false", v)
+		}
+	}
+
+	var err error
+	func() {
+		defer errRecover(&err)
+		parseBoolSetting("binary_parameters", "maybe")
+	}()
+	if err == nil || !strings.Contains(err.Error(), `invalid binary_parameters "maybe"`) {
+		t.Errorf("Expected an error for an invalid boolean, got %v", err)
+	}
+
+	// the settings are accepted when connecting; only the dial fails
+	for _, v := range []string{"yes", "no", "on", "off"} {
+		_, err := open(context.Background(), "host=127.0.0.1 port=1 sslmode=disable binary_parameters="+v, nil)
+		if err == nil || strings.Contains(err.Error(), "invalid binary_parameters") {
+			t.Errorf("Expected binary_parameters=%s to be accepted, got %v", v, err)
+		}
+	}
+}
+
+func TestResetSessionPingsIdleConnection(t *testing.T) {
+	client, server := net.Pipe()
+	server.Close()
+	cn := &conn{c: client, buf: bufio.NewReader(client), maxIdleTime: time.Minute}
+
+	// recently used connections aren't checked
+	cn.lastUsed = time.Now()
+	if err := cn.ResetSession(context.Background()); err != nil {
+		t.Fatalf("Expected no error for a recently used connection, got %v", err)
+	}
+
+	cn.lastUsed = time.Now().Add(-2 * time.Minute)
+	if err := cn.ResetSession(context.Background()); err != driver.ErrBadConn {
+		t.Fatalf("Expected %v for a dead idle connection, got %v", driver.ErrBadConn, err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	db, err := openTestConnConninfo("user=pqgotest password=pqgotest max_idle_time=1ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// the idle connection is pinged and reused
+	var one int
+	if err := db.QueryRow("SELECT 1").Scan(&one); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchCancelAbandonsConnection(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	// nothing listens on port 1, so the cancel request goes nowhere and the
+	// connection has to be abandoned once the grace period is up
+	cn := &conn{
+		c:                 client,
+		opts:              values{"host": "127.0.0.1", "port": "1", "sslmode": "disable"},
+		cancelGracePeriod: 50 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	finish := cn.watchCancel(ctx)
+	var b [1]byte
+	if _, err := client.Read(b[:]); err == nil {
+		t.Fatal("expected the read to fail once the connection was closed")
+	}
+	if err := finish(); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if cn.IsValid() {
+		t.Fatal("expected the connection to be marked bad")
+	}
+}
+
+func TestCancelGivesUp(t *testing.T) {
+	// a server that takes the cancel request but never closes the
+	// connection
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(ioutil.Discard, c)
+	}()
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	cn := &conn{
+		opts:              values{"host": host, "port": port, "sslmode": "disable"},
+		cancelGracePeriod: 50 * time.Millisecond,
+	}
+	done := make(chan error, 1)
+	go func() { done <- cn.cancel() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancel did not give up after the grace period")
+	}
+}
+
+func TestWatchCancelFinishedInTime(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	cn := &conn{c: client, cancelGracePeriod: time.Second}
+	finish := cn.watchCancel(context.Background())
+	if err := finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finish = cn.watchCancel(ctx)
+	if err := finish(); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if !cn.IsValid() {
+		t.Fatal("expected the connection to still be usable")
+	}
+}
+
+func TestTxModes(t *testing.T) {
+	tests := []struct {
+		level    sql.IsolationLevel
+		readOnly bool
+		expected string
+	}{
+		{sql.LevelDefault, false, ""},
+		{sql.LevelDefault, true, " READ ONLY"},
+		{sql.LevelReadUncommitted, false, " ISOLATION LEVEL READ UNCOMMITTED"},
+		{sql.LevelReadCommitted, false, " ISOLATION LEVEL READ COMMITTED"},
+		{sql.LevelSnapshot, false, " ISOLATION LEVEL REPEATABLE READ"},
+		{sql.LevelSerializable, true, " ISOLATION LEVEL SERIALIZABLE READ ONLY"},
+	}
+	for _, tt := range tests {
+		modes, err := txModes(driver.TxOptions{Isolation: driver.IsolationLevel(tt.level), ReadOnly: tt.readOnly})
+		if err != nil {
+			t.Errorf("%v: %v", tt.level, err)
+		} else if modes != tt.expected {
+			t.Errorf("%v, read-only %v: expected %q, got %q", tt.level, tt.readOnly, tt.expected, modes)
+		}
+	}
+
+	for _, level := range []sql.IsolationLevel{sql.LevelWriteCommitted, sql.LevelLinearizable} {
+		if _, err := txModes(driver.TxOptions{Isolation: driver.IsolationLevel(level)}); err == nil {
+			t.Errorf("expected an error for %v", level)
+		}
+	}
+}
+
+func TestContextCancelExec(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := db.ExecContext(ctx, "SELECT pg_sleep(10)")
+	if pqErr, ok := err.(*Error); !ok || pqErr.Code != "57014" {
+		t.Fatalf("expected a query_canceled error, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("the cancel took %v", d)
+	}
+
+	// the same connection is ready for the next query
+	if _, err := db.ExecContext(context.Background(), "SELECT $1::int", 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContextBeginTx(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("CREATE TEMP TABLE temp (a int)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// database/sql rolls the transaction back once ctx is done
+	cancel()
+	if err := tx.Commit(); err != sql.ErrTxDone {
+		t.Fatalf("expected %v, got %v", sql.ErrTxDone, err)
+	}
+	if _, err := db.Exec("SELECT * FROM temp"); err == nil {
+		t.Fatal("expected the table to have been rolled back")
+	}
+
+	tx, err = db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	var level, readOnly string
+	if err := tx.QueryRow("SELECT current_setting('transaction_isolation'), current_setting('transaction_read_only')").Scan(&level, &readOnly); err != nil {
+		t.Fatal(err)
+	}
+	if level != "repeatable read" || readOnly != "on" {
+		t.Errorf("expected a read-only repeatable read transaction, got %s, %s", level, readOnly)
+	}
+}
+
+func TestTransactionStatus(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	c, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	status := func() (ts TransactionStatus) {
+		err := c.Raw(func(driverConn interface{}) error {
+			ts = driverConn.(interface{ TransactionStatus() TransactionStatus }).TransactionStatus()
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	exec := func(q string) {
+		c.ExecContext(context.Background(), q)
+	}
+
+	if ts := status(); ts != TxnStatusIdle {
+		t.Errorf("Expected %v, got %v", TxnStatusIdle, ts)
+	}
+	exec("BEGIN")
+	if ts := status(); ts != TxnStatusIdleInTransaction {
+		t.Errorf("Expected %v, got %v", TxnStatusIdleInTransaction, ts)
+	}
+	exec("SELECT * FROM no_such_table")
+	if ts := status(); ts != TxnStatusInFailedTransaction {
+		t.Errorf("Expected %v, got %v", TxnStatusInFailedTransaction, ts)
+	}
+	exec("ROLLBACK")
+	if ts := status(); ts != TxnStatusIdle {
+		t.Errorf("Expected %v, got %v", TxnStatusIdle, ts)
+	}
+}
+
+func TestUnexpectedCommandTag(t *testing.T) {
+	// the server answers BEGIN as though it were a SELECT
+	const response = "C\x00\x00\x00\x0dSELECT 0\x00" +
+		"Z\x00\x00\x00\x05T"
+	cn := fakeConn(response, 0)
+	cn.txnStatus = TxnStatusIdle
+
+	_, err := cn.Begin()
+	expected := CommandTagError{Tag: "SELECT", Expected: "BEGIN"}
+	if err != expected {
+		t.Fatalf("Expected %v, got %v", expected, err)
+	}
+	if cn.IsValid() {
+		t.Fatal("Expected the connection to be discarded")
+	}
+	if _, _, err := cn.simpleExec("SELECT 1"); err != expected {
+		t.Fatalf("Expected the connection to refuse queries with %v, got %v", expected, err)
+	}
+}
+
+func TestRelaxedCommandTags(t *testing.T) {
+	// a pooler answers BEGIN as though it were a SELECT, but the transaction
+	// status is right
+	cn := fakeConn("C\x00\x00\x00\x0dSELECT 0\x00"+
+		"Z\x00\x00\x00\x05T", 0)
+	cn.txnStatus = TxnStatusIdle
+	cn.relaxedCommandTags = true
+
+	if _, err := cn.Begin(); err != nil {
+		t.Fatalf("Expected BEGIN to succeed, got %v", err)
+	}
+	if !cn.IsValid() {
+		t.Fatal("Expected the connection to stay valid")
+	}
+
+	// the transaction status is still checked
+	cn = fakeConn("C\x00\x00\x00\x0aBEGIN\x00"+
+		"Z\x00\x00\x00\x05I", 0)
+	cn.txnStatus = TxnStatusIdle
+	cn.relaxedCommandTags = true
+	if _, err := cn.Begin(); err == nil {
+		t.Fatal("Expected an error for an idle transaction status after BEGIN")
+	}
+}
+
+func TestUnknownTransactionStatus(t *testing.T) {
+	if s := TransactionStatus('X').String(); s != "unknown(88)" {
+		t.Errorf("Unexpected string %q", s)
+	}
+
+	cn := fakeConn("Z\x00\x00\x00\x05X", 0)
+	_, r := cn.recv1()
+	cn.processReadyForQuery(r)
+	if cn.IsValid() {
+		t.Fatal("Expected the connection to be discarded")
+	}
+	if _, _, err := cn.simpleExec("SELECT 1"); err == nil || !strings.Contains(err.Error(), "unknown(88)") {
+		t.Fatalf("Expected the connection to refuse queries, got %v", err)
+	}
+}
+
+func TestFatalError(t *testing.T) {
+	// the server ends a transaction left idle too long and closes the
+	// connection, which can't be retried on another
+	cn := fakeConn("E\x00\x00\x00\x4eSFATAL\x00C25P03\x00Mterminating connection due to idle-in-transaction timeout\x00\x00", 0)
+	_, _, err := cn.simpleExec("SELECT 1")
+	if e, ok := err.(*Error); !ok || e.Code != ErrCodeIdleInTransactionSessionTimeout {
+		t.Fatalf("Expected an idle_in_transaction_session_timeout error, got %v", err)
+	}
+	if cn.IsValid() {
+		t.Fatal("Expected the connection to be discarded")
+	}
+
+	// other FATAL errors are bad connections, for database/sql to retry
+	cn = fakeConn("E\x00\x00\x00\x48SFATAL\x00C57P01\x00Mterminating connection due to administrator command\x00\x00", 0)
+	if _, _, err := cn.simpleExec("SELECT 1"); err != driver.ErrBadConn {
+		t.Fatalf("Expected %v, got %v", driver.ErrBadConn, err)
+	}
+	if cn.IsValid() {
+		t.Fatal("Expected the connection to be discarded")
+	}
+}
+
+func TestInvalidSessionTimeout(t *testing.T) {
+	for _, conninfo := range []string{"lock_timeout=1s", "idle_in_transaction_session_timeout=-1"} {
+		_, err := open(context.Background(), "host=127.0.0.1 port=1 "+conninfo, nil)
+		if err == nil || !strings.Contains(err.Error(), "expected a number of milliseconds") {
+			t.Errorf("%s: expected an invalid setting error, got %v", conninfo, err)
+		}
+	}
+}
+
+func TestRole(t *testing.T) {
+	db, err := openTestConnConninfo("user=pqgotest password=pqgotest role=pqgotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var role string
+	if err := db.QueryRow("SELECT current_user").Scan(&role); err != nil {
+		t.Fatal(err)
+	}
+	if role != "pqgotest" {
+		t.Errorf("Expected role pqgotest, got %q", role)
+	}
+
+	// the next user of the pooled connection gets the setting back
+	if _, err := db.Exec("SET ROLE NONE"); err != nil {
+		t.Fatal(err)
+	}
+	var setting string
+	if err := db.QueryRow("SHOW role").Scan(&setting); err != nil {
+		t.Fatal(err)
+	}
+	if setting != "pqgotest" {
+		t.Errorf("Expected role pqgotest after a reset, got %q", setting)
+	}
+
+	var member bool
+	if err := db.QueryRow("SELECT pg_has_role('pg_monitor', 'MEMBER')").Scan(&member); err != nil {
+		t.Fatal(err)
+	}
+	if member {
+		return
+	}
+	other, err := openTestConnConninfo("user=pqgotest password=pqgotest role=pg_monitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	err = other.Ping()
+	if e, ok := err.(*Error); !ok || e.Code != ErrCodeInsufficientPrivilege {
+		t.Fatalf("Expected an insufficient_privilege error, got %v", err)
+	}
+}
+
+func TestInvalidRole(t *testing.T) {
+	_, err := open(context.Background(), "host=127.0.0.1 port=1 role='a\x00b'", nil)
+	if err == nil || !strings.Contains(err.Error(), "invalid role") {
+		t.Errorf("expected an invalid role error, got %v", err)
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	txn, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Rollback()
+	if _, err := txn.Exec("SELECT pg_advisory_xact_lock(4242)"); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := openTestConnConninfo("user=pqgotest password=pqgotest lock_timeout=100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	_, err = other.Exec("SELECT pg_advisory_lock(4242)")
+	if e, ok := err.(*Error); !ok || e.Code != ErrCodeLockNotAvailable {
+		t.Fatalf("Expected a lock_not_available error, got %v", err)
+	}
+
+	// the next user of the pooled connection gets the setting back
+	if _, err := other.Exec("SET lock_timeout TO 0"); err != nil {
+		t.Fatal(err)
+	}
+	var lockTimeout string
+	if err := other.QueryRow("SHOW lock_timeout").Scan(&lockTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if lockTimeout != "100ms" {
+		t.Errorf("Expected lock_timeout 100ms after a reset, got %q", lockTimeout)
+	}
+}
+
+func TestIdleInTransactionSessionTimeout(t *testing.T) {
+	db, err := openTestConnConninfo("user=pqgotest password=pqgotest idle_in_transaction_session_timeout=100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	txn, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Rollback()
+	if _, err := txn.Exec("SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	_, err = txn.Exec("SELECT 1")
+	if e, ok := err.(*Error); !ok || e.Code != ErrCodeIdleInTransactionSessionTimeout {
+		t.Fatalf("Expected an idle_in_transaction_session_timeout error, got %v", err)
+	}
+
+	// the connection is replaced
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMD5Auth(t *testing.T) {
+	auth := func() (err error) {
+		defer errRecover(&err)
+		cn := fakeConn("R\x00\x00\x00\x08\x00\x00\x00\x00", 0)
+		r := readBuf("\x00\x00\x00\x05salt")
+		cn.auth(&r, values{"user": "pqgotest", "password": "pqgotest"})
+		return nil
+	}
+	if err := auth(); err != nil && !md5Forbidden() {
+		t.Fatal(err)
+	}
+
+	defer func(f func() bool) { md5Forbidden = f }(md5Forbidden)
+	md5Forbidden = func() bool { return true }
+	err := auth()
+	if err == nil || !strings.Contains(err.Error(), "use SCRAM") {
+		t.Fatalf("Expected an error about FIPS mode, got %v", err)
+	}
+}
+
+func TestRuntimeParameter(t *testing.T) {
+	cn := &conn{}
+	if _, ok := cn.RuntimeParameter("TimeZone"); ok {
+		t.Fatal("Expected no value before the server reports one")
+	}
+	r := readBuf("TimeZone\x00Etc/UTC\x00")
+	cn.processParameterStatus(&r)
+	if v, ok := cn.RuntimeParameter("TimeZone"); !ok || v != "Etc/UTC" {
+		t.Errorf("Expected Etc/UTC, got %q, %v", v, ok)
+	}
+}
+
+func TestConnThroughRaw(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	c, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var pid int
+	var version string
+	err = c.Raw(func(driverConn interface{}) error {
+		pqConn := driverConn.(Conn)
+		pid = pqConn.BackendPID()
+		version, _ = pqConn.RuntimeParameter("server_version")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var serverPID int
+	var serverVersion string
+	err = c.QueryRowContext(context.Background(), "SELECT pg_backend_pid(), current_setting('server_version')").Scan(&serverPID, &serverVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid != serverPID || version != serverVersion {
+		t.Errorf("Expected %d, %q, got %d, %q", serverPID, serverVersion, pid, version)
+	}
+}
+
+func TestSetApplicationName(t *testing.T) {
+	db, err := openTestConnConninfo("user=pqgotest password=pqgotest application_name=pqtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reported string
+	err = c.Raw(func(driverConn interface{}) error {
+		pqConn := driverConn.(Conn)
+		if err := pqConn.SetApplicationName("it's a job"); err != nil {
+			return err
+		}
+		reported, _ = pqConn.RuntimeParameter("application_name")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	if err := c.QueryRowContext(ctx, "SELECT current_setting('application_name')").Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "it's a job" || reported != name {
+		t.Errorf("Expected it's a job, got %q, reported %q", name, reported)
+	}
+	c.Close()
+
+	// the next user of the connection gets the name it started with
+	if err := db.QueryRow("SELECT current_setting('application_name')").Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "pqtest" {
+		t.Errorf("Expected pqtest after the connection was returned, got %q", name)
+	}
+}
+
+func TestSetApplicationNameNUL(t *testing.T) {
+	cn := &conn{}
+	if err := cn.SetApplicationName("a\x00b"); err == nil {
+		t.Fatal("Expected an error for a name with a NUL byte")
+	}
+}
@@ -0,0 +1,186 @@
+package pq
+
+import (
+	"context"
+	"database/sql/driver"
+	"log"
+	"time"
+)
+
+// Connector represents a fixed configuration for the pq driver with a given
+// name.  It implements driver.Connector, so it can be used with sql.OpenDB,
+// and lets applications set options, such as Hooks, that can't be expressed
+// in a connection string.
+type Connector struct {
+	dsn string
+
+	// Hooks, if not nil, are called on lifecycle events of every connection
+	// the Connector makes.
+	Hooks *Hooks
+
+	// NotificationHandler, if not nil, is set as the notification handler
+	// of every connection the Connector makes; see SetNotificationHandler.
+	NotificationHandler func(*Notification)
+}
+
+// NewConnector returns a Connector for the given connection string or URL,
+// which takes the same form as the name passed to sql.Open.
+func NewConnector(dsn string) (*Connector, error) {
+	return &Connector{dsn: dsn}, nil
+}
+
+// Connect returns a new connection to the database, giving up if ctx is
+// done before it's ready.  It implements driver.Connector.
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cn, err := open(ctx, c.dsn, c.Hooks)
+	if err != nil {
+		return nil, err
+	}
+	if c.NotificationHandler != nil {
+		cn.(*conn).SetNotificationHandler(c.NotificationHandler)
+	}
+	return cn, nil
+}
+
+// Driver returns the pq driver.  It implements driver.Connector.
+func (c *Connector) Driver() driver.Driver {
+	return &drv{}
+}
+
+// OpenConnector implements driver.DriverContext.
+func (d *drv) OpenConnector(name string) (driver.Connector, error) {
+	return NewConnector(name)
+}
+
+// Hooks are callbacks for observing connections, e.g. to collect metrics or
+// trace queries, and for rewriting their queries.  Any of them may be nil.
+// They are called synchronously from the goroutine using the connection, so
+// they should return quickly.
+type Hooks struct {
+	// OnConnect is called when a connection is ready for queries, with the
+	// process ID of its server backend.
+	OnConnect func(backendPID int)
+
+	// OnQueryStart is called before a query is sent to the server.
+	OnQueryStart func(query string)
+
+	// OnQueryEnd is called when a query has completed, or for a query
+	// returning rows, when its rows are ready to be read.  d is the time
+	// since the query was started.
+	OnQueryEnd func(query string, d time.Duration)
+
+	// OnError is called with errors connecting and running queries.
+	OnError func(err error)
+
+	// OnNotice is called with the notices and warnings the server sends,
+	// such as those raised with RAISE WARNING.  Its Severity fields tell
+	// them apart.
+	OnNotice func(notice *Error)
+
+	// OnWarning is called with problems the driver works around rather
+	// than fails on, such as a server time zone that can't be loaded,
+	// instead of logging them with the log package.
+	OnWarning func(err error)
+
+	// OnStartup is called when the server has accepted a connection, before
+	// OnConnect, with the run-time parameters sent in the startup message
+	// and the parameters the server reported back, such as TimeZone and
+	// server_version.  It helps to diagnose settings that didn't take
+	// effect.  The password is not among the parameters sent; it is only
+	// ever sent to authenticate.
+	OnStartup func(sent, reported map[string]string)
+
+	// OnRowsDone is called when all the rows of a query have been read, or
+	// the rows have been closed, with the number of rows and bytes the
+	// server sent for it.
+	OnRowsDone func(query string, stats QueryStats)
+
+	// RewriteQuery, unlike the others, changes what is sent: it is called
+	// with the SQL of each statement prepared or run, and what it returns
+	// is sent in its place, e.g. with a /* app:orders */ comment prepended
+	// for attribution in pg_stat_statements.  The other hooks see the
+	// rewritten SQL.  A rewrite must keep the $1, $2, ... parameter
+	// markers meaning what they did.  COPY statements and the statements
+	// pq runs itself, such as BEGIN, are not rewritten.
+	RewriteQuery func(query string) string
+}
+
+func (h *Hooks) connect(backendPID int) {
+	if h != nil && h.OnConnect != nil {
+		h.OnConnect(backendPID)
+	}
+}
+
+// wantStartup reports whether OnStartup is set, so the parameters are only
+// collected when they'll be used.
+func (h *Hooks) wantStartup() bool {
+	return h != nil && h.OnStartup != nil
+}
+
+func (h *Hooks) startup(sent, reported map[string]string) {
+	if h.wantStartup() {
+		h.OnStartup(sent, reported)
+	}
+}
+
+func (h *Hooks) rowsDone(q string, stats QueryStats) {
+	if h != nil && h.OnRowsDone != nil {
+		h.OnRowsDone(q, stats)
+	}
+}
+
+func (h *Hooks) notice(n *Error) {
+	if h != nil && h.OnNotice != nil {
+		h.OnNotice(n)
+	}
+}
+
+func (h *Hooks) warning(err error) {
+	if h != nil && h.OnWarning != nil {
+		h.OnWarning(err)
+		return
+	}
+	log.Print(err)
+}
+
+func (h *Hooks) rewriteQuery(q string) string {
+	if h != nil && h.RewriteQuery != nil {
+		return h.RewriteQuery(q)
+	}
+	return q
+}
+
+func (h *Hooks) error(err error) {
+	if h != nil && h.OnError != nil {
+		h.OnError(err)
+	}
+}
+
+// queryStart calls OnQueryStart and returns the start time to pass to
+// queryEnd, which is zero if there are no hooks to spare the time lookup.
+func (h *Hooks) queryStart(q string) time.Time {
+	if h == nil {
+		return time.Time{}
+	}
+	if h.OnQueryStart != nil {
+		h.OnQueryStart(q)
+	}
+	return time.Now()
+}
+
+// queryEnd calls OnQueryEnd, and OnError if the query failed.  It is meant to
+// be deferred before errRecover, so that *err holds the query's final error.
+func (h *Hooks) queryEnd(q string, start time.Time, err *error) {
+	if h == nil {
+		return
+	}
+	if h.OnQueryEnd != nil {
+		h.OnQueryEnd(q, time.Since(start))
+	}
+	if *err != nil {
+		h.error(*err)
+	}
+}
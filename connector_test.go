@@ -0,0 +1,234 @@
+package pq
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNilHooks(t *testing.T) {
+	var h *Hooks
+	h.connect(1)
+	h.error(ErrNotSupported)
+	h.notice(&Error{Severity: Ewarning})
+	h.startup(nil, nil)
+	h.rowsDone("SELECT 1", QueryStats{})
+	err := error(ErrNotSupported)
+	h.queryEnd("SELECT 1", h.queryStart("SELECT 1"), &err)
+	if q := h.rewriteQuery("SELECT 1"); q != "SELECT 1" {
+		t.Errorf("Expected the query to be left alone, got %q", q)
+	}
+
+	h = &Hooks{}
+	h.connect(1)
+	h.notice(&Error{Severity: Ewarning})
+	h.startup(nil, nil)
+	h.rowsDone("SELECT 1", QueryStats{})
+	h.queryEnd("SELECT 1", h.queryStart("SELECT 1"), &err)
+}
+
+func TestConnectorConnectError(t *testing.T) {
+	c, err := (&drv{}).OpenConnector("host=127.0.0.1 port=1 sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hookErr error
+	c.(*Connector).Hooks = &Hooks{OnError: func(err error) { hookErr = err }}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Connect(ctx); err != context.Canceled {
+		t.Errorf("Expected %v, got %v", context.Canceled, err)
+	}
+
+	_, err = c.Connect(context.Background())
+	if err == nil {
+		t.Fatal("Expected an error connecting to a closed port")
+	}
+	if hookErr != err {
+		t.Errorf("Expected OnError to be called with %v, got %v", err, hookErr)
+	}
+}
+
+func TestConnectorHooks(t *testing.T) {
+	c, err := NewConnector("user=pqgotest password=pqgotest dbname=pqgotest sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pid int
+	var started, ended []string
+	var errs []error
+	c.Hooks = &Hooks{
+		OnConnect:    func(backendPID int) { pid = backendPID },
+		OnQueryStart: func(q string) { started = append(started, q) },
+		OnQueryEnd:   func(q string, d time.Duration) { ended = append(ended, q) },
+		OnError:      func(err error) { errs = append(errs, err) },
+	}
+
+	db := sql.OpenDB(c)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	var serverPID int
+	if err := db.QueryRow("SELECT pg_backend_pid()").Scan(&serverPID); err != nil {
+		t.Fatal(err)
+	}
+	if pid == 0 || pid != serverPID {
+		t.Errorf("Expected OnConnect with backend pid %d, got %d", serverPID, pid)
+	}
+	if len(started) != 1 || started[0] != "SELECT pg_backend_pid()" || len(ended) != 1 {
+		t.Errorf("Unexpected query hooks: started %v, ended %v", started, ended)
+	}
+
+	if _, err := db.Exec("SELECT * FROM no_such_table"); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if len(errs) != 1 {
+		t.Fatalf("Expected one error, got %v", errs)
+	}
+	if pqErr, ok := errs[0].(*Error); !ok || pqErr.Code != "42P01" {
+		t.Errorf("Expected an undefined_table error, got %v", errs[0])
+	}
+}
+
+func TestRewriteQueryHook(t *testing.T) {
+	cn := fakeConn("C\x00\x00\x00\x0dSELECT 1\x00"+"Z\x00\x00\x00\x05I", 0)
+	var started []string
+	cn.hooks = &Hooks{
+		RewriteQuery: func(q string) string { return "/* app:orders */ " + q },
+		OnQueryStart: func(q string) { started = append(started, q) },
+	}
+
+	if _, err := cn.Exec("SELECT 1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"/* app:orders */ SELECT 1"}; !reflect.DeepEqual(started, expected) {
+		t.Errorf("Expected %q, got %q", expected, started)
+	}
+
+	cn.simpleProtocol = true
+	st, err := cn.Prepare("SELECT $1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := st.(*stmt).query; q != "/* app:orders */ SELECT $1" {
+		t.Errorf("Expected the prepared statement to be rewritten, got %q", q)
+	}
+}
+
+func TestRewriteQueryHookFromDb(t *testing.T) {
+	c, err := NewConnector("user=pqgotest password=pqgotest dbname=pqgotest sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Hooks = &Hooks{RewriteQuery: func(q string) string { return "/* app:orders */ " + q }}
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	var q string
+	if err := db.QueryRow("SELECT current_query() WHERE $1", true).Scan(&q); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "/* app:orders */ SELECT current_query() WHERE $1"; q != expected {
+		t.Errorf("Expected %q, got %q", expected, q)
+	}
+}
+
+func TestNoticeHook(t *testing.T) {
+	c, err := NewConnector("user=pqgotest password=pqgotest dbname=pqgotest sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var notices []*Error
+	c.Hooks = &Hooks{OnNotice: func(n *Error) { notices = append(notices, n) }}
+
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	if _, err := db.Exec("DO $$ BEGIN RAISE WARNING 'careful'; END $$"); err != nil {
+		t.Fatal(err)
+	}
+	if len(notices) != 1 {
+		t.Fatalf("Expected one notice, got %v", notices)
+	}
+	n := notices[0]
+	if n.Severity != Ewarning || n.Message != "careful" {
+		t.Errorf("Unexpected notice %+v", n)
+	}
+	if n.SeverityNonLocalized != "" && n.SeverityNonLocalized != Ewarning {
+		t.Errorf("Expected non-localized severity %q, got %q", Ewarning, n.SeverityNonLocalized)
+	}
+}
+
+func TestConnectorNotificationHandler(t *testing.T) {
+	c, err := NewConnector("user=pqgotest password=pqgotest dbname=pqgotest sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []*Notification
+	c.NotificationHandler = func(n *Notification) { got = append(got, n) }
+
+	db := sql.OpenDB(c)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// a session is notified of its own NOTIFYs, before its ReadyForQuery
+	if _, err := db.Exec("LISTEN pq_test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("NOTIFY pq_test, 'hello'"); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Channel != "pq_test" || got[0].Extra != "hello" {
+		t.Errorf("Unexpected notifications %+v", got)
+	}
+}
+
+func TestStartupHook(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		var n uint32
+		if binary.Read(server, binary.BigEndian, &n) != nil {
+			return
+		}
+		io.CopyN(ioutil.Discard, server, int64(n-4))
+		io.WriteString(server, "R\x00\x00\x00\x08\x00\x00\x00\x00"+
+			"S\x00\x00\x00\x15TimeZone\x00Etc/UTC\x00"+
+			"S\x00\x00\x00\x18server_version\x0013.4\x00"+
+			"K\x00\x00\x00\x0c\x00\x00\x00\x01\x00\x00\x00\x02"+
+			"Z\x00\x00\x00\x05I")
+	}()
+
+	var sent, reported map[string]string
+	cn := &conn{c: client, buf: bufio.NewReader(client), hooks: &Hooks{
+		OnStartup: func(s, r map[string]string) { sent, reported = s, r },
+	}}
+	o := values{"user": "pqgotest", "password": "secret", "dbname": "pqgotest", "application_name": "app"}
+	cn.startup(o)
+
+	expectedSent := map[string]string{"user": "pqgotest", "database": "pqgotest", "application_name": "app"}
+	if !reflect.DeepEqual(sent, expectedSent) {
+		t.Errorf("Expected sent parameters %v, got %v", expectedSent, sent)
+	}
+	expectedReported := map[string]string{"TimeZone": "Etc/UTC", "server_version": "13.4"}
+	if !reflect.DeepEqual(reported, expectedReported) {
+		t.Errorf("Expected reported parameters %v, got %v", expectedReported, reported)
+	}
+	if cn.processID != 1 {
+		t.Errorf("Expected backend PID 1, got %d", cn.processID)
+	}
+}
@@ -1,8 +1,13 @@
 package pq
 
 import (
+	"database/sql"
 	"database/sql/driver"
 	"encoding/binary"
+	"fmt"
+	"github.com/gregb/pq/oid"
+	"io"
+	"strings"
 	"sync/atomic"
 )
 
@@ -34,15 +39,150 @@ func CopyInSchema(schema, table string, columns ...string) string {
 	return stmt
 }
 
+// CopyInCSV creates a COPY FROM statement in CSV format that can be prepared
+// with DB.Prepare().  If header is true, the driver sends the column names
+// as the first line, like a CSV file exported with a header row would have.
+func CopyInCSV(table string, header bool, columns ...string) string {
+	stmt := CopyIn(table, columns...) + " WITH CSV"
+	if header {
+		stmt += " HEADER"
+	}
+	return stmt
+}
+
+// CopyInBinary creates a COPY FROM statement in binary format that can be
+// prepared with DB.Prepare().  Values are sent without the escaping of the
+// text format, encoded by the types of the table's columns, which must be
+// among those that binary COPY supports: bytea, the string types, json and
+// jsonb, bool, the integer and floating point types, timestamps and dates.
+func CopyInBinary(table string, columns ...string) string {
+	return CopyIn(table, columns...) + " WITH BINARY"
+}
+
+// copyFormat is what the driver needs to know about a COPY statement's
+// options to send it rows.
+type copyFormat struct {
+	csv     bool
+	binary  bool
+	header  bool
+	table   string
+	columns []string
+}
+
+// parseCopyFormat picks the table, the column list and the CSV, BINARY and
+// HEADER options out of a COPY statement, in either the WITH (FORMAT csv,
+// HEADER) form or the older WITH CSV HEADER one.  The table is as written,
+// quotes and all.
+func parseCopyFormat(q string) (f copyFormat) {
+	tokens := tokenizeCopy(q)
+	inColumns := false
+	seenFrom := false
+	for i, tok := range tokens {
+		word := strings.ToUpper(tok)
+		switch {
+		case !seenFrom && word == "(":
+			inColumns = true
+		case inColumns && word == ")":
+			inColumns = false
+		case inColumns && word != ",":
+			f.columns = append(f.columns, unquoteIdentifier(tok))
+		case word == "FROM":
+			seenFrom = true
+		case !seenFrom:
+			if i > 0 && !inColumns {
+				f.table += tok
+			}
+		case word == "CSV":
+			f.csv = true
+		case word == "BINARY":
+			f.binary = true
+		case word == "FORMAT" && i+1 < len(tokens):
+			format := strings.Trim(tokens[i+1], "'")
+			f.csv = strings.EqualFold(format, "csv")
+			f.binary = strings.EqualFold(format, "binary")
+		case word == "HEADER":
+			f.header = true
+			if i+1 < len(tokens) {
+				switch strings.ToUpper(tokens[i+1]) {
+				case "FALSE", "OFF", "0":
+					f.header = false
+				}
+			}
+		}
+	}
+	return f
+}
+
+// tokenizeCopy splits a COPY statement into words, quoted identifiers and
+// strings, and single punctuation characters.
+func tokenizeCopy(q string) (tokens []string) {
+	for i := 0; i < len(q); {
+		c := q[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(q) {
+				if q[j] == c {
+					if j+1 < len(q) && q[j+1] == c {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			if j < len(q) {
+				j++
+			}
+			tokens = append(tokens, q[i:j])
+			i = j
+		case c == '(' || c == ')' || c == ',' || c == ';':
+			tokens = append(tokens, q[i:i+1])
+			i++
+		default:
+			j := i
+			for j < len(q) && !strings.ContainsRune(" \t\n\r\"'(),;", rune(q[j])) {
+				j++
+			}
+			tokens = append(tokens, q[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+// unquoteIdentifier returns the name of a possibly quoted identifier.
+func unquoteIdentifier(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return strings.Replace(s[1:len(s)-1], `""`, `"`, -1)
+	}
+	return strings.ToLower(s)
+}
+
 type copyin struct {
-	cn      *conn
-	buffer  []byte
+	cn     *conn
+	format copyFormat
+	buffer []byte
+
+	// the types of the columns, for encoding rows in binary format
+	colTyps []oid.Oid
+
 	rowData chan []byte
 	done    chan bool
 
 	closed   bool
 	err      error
 	errorset int32
+
+	// whether resploop has signalled done, after the server's
+	// ReadyForQuery
+	finished bool
+
+	// the row count of the server's COPY command tag, set by resploop
+	// before it signals done
+	rowsAffected int64
 }
 
 const ciBufferSize = 64 * 1024
@@ -52,16 +192,44 @@ const ciBufferFlushSize = 63 * 1024
 
 func (cn *conn) prepareCopyIn(q string) (_ driver.Stmt, err error) {
 	defer errRecover(&err)
+	cn.checkBad()
+
+	// a COPY TO isn't run until its rows are asked for
+	if source, binary, ok := copyOutSource(q); ok {
+		return cn.prepareCopyOut(q, source, binary), nil
+	}
 
 	ci := &copyin{
 		cn:      cn,
+		format:  parseCopyFormat(q),
 		buffer:  make([]byte, 0, ciBufferSize),
 		rowData: make(chan []byte),
 		done:    make(chan bool),
 	}
+	if ci.format.header && len(ci.format.columns) == 0 {
+		errorf("COPY with HEADER needs a column list to write the header from")
+	}
 	// add CopyData identifier + 4 bytes for message length
 	ci.buffer = append(ci.buffer, 'd', 0, 0, 0, 0)
 
+	// binary format needs the columns' types, which the server doesn't
+	// tell, so a SELECT of the columns is described for them
+	if ci.format.binary {
+		ci.colTyps = ci.describeColumns()
+		ci.buffer = append(ci.buffer, copyBinaryHeader...)
+	}
+
+	// the server skips the first line of a CSV with a header
+	if ci.format.header {
+		for i, col := range ci.format.columns {
+			if i > 0 {
+				ci.buffer = append(ci.buffer, ',')
+			}
+			ci.buffer = appendCSVField(ci.buffer, col)
+		}
+		ci.buffer = append(ci.buffer, '\n')
+	}
+
 	b := cn.writeBuf('Q')
 	b.string(q)
 	cn.send(b)
@@ -70,8 +238,12 @@ func (cn *conn) prepareCopyIn(q string) (_ driver.Stmt, err error) {
 		t, r := cn.recv1()
 		switch t {
 		case 'G':
-			if r.byte() != 0 {
-				errorf("only text format supported for COPY")
+			binaryFormat := r.byte() != 0
+			if binaryFormat != ci.format.binary {
+				errorf("COPY started in an unexpected format")
+			}
+			if n := r.int16(); binaryFormat && n != len(ci.colTyps) {
+				errorf("COPY reads %d columns but %d were described", n, len(ci.colTyps))
 			}
 			go ci.resploop()
 			return ci, err
@@ -89,6 +261,25 @@ func (cn *conn) prepareCopyIn(q string) (_ driver.Stmt, err error) {
 	panic("not reached")
 }
 
+// describeColumns returns the types of the columns a binary COPY FROM reads.
+func (ci *copyin) describeColumns() []oid.Oid {
+	if ci.format.table == "" {
+		errorf("could not find the table of a binary COPY")
+	}
+	list := "*"
+	if len(ci.format.columns) > 0 {
+		quoted := make([]string, len(ci.format.columns))
+		for i, col := range ci.format.columns {
+			quoted[i] = QuoteIdentifier(col)
+		}
+		list = strings.Join(quoted, ", ")
+	}
+	st := &stmt{cn: ci.cn, name: "", query: "SELECT " + list + " FROM " + ci.format.table}
+	st.prepare()
+	checkCopyBinaryTypes(st.rowTyps)
+	return st.rowTyps
+}
+
 func (ci *copyin) flush(buf []byte) {
 	// set message length (without message identifier)
 	binary.BigEndian.PutUint32(buf[1:], uint32(len(buf)-1))
@@ -99,13 +290,27 @@ func (ci *copyin) flush(buf []byte) {
 	}
 }
 
+// resploop reads the server's responses while rows are being sent.  The
+// server may end the COPY with an ErrorResponse at any point, such as when
+// a row violates a constraint, and is then ready for the next query; the
+// error is kept for Exec to return.
 func (ci *copyin) resploop() {
+	defer func() {
+		if e := recover(); e != nil {
+			// the connection failed, or is out of step with the server, so
+			// it can't be used again
+			ci.cn.bad = driver.ErrBadConn
+			ci.seterror(driver.ErrBadConn)
+			ci.done <- true
+		}
+	}()
 	for {
 		t, r := ci.cn.recv1()
 		switch t {
 		case 'C':
-			// complete
+			ci.rowsAffected, _ = parseComplete(r.string())
 		case 'Z':
+			ci.cn.processReadyForQuery(r)
 			ci.done <- true
 			return
 		case 'E':
@@ -117,6 +322,26 @@ func (ci *copyin) resploop() {
 	}
 }
 
+// wait waits for resploop to see the server's ReadyForQuery, after which
+// the connection is ready for the next query.
+func (ci *copyin) wait() {
+	if !ci.finished {
+		<-ci.done
+		ci.finished = true
+	}
+}
+
+// fail aborts the COPY with a CopyFail because of an error on the client's
+// side, so that the rows sent before aren't committed, and waits for the
+// server to end it.  Exec returns err from then on.
+func (ci *copyin) fail(err error) {
+	b := ci.cn.writeBuf('f')
+	b.string(err.Error())
+	ci.cn.send(b)
+	ci.wait()
+	ci.seterror(err)
+}
+
 func (ci *copyin) isErrorSet() bool {
 	return atomic.LoadInt32(&ci.errorset) != 0
 }
@@ -136,11 +361,14 @@ func (ci *copyin) Query(v []driver.Value) (r driver.Rows, err error) {
 
 // Exec inserts values into the COPY stream. The insert is asynchronous
 // and Exec can return errors from previous Exec calls to the same
-// COPY stmt.
+// COPY stmt.  Once the server has ended the COPY with an error, such as a
+// row violating a constraint, Exec returns that error from then on.  A row
+// that can't be encoded aborts the COPY too.
 //
 // You need to call Exec(nil) to sync the COPY stream and to get any
 // errors from pending data, since Stmt.Close() doesn't return errors
-// to the user.
+// to the user.  The result of Exec(nil) has the number of rows the server
+// copied as its RowsAffected.
 func (ci *copyin) Exec(v []driver.Value) (r driver.Result, err error) {
 	defer errRecover(&err)
 
@@ -151,25 +379,27 @@ func (ci *copyin) Exec(v []driver.Value) (r driver.Result, err error) {
 	}
 
 	if ci.isErrorSet() {
-		err = ci.err
-		return nil, err
+		// the server has ended the COPY; once it's ready for the next
+		// query, so is the connection
+		ci.wait()
+		return nil, ci.err
 	}
 
 	if len(v) == 0 {
 		err = ci.Close()
 		ci.closed = true
-		return
-	}
-
-	numValues := len(v)
-	for i, value := range v {
-		ci.buffer = appendEncodedText(&ci.cn.parameterStatus, ci.buffer, value)
-		if i < numValues-1 {
-			ci.buffer = append(ci.buffer, '\t')
+		if err != nil {
+			return nil, err
 		}
+		return driver.RowsAffected(ci.rowsAffected), nil
 	}
 
-	ci.buffer = append(ci.buffer, '\n')
+	start := len(ci.buffer)
+	if err := ci.appendRow(v); err != nil {
+		ci.buffer = ci.buffer[:start]
+		ci.fail(err)
+		return nil, err
+	}
 
 	if len(ci.buffer) > ciBufferFlushSize {
 		ci.flush(ci.buffer)
@@ -180,6 +410,34 @@ func (ci *copyin) Exec(v []driver.Value) (r driver.Result, err error) {
 	return
 }
 
+// appendRow appends a row of values to the buffer, in the COPY's format.
+func (ci *copyin) appendRow(v []driver.Value) (err error) {
+	defer errRecover(&err)
+
+	if ci.format.binary {
+		ci.buffer = appendCopyBinaryRow(ci.buffer, v, ci.colTyps)
+		return nil
+	}
+
+	numValues := len(v)
+	for i, value := range v {
+		if ci.format.csv {
+			ci.buffer = appendEncodedCSV(&ci.cn.parameterStatus, ci.buffer, value)
+			if i < numValues-1 {
+				ci.buffer = append(ci.buffer, ',')
+			}
+			continue
+		}
+		ci.buffer = appendEncodedText(&ci.cn.parameterStatus, ci.buffer, value)
+		if i < numValues-1 {
+			ci.buffer = append(ci.buffer, '\t')
+		}
+	}
+
+	ci.buffer = append(ci.buffer, '\n')
+	return nil
+}
+
 func (ci *copyin) Close() (err error) {
 	defer errRecover(&err)
 
@@ -187,12 +445,20 @@ func (ci *copyin) Close() (err error) {
 		return nil
 	}
 
-	if len(ci.buffer) > 0 {
-		ci.flush(ci.buffer)
+	// there's no sense in sending the rest of the rows once the server has
+	// ended the COPY with an error
+	if !ci.isErrorSet() {
+		if ci.format.binary {
+			// the trailer
+			ci.buffer = append(ci.buffer, 0xff, 0xff)
+		}
+		if len(ci.buffer) > 0 {
+			ci.flush(ci.buffer)
+		}
+		ci.cn.send(ci.cn.writeBuf('c'))
 	}
-	ci.cn.send(ci.cn.writeBuf('c'))
 
-	<-ci.done
+	ci.wait()
 
 	if ci.isErrorSet() {
 		err = ci.err
@@ -200,3 +466,113 @@ func (ci *copyin) Close() (err error) {
 	}
 	return
 }
+
+// CopyInRaw copies data that is already in one of the COPY formats, "text",
+// "csv" or "binary", from r into table on c's underlying connection, such as
+// the output of a COPY TO.  It saves decoding and encoding the rows to pass
+// them through a CopyIn statement.  It returns the number of rows copied.
+// If reading from r fails, the COPY is aborted and the error returned.
+func CopyInRaw(c *sql.Conn, table, format string, r io.Reader) (n int64, err error) {
+	return copyInRaw(c, QuoteIdentifier(table), format, r)
+}
+
+// CopyInRawSchema is CopyInRaw for a table in the given schema.
+func CopyInRawSchema(c *sql.Conn, schema, table, format string, r io.Reader) (n int64, err error) {
+	return copyInRaw(c, QuoteIdentifier(schema)+"."+QuoteIdentifier(table), format, r)
+}
+
+func copyInRaw(c *sql.Conn, table, format string, r io.Reader) (n int64, err error) {
+	switch strings.ToLower(format) {
+	case "text", "csv", "binary":
+	default:
+		return 0, fmt.Errorf("pq: unknown COPY format %q", format)
+	}
+	q := `COPY ` + table + ` FROM STDIN WITH (FORMAT ` + format + `)`
+
+	err = c.Raw(func(driverConn interface{}) error {
+		cn, ok := driverConn.(*conn)
+		if !ok {
+			return fmt.Errorf("pq: CopyInRaw called on a %T connection", driverConn)
+		}
+		n, err = cn.copyInRaw(q, r)
+		return err
+	})
+	return n, err
+}
+
+func (cn *conn) copyInRaw(q string, data io.Reader) (n int64, err error) {
+	defer cn.hooks.queryEnd(q, cn.hooks.queryStart(q), &err)
+	defer errRecover(&err)
+	cn.checkBad()
+
+	b := cn.writeBuf('Q')
+	b.string(q)
+	cn.send(b)
+
+	for started := false; !started; {
+		t, r := cn.recv1()
+		switch t {
+		case 'G':
+			started = true
+		case 'E':
+			err = parseError(r)
+		case 'Z':
+			cn.processReadyForQuery(r)
+			if err == nil {
+				errorf("unexpected ReadyForQuery before COPY started")
+			}
+			return 0, err
+		default:
+			errorf("unknown response for copy query: %q", t)
+		}
+	}
+
+	// forward r in CopyData messages; the writes block while the server
+	// catches up
+	buf := make([]byte, ciBufferSize)
+	buf[0] = 'd'
+	var readErr error
+	for {
+		m, rerr := data.Read(buf[5:])
+		if m > 0 {
+			binary.BigEndian.PutUint32(buf[1:], uint32(m+4))
+			if _, err := cn.c.Write(buf[:5+m]); err != nil {
+				panic(err)
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			readErr = rerr
+			break
+		}
+	}
+
+	if readErr != nil {
+		// CopyFail
+		b := cn.writeBuf('f')
+		b.string(readErr.Error())
+		cn.send(b)
+	} else {
+		cn.send(cn.writeBuf('c'))
+	}
+
+	for {
+		t, r := cn.recv1()
+		switch t {
+		case 'C':
+			n, _ = parseComplete(r.string())
+		case 'E':
+			err = parseError(r)
+		case 'Z':
+			cn.processReadyForQuery(r)
+			if readErr != nil {
+				return 0, readErr
+			}
+			return n, err
+		default:
+			errorf("unknown response for copy: %q", t)
+		}
+	}
+}
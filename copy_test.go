@@ -1,8 +1,16 @@
 package pq
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -33,6 +41,52 @@ func TestCopyInSchemaStmt(t *testing.T) {
 	}
 }
 
+func TestCopyInCSVStmt(t *testing.T) {
+	stmt := CopyInCSV("table name", true, "column 1", "column 2")
+	if stmt != `COPY "table name" ("column 1", "column 2") FROM STDIN WITH CSV HEADER` {
+		t.Fatal(stmt)
+	}
+
+	stmt = CopyInCSV("table name", false, "column 1")
+	if stmt != `COPY "table name" ("column 1") FROM STDIN WITH CSV` {
+		t.Fatal(stmt)
+	}
+}
+
+func TestParseCopyFormat(t *testing.T) {
+	tests := []struct {
+		q        string
+		expected copyFormat
+	}{
+		{CopyIn("t", "a"), copyFormat{table: `"t"`, columns: []string{"a"}}},
+		{CopyInCSV("t", true, "a", "B c"), copyFormat{csv: true, header: true, table: `"t"`, columns: []string{"a", "B c"}}},
+		{`COPY t ("x""y") FROM STDIN CSV HEADER`, copyFormat{csv: true, header: true, table: "t", columns: []string{`x"y`}}},
+		{`COPY t (A, "B") FROM STDIN WITH (FORMAT csv, HEADER)`, copyFormat{csv: true, header: true, table: "t", columns: []string{"a", "B"}}},
+		{`copy t from stdin with (format 'csv', header false)`, copyFormat{csv: true, table: "t"}},
+		{`COPY "t(x)" FROM STDIN WITH (FORMAT text)`, copyFormat{table: `"t(x)"`}},
+		{CopyInBinary("t", "a"), copyFormat{binary: true, table: `"t"`, columns: []string{"a"}}},
+		{`COPY s.t FROM STDIN WITH (FORMAT binary)`, copyFormat{binary: true, table: "s.t"}},
+	}
+
+	for _, tt := range tests {
+		if got := parseCopyFormat(tt.q); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("parseCopyFormat(%s) = %+v, expected %+v", tt.q, got, tt.expected)
+		}
+	}
+}
+
+func TestAppendEncodedCSV(t *testing.T) {
+	var buf []byte
+	for _, v := range []interface{}{int64(1), "plain", "a,b", `say "hi"`, "", nil, `\.`, "two\nlines"} {
+		buf = appendEncodedCSV(&parameterStatus{serverVersion: 90000}, buf, v)
+		buf = append(buf, '|')
+	}
+	expected := `1|plain|"a,b"|"say ""hi"""|""||"\."|"two` + "\n" + `lines"|`
+	if string(buf) != expected {
+		t.Errorf("Expected %s, got %s", expected, buf)
+	}
+}
+
 func TestCopyInMultipleValues(t *testing.T) {
 	db := openTestConn(t)
 	defer db.Close()
@@ -56,10 +110,13 @@ func TestCopyInMultipleValues(t *testing.T) {
 		}
 	}
 
-	_, err = stmt.Exec()
+	res, err := stmt.Exec()
 	if err != nil {
 		t.Fatal(err)
 	}
+	if n, _ := res.RowsAffected(); n != 500 {
+		t.Fatalf("expected 500 rows affected, not %d", n)
+	}
 
 	err = stmt.Close()
 	if err != nil {
@@ -79,6 +136,156 @@ func TestCopyInMultipleValues(t *testing.T) {
 
 }
 
+// Does not access database, simply tests reading the COPY command tag
+func TestCopyInRowsAffected(t *testing.T) {
+	ci := &copyin{
+		cn:   fakeConn("C\x00\x00\x00\x0dCOPY 500\x00Z\x00\x00\x00\x05I", 0),
+		done: make(chan bool),
+	}
+	go ci.resploop()
+
+	res, err := ci.Exec(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 500 {
+		t.Fatalf("expected 500 rows affected, not %d", n)
+	}
+}
+
+// errorResponse returns an ErrorResponse message with the given code and
+// constraint.
+func errorResponse(code, constraint string) string {
+	body := "SERROR\x00C" + code + "\x00Mviolation\x00n" + constraint + "\x00\x00"
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(body)+4))
+	return "E" + string(n[:]) + body
+}
+
+// Does not access database, simply tests the handling of an error the
+// server ends the COPY with
+func TestCopyInServerError(t *testing.T) {
+	ci := &copyin{
+		cn:     fakeConn(errorResponse("23505", "temp_pkey")+"Z\x00\x00\x00\x05E", 0),
+		buffer: []byte{'d', 0, 0, 0, 0},
+		done:   make(chan bool),
+	}
+	go ci.resploop()
+
+	_, err := ci.Exec(nil)
+	if !IsUniqueViolation(err) {
+		t.Fatalf("expected a unique violation, got %v", err)
+	}
+	if name, _ := ConstraintName(err); name != "temp_pkey" {
+		t.Errorf("expected the temp_pkey constraint, got %q", name)
+	}
+	if status := ci.cn.TransactionStatus(); status != TxnStatusInFailedTransaction {
+		t.Errorf("expected the transaction status from ReadyForQuery, got %v", status)
+	}
+}
+
+// Does not access database, simply tests that a row that can't be encoded
+// aborts the COPY
+func TestCopyInEncodeError(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	sent := make(chan []byte, 10)
+	go func() {
+		defer server.Close()
+		for {
+			var hdr [5]byte
+			if _, err := io.ReadFull(server, hdr[:]); err != nil {
+				return
+			}
+			body := make([]byte, binary.BigEndian.Uint32(hdr[1:])-4)
+			if _, err := io.ReadFull(server, body); err != nil {
+				return
+			}
+			sent <- append(hdr[:1], body...)
+			if hdr[0] == 'f' {
+				io.WriteString(server, errorResponse("57014", "")+"Z\x00\x00\x00\x05I")
+			}
+		}
+	}()
+
+	ci := &copyin{
+		cn:     &conn{c: client, buf: bufio.NewReader(client)},
+		buffer: []byte{'d', 0, 0, 0, 0},
+		done:   make(chan bool),
+	}
+	go ci.resploop()
+
+	if _, err := ci.Exec([]driver.Value{int64(1), "a"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ci.Exec([]driver.Value{int64(2), struct{}{}})
+	if err == nil || !strings.Contains(err.Error(), "unknown type") {
+		t.Fatalf("expected an encoding error, got %v", err)
+	}
+	if msg := <-sent; msg[0] != 'f' || !strings.Contains(string(msg), "unknown type") {
+		t.Errorf("expected a CopyFail, got %q", msg)
+	}
+	if len(ci.buffer) != 5+len("1\ta\n") {
+		t.Errorf("expected the partial row to be dropped, got %q", ci.buffer[5:])
+	}
+	if _, err2 := ci.Exec(nil); err2 != err {
+		t.Errorf("expected the encoding error again, got %v", err2)
+	}
+}
+
+func TestCopyInConstraintViolation(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	txn, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Rollback()
+
+	_, err = txn.Exec("CREATE TEMP TABLE temp (num INTEGER CONSTRAINT temp_num_key UNIQUE)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stmt, err := txn.Prepare(CopyIn("temp", "num"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, num := range []int64{1, 2, 1, 3} {
+		if _, err = stmt.Exec(num); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		_, err = stmt.Exec()
+	}
+	if !IsUniqueViolation(err) {
+		t.Fatalf("expected a unique violation, got %v", err)
+	}
+	if name, _ := ConstraintName(err); name != "temp_num_key" {
+		t.Errorf("expected the temp_num_key constraint, got %q", name)
+	}
+	stmt.Close()
+
+	// the connection is in step with the server, in the failed transaction
+	if _, err := txn.Exec("SELECT 1"); !isErrorCode(err, "in_failed_sql_transaction") {
+		t.Errorf("expected the transaction to have failed, got %v", err)
+	}
+	if err := txn.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT 1").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("unexpected result %d, %v", n, err)
+	}
+}
+
+func isErrorCode(err error, name string) bool {
+	e, ok := err.(*Error)
+	return ok && e.Code.Name() == name
+}
+
 func TestCopyInTypes(t *testing.T) {
 	db := openTestConn(t)
 	defer db.Close()
@@ -235,3 +442,152 @@ func BenchmarkCopyIn(b *testing.B) {
 	}
 
 }
+
+func TestCopyInCSVHeader(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	_, err := db.Exec("CREATE TEMP TABLE temp (num INTEGER, text VARCHAR, nothing VARCHAR)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stmt, err := db.Prepare(CopyInCSV("temp", true, "num", "text", "nothing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = stmt.Exec(int64(1), "with, \"quotes\"\nand lines", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stmt.Exec(int64(2), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = stmt.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = stmt.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query("SELECT num, text, nothing FROM temp ORDER BY num")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	expected := []string{"with, \"quotes\"\nand lines", ""}
+	n := 0
+	for ; rows.Next(); n++ {
+		var num int
+		var text string
+		var nothing sql.NullString
+		if err := rows.Scan(&num, &text, &nothing); err != nil {
+			t.Fatal(err)
+		}
+		if num != n+1 || text != expected[n] || nothing.Valid {
+			t.Errorf("unexpected row %d: %d, %q, %v", n, num, text, nothing)
+		}
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 rows, not %d", n)
+	}
+}
+
+func TestCopyInRaw(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	c, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = c.ExecContext(context.Background(), "CREATE TEMP TABLE temp (num INTEGER, text VARCHAR)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// more than fits in one CopyData message
+	var text bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		text.WriteString("1\tsome text\n")
+	}
+	n, err := CopyInRaw(c, "temp", "text", &text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10000 {
+		t.Fatalf("expected 10000 rows copied, not %d", n)
+	}
+
+	n, err = CopyInRaw(c, "temp", "csv", strings.NewReader("2,\"with, comma\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 row copied, not %d", n)
+	}
+
+	var s string
+	err = c.QueryRowContext(context.Background(), "SELECT text FROM temp WHERE num = 2").Scan(&s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "with, comma" {
+		t.Errorf("unexpected text %q", s)
+	}
+
+	// malformed data is refused by the server, and the connection is
+	// still usable after
+	if _, err := CopyInRaw(c, "temp", "text", strings.NewReader("x\ty\n")); err == nil {
+		t.Fatal("expected an error for malformed data")
+	}
+
+	// a failing reader aborts the COPY
+	readErr := errors.New("read failed")
+	r := io.MultiReader(strings.NewReader("3\tpartial\n"), &errReader{readErr})
+	if _, err := CopyInRaw(c, "temp", "text", r); err != readErr {
+		t.Fatalf("expected %v, got %v", readErr, err)
+	}
+
+	var count int
+	err = c.QueryRowContext(context.Background(), "SELECT count(*) FROM temp").Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 10001 {
+		t.Fatalf("expected 10001 rows, not %d", count)
+	}
+
+	if _, err := CopyInRaw(c, "temp", "xml", &text); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+
+	// table names are quoted, and can be qualified with a schema
+	_, err = c.ExecContext(context.Background(), `CREATE TEMP TABLE "odd ""name""" (num INTEGER)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := CopyInRaw(c, `odd "name"`, "text", strings.NewReader("1\n")); err != nil || n != 1 {
+		t.Fatalf("expected 1 row copied, got %d, %v", n, err)
+	}
+	if n, err := CopyInRawSchema(c, "pg_temp", `odd "name"`, "text", strings.NewReader("2\n")); err != nil || n != 1 {
+		t.Fatalf("expected 1 row copied, got %d, %v", n, err)
+	}
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
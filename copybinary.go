@@ -0,0 +1,221 @@
+package pq
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"github.com/gregb/pq/oid"
+	"time"
+)
+
+// copyBinarySignature starts the header of COPY binary format, which goes on
+// with a flags field and the length of a header extension, both zero here.
+const copyBinarySignature = "PGCOPY\n\377\r\n\000"
+
+const copyBinaryHeader = copyBinarySignature + "\000\000\000\000" + "\000\000\000\000"
+
+// pgEpoch is the time the binary formats of timestamps and dates count from.
+var pgEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC).Unix()
+
+// hasCopyBinaryCodec reports whether a column of typ can be copied in and
+// out in binary format: the types with a binary decoder, the string types,
+// JSON, timestamps and dates.
+func hasCopyBinaryCodec(typ oid.Oid) bool {
+	switch typ {
+	case oid.T_text, oid.T_varchar, oid.T_bpchar, oid.T_name, oid.T_json, oid.T_jsonb,
+		oid.T_timestamp, oid.T_timestamptz, oid.T_date:
+		return true
+	}
+	return hasBinaryDecoder(typ)
+}
+
+// checkCopyBinaryTypes fails if any of the columns of a binary COPY has a type
+// without a binary codec.
+func checkCopyBinaryTypes(typs []oid.Oid) {
+	for i, typ := range typs {
+		if !hasCopyBinaryCodec(typ) {
+			errorf("binary COPY is not supported for column %d, of type %d; use the text format", i+1, typ)
+		}
+	}
+}
+
+// encodeCopyBinary encodes a value of a row of a binary COPY FROM, for a
+// column of typ, one hasCopyBinaryCodec accepts.  Besides what encodeBinary
+// encodes, it takes an int64 for a float column, a string or []byte for a
+// string, JSON or bytea column, and a time.Time for a timestamp or date
+// column.  A timestamp without time zone or a date takes the time's wall
+// clock reading, as the text format would.
+func encodeCopyBinary(x interface{}, typ oid.Oid) []byte {
+	if b, ok := encodeBinary(x, typ); ok {
+		return b
+	}
+	switch v := x.(type) {
+	case int64:
+		if b, ok := encodeBinary(float64(v), typ); ok {
+			return b
+		}
+	case string:
+		return encodeCopyBinary([]byte(v), typ)
+	case []byte:
+		switch typ {
+		case oid.T_bytea, oid.T_text, oid.T_varchar, oid.T_bpchar, oid.T_name, oid.T_json:
+			return v
+		case oid.T_jsonb:
+			// version 1 of jsonb's binary format is its text
+			return append([]byte{1}, v...)
+		}
+	case time.Time:
+		b := make([]byte, 8)
+		switch typ {
+		case oid.T_timestamptz:
+			binary.BigEndian.PutUint64(b, uint64(pgMicroseconds(v)))
+			return b
+		case oid.T_timestamp:
+			binary.BigEndian.PutUint64(b, uint64(pgMicroseconds(wallClock(v))))
+			return b
+		case oid.T_date:
+			y, m, d := v.Date()
+			days := (time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() - pgEpoch) / (24 * 60 * 60)
+			binary.BigEndian.PutUint32(b, uint32(int32(days)))
+			return b[:4]
+		}
+	}
+	errorf("cannot encode %T as type %d in a binary COPY", x, typ)
+	panic("not reached")
+}
+
+// pgMicroseconds returns the microseconds from pgEpoch to t.
+func pgMicroseconds(t time.Time) int64 {
+	return (t.Unix()-pgEpoch)*1000000 + int64(t.Nanosecond()/1000)
+}
+
+// wallClock returns the time in UTC with t's wall clock reading.
+func wallClock(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// fromPgMicroseconds returns the time us microseconds after pgEpoch.
+func fromPgMicroseconds(us int64) time.Time {
+	sec, usec := us/1000000, us%1000000
+	if usec < 0 {
+		sec--
+		usec += 1000000
+	}
+	return time.Unix(pgEpoch+sec, usec*1000)
+}
+
+// decodeCopyBinary decodes a field of a row of a binary COPY TO, of a type
+// hasCopyBinaryCodec accepts, into the value decode would read its text
+// into.  Timestamps with time zone are in the session's time zone, as far
+// as it's known, or else in UTC; timestamps without one and dates are in a
+// zone of offset zero, as parseTs leaves them.
+func decodeCopyBinary(parameterStatus *parameterStatus, s []byte, typ oid.Oid) interface{} {
+	switch typ {
+	case oid.T_text, oid.T_varchar, oid.T_bpchar, oid.T_name, oid.T_json:
+		return decode(parameterStatus, s, typ)
+	case oid.T_jsonb:
+		if len(s) == 0 || s[0] != 1 {
+			errorf("unknown version of jsonb binary format")
+		}
+		return decode(parameterStatus, s[1:], typ)
+	case oid.T_timestamptz:
+		if len(s) != 8 {
+			break
+		}
+		t := fromPgMicroseconds(int64(binary.BigEndian.Uint64(s)))
+		if loc := parameterStatus.currentLocation; loc != nil {
+			return t.In(loc)
+		}
+		return t.UTC()
+	case oid.T_timestamp:
+		if len(s) != 8 {
+			break
+		}
+		return fromPgMicroseconds(int64(binary.BigEndian.Uint64(s))).In(time.FixedZone("", 0))
+	case oid.T_date:
+		if len(s) != 4 {
+			break
+		}
+		days := int64(int32(binary.BigEndian.Uint32(s)))
+		return time.Unix(pgEpoch+days*24*60*60, 0).In(time.FixedZone("", 0))
+	default:
+		return decodeBinary(s, typ)
+	}
+	errorf("invalid binary value of %d bytes for type %d", len(s), typ)
+	panic("not reached")
+}
+
+// appendCopyBinaryRow appends a row of values to buf, in COPY binary format,
+// for columns of typs.
+func appendCopyBinaryRow(buf []byte, v []driver.Value, typs []oid.Oid) []byte {
+	if len(v) != len(typs) {
+		errorf("binary COPY row has %d values; expected %d", len(v), len(typs))
+	}
+	w := writeBuf(buf)
+	w.int16(len(v))
+	for i, value := range v {
+		if isNull(value) {
+			w.int32(-1)
+			continue
+		}
+		b := encodeCopyBinary(value, typs[i])
+		w.int32(len(b))
+		w.bytes(b)
+	}
+	return w
+}
+
+// parseCopyBinaryRow reads a CopyData message of a binary COPY TO into dest,
+// decoding each field by its column's type.  The server sends the header
+// along with the first row, and the trailer on its own; ok is false if data
+// holds no row.
+func (st *stmt) parseCopyBinaryRow(data []byte, dest []driver.Value) (ok bool) {
+	if bytes.HasPrefix(data, []byte(copyBinarySignature)) {
+		if len(data) < len(copyBinaryHeader) {
+			errorf("short binary COPY header")
+		}
+		extension := int(binary.BigEndian.Uint32(data[len(copyBinarySignature)+4:]))
+		data = data[len(copyBinaryHeader):]
+		if extension < 0 || extension > len(data) {
+			errorf("invalid binary COPY header extension of %d bytes", extension)
+		}
+		data = data[extension:]
+	}
+	if len(data) == 0 {
+		return false
+	}
+	if len(data) < 2 {
+		errorf("short binary COPY row")
+	}
+	n := int(int16(binary.BigEndian.Uint16(data)))
+	data = data[2:]
+	if n == -1 {
+		// the trailer
+		return false
+	}
+	if n != len(st.rowTyps) {
+		errorf("COPY row has %d fields; expected %d", n, len(st.rowTyps))
+	}
+	for i := 0; i < n; i++ {
+		if len(data) < 4 {
+			errorf("short binary COPY row")
+		}
+		l := int(int32(binary.BigEndian.Uint32(data)))
+		data = data[4:]
+		if l > len(data) {
+			errorf("short binary COPY row")
+		}
+		if l < 0 {
+			if i < len(dest) {
+				dest[i] = nil
+			}
+			continue
+		}
+		if i < len(dest) {
+			dest[i] = decodeCopyBinary(&st.cn.parameterStatus, data[:l], st.rowTyps[i])
+		}
+		data = data[l:]
+	}
+	return true
+}
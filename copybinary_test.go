@@ -0,0 +1,155 @@
+package pq
+
+import (
+	"database/sql/driver"
+	"github.com/gregb/pq/oid"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCopyBinaryCodec(t *testing.T) {
+	ps := &parameterStatus{}
+	ts := time.Date(1999, time.December, 31, 23, 59, 58, 123456000, time.UTC)
+	tests := []struct {
+		value    interface{}
+		typ      oid.Oid
+		encoded  string
+		expected interface{}
+	}{
+		{int64(-2), oid.T_int4, "\xff\xff\xff\xfe", int64(-2)},
+		{int64(3), oid.T_float8, "\x40\x08\x00\x00\x00\x00\x00\x00", float64(3)},
+		{true, oid.T_bool, "\x01", true},
+		{[]byte{0, 1}, oid.T_bytea, "\x00\x01", []byte{0, 1}},
+		{"text", oid.T_varchar, "text", "text"},
+		{"{}", oid.T_jsonb, "\x01{}", []byte("{}")},
+		{ts, oid.T_timestamptz, "\xff\xff\xff\xff\xff\xe3\x5d\xc0", ts},
+		{ts.In(time.FixedZone("", 3600)), oid.T_timestamp, "\x00\x00\x00\x00\xd6\x77\x01\xc0", ts.Add(time.Hour)},
+		{ts, oid.T_date, "\xff\xff\xff\xff", time.Date(1999, time.December, 31, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		b := encodeCopyBinary(tt.value, tt.typ)
+		if string(b) != tt.encoded {
+			t.Errorf("%v as %d: expected %q, got %q", tt.value, tt.typ, tt.encoded, b)
+			continue
+		}
+		got := decodeCopyBinary(ps, b, tt.typ)
+		if expected, ok := tt.expected.(time.Time); ok {
+			if tm, ok := got.(time.Time); !ok || !tm.Equal(expected) {
+				t.Errorf("%q as %d: expected %v, got %v", b, tt.typ, expected, got)
+			}
+		} else if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q as %d: expected %#v, got %#v", b, tt.typ, tt.expected, got)
+		}
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic for a value of the wrong type")
+			}
+		}()
+		encodeCopyBinary("1", oid.T_int4)
+	}()
+}
+
+// Does not access database, simply tests reading the rows of a binary COPY
+func TestParseCopyBinaryRow(t *testing.T) {
+	st := &stmt{cn: &conn{}, rowTyps: []oid.Oid{oid.T_int4, oid.T_text}}
+
+	row := appendCopyBinaryRow(nil, []driver.Value{int64(7), nil}, st.rowTyps)
+	dest := make([]driver.Value, 2)
+	if !st.parseCopyBinaryRow(append([]byte(copyBinaryHeader), row...), dest) {
+		t.Fatal("expected a row after the header")
+	}
+	if !reflect.DeepEqual(dest, []driver.Value{int64(7), nil}) {
+		t.Errorf("unexpected row %#v", dest)
+	}
+	if st.parseCopyBinaryRow([]byte{0xff, 0xff}, dest) {
+		t.Error("expected no row in the trailer")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic for a short row")
+			}
+		}()
+		st.parseCopyBinaryRow(row[:len(row)-1], dest)
+	}()
+}
+
+func TestCopyBinaryFromDb(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	txn, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Rollback()
+
+	_, err = txn.Exec("CREATE TEMP TABLE temp (a int, b bytea, c timestamptz, d text)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stmt, err := txn.Prepare(CopyInBinary("temp", "a", "b", "c", "d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2020, time.March, 4, 5, 6, 7, 8000, time.UTC)
+	for i := 0; i < 1000; i++ {
+		// bytes that the text format would have to escape
+		if _, err := stmt.Exec(i, []byte{0, '\\', '\t', '\n'}, created, "tab\there"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := stmt.Exec(nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	res, err := stmt.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 1001 {
+		t.Errorf("expected 1001 rows, got %d", n)
+	}
+
+	rows, err := txn.Query("COPY (SELECT a, b, c, d FROM temp ORDER BY a) TO STDOUT WITH (FORMAT binary)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for rows.Next() {
+		var a *int
+		var b []byte
+		var c *time.Time
+		var d *string
+		if err := rows.Scan(&a, &b, &c, &d); err != nil {
+			t.Fatal(err)
+		}
+		if n < 1000 && (*a != n || string(b) != "\x00\\\t\n" || !c.Equal(created) || *d != "tab\there") {
+			t.Errorf("unexpected row %d: %v, %q, %v, %q", n, *a, b, *c, *d)
+		}
+		if n == 1000 && (a != nil || b != nil || c != nil || d != nil) {
+			t.Errorf("expected a row of NULLs, got %v, %q, %v, %v", a, b, c, d)
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1001 {
+		t.Errorf("expected 1001 rows, got %d", n)
+	}
+
+	// types without a binary codec are refused before the COPY starts
+	_, err = txn.Query("COPY (SELECT 1::numeric) TO STDOUT WITH BINARY")
+	if err == nil {
+		t.Fatal("expected an error for a numeric column")
+	}
+	if _, err := txn.Exec("SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+}
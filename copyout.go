@@ -0,0 +1,393 @@
+package pq
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/gregb/pq/message"
+	"io"
+	"strings"
+)
+
+// CopyOut creates a COPY TO statement that can be run with DB.Query, to
+// read the rows of a table as with a SELECT, but at the speed of a COPY.
+// Without columns, every column of the table is read.
+//
+//	rows, err := db.Query(pq.CopyOut("events", "id", "created"))
+//
+// The fields of the rows are decoded by their columns' types, as a query's
+// would be.
+func CopyOut(table string, columns ...string) string {
+	return copyOut(QuoteIdentifier(table), columns)
+}
+
+// CopyOutSchema creates a COPY TO statement like CopyOut does, for a table
+// in the given schema.
+func CopyOutSchema(schema, table string, columns ...string) string {
+	return copyOut(QuoteIdentifier(schema)+"."+QuoteIdentifier(table), columns)
+}
+
+func copyOut(table string, columns []string) string {
+	stmt := `COPY ` + table
+	if len(columns) > 0 {
+		quoted := make([]string, len(columns))
+		for i, col := range columns {
+			quoted[i] = QuoteIdentifier(col)
+		}
+		stmt += ` (` + strings.Join(quoted, ", ") + `)`
+	}
+	return stmt + ` TO STDOUT`
+}
+
+// copyOutSource returns a query for the rows that a COPY ... TO STDOUT
+// statement writes, whose description gives their column names and types:
+// the query of COPY (query) TO, or a SELECT of the columns of COPY table
+// (columns) TO.  ok is false if q isn't a COPY TO STDOUT.  binary is true
+// for a COPY in binary format, the one option after STDOUT that is allowed;
+// others, such as CSV or a delimiter, are refused, since the rows are
+// otherwise read in the text format with its usual delimiter and NULL.
+func copyOutSource(q string) (source string, binary bool, ok bool) {
+	i := len("COPY")
+	for i < len(q) && strings.IndexByte(" \t\n\r", q[i]) >= 0 {
+		i++
+	}
+
+	var rest []string
+	if i < len(q) && q[i] == '(' {
+		j := skipParenthesized(q, i)
+		if j > len(q) {
+			return "", false, false
+		}
+		source = q[i+1 : j-1]
+		rest = tokenizeCopy(q[j:])
+	} else {
+		tokens := tokenizeCopy(q[i:])
+		var table string
+		var columns []string
+		inColumns := false
+		for len(tokens) > 0 {
+			tok := tokens[0]
+			if !inColumns && strings.EqualFold(tok, "TO") {
+				break
+			}
+			tokens = tokens[1:]
+			switch {
+			case tok == "(":
+				inColumns = true
+			case tok == ")":
+				inColumns = false
+			case inColumns && tok != ",":
+				columns = append(columns, tok)
+			case !inColumns:
+				// words, such as ONLY, are kept apart, but not the
+				// parts of a qualified name
+				if table != "" && !strings.HasSuffix(table, ".") && !strings.HasPrefix(tok, ".") {
+					table += " "
+				}
+				table += tok
+			}
+		}
+		if table == "" {
+			return "", false, false
+		}
+		list := "*"
+		if len(columns) > 0 {
+			list = strings.Join(columns, ", ")
+		}
+		source = "SELECT " + list + " FROM " + table
+		rest = tokens
+	}
+
+	if len(rest) < 2 || !strings.EqualFold(rest[0], "TO") || !strings.EqualFold(rest[1], "STDOUT") {
+		return "", false, false
+	}
+	// WITH BINARY, or WITH (FORMAT binary)
+	var options []string
+	for _, tok := range rest[2:] {
+		switch strings.ToUpper(tok) {
+		case "WITH", "(", ")", ";":
+		default:
+			options = append(options, strings.Trim(tok, "'"))
+		}
+	}
+	switch {
+	case len(options) == 0:
+	case len(options) == 1 && strings.EqualFold(options[0], "BINARY"),
+		len(options) == 2 && strings.EqualFold(options[0], "FORMAT") && strings.EqualFold(options[1], "binary"):
+		binary = true
+	default:
+		errorf("COPY TO options such as %s aren't supported for reading rows", options[0])
+	}
+	return source, binary, true
+}
+
+// skipParenthesized returns the index after the parenthesized part of q
+// starting at q[i], skipping over strings, quoted identifiers and comments,
+// or len(q)+1 if it isn't closed.
+func skipParenthesized(q string, i int) int {
+	depth := 0
+	for j := i; j < len(q); {
+		c := q[j]
+		switch {
+		case c == '\'':
+			j = skipQuoted(q, j, isEscapeString(q, j))
+		case c == '"':
+			j = skipQuoted(q, j, false)
+		case c == '-' && strings.HasPrefix(q[j:], "--"):
+			end := strings.IndexByte(q[j:], '\n')
+			if end < 0 {
+				return len(q) + 1
+			}
+			j += end
+		case c == '/' && strings.HasPrefix(q[j:], "/*"):
+			j = skipBlockComment(q, j)
+		case c == '$' && !isIdentChar(q, j-1):
+			j = skipDollarQuoted(q, j)
+		case c == '(':
+			depth++
+			j++
+		case c == ')':
+			depth--
+			j++
+			if depth == 0 {
+				return j
+			}
+		default:
+			j++
+		}
+	}
+	return len(q) + 1
+}
+
+// copyOutStmt is a COPY TO STDOUT statement, which Query runs to read its
+// rows like those of a SELECT.
+type copyOutStmt struct {
+	cn     *conn
+	query  string
+	source string
+	binary bool
+}
+
+func (cn *conn) prepareCopyOut(q, source string, binary bool) driver.Stmt {
+	return &copyOutStmt{cn: cn, query: q, source: source, binary: binary}
+}
+
+func (co *copyOutStmt) Close() error {
+	return nil
+}
+
+func (co *copyOutStmt) NumInput() int {
+	return 0
+}
+
+// Exec runs the COPY and discards its rows.  The result has the number of
+// rows copied as its RowsAffected.
+func (co *copyOutStmt) Exec(v []driver.Value) (driver.Result, error) {
+	r, err := co.Query(v)
+	if err != nil {
+		return nil, err
+	}
+	rs := r.(*rows)
+	if err := rs.Close(); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(rs.rowCount), nil
+}
+
+// Query runs the COPY and returns its rows.  The source of the rows is
+// described first, for the names and types of their columns.
+func (co *copyOutStmt) Query(v []driver.Value) (_ driver.Rows, err error) {
+	cn := co.cn
+	defer cn.hooks.queryEnd(co.query, cn.hooks.queryStart(co.query), &err)
+	defer errRecover(&err)
+	cn.checkBad()
+
+	st := &stmt{cn: cn, name: "", query: co.source}
+	st.prepare()
+	if co.binary {
+		checkCopyBinaryTypes(st.rowTyps)
+	}
+
+	startBytes := cn.bytesReceived
+	b := cn.writeMessageType(message.Query)
+	b.string(co.query)
+	cn.send(b)
+
+	for {
+		t, r := cn.recv1()
+		switch t {
+		case message.CopyOutResponse:
+			if binaryFormat := r.byte() != 0; binaryFormat != co.binary {
+				errorf("COPY started in an unexpected format")
+			}
+			if n := r.int16(); n != len(st.cols) {
+				errorf("COPY writes %d columns but its source has %d", n, len(st.cols))
+			}
+			return &rows{st: st, startBytes: startBytes, copyBinary: co.binary}, nil
+		case message.Error:
+			err = parseError(r)
+		case message.ReadyForQuery:
+			cn.processReadyForQuery(r)
+			if err == nil {
+				errorf("unexpected ReadyForQuery before COPY started")
+			}
+			return nil, err
+		default:
+			errorf("unknown response for copy query: %q", t)
+		}
+	}
+}
+
+// CopyOutRaw copies table from c's underlying connection to w in one of the
+// COPY formats, "text", "csv" or "binary", as the server writes it, such as
+// for CopyInRaw into another database.  The data is written to w as it
+// arrives, a CopyData message at a time, rather than held in memory.  It
+// returns the number of rows copied.  If writing to w fails, the rest of the
+// data is read and discarded, so that the connection can be used again, and
+// the error returned.
+func CopyOutRaw(c *sql.Conn, table, format string, w io.Writer) (n int64, err error) {
+	switch strings.ToLower(format) {
+	case "text", "csv", "binary":
+	default:
+		return 0, fmt.Errorf("pq: unknown COPY format %q", format)
+	}
+	q := `COPY ` + QuoteIdentifier(table) + ` TO STDOUT WITH (FORMAT ` + format + `)`
+
+	err = c.Raw(func(driverConn interface{}) error {
+		cn, ok := driverConn.(*conn)
+		if !ok {
+			return fmt.Errorf("pq: CopyOutRaw called on a %T connection", driverConn)
+		}
+		n, err = cn.copyOutRaw(q, w)
+		return err
+	})
+	return n, err
+}
+
+func (cn *conn) copyOutRaw(q string, w io.Writer) (n int64, err error) {
+	defer cn.hooks.queryEnd(q, cn.hooks.queryStart(q), &err)
+	defer errRecover(&err)
+	cn.checkBad()
+
+	b := cn.writeMessageType(message.Query)
+	b.string(q)
+	cn.send(b)
+
+	var writeErr error
+	for {
+		t, r := cn.recv1()
+		switch t {
+		case message.CopyOutResponse, message.CopyDone:
+			// ignore
+		case message.CopyData:
+			if writeErr == nil {
+				_, writeErr = w.Write(*r)
+			}
+		case message.CommandComplete:
+			n, _ = parseComplete(r.string())
+		case message.Error:
+			err = parseError(r)
+		case message.ReadyForQuery:
+			cn.processReadyForQuery(r)
+			if writeErr != nil {
+				return 0, writeErr
+			}
+			return n, err
+		default:
+			errorf("unknown response for copy: %q", t)
+		}
+	}
+}
+
+// parseCopyRow reads a line of COPY text format into dest, decoding each
+// field by its column's type as parseDataRow does.  Tabs within fields are
+// escaped, so the line splits at tabs.
+func (st *stmt) parseCopyRow(line []byte, dest []driver.Value) {
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		line = line[:n-1]
+	}
+	fields := bytes.Split(line, []byte{'\t'})
+	if len(fields) != len(st.rowTyps) {
+		errorf("COPY row has %d fields; expected %d", len(fields), len(st.rowTyps))
+	}
+	if len(fields) > len(dest) {
+		fields = fields[:len(dest)]
+	}
+	for i, f := range fields {
+		if string(f) == `\N` {
+			dest[i] = nil
+			continue
+		}
+		dest[i] = decode(&st.cn.parameterStatus, unescapeCopyText(f), st.rowTyps[i])
+	}
+}
+
+// unescapeCopyText undoes the backslash escapes of a field of COPY text
+// format: \b, \f, \n, \r, \t and \v, one to three octal digits, x and one or
+// two hex digits, and a backslash before any other character, which stands
+// for that character.
+func unescapeCopyText(f []byte) []byte {
+	if bytes.IndexByte(f, '\\') < 0 {
+		return f
+	}
+	out := make([]byte, 0, len(f))
+	for i := 0; i < len(f); i++ {
+		c := f[i]
+		if c != '\\' || i+1 == len(f) {
+			out = append(out, c)
+			continue
+		}
+		i++
+		switch c = f[i]; c {
+		case 'b':
+			out = append(out, '\b')
+		case 'f':
+			out = append(out, '\f')
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'v':
+			out = append(out, '\v')
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			v := c - '0'
+			for n := 1; n < 3 && i+1 < len(f) && f[i+1] >= '0' && f[i+1] <= '7'; n++ {
+				i++
+				v = v*8 + f[i] - '0'
+			}
+			out = append(out, v)
+		case 'x':
+			var v byte
+			n := 0
+			for ; n < 2 && i+1 < len(f) && isHexDigit(f[i+1]); n++ {
+				i++
+				v = v*16 + unhex(f[i])
+			}
+			if n == 0 {
+				out = append(out, 'x')
+			} else {
+				out = append(out, v)
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
@@ -0,0 +1,282 @@
+package pq
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCopyOutStatement(t *testing.T) {
+	if s := CopyOut("events"); s != `COPY "events" TO STDOUT` {
+		t.Errorf("unexpected statement %s", s)
+	}
+	if s := CopyOut("events", "id", "created"); s != `COPY "events" ("id", "created") TO STDOUT` {
+		t.Errorf("unexpected statement %s", s)
+	}
+	if s := CopyOutSchema("app", "events", "id"); s != `COPY "app"."events" ("id") TO STDOUT` {
+		t.Errorf("unexpected statement %s", s)
+	}
+}
+
+func TestCopyOutSource(t *testing.T) {
+	tests := []struct {
+		q      string
+		source string
+		binary bool
+		ok     bool
+	}{
+		{`COPY "events" TO STDOUT`, `SELECT * FROM "events"`, false, true},
+		{`COPY "app"."events" ("id", "created") TO STDOUT`, `SELECT "id", "created" FROM "app"."events"`, false, true},
+		{`copy events (id) to stdout;`, `SELECT id FROM events`, false, true},
+		{`COPY ONLY app . "events" TO STDOUT`, `SELECT * FROM ONLY app."events"`, false, true},
+		{`COPY (SELECT a, ')' FROM t WHERE f(a)) TO STDOUT`, `SELECT a, ')' FROM t WHERE f(a)`, false, true},
+		{`COPY events TO STDOUT WITH BINARY`, `SELECT * FROM events`, true, true},
+		{`COPY events TO STDOUT WITH (FORMAT 'binary');`, `SELECT * FROM events`, true, true},
+		{`COPY events FROM STDIN`, ``, false, false},
+		{`COPY events TO '/tmp/events'`, ``, false, false},
+		{`COPY (SELECT 1 TO STDOUT`, ``, false, false},
+	}
+	for _, tt := range tests {
+		source, binary, ok := copyOutSource(tt.q)
+		if source != tt.source || binary != tt.binary || ok != tt.ok {
+			t.Errorf("%s: expected %q, %v, %v, got %q, %v, %v", tt.q, tt.source, tt.binary, tt.ok, source, binary, ok)
+		}
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic for COPY TO options")
+			}
+		}()
+		copyOutSource(`COPY events TO STDOUT WITH CSV`)
+	}()
+}
+
+func TestUnescapeCopyText(t *testing.T) {
+	tests := []struct {
+		field    string
+		expected string
+	}{
+		{`plain`, "plain"},
+		{`a\tb\nc\\d`, "a\tb\nc\\d"},
+		{`\b\f\r\v`, "\b\f\r\v"},
+		{`\101\7x`, "A\x07x"},
+		{`\x41\x4g\xz`, "A\x04gxz"},
+		{`\.`, "."},
+	}
+	for _, tt := range tests {
+		if got := string(unescapeCopyText([]byte(tt.field))); got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.field, tt.expected, got)
+		}
+	}
+}
+
+// backendMessage returns a message of type t from the server with body.
+func backendMessage(t byte, body string) string {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(body)+4))
+	return string(t) + string(n[:]) + body
+}
+
+// Does not access database, simply tests reading the rows of a COPY TO
+func TestCopyOutRows(t *testing.T) {
+	column := func(name string, typ uint32) string {
+		var b [18]byte
+		binary.BigEndian.PutUint32(b[6:], typ)
+		return name + "\x00" + string(b[:])
+	}
+	ready := backendMessage('Z', "I")
+	response := backendMessage('1', "") +
+		backendMessage('t', "\x00\x00") +
+		backendMessage('T', "\x00\x02"+column("id", 23)+column("name", 25)) +
+		ready +
+		backendMessage('H', "\x00\x00\x02\x00\x00\x00\x00") +
+		backendMessage('d', "1\tone\\ttab\n") +
+		backendMessage('d', "2\t\\N\n") +
+		backendMessage('c', "") +
+		backendMessage('C', "COPY 2\x00") +
+		ready
+
+	cn := fakeConn(response, 0)
+	st, err := cn.prepareCopyIn(CopyOut("names", "id", "name"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := st.Query(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if cols := r.Columns(); !reflect.DeepEqual(cols, []string{"id", "name"}) {
+		t.Errorf("unexpected columns %v", cols)
+	}
+	expected := [][]driver.Value{{int64(1), []byte("one\ttab")}, {int64(2), nil}}
+	for _, row := range expected {
+		dest := make([]driver.Value, 2)
+		if err := r.Next(dest); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(dest, row) {
+			t.Errorf("expected %#v, got %#v", row, dest)
+		}
+	}
+	if err := r.Next(make([]driver.Value, 2)); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestCopyOutFromDb(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	txn, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Rollback()
+
+	_, err = txn.Exec("CREATE TEMP TABLE temp (a int, b text, c timestamptz)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = txn.Exec(`INSERT INTO temp VALUES (1, E'tab\tand\\backslash', now()), (2, NULL, NULL)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := txn.Query(CopyOut("temp", "a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for rows.Next() {
+		var a int
+		var b *string
+		if err := rows.Scan(&a, &b); err != nil {
+			t.Fatal(err)
+		}
+		if b == nil {
+			got = append(got, "NULL")
+		} else {
+			got = append(got, *b)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, "|") != "tab\tand\\backslash|NULL" {
+		t.Errorf("unexpected rows %q", got)
+	}
+
+	// the columns of the query of COPY (query) TO are described too
+	var c interface{}
+	err = txn.QueryRow("COPY (SELECT c FROM temp WHERE a = 1) TO STDOUT").Scan(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.(time.Time); !ok {
+		t.Errorf("expected a time.Time, got %T", c)
+	}
+
+	res, err := txn.Exec(CopyOut("temp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 2 {
+		t.Errorf("expected 2 rows, got %d", n)
+	}
+}
+
+type errWriter struct {
+	err error
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
+
+// Does not access database, simply tests passing on the data of a COPY TO
+func TestCopyOutRawData(t *testing.T) {
+	response := backendMessage('H', "\x00\x00\x02\x00\x00\x00\x00") +
+		backendMessage('d', "1,one\n") +
+		backendMessage('d', "2,two\n") +
+		backendMessage('c', "") +
+		backendMessage('C', "COPY 2\x00") +
+		backendMessage('Z', "I")
+
+	var out bytes.Buffer
+	n, err := fakeConn(response, 0).copyOutRaw(`COPY "t" TO STDOUT WITH (FORMAT csv)`, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || out.String() != "1,one\n2,two\n" {
+		t.Errorf("unexpected %d rows %q", n, out.String())
+	}
+
+	// a failing writer gets no more data, but the COPY is read to its end
+	writeErr := errors.New("write failed")
+	cn := fakeConn(response, 0)
+	if _, err := cn.copyOutRaw(`COPY "t" TO STDOUT WITH (FORMAT csv)`, &errWriter{writeErr}); err != writeErr {
+		t.Fatalf("expected %v, got %v", writeErr, err)
+	}
+	if cn.txnStatus != TxnStatusIdle {
+		t.Errorf("expected the connection to be idle, got %v", cn.txnStatus)
+	}
+}
+
+func TestCopyOutRaw(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	c, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = c.ExecContext(context.Background(), "CREATE TEMP TABLE temp (num INTEGER, text VARCHAR)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.ExecContext(context.Background(), "INSERT INTO temp SELECT i, 'with, comma' FROM generate_series(1, 10000) i")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var csv bytes.Buffer
+	n, err := CopyOutRaw(c, "temp", "csv", &csv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10000 {
+		t.Fatalf("expected 10000 rows copied, not %d", n)
+	}
+	if !strings.HasPrefix(csv.String(), "1,\"with, comma\"\n2,") {
+		t.Errorf("unexpected data %q", csv.String()[:40])
+	}
+
+	// what is copied out can be copied back in
+	var binaryData bytes.Buffer
+	if _, err := CopyOutRaw(c, "temp", "binary", &binaryData); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := CopyInRaw(c, "temp", "binary", &binaryData); err != nil || n != 10000 {
+		t.Fatalf("expected 10000 rows copied in, got %d, %v", n, err)
+	}
+
+	if _, err := CopyOutRaw(c, "missing", "text", &csv); err == nil {
+		t.Fatal("expected an error for a missing table")
+	}
+	if _, err := CopyOutRaw(c, "temp", "xml", &csv); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
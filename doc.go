@@ -31,7 +31,7 @@ Connection String Parameters
 Similarly to libpq, when establishing a connection using pq you are expected to
 supply a connection string containing zero or more parameters.
 A subset of the connection parameters supported by libpq are also supported by pq.
-Additionally, pq also lets you specify run-time parameters (such as search_path or work_mem)
+Additionally, pq also lets you specify run-time parameters (such as timezone or work_mem)
 directly in the connection string.  This is different from libpq, which does not allow
 run-time parameters in the connection string, instead requiring you to supply
 them in the options parameter.
@@ -44,14 +44,60 @@ supported:
 	* password - The user's password
 	* host - The host to connect to. Values that start with / are for unix domain sockets. (default is localhost)
 	* port - The port to bind to. (default is 5432)
+	* service - The name of a connection service whose settings are read from the service file; see below
+	* target_session_attrs - The kind of server to connect to when several hosts are given; see below (default is any)
+	* role - The role to assume with SET ROLE, set on every connection and set again whenever database/sql reuses one; see below
+	* search_path - The comma-separated schemas to look up unqualified names in, set on every connection and set again whenever database/sql reuses one; see below
+	* discard_on_reset - Whether DISCARD ALL is run whenever database/sql reuses a connection, to drop temporary tables, session settings and the like left by its previous user; see below (default is false)
+	* statement_name_prefix - A prefix for the names of the statements pq prepares on the server, so they can't collide with the names of statements prepared with PREPARE, of at most 44 bytes (default is none, the names are numbers)
+	* lock_timeout - How long, in milliseconds, a statement may wait for a lock before it fails with ErrCodeLockNotAvailable, set like search_path (default is the server's)
+	* idle_in_transaction_session_timeout - How long, in milliseconds, a connection may sit idle in a transaction before the server ends it, and the next statement fails with ErrCodeIdleInTransactionSessionTimeout, set like search_path (default is the server's)
 	* sslmode - Whether or not to use SSL (default is require, this is not the default for libpq)
+	* tls_server_name - The server name to send for SNI, if it differs from host
+	* sslcert - The file holding the client's SSL certificate, if the server requires one
+	* sslkey - The file holding the key for sslcert
+	* sslrootcert - The file holding the certificates to verify the server against with verify-ca or verify-full, instead of the system's
+	* sslcrl - The file holding the certificate revocation lists to reject revoked server certificates with verify-ca or verify-full; each must be signed by its issuer, from the server's chain or sslrootcert
+	* max_idle_time - How long a pooled connection may be idle before it is checked with a ping when it is next used, in seconds or as a Go duration such as "5m" (default is 0, never)
+	* cancel_grace_period - How long to wait for a query cancelled by its context to stop before the connection is closed and marked bad instead, in seconds or as a Go duration (default is 10s)
+	* strict_command_tags - Whether BEGIN, COMMIT and ROLLBACK must complete with their own command tags, or the connection is discarded; set it to false behind connection poolers or proxies that rewrite or absorb transaction control statements (default is true)
+	* prefer_simple_protocol - Whether to run queries with the simple query protocol, with their parameters interpolated, instead of preparing them on the server (default is false); see below
+	* binary_results - Whether the values of bytea, bool, integer and floating-point columns are read in binary format, which saves parsing them, and hex-decoding bytea, while other columns are still read as text; it has no effect with prefer_simple_protocol (default is false)
+	* binary_parameters - Whether integer, floating-point and boolean parameters are sent in binary format when the server expects a parameter of that type, which saves formatting and parsing them, while other parameters are still sent as text; it has no effect with prefer_simple_protocol (default is false)
+	* keep_raw_values - Whether rows keep the bytes the server sent for each value of the current row, for their RawValue method; see below (default is false)
+	* text_as_string - Whether values of every text type, including bpchar, json, xml, enums and other types that aren't built in, are read as strings rather than some of them as []byte, in arrays too (default is false)
+	* truncate_timestamps - Whether time.Time parameters are sent truncated to microseconds, so that a time t is read back as t.Truncate(time.Microsecond), rather than rounded by the server (default is false)
+	* hstore - Whether to look up the hstore extension's type when connecting, so that hstore values are read as map[string]sql.NullString (default is false); see below
+
+The settings that are either on or off take true or false, yes or no, on or
+off, or 1 or 0, in any case, as the server's own boolean settings do.
 
 Valid values for sslmode are:
 
 	* disable - No SSL
 	* require - Always SSL (skip verification)
+	* verify-ca - Always SSL (verify that the server's certificate was issued by a trusted authority, but not that it is for host)
 	* verify-full - Always SSL (require verification)
 
+With verify-full, the server's certificate is verified against host, even if
+tls_server_name is set, so that a proxy routing by SNI can't stand in for the
+intended server.
+With verify-ca, a certificate issued by a trusted authority is accepted
+whatever name it is for, as where the server is reached by an address its
+certificate doesn't name.
+
+host and port may be comma-separated lists, to try several servers in turn.
+Either one port applies to every host or there is one for each.  pq
+connects to the first server that accepts the connection and whose session
+matches target_session_attrs, which is one of:
+
+	* any - Any server
+	* read-write - A server whose sessions default to read-write transactions
+	* read-only - A server whose sessions default to read-only transactions
+	* primary - A server that is not in hot standby
+	* standby - A server in hot standby
+	* prefer-standby - A standby if one can be connected to, otherwise any server
+
 See http://www.postgresql.org/docs/current/static/libpq-connect.html#LIBPQ-CONNSTRING
 for more information about connection string parameters.
 
@@ -69,12 +115,74 @@ set at backend start time can be set in the connection string.  For more
 information, see
 http://www.postgresql.org/docs/current/static/runtime-config.html.
 
+Run-time parameters set this way are the session defaults of every
+connection, which is how a pool of connections can share, for example, a
+default_transaction_isolation, default_transaction_read_only or timezone.
+pq checks the values of the default_transaction_* parameters before
+connecting.
+
+search_path is the exception: pq sets it with SET search_path right after
+connecting, and sets it again each time database/sql takes the connection
+from its pool, so that a schema set by a previous user of the connection,
+such as another tenant's, doesn't carry over.  The schema names are checked
+and quoted before being sent; unquoted names are folded to lower case, as
+in SQL.
+
+	"dbname=app search_path='tenant_42, \"$user\", public'"
+
+lock_timeout and idle_in_transaction_session_timeout are set the same way,
+so that a pooled connection keeps its bounds on lock waits and abandoned
+transactions whatever a previous user of it set.  A statement that waits too
+long for a lock fails with a *pq.Error whose Code is ErrCodeLockNotAvailable.
+A transaction left idle for too long is ended by the server, which closes
+the connection; the next statement in it fails with a *pq.Error whose Code
+is ErrCodeIdleInTransactionSessionTimeout.
+
+role is set the same way too, with SET ROLE, so that every connection of a
+pool acts as that role, and one that a previous user of the connection set
+with SET ROLE of its own doesn't carry over.  The role name is taken as it
+is, case and all, and quoted.  If the user isn't a member of the role,
+connecting fails with a *pq.Error whose Code is
+ErrCodeInsufficientPrivilege.
+
+	"user=app_login role=tenant_42"
+
+With discard_on_reset, each time database/sql takes a connection from its
+pool pq runs DISCARD ALL first, as a connection pooler would, and then sets
+role, search_path and the timeouts again.  DISCARD ALL deallocates the
+statements prepared on the connection too; pq prepares each of them again
+the next time it is used, as it does after DISCARD ALL or DEALLOCATE ALL run
+with Exec.
+
 Most environment variables as specified at http://www.postgresql.org/docs/current/static/libpq-envars.html
 supported by libpq are also supported by pq.  If any of the environment
 variables not supported by pq are set, pq will panic during connection
 establishment.  Environment variables have a lower precedence than explicitly
 provided connection parameters.
 
+The settings of a connection service, named with service or PGSERVICE, are
+read from the service file as libpq reads them: from the file PGSERVICEFILE
+names, or else ~/.pg_service.conf, and then from pg_service.conf in the
+directory PGSYSCONFDIR names, whichever defines the service first.  Each
+service is a section headed by its name in brackets, of key=value lines of
+connection parameters:
+
+	[reports]
+	host=replica.example.com
+	dbname=sales
+	target_session_attrs=read-only
+
+The service's settings take precedence over environment variables, and
+explicitly provided parameters over the service's.  Connecting fails if the
+service isn't defined.
+
+A password is sent the way the server asks for it: as it is, md5-hashed, or
+with SCRAM-SHA-256, which only uses SHA-256 and HMAC.  Programs run with
+GODEBUG=fips140=only, in which Go refuses to compute md5 hashes, can't use md5
+authentication; with Go 1.26 and later, connecting to a server that asks for
+it fails with an error saying so.  Set password_encryption to scram-sha-256
+on the server, and the password again, to use SCRAM instead.
+
 
 Queries
 
@@ -85,21 +193,257 @@ as shown above. The same marker can be reused for the same parameter:
 	rows, err := db.Query(`SELECT name FROM users WHERE favorite_fruit = $1
 		OR age BETWEEN $2 AND $2 + 3`, "orange", 64)
 
-pq does not support the LastInsertId() method of the Result type in database/sql.
-To return the identifier of an INSERT (or UPDATE or DELETE), use the Postgres
-RETURNING clause with a standard Query or QueryRow call:
+The server infers the type of each parameter from the query.  Where it
+can't, as in SELECT $1 IS NULL, the query fails with a *pq.Error whose Code
+is ErrCodeIndeterminateDatatype ("could not determine data type of parameter
+$1"); cast the parameter, as in $1::text, to give it a type.  A parameter the
+server leaves of type unknown is sent as text for it to cast: numbers,
+booleans and times as their usual text, and strings and []byte as they are,
+though []byte that isn't valid UTF-8 text is refused; cast such a parameter
+to bytea.
+
+The context of QueryContext, ExecContext and BeginTx is watched while the
+statement runs: once it is done, pq asks the server to cancel the
+statement, which then fails with a *pq.Error of code 57014
+(query_canceled), and the connection is ready for the next one.  If the
+server doesn't give up on the statement within cancel_grace_period, the
+connection is closed instead.
+
+The isolation level and read-only mode of the sql.TxOptions given to BeginTx
+are passed on in the BEGIN statement.  sql.LevelSnapshot is REPEATABLE
+READ, which is snapshot isolation in Postgres; sql.LevelWriteCommitted and
+sql.LevelLinearizable are refused.
+
+Postgres has no notion of a last insert id, so the LastInsertId() method of
+the Result type in database/sql only works for statements with a RETURNING
+clause that returns a single integer column, such as RETURNING id; the rows
+of a SELECT run with Exec never give one.  To return other columns of an
+INSERT (or UPDATE or DELETE), use the Postgres RETURNING clause with a
+standard Query or QueryRow call:
 
 	rows, err := db.Query(`INSERT INTO users(name, favorite_fruit, age)
 		VALUES('beatrice', 'starfruit', 93) RETURNING id`)
 
+An INSERT of several rows returns a row for each; Query reads them all, just
+as it reads the rows of a SELECT, while the LastInsertId of Exec is the last
+row's.  Exec keeps the rows too, though the sql.Result of DB.Exec has no way
+to hand them over; a statement run on the driver connection underneath a
+sql.Conn returns a pq.Result, whose Returned method gives the rows as Query
+would have read them:
+
+	err = c.Raw(func(driverConn interface{}) error {
+		st, err := driverConn.(driver.Conn).Prepare("DELETE FROM sessions WHERE expires < now() RETURNING id")
+		if err != nil {
+			return err
+		}
+		defer st.Close()
+		res, err := st.Exec(nil)
+		if err != nil {
+			return err
+		}
+		_, rows := res.(pq.Result).Returned()
+		log.Printf("expired %d sessions", len(rows))
+		return nil
+	})
+
+Since Exec keeps every row a statement returns, use Query for a statement
+that returns many.
+
 For more details on RETURNING, see the Postgres documentation:
 
 	http://www.postgresql.org/docs/current/static/sql-insert.html
 	http://www.postgresql.org/docs/current/static/sql-update.html
 	http://www.postgresql.org/docs/current/static/sql-delete.html
 
+A CALL of a procedure with INOUT parameters, or OUT parameters since
+Postgres 14, returns their values as a single row, to be read with Query or
+QueryRow like any other:
+
+	var total int
+	err := db.QueryRow("CALL add_to_total($1, $2)", 42, nil).Scan(&total)
+
+A nil []byte parameter is sent as NULL, while an empty, non-nil one is an
+empty bytea (or string).  This holds for array elements and COPY as well, and
+an empty bytea is read back as an empty, non-nil []byte.
+
+A large bytea can be written straight to an io.Writer, such as a file, by
+scanning it with pq.ByteaWriter, rather than into a []byte.
+
+timestamptz values are read in the server's TimeZone.  Loading it takes a
+time zone database, which minimal containers may not have; the values then
+only have their UTC offsets, and pq logs a warning saying why, once for each
+zone, or passes it to the OnWarning hook of a Connector's Hooks instead.  To
+embed a database in the program, import time/tzdata or build with -tags
+timetzdata.
+
+Postgres keeps times to the microsecond, and rounds the nanoseconds of
+time.Time parameters to it.  With truncate_timestamps=true they are sent
+truncated to microseconds instead, so that a time t is read back as
+t.Truncate(time.Microsecond).
+
+numeric values are read as their text, so they can be scanned into a string
+to keep every digit, or into an int64 or a float64 where that's close
+enough.  Aggregates return numeric more often than might be expected: sum of
+a bigint or numeric column and avg of any integer or numeric column are
+numeric, while count is a bigint, sum of an int or smallint column is a
+bigint, and sum and avg of real and double precision columns are double
+precision.  A numeric with a fraction, such as most averages, can't be
+scanned into an int64.
+
+pq.Numeric holds a numeric as its text, and can be scanned into or passed
+as a parameter; pq.NullNumeric also takes NULL.  Its Rat method returns the
+exact value as a *big.Rat:
+
+	var price pq.Numeric
+	err := db.QueryRow("SELECT price FROM items WHERE id = $1", id).Scan(&price)
+
+Arrays are read as slices, such as []int64, so they can be scanned into
+those directly, and into others with pq.Array.  A NULL array leaves the
+slice nil, while an empty array, {}, makes it an empty slice that isn't nil.
+An array with NULL elements is read as a slice of pointers, []*int64, with
+nil for NULL; scan it with pq.Array into a []*int64 or []sql.NullInt64, as
+a []int64 can't hold its NULLs.
+
+numeric and money arrays are read as []string, each element's text as the
+server wrote it, so no digits are lost; money elements keep their currency
+symbol and separators, which follow the server's lc_monetary.
+
+Intervals are read as their text, so they can be scanned into a string, or
+into a pq.Interval, which parses it.  Interval arrays are read as
+[]pq.Interval.
+
+The result of a function returning void, as in SELECT pg_notify('jobs', ''),
+is read as nil, so it can be scanned into an interface{}, a sql.RawBytes or
+any other destination that takes NULL.
+
+net.IP parameters are sent as inet addresses, such as 192.168.0.1 or ::1, and
+net.IPNet parameters with their netmask, such as 192.168.0.1/24.  inet and
+cidr values are read as text; scan them with pq.Inet into a net.IP or a
+net.IPNet:
+
+	var ip net.IP
+	err := db.QueryRow("SELECT addr FROM hosts WHERE id = $1", id).Scan(pq.Inet(&ip))
+
+Slices of net.IP, net.IPNet and *net.IPNet passed to pq.Array are sent as
+inet arrays, and inet and cidr arrays can be scanned into them with
+pq.Array; a NULL element is a nil net.IP, a zero net.IPNet or a nil
+*net.IPNet.
+
+big.Int and *big.Int parameters are sent as their decimal text, which suits
+numeric columns, and bigint columns as long as the value fits.  Scan an
+integral numeric, or any integer, with pq.BigInt into a *big.Int:
+
+	var n *big.Int
+	err := db.QueryRow("SELECT total FROM counters WHERE id = $1", id).Scan(pq.BigInt(&n))
+
+json and jsonb values are read as their JSON text, as []byte, so they can
+be scanned into a json.RawMessage or a string; json and jsonb arrays are
+read as []string.  json.RawMessage parameters are sent as their text, rather
+than as a bytea like other []byte, and struct, map and slice parameters,
+or pointers to them, implementing json.Marshaler, but not driver.Valuer, as
+the JSON it returns.  Named integer and string types are sent as what they
+are, even if they implement json.Marshaler:
+
+	err := db.QueryRow("SELECT doc FROM events WHERE doc @> $1", json.RawMessage(`{"kind": "login"}`)).Scan(&doc)
+
+uuid values are read as strings, checked to be uuids, which other UUID
+types, such as those of github.com/google/uuid, scan from; uuid arrays are
+read as []string.  Scan them into a pq.UUID, or a pq.NullUUID if they may
+be NULL, to have them parsed into 16 bytes.
+pq.UUID and [16]byte parameters are sent as uuids:
+
+	var id pq.UUID
+	err := db.QueryRow("INSERT INTO items (name) VALUES ($1) RETURNING id", name).Scan(&id)
+
+map[string]string and map[string]sql.NullString parameters are sent as the
+text of an hstore.  hstore's type belongs to its extension, so its OID
+differs from database to database; with hstore=true, it is looked up when
+connecting, and hstore values are then read as map[string]sql.NullString,
+with NULL values not Valid.  A NULL hstore can't be scanned into a map; the
+Hstore type of github.com/gregb/pq/hstore takes either.  The extension must
+be installed before connecting:
+
+	var attrs map[string]sql.NullString
+	err := db.QueryRow("SELECT attrs FROM items WHERE id = $1", id).Scan(&attrs)
+
+The ScanType of a sql.ColumnType is a type that can hold NULL, such as
+sql.NullInt64 for an int4 column, sql.NullString for a varchar and
+pq.NullTime for a timestamptz, or a pointer to what the value is read as.
+Whether a column can actually be NULL isn't reported by the server, so every
+column is taken to be nullable.  Arrays are interface{}, as are types read
+by a decoder set with SetUnknownTypeDecoder.
+
 For additional instructions on querying see the documentation for the database/sql package.
 
+Connection poolers
+
+Connection poolers such as PgBouncer in transaction pooling mode may run
+each statement on a different server connection, so a statement prepared on
+one may not exist on the next: queries fail with errors like "prepared
+statement does not exist".  With prefer_simple_protocol=true, statements are
+never prepared on the server.  Each query is sent with the simple query
+protocol instead, its $n parameters replaced by quoted literals (see
+QuoteLiteral), which the server types as it would parameters.  []byte
+parameters are sent as bytea, while the []byte a driver.Valuer returns, such
+as pq.Array's array text or JSON, is sent as text.  Slices are sent as arrays
+of the type their elements suggest.  Parameters with NUL bytes are rejected.
+Setting strict_command_tags=false helps behind poolers too.
+
+Driver connections
+
+Methods specific to pq, such as BackendPID, RuntimeParameter and
+TransactionStatus, are reached through the pq.Conn interface of the driver
+connection underneath a sql.Conn:
+
+	c, err := db.Conn(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	err = c.Raw(func(driverConn interface{}) error {
+		pid := driverConn.(pq.Conn).BackendPID()
+		tz, _ := driverConn.(pq.Conn).RuntimeParameter("TimeZone")
+		log.Printf("backend %d is in time zone %s", pid, tz)
+		return nil
+	})
+
+SetApplicationName changes the application_name of the connection, as shown
+in pg_stat_activity, to tag a long-lived connection with the work it is
+doing.  The name it started with is restored when the connection goes back
+to the pool:
+
+	err = c.Raw(func(driverConn interface{}) error {
+		return driverConn.(pq.Conn).SetApplicationName("nightly-report")
+	})
+
+The rows of a query run on the driver connection have methods of their own
+too, through the pq.Rows interface.  With keep_raw_values on, RawValue
+returns the bytes the server sent for a value of the current row, before pq
+decoded them, which helps to track down a value that is read wrongly
+without logging all the traffic:
+
+	err = c.Raw(func(driverConn interface{}) error {
+		st, err := driverConn.(driver.Conn).Prepare("SELECT created FROM events")
+		if err != nil {
+			return err
+		}
+		defer st.Close()
+		rows, err := st.Query(nil)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+			log.Printf("%v was read from %q", dest[0], rows.(pq.Rows).RawValue(0))
+		}
+		return nil
+	})
+
+Functions that need a single connection, such as pq.FlushStatements and
+pq.CopyInRaw, take the sql.Conn directly.
+
 Errors
 
 pq may return errors of type *pq.Error which can be interrogated for error details:
@@ -110,6 +454,15 @@ pq may return errors of type *pq.Error which can be interrogated for error detai
 
 See the pq.Error type for details.
 
+pq.IsUniqueViolation, pq.IsForeignKeyViolation and pq.IsCheckViolation tell
+constraint violations apart, and pq.ConstraintName says which constraint was
+violated:
+
+	if pq.IsUniqueViolation(err) {
+		name, _ := pq.ConstraintName(err)
+		fmt.Println("duplicate value for", name)
+	}
+
 
 Bulk imports
 
@@ -117,8 +470,12 @@ You can make bulk imports by preparing a pq.CopyIn statement. pq.CopyIn
 uses Postgres COPY FROM feature. The encoding is handled by pq and you
 can insert rows by calling stmt.Exec. Note that bulk inserts are
 asynchronous and Exec can return errors for previous Exec calls.
+Once the server has reported an error, such as a row violating a
+constraint, the COPY is over, and Exec returns the *pq.Error from then on;
+the connection can be used again.
 It is also necessary to call stmt.Exec() before stmt.Close() to get
-any errors from pending inserts. For example:
+any errors from pending inserts; the RowsAffected of its result is the
+number of rows the server copied. For example:
 
 	stmt, err := db.Prepare(pq.CopyIn("users", "name", "age"))
 	if err != nil {
@@ -142,5 +499,77 @@ any errors from pending inserts. For example:
 		log.Fatal(err)
 	}
 
+Data that is already in a COPY format, such as a CSV file, can be copied in
+as is with pq.CopyInRaw:
+
+	n, err := pq.CopyInRaw(conn, "users", "csv", f)
+
+pq.CopyInBinary prepares a COPY in binary format, which sends bytea and
+text values without escaping them and numbers and timestamps without
+formatting them.  pq looks up the types of the table's columns to encode
+the values by, and refuses columns of types it has no binary encoding for;
+those it has are bytea, the string types, json, jsonb, bool, the integer
+and floating point types, timestamps and dates.
+
+
+Bulk exports
+
+A whole table is read faster with COPY TO than with a SELECT.  Run a
+pq.CopyOut statement, or any COPY ... TO STDOUT, with Query, and its rows
+are read like a SELECT's, each field decoded by its column's type:
+
+	rows, err := db.Query(pq.CopyOut("users", "name", "age"))
+
+pq describes the table's columns, or the query of a COPY (query) TO, before
+it starts the COPY, to learn their names and types.  The rows are read in
+the text format, so options such as CSV or DELIMITER aren't supported,
+except for WITH BINARY, which reads them in binary format, for columns of
+the types pq.CopyInBinary supports.
+Exec runs a COPY TO and discards its rows, with the number of rows as the
+RowsAffected of its result.
+
+The rows are read from the server one at a time as Next is called, so a
+large table isn't held in memory.  To pass the data on as the server writes
+it, in any of the COPY formats, use pq.CopyOutRaw, which writes it to an
+io.Writer as it arrives:
+
+	n, err := pq.CopyOutRaw(conn, "users", "csv", f)
+
+
+Notifications
+
+pq.Listener listens for the notifications sent with NOTIFY or pg_notify,
+on a connection of its own, which it opens again, listening to the same
+channels, if it fails.  Its event callback, if one is given, hears of the
+connection being lost, attempts to connect failing and connecting again:
+
+	l, err := pq.NewListener(conninfo, 10*time.Second, time.Minute, nil)
+	...
+	err = l.Listen("jobs")
+	...
+	for n := range l.Notify {
+		if n == nil {
+			// the connection was lost and opened again; look for
+			// anything that was missed
+			continue
+		}
+		fmt.Println(n.Channel, n.Extra)
+	}
+
+Notifications for a connection that runs queries as well arrive along with
+their results.  They are dropped unless a handler is set, with the
+SetNotificationHandler method of pq.Conn, or for every connection, on a
+Connector:
+
+	c, err := pq.NewConnector(conninfo)
+	...
+	c.NotificationHandler = func(n *pq.Notification) {
+		log.Printf("%s: %s", n.Channel, n.Extra)
+	}
+	db := sql.OpenDB(c)
+
+The handler is called from the goroutine reading the results, while it
+reads them.
+
 */
 package pq
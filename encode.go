@@ -3,15 +3,23 @@ package pq
 import (
 	"bytes"
 	"database/sql/driver"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"github.com/gregb/pq/oid"
 	"math"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
+// encode returns the text of a parameter of type typ.  A parameter whose
+// type the server left unknown (oid.T_unknown) is sent as text, for the
+// server to cast: numbers, booleans and times as their usual text, strings
+// and []byte that is valid UTF-8 as they are.  Other []byte can't be sent
+// that way.
 func encode(parameterStatus *parameterStatus, x interface{}, typ oid.Oid) []byte {
 
 	switch v := x.(type) {
@@ -21,21 +29,37 @@ func encode(parameterStatus *parameterStatus, x interface{}, typ oid.Oid) []byte
 		return []byte(fmt.Sprintf("%f", v))
 	case float64:
 		return []byte(fmt.Sprintf("%g", v))
+	case []float64:
+		b, err := encodeGeometry(v, typ)
+		if err != nil {
+			panic(err)
+		}
+		return b
 	case []byte:
 		if typ == oid.T_bytea {
-			return encodeBytea(parameterStatus.serverVersion, v)
+			return encodeBytea(parameterStatus, v)
+		}
+		if typ == oid.T_unknown && (!utf8.Valid(v) || bytes.IndexByte(v, 0) >= 0) {
+			// the server would take them as text, which they can't be
+			errorf("cannot send binary data as a parameter of unknown type; cast the parameter, as in $1::bytea")
 		}
 
 		return v
 	case string:
 		if typ == oid.T_bytea {
-			return encodeBytea(parameterStatus.serverVersion, []byte(v))
+			return encodeBytea(parameterStatus, []byte(v))
 		}
 		return []byte(v)
 	case bool:
 		return []byte(fmt.Sprintf("%t", v))
 	case time.Time:
-		return []byte(v.Format(time.RFC3339Nano))
+		switch typ {
+		case oid.T_timetz:
+			return []byte(v.Format(timetzFormat(v)))
+		case oid.T_time:
+			return []byte(v.Format("15:04:05.999999"))
+		}
+		return []byte(v.Format(timestampFormat(parameterStatus)))
 	default:
 		errorf("encode: unknown type for %T", v)
 	}
@@ -94,17 +118,190 @@ func decode(parameterStatus *parameterStatus, s []byte, typ oid.Oid) interface{}
 			errorf("%s", err)
 		}
 
+		// a path is open or closed depending on its brackets, which the
+		// floats alone would lose
+		if typ == oid.T_path {
+			if len(s) > 0 && s[0] == '[' {
+				return openPath(floats)
+			}
+			return closedPath(floats)
+		}
+
 		return floats
-	case oid.T_varchar, oid.T_char:
+	case oid.T_varchar:
+		return string(s)
+	case oid.T_uuid:
+		// a string, checked to be a uuid, rather than the bytes of its text
+		if _, err := ParseUUID(string(s)); err != nil {
+			panic(err)
+		}
 		return string(s)
+	case oid.T_char:
+		return parseChar(s)
+	case oid.T_void:
+		// the result of a function returning void is an empty string that
+		// means nothing
+		return nil
+	}
+
+	if parameterStatus != nil && typ == parameterStatus.hstoreOid && typ != 0 {
+		m, err := parseHstore(s)
+		if err != nil {
+			panic(err)
+		}
+		return m
+	}
+
+	if !typ.IsBuiltin() {
+		if d := getUnknownTypeDecoder(); d != nil {
+			v, err := d(typ, s)
+			if err != nil {
+				panic(err)
+			}
+			return v
+		}
 	}
 
+	if parameterStatus.decodesAsString(typ) {
+		return string(s)
+	}
 	return s
 }
 
+// hasBinaryDecoder reports whether decodeBinary can read values of typ in
+// binary format.  These are the types whose binary format doesn't depend on
+// the server's settings, and which decodeBinary reads into the same values
+// as decode reads their text into.
+func hasBinaryDecoder(typ oid.Oid) bool {
+	switch typ {
+	case oid.T_bytea, oid.T_bool, oid.T_int2, oid.T_int4, oid.T_int8, oid.T_float4, oid.T_float8:
+		return true
+	}
+	return false
+}
+
+// decodeBinary decodes a value in binary format, of a type hasBinaryDecoder
+// accepts.
+func decodeBinary(s []byte, typ oid.Oid) interface{} {
+	switch {
+	case typ == oid.T_bytea:
+		// s is the connection's buffer
+		return append([]byte{}, s...)
+	case typ == oid.T_bool && len(s) == 1:
+		return s[0] != 0
+	case typ == oid.T_int2 && len(s) == 2:
+		return int64(int16(binary.BigEndian.Uint16(s)))
+	case typ == oid.T_int4 && len(s) == 4:
+		return int64(int32(binary.BigEndian.Uint32(s)))
+	case typ == oid.T_int8 && len(s) == 8:
+		return int64(binary.BigEndian.Uint64(s))
+	case typ == oid.T_float4 && len(s) == 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(s)))
+	case typ == oid.T_float8 && len(s) == 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(s))
+	case !hasBinaryDecoder(typ):
+		errorf("no binary decoder for type %d", typ)
+	}
+	errorf("invalid binary value of %d bytes for type %d", len(s), typ)
+	panic("not reached")
+}
+
+// encodeBinary encodes a parameter in binary format, if it can be: an int64
+// for an int2, int4 or int8 it fits in, a float64 for a float4 or float8, or
+// a bool for a bool.  ok is false for anything else, which is sent as text.
+func encodeBinary(x interface{}, typ oid.Oid) (b []byte, ok bool) {
+	switch v := x.(type) {
+	case int64:
+		switch {
+		case typ == oid.T_int8:
+			b = make([]byte, 8)
+			binary.BigEndian.PutUint64(b, uint64(v))
+			return b, true
+		case typ == oid.T_int4 && v >= math.MinInt32 && v <= math.MaxInt32:
+			b = make([]byte, 4)
+			binary.BigEndian.PutUint32(b, uint32(v))
+			return b, true
+		case typ == oid.T_int2 && v >= math.MinInt16 && v <= math.MaxInt16:
+			b = make([]byte, 2)
+			binary.BigEndian.PutUint16(b, uint16(v))
+			return b, true
+		}
+	case float64:
+		switch typ {
+		case oid.T_float8:
+			b = make([]byte, 8)
+			binary.BigEndian.PutUint64(b, math.Float64bits(v))
+			return b, true
+		case oid.T_float4:
+			b = make([]byte, 4)
+			binary.BigEndian.PutUint32(b, math.Float32bits(float32(v)))
+			return b, true
+		}
+	case bool:
+		if typ == oid.T_bool {
+			if v {
+				return []byte{1}, true
+			}
+			return []byte{0}, true
+		}
+	}
+	return nil, false
+}
+
+// decodesAsString reports whether values of typ are decoded as strings
+// because text_as_string is set.
+func (p *parameterStatus) decodesAsString(typ oid.Oid) bool {
+	return p != nil && p.textAsString && isTextType(typ)
+}
+
+// isTextType reports whether typ is one of the types that text_as_string
+// decodes as strings: the string types, such as text, bpchar and name, JSON,
+// XML and unknown, and the types that aren't built in, such as enums.
+func isTextType(typ oid.Oid) bool {
+	switch typ {
+	case oid.T_json, oid.T_jsonb, oid.T_xml, oid.T_unknown:
+		return true
+	}
+	return typ.Category() == oid.C_string || !typ.IsBuiltin()
+}
+
+var unknownTypeDecoder struct {
+	sync.RWMutex
+	decode func(o oid.Oid, raw []byte) (interface{}, error)
+}
+
+// SetUnknownTypeDecoder sets a function to decode values of types that
+// aren't built into Postgres, such as enums, domains and the types of
+// extensions, whose OIDs differ from database to database.  It is called
+// with the value's type and its text representation, and what it returns is
+// scanned into the destination in place of the raw bytes.  Passing nil
+// restores the default of returning the raw bytes.
+func SetUnknownTypeDecoder(decode func(o oid.Oid, raw []byte) (interface{}, error)) {
+	unknownTypeDecoder.Lock()
+	unknownTypeDecoder.decode = decode
+	unknownTypeDecoder.Unlock()
+}
+
+func getUnknownTypeDecoder() func(o oid.Oid, raw []byte) (interface{}, error) {
+	unknownTypeDecoder.RLock()
+	defer unknownTypeDecoder.RUnlock()
+	return unknownTypeDecoder.decode
+}
+
+// isNull reports whether x is sent as NULL.  Besides nil, that is a nil
+// []byte, which database/sql passes on as is, whereas an empty non-nil one is
+// an empty bytea (or string).
+func isNull(x interface{}) bool {
+	b, ok := x.([]byte)
+	return x == nil || ok && b == nil
+}
+
 // appendEncodedText encodes item in text format as required by COPY
 // and appends to buf
 func appendEncodedText(parameterStatus *parameterStatus, buf []byte, x interface{}) []byte {
+	if isNull(x) {
+		return append(buf, "\\N"...)
+	}
 	switch v := x.(type) {
 	case int64:
 		return strconv.AppendInt(buf, v, 10)
@@ -113,16 +310,14 @@ func appendEncodedText(parameterStatus *parameterStatus, buf []byte, x interface
 	case float64:
 		return strconv.AppendFloat(buf, v, 'f', -1, 64)
 	case []byte:
-		encodedBytea := encodeBytea(parameterStatus.serverVersion, v)
+		encodedBytea := encodeBytea(parameterStatus, v)
 		return appendEscapedText(buf, string(encodedBytea))
 	case string:
 		return appendEscapedText(buf, v)
 	case bool:
 		return strconv.AppendBool(buf, v)
 	case time.Time:
-		return append(buf, v.Format(time.RFC3339Nano)...)
-	case nil:
-		return append(buf, "\\N"...)
+		return append(buf, v.Format(timestampFormat(parameterStatus))...)
 	default:
 		errorf("encode: unknown type for %T", v)
 	}
@@ -130,6 +325,32 @@ func appendEncodedText(parameterStatus *parameterStatus, buf []byte, x interface
 	panic("not reached")
 }
 
+// appendEncodedCSV is appendEncodedText for COPY in CSV format, where NULL
+// is an unquoted empty field.
+func appendEncodedCSV(parameterStatus *parameterStatus, buf []byte, x interface{}) []byte {
+	if isNull(x) {
+		return buf
+	}
+	switch v := x.(type) {
+	case []byte:
+		return appendCSVField(buf, string(encodeBytea(parameterStatus, v)))
+	case string:
+		return appendCSVField(buf, v)
+	}
+	return appendEncodedText(parameterStatus, buf, x)
+}
+
+// appendCSVField quotes a field if it could otherwise be mistaken for a
+// delimiter, a line end, NULL or the end-of-data marker.
+func appendCSVField(buf []byte, text string) []byte {
+	if text != "" && text != `\.` && !strings.ContainsAny(text, ",\"\r\n") {
+		return append(buf, text...)
+	}
+	buf = append(buf, '"')
+	buf = append(buf, strings.Replace(text, `"`, `""`, -1)...)
+	return append(buf, '"')
+}
+
 func appendEscapedText(buf []byte, text string) []byte {
 	escapeNeeded := false
 	startPos := 0
@@ -166,6 +387,27 @@ func appendEscapedText(buf []byte, text string) []byte {
 	return result
 }
 
+// timestampFormat returns the layout for sending a time.Time as a timestamp.
+// With truncate_timestamps, it truncates the time to microseconds, the
+// resolution Postgres keeps, rather than leave the server to round it, so
+// that a time read back equals t.Truncate(time.Microsecond).
+func timestampFormat(p *parameterStatus) string {
+	if p != nil && p.truncateTimestamps {
+		return "2006-01-02T15:04:05.999999Z07:00"
+	}
+	return time.RFC3339Nano
+}
+
+// timetzFormat is the layout for a timetz: the time of day and the zone
+// offset, with seconds in the offset only if the zone has them, as some
+// historical ones do.
+func timetzFormat(t time.Time) string {
+	if _, offset := t.Zone(); offset%60 != 0 {
+		return "15:04:05.999999-07:00:00"
+	}
+	return "15:04:05.999999-07:00"
+}
+
 func mustParse(f string, typ oid.Oid, s []byte) time.Time {
 	str := string(s)
 
@@ -179,6 +421,10 @@ func mustParse(f string, typ oid.Oid, s []byte) time.Time {
 	if (typ == oid.T_timestamptz || typ == oid.T_timetz) &&
 		str[len(str)-3] == ':' {
 		f += ":00"
+		// ... or one with seconds, which timetz allows
+		if typ == oid.T_timetz && len(str) > 6 && str[len(str)-6] == ':' {
+			f += ":00"
+		}
 	}
 	t, err := time.Parse(f, str)
 	if err != nil {
@@ -288,9 +534,23 @@ func parseTs(currentLocation *time.Location, str string) (result time.Time) {
 	return t
 }
 
+// parseChar decodes a "char", the single-byte type of catalog columns such
+// as pg_proc.provolatile.  Since Postgres 15, bytes outside ASCII are
+// written as a backslash and three octal digits.
+func parseChar(s []byte) string {
+	if len(s) == 4 && s[0] == '\\' {
+		if b, err := strconv.ParseUint(string(s[1:]), 8, 8); err == nil {
+			return string([]byte{byte(b)})
+		}
+	}
+	return string(s)
+}
+
 // Parse a bytea value received from the server.  Both "hex" and the legacy
 // "escape" format are supported.
 func parseBytea(s []byte) (result []byte) {
+	// an empty bytea is not NULL, so it mustn't come out nil
+	result = []byte{}
 	if len(s) >= 2 && bytes.Equal(s[:2], []byte("\\x")) {
 		// bytea_output = hex
 		s = s[2:] // trim off leading "\\x"
@@ -334,8 +594,8 @@ func parseBytea(s []byte) (result []byte) {
 	}
 	return result
 }
-func encodeBytea(serverVersion int, v []byte) (result []byte) {
-	if serverVersion >= 90000 {
+func encodeBytea(parameterStatus *parameterStatus, v []byte) (result []byte) {
+	if parameterStatus.serverVersionAtLeast(90000) {
 		// Use the hex format if we know that the server supports it
 		result = []byte(fmt.Sprintf("\\x%x", v))
 	} else {
@@ -383,6 +643,55 @@ func (nt NullTime) Value() (driver.Value, error) {
 	return nt.Time, nil
 }
 
+// encodeGeometry formats the flat list of coordinates of a geometric type,
+// the same form they are decoded into, as the type's text representation.
+func encodeGeometry(f []float64, typ oid.Oid) ([]byte, error) {
+	points := func() string {
+		parts := make([]string, 0, len(f)/2)
+		for i := 0; i+1 < len(f); i += 2 {
+			parts = append(parts, "("+formatFloat(f[i])+","+formatFloat(f[i+1])+")")
+		}
+		return strings.Join(parts, ",")
+	}
+
+	var want int
+	var s string
+	switch typ {
+	case oid.T_point:
+		want, s = 2, points()
+	case oid.T_lseg:
+		want, s = 4, "["+points()+"]"
+	case oid.T_box:
+		want, s = 4, points()
+	case oid.T_line:
+		if len(f) == 3 {
+			s = "{" + formatFloat(f[0]) + "," + formatFloat(f[1]) + "," + formatFloat(f[2]) + "}"
+		}
+		want = 3
+	case oid.T_circle:
+		if len(f) == 3 {
+			s = "<(" + formatFloat(f[0]) + "," + formatFloat(f[1]) + ")," + formatFloat(f[2]) + ">"
+		}
+		want = 3
+	case oid.T_path, oid.T_polygon:
+		if len(f) == 0 || len(f)%2 != 0 {
+			return nil, fmt.Errorf("pq: cannot encode %d coordinates as a path or polygon", len(f))
+		}
+		return []byte("(" + points() + ")"), nil
+	default:
+		return nil, fmt.Errorf("pq: cannot encode []float64 as type %d", typ)
+	}
+
+	if len(f) != want {
+		return nil, fmt.Errorf("pq: expected %d coordinates for type %d; got %d", want, typ, len(f))
+	}
+	return []byte(s), nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
 // ExtractFloats extracts all floats from a string
 // Parameter represents an ASCII string
 // Returns a slice of all floats parsed out
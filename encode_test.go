@@ -2,8 +2,11 @@ package pq
 
 import (
 	"bytes"
+	"database/sql"
 	"fmt"
 	"github.com/gregb/pq/oid"
+	"math"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -267,6 +270,69 @@ func TestByteaOutputFormatEncoding(t *testing.T) {
 	}
 }
 
+// Does not access database; nil []byte is NULL, empty []byte an empty bytea
+func TestNilAndEmptyBytea(t *testing.T) {
+	ps := &parameterStatus{serverVersion: 90000}
+
+	if !isNull([]byte(nil)) || isNull([]byte{}) || !isNull(nil) {
+		t.Error("Expected only nil and nil []byte to be NULL")
+	}
+
+	for _, in := range []string{"\\x", ""} {
+		if b := parseBytea([]byte(in)); b == nil || len(b) != 0 {
+			t.Errorf("Expected %q to decode to an empty non-nil bytea, got %#v", in, b)
+		}
+	}
+
+	if got := appendEncodedText(ps, nil, []byte(nil)); string(got) != "\\N" {
+		t.Errorf("Expected nil []byte to COPY as NULL, got %q", got)
+	}
+	if got := appendEncodedText(ps, nil, []byte{}); string(got) != "\\\\x" {
+		t.Errorf("Expected empty []byte to COPY as an empty bytea, got %q", got)
+	}
+	if got := appendEncodedCSV(ps, nil, []byte(nil)); len(got) != 0 {
+		t.Errorf("Expected nil []byte to COPY as NULL, got %q", got)
+	}
+
+	b, err := EncodeArray([][]byte{nil, {}}, oid.T__bytea)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{NULL,"\\x"}` {
+		t.Errorf("Unexpected encoding of bytea[]: %s", b)
+	}
+	v, err := DecodeArray(b, oid.T__bytea)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := v.([]*[]byte); len(got) != 2 || got[0] != nil || got[1] == nil || *got[1] == nil || len(*got[1]) != 0 {
+		t.Errorf("Unexpected decoding of bytea[]: %#v", v)
+	}
+}
+
+func TestNilAndEmptyByteaFromDb(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	var isNull bool
+	var result []byte
+	err := db.QueryRow("SELECT $1::bytea IS NULL, $1::bytea", []byte(nil)).Scan(&isNull, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isNull || result != nil {
+		t.Errorf("Expected nil []byte to be NULL, got %v, %#v", isNull, result)
+	}
+
+	err = db.QueryRow("SELECT $1::bytea IS NULL, $1::bytea", []byte{}).Scan(&isNull, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isNull || result == nil || len(result) != 0 {
+		t.Errorf("Expected empty []byte to be an empty bytea, got %v, %#v", isNull, result)
+	}
+}
+
 func TestByteaOutputFormats(t *testing.T) {
 	db := openTestConn(t)
 	defer db.Close()
@@ -535,3 +601,498 @@ func TestGeometryToFloats(t *testing.T) {
 		}
 	}
 }
+
+func TestUnknownTypeDecoder(t *testing.T) {
+	defer SetUnknownTypeDecoder(nil)
+
+	const enumOid = oid.Oid(123456)
+	if got := decode(&parameterStatus{}, []byte("happy"), enumOid); !bytes.Equal(got.([]byte), []byte("happy")) {
+		t.Fatalf("Expected raw bytes without a decoder, got %v", got)
+	}
+
+	var seen oid.Oid
+	SetUnknownTypeDecoder(func(o oid.Oid, raw []byte) (interface{}, error) {
+		seen = o
+		if len(raw) == 0 {
+			return nil, fmt.Errorf("empty value")
+		}
+		return "mood:" + string(raw), nil
+	})
+
+	if got := decode(&parameterStatus{}, []byte("happy"), enumOid); got != "mood:happy" {
+		t.Errorf("Expected the decoder's value, got %v", got)
+	}
+	if seen != enumOid {
+		t.Errorf("Expected the decoder to be called with %d, got %d", enumOid, seen)
+	}
+
+	// built-in types don't go through the decoder
+	if got := decode(&parameterStatus{}, []byte("{}"), oid.T_json); !bytes.Equal(got.([]byte), []byte("{}")) {
+		t.Errorf("Expected raw bytes for json, got %v", got)
+	}
+
+	var err error
+	func() {
+		defer errRecover(&err)
+		decode(&parameterStatus{}, []byte{}, enumOid)
+	}()
+	if err == nil || err.Error() != "empty value" {
+		t.Errorf("Expected the decoder's error, got %v", err)
+	}
+}
+
+func TestUnknownTypeDecoderFromDb(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+	defer SetUnknownTypeDecoder(nil)
+
+	SetUnknownTypeDecoder(func(o oid.Oid, raw []byte) (interface{}, error) {
+		return int64(len(raw)), nil
+	})
+
+	txn, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Rollback()
+
+	_, err = txn.Exec("CREATE TYPE pg_temp.mood AS ENUM ('sad', 'happy')")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	err = txn.QueryRow("SELECT 'happy'::pg_temp.mood").Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("Expected the decoder's value 5, got %d", n)
+	}
+}
+
+func TestDecodeVoid(t *testing.T) {
+	for _, ps := range []*parameterStatus{{}, {textAsString: true}} {
+		if v := decode(ps, []byte{}, oid.T_void); v != nil {
+			t.Errorf("expected nil, got %#v", v)
+		}
+	}
+}
+
+func TestVoidFunction(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	var v interface{}
+	if err := db.QueryRow("SELECT pg_sleep(0)").Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v != nil {
+		t.Errorf("expected nil, got %#v", v)
+	}
+
+	// the result can be discarded into a sql.RawBytes too
+	var raw sql.RawBytes
+	rows, err := db.Query("SELECT pg_sleep(0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatal("expected a row")
+	}
+	if err := rows.Scan(&raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw != nil {
+		t.Errorf("expected nil, got %q", raw)
+	}
+}
+
+func TestDecodeTextAsString(t *testing.T) {
+	ps := &parameterStatus{textAsString: true}
+	for _, typ := range []oid.Oid{oid.T_text, oid.T_bpchar, oid.T_name, oid.T_json, oid.T_jsonb, oid.T_xml, oid.T_unknown, oid.Oid(16384)} {
+		if v := decode(ps, []byte("x"), typ); v != "x" {
+			t.Errorf("%d: expected a string, got %#v", typ, v)
+		}
+		if v := decode(&parameterStatus{}, []byte("x"), typ); !reflect.DeepEqual(v, []byte("x")) {
+			t.Errorf("%d: expected []byte without text_as_string, got %#v", typ, v)
+		}
+	}
+
+	// types that aren't text keep their usual decoding
+	if v := decode(ps, []byte(`\x78`), oid.T_bytea); !reflect.DeepEqual(v, []byte("x")) {
+		t.Errorf("expected bytea to decode to []byte, got %#v", v)
+	}
+	if v := decode(ps, []byte("1.5"), oid.T_numeric); !reflect.DeepEqual(v, []byte("1.5")) {
+		t.Errorf("expected numeric to decode to []byte, got %#v", v)
+	}
+
+	ac := &arrayConverter{ArrayTyp: oid.T__bpchar, parameterStatus: ps}
+	v, err := ac.decode([]byte(`{a,"b c",NULL}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := v.([]*string); !ok || len(got) != 3 || *got[0] != "a" || *got[1] != "b c" || got[2] != nil {
+		t.Errorf("expected a []*string, got %#v", v)
+	}
+}
+
+func TestTextAsStringFromDb(t *testing.T) {
+	db, err := openTestConnConninfo("user=pqgotest password=pqgotest text_as_string=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	txn, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Rollback()
+
+	_, err = txn.Exec("CREATE TYPE pg_temp.mood AS ENUM ('sad', 'happy')")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var values [5]interface{}
+	err = txn.QueryRow(`SELECT 'ab'::char(3), '{"a": 1}'::json, '<a/>'::xml,
+		'happy'::pg_temp.mood, ARRAY['x']::char(2)[]`).Scan(&values[0], &values[1], &values[2], &values[3], &values[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [5]interface{}{"ab ", `{"a": 1}`, "<a/>", "happy", []string{"x "}}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, values)
+	}
+}
+
+func TestAggregates(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	const values = "(VALUES (1, 1.25::numeric(10,2), 0.5::float8), (2, 2.5, 1), (4, 3, 1.5)) AS v (i, n, f)"
+
+	ints := []struct {
+		agg      string
+		typ      string
+		expected int64
+	}{
+		{"count(*)", "bigint", 3},
+		{"sum(i)", "bigint", 7},
+		{"sum(i::int8)", "numeric", 7},
+		{"max(i)", "integer", 4},
+		{"sum(i::numeric)", "numeric", 7},
+	}
+	for _, tt := range ints {
+		var typ string
+		var n int64
+		err := db.QueryRow("SELECT pg_typeof("+tt.agg+")::text, "+tt.agg+" FROM "+values).Scan(&typ, &n)
+		if err != nil {
+			t.Errorf("%s: %v", tt.agg, err)
+			continue
+		}
+		if typ != tt.typ || n != tt.expected {
+			t.Errorf("%s: expected %s %d, got %s %d", tt.agg, tt.typ, tt.expected, typ, n)
+		}
+	}
+
+	floats := []struct {
+		agg      string
+		typ      string
+		expected float64
+	}{
+		{"avg(i)", "numeric", 7.0 / 3},
+		{"sum(n)", "numeric", 6.75},
+		{"avg(n)", "numeric", 2.25},
+		{"sum(f)", "double precision", 3},
+		{"avg(f)", "double precision", 1},
+		{"sum(i::int8)", "numeric", 7},
+	}
+	for _, tt := range floats {
+		var typ string
+		var f float64
+		err := db.QueryRow("SELECT pg_typeof("+tt.agg+")::text, "+tt.agg+" FROM "+values).Scan(&typ, &f)
+		if err != nil {
+			t.Errorf("%s: %v", tt.agg, err)
+			continue
+		}
+		if typ != tt.typ || math.Abs(f-tt.expected) > 1e-12 {
+			t.Errorf("%s: expected %s %v, got %s %v", tt.agg, tt.typ, tt.expected, typ, f)
+		}
+	}
+
+	// a fraction can't be scanned into an integer, and no rows make NULL
+	var n int64
+	if err := db.QueryRow("SELECT avg(i) FROM " + values).Scan(&n); err == nil {
+		t.Errorf("expected an error scanning avg(i) into an int64, got %d", n)
+	}
+	var nf sql.NullFloat64
+	if err := db.QueryRow("SELECT sum(n) FROM " + values + " WHERE false").Scan(&nf); err != nil || nf.Valid {
+		t.Errorf("expected NULL, got %v, %v", nf, err)
+	}
+}
+
+func TestEncodeTimetz(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct {
+		in       time.Time
+		expected string
+	}{
+		{time.Date(2024, 1, 15, 9, 30, 0, 123456000, ny), "09:30:00.123456-05:00"},
+		{time.Date(2024, 7, 15, 9, 30, 0, 0, ny), "09:30:00-04:00"},
+		{time.Date(2024, 1, 15, 23, 59, 59, 0, time.FixedZone("", 5*3600+45*60)), "23:59:59+05:45"},
+		{time.Date(1880, 1, 1, 12, 0, 0, 0, time.FixedZone("LMT", -(4*3600 + 56*60 + 2))), "12:00:00-04:56:02"},
+	}
+
+	for _, tt := range tests {
+		got := string(encode(&parameterStatus{}, tt.in, oid.T_timetz))
+		if got != tt.expected {
+			t.Errorf("Expected %s, got %s", tt.expected, got)
+			continue
+		}
+
+		back := decode(&parameterStatus{}, []byte(got), oid.T_timetz).(time.Time)
+		_, inOffset := tt.in.Zone()
+		_, backOffset := back.Zone()
+		if back.Hour() != tt.in.Hour() || back.Minute() != tt.in.Minute() ||
+			back.Second() != tt.in.Second() || back.Nanosecond() != tt.in.Nanosecond() ||
+			inOffset != backOffset {
+			t.Errorf("Expected %v to decode to the time of day of %v", back, tt.in)
+		}
+	}
+}
+
+func TestTimetzRoundtrip(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	in := time.Date(2024, 1, 15, 9, 30, 0, 123456000, ny)
+
+	var out time.Time
+	err = db.QueryRow("SELECT $1::timetz", in).Scan(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, inOffset := in.Zone()
+	_, outOffset := out.Zone()
+	if out.Hour() != 9 || out.Minute() != 30 || out.Nanosecond() != 123456000 || outOffset != inOffset {
+		t.Errorf("Expected the time of day and offset of %v, got %v", in, out)
+	}
+}
+
+func TestEncodeTimestampTruncates(t *testing.T) {
+	in := time.Date(2012, 11, 6, 10, 23, 42, 123456789, time.UTC)
+	truncate := &parameterStatus{truncateTimestamps: true}
+	if s := string(encode(truncate, in, oid.T_timestamptz)); s != "2012-11-06T10:23:42.123456Z" {
+		t.Errorf("Unexpected encoding %q", s)
+	}
+	if s := string(appendEncodedText(truncate, nil, in)); s != "2012-11-06T10:23:42.123456Z" {
+		t.Errorf("Unexpected text encoding %q", s)
+	}
+
+	// only with truncate_timestamps
+	if s := string(encode(&parameterStatus{}, in, oid.T_timestamptz)); s != "2012-11-06T10:23:42.123456789Z" {
+		t.Errorf("Unexpected encoding %q", s)
+	}
+}
+
+func TestTimestampNanosecondRoundtrip(t *testing.T) {
+	db, err := openTestConnConninfo("truncate_timestamps=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// the server would round .1234569 up; the driver truncates
+	in := time.Date(2012, 11, 6, 10, 23, 42, 123456900, time.UTC)
+	var out time.Time
+	if err := db.QueryRow("SELECT $1::timestamptz", in).Scan(&out); err != nil {
+		t.Fatal(err)
+	}
+	if expected := in.Truncate(time.Microsecond); !out.Equal(expected) {
+		t.Errorf("Expected %v, got %v", expected, out)
+	}
+}
+
+func TestDecodeBinary(t *testing.T) {
+	tests := []struct {
+		in       string
+		typ      oid.Oid
+		expected interface{}
+	}{
+		{"\x00\xff", oid.T_bytea, []byte{0, 0xff}},
+		{"\x01", oid.T_bool, true},
+		{"\x00", oid.T_bool, false},
+		{"\xff\xfe", oid.T_int2, int64(-2)},
+		{"\x7f\xff\xff\xff", oid.T_int4, int64(math.MaxInt32)},
+		{"\x80\x00\x00\x00\x00\x00\x00\x00", oid.T_int8, int64(math.MinInt64)},
+		{"\x3f\xc0\x00\x00", oid.T_float4, 1.5},
+		{"\x40\x09\x21\xfb\x54\x44\x2d\x18", oid.T_float8, math.Pi},
+	}
+	for _, tt := range tests {
+		if !hasBinaryDecoder(tt.typ) {
+			t.Errorf("%d: expected a binary decoder", tt.typ)
+		}
+		got := decodeBinary([]byte(tt.in), tt.typ)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%d: expected %#v, got %#v", tt.typ, tt.expected, got)
+		}
+
+		// the text decoder reads the same value from the text
+		text := fmt.Sprint(tt.expected)
+		switch v := tt.expected.(type) {
+		case []byte:
+			text = fmt.Sprintf("\\x%x", v)
+		case bool:
+			text = map[bool]string{true: "t", false: "f"}[v]
+		}
+		if got := decode(&parameterStatus{serverVersion: 90000}, []byte(text), tt.typ); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%d: decode of %q is %#v, not %#v", tt.typ, text, got, tt.expected)
+		}
+	}
+
+	for _, typ := range []oid.Oid{oid.T_int4, oid.T_text} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%d: expected a panic", typ)
+				}
+			}()
+			decodeBinary([]byte{1, 2}, typ)
+		}()
+	}
+	if hasBinaryDecoder(oid.T_timestamptz) || hasBinaryDecoder(oid.T_numeric) {
+		t.Error("expected text format for timestamptz and numeric")
+	}
+}
+
+func TestEncodeUnknown(t *testing.T) {
+	tm := time.Date(2001, time.February, 3, 4, 5, 6, 0, time.UTC)
+	tests := []struct {
+		x        interface{}
+		expected string
+	}{
+		{int64(42), "42"},
+		{1.5, "1.5"},
+		{true, "true"},
+		{"text", "text"},
+		{[]byte(`{"a": 1}`), `{"a": 1}`},
+		{tm, "2001-02-03T04:05:06Z"},
+	}
+	for _, tt := range tests {
+		if got := string(encode(&parameterStatus{}, tt.x, oid.T_unknown)); got != tt.expected {
+			t.Errorf("%#v: expected %q, got %q", tt.x, tt.expected, got)
+		}
+	}
+
+	for _, b := range [][]byte{{0xff, 0xfe}, []byte("a\x00b")} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%q: expected a panic", b)
+				}
+			}()
+			encode(&parameterStatus{}, b, oid.T_unknown)
+		}()
+	}
+}
+
+func TestUnknownParameterType(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	_, err := db.Exec("SELECT $1 IS NULL", "x")
+	if pqErr, ok := err.(*Error); !ok || pqErr.Code != ErrCodeIndeterminateDatatype {
+		t.Fatalf("expected an indeterminate datatype error, got %v", err)
+	}
+
+	var isNull bool
+	if err := db.QueryRow("SELECT $1::text IS NULL", "x").Scan(&isNull); err != nil {
+		t.Fatal(err)
+	}
+	if isNull {
+		t.Error("expected false")
+	}
+}
+
+func TestEncodeBinary(t *testing.T) {
+	tests := []struct {
+		in  interface{}
+		typ oid.Oid
+	}{
+		{int64(math.MinInt64), oid.T_int8},
+		{int64(-2), oid.T_int4},
+		{int64(math.MaxInt16), oid.T_int2},
+		{1.5, oid.T_float4},
+		{math.Pi, oid.T_float8},
+		{true, oid.T_bool},
+		{false, oid.T_bool},
+	}
+	for _, tt := range tests {
+		b, ok := encodeBinary(tt.in, tt.typ)
+		if !ok {
+			t.Errorf("%v as %d: expected a binary encoding", tt.in, tt.typ)
+			continue
+		}
+		if got := decodeBinary(b, tt.typ); got != tt.in {
+			t.Errorf("%v as %d: got %v back", tt.in, tt.typ, got)
+		}
+	}
+
+	// values that don't fit, or aren't of the parameter's type, are text
+	for _, tt := range []struct {
+		in  interface{}
+		typ oid.Oid
+	}{
+		{int64(math.MaxInt32 + 1), oid.T_int4},
+		{int64(math.MinInt16 - 1), oid.T_int2},
+		{int64(1), oid.T_float8},
+		{1.5, oid.T_numeric},
+		{"1", oid.T_int4},
+		{true, oid.T_text},
+	} {
+		if _, ok := encodeBinary(tt.in, tt.typ); ok {
+			t.Errorf("%v as %d: expected no binary encoding", tt.in, tt.typ)
+		}
+	}
+}
+
+func TestBinaryParameters(t *testing.T) {
+	db, err := openTestConnConninfo("user=pqgotest password=pqgotest binary_parameters=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var (
+		i2, i4, i8 int64
+		f4, f8     float64
+		b          bool
+		n, s       string
+	)
+	err = db.QueryRow("SELECT $1::int2, $2::int4, $3::int8, $4::float4, $5::float8, $6::bool, $7::numeric, $8::text",
+		-2, math.MaxInt32, int64(math.MinInt64), 1.5, 0.1, true, 1.5, "x").Scan(&i2, &i4, &i8, &f4, &f8, &b, &n, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i2 != -2 || i4 != math.MaxInt32 || i8 != math.MinInt64 || f4 != 1.5 || f8 != 0.1 || !b || n != "1.5" || s != "x" {
+		t.Errorf("unexpected values %v %v %v %v %v %v %v %v", i2, i4, i8, f4, f8, b, n, s)
+	}
+
+	// a value out of range is sent as text, for the server to reject
+	_, err = db.Exec("SELECT $1::int2", 1<<20)
+	if e, ok := err.(*Error); !ok || e.Code != "22003" {
+		t.Errorf("expected a numeric_value_out_of_range error, got %v", err)
+	}
+}
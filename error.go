@@ -40,6 +40,10 @@ type Error struct {
 	File             string
 	Line             string
 	Routine          string
+
+	// SeverityNonLocalized is Severity untranslated by the server, which
+	// servers send from 9.6 on.
+	SeverityNonLocalized string
 }
 
 // ErrorCode is a five digit pq error code
@@ -171,6 +175,7 @@ var errorCodeNames = map[ErrorCode]string{
 	"25007": "schema_and_data_statement_mixing_not_supported",
 	"25P01": "no_active_sql_transaction",
 	"25P02": "in_failed_sql_transaction",
+	"25P03": "idle_in_transaction_session_timeout",
 	// Class 26 - Invalid SQL Statement Name
 	"26000": "invalid_sql_statement_name",
 	// Class 27 - Triggered Data Change Violation
@@ -339,6 +344,8 @@ func parseError(r *readBuf) *Error {
 		switch t {
 		case 'S':
 			err.Severity = msg
+		case 'V':
+			err.SeverityNonLocalized = msg
 		case 'C':
 			err.Code = ErrorCode(msg)
 		case 'M':
@@ -376,6 +383,80 @@ func parseError(r *readBuf) *Error {
 	return err
 }
 
+// Error codes of integrity constraint violations.
+const (
+	ErrCodeForeignKeyViolation ErrorCode = "23503"
+	ErrCodeUniqueViolation     ErrorCode = "23505"
+	ErrCodeCheckViolation      ErrorCode = "23514"
+)
+
+// Error codes of the errors the lock_timeout and
+// idle_in_transaction_session_timeout settings end in.  The connection is
+// closed after the latter.
+const (
+	ErrCodeLockNotAvailable                ErrorCode = "55P03"
+	ErrCodeIdleInTransactionSessionTimeout ErrorCode = "25P03"
+)
+
+// ErrCodeInsufficientPrivilege is the error code of a statement the current
+// role lacks the privilege for, such as the SET ROLE of the role setting to a
+// role the user isn't a member of.
+const ErrCodeInsufficientPrivilege ErrorCode = "42501"
+
+// ErrCodeIndeterminateDatatype is the error code of a statement with a
+// parameter whose type the server can't infer from the query, as in
+// SELECT $1 IS NULL.  A cast, as in $1::text, gives it one.
+const ErrCodeIndeterminateDatatype ErrorCode = "42P18"
+
+// IsUniqueViolation reports whether err is a violation of a unique
+// constraint, such as an insert of a duplicate primary key.
+func IsUniqueViolation(err error) bool {
+	e := asError(err)
+	return e != nil && e.Code == ErrCodeUniqueViolation
+}
+
+// IsForeignKeyViolation reports whether err is a violation of a foreign key
+// constraint, either by a row referring to one that doesn't exist or by the
+// removal of a row that is still referred to.
+func IsForeignKeyViolation(err error) bool {
+	e := asError(err)
+	return e != nil && e.Code == ErrCodeForeignKeyViolation
+}
+
+// IsCheckViolation reports whether err is a violation of a check
+// constraint.
+func IsCheckViolation(err error) bool {
+	e := asError(err)
+	return e != nil && e.Code == ErrCodeCheckViolation
+}
+
+// ConstraintName returns the name of the constraint err is a violation of,
+// so that an application can tell which one failed, as in
+//
+//	if name, ok := pq.ConstraintName(err); ok && name == "users_email_key" {
+//		return errors.New("that email address is already registered")
+//	}
+//
+// ok is false if err isn't an Error or doesn't name a constraint.
+func ConstraintName(err error) (name string, ok bool) {
+	e := asError(err)
+	if e == nil || e.Constraint == "" {
+		return "", false
+	}
+	return e.Constraint, true
+}
+
+// asError returns err as an *Error, or nil if it isn't one.
+func asError(err error) *Error {
+	switch v := err.(type) {
+	case *Error:
+		return v
+	case Error:
+		return &v
+	}
+	return nil
+}
+
 // Fatal returns true if the Error Severity is fatal.
 func (err *Error) Fatal() bool {
 	return err.Severity == Efatal
@@ -387,6 +468,8 @@ func (err *Error) Get(k byte) (v string) {
 	switch k {
 	case 'S':
 		return err.Severity
+	case 'V':
+		return err.SeverityNonLocalized
 	case 'C':
 		return string(err.Code)
 	case 'M':
@@ -447,7 +530,9 @@ func errRecover(err *error) {
 	case runtime.Error:
 		panic(v)
 	case *Error:
-		if v.Fatal() {
+		// a connection that timed out in a transaction can't be retried
+		// on another, so what happened is more use than ErrBadConn
+		if v.Fatal() && v.Code != ErrCodeIdleInTransactionSessionTimeout {
 			*err = driver.ErrBadConn
 		} else {
 			*err = v
@@ -0,0 +1,87 @@
+package pq
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConstraintViolations(t *testing.T) {
+	tests := []struct {
+		err                 error
+		unique, fkey, check bool
+		constraint          string
+	}{
+		{&Error{Code: "23505", Constraint: "users_email_key"}, true, false, false, "users_email_key"},
+		{Error{Code: "23503", Constraint: "orders_user_id_fkey"}, false, true, false, "orders_user_id_fkey"},
+		{&Error{Code: "23514", Constraint: "users_age_check"}, false, false, true, "users_age_check"},
+		{&Error{Code: "23502", Column: "email"}, false, false, false, ""},
+		{errors.New("23505"), false, false, false, ""},
+		{nil, false, false, false, ""},
+	}
+
+	for _, tt := range tests {
+		if got := IsUniqueViolation(tt.err); got != tt.unique {
+			t.Errorf("IsUniqueViolation(%#v) = %v, expected %v", tt.err, got, tt.unique)
+		}
+		if got := IsForeignKeyViolation(tt.err); got != tt.fkey {
+			t.Errorf("IsForeignKeyViolation(%#v) = %v, expected %v", tt.err, got, tt.fkey)
+		}
+		if got := IsCheckViolation(tt.err); got != tt.check {
+			t.Errorf("IsCheckViolation(%#v) = %v, expected %v", tt.err, got, tt.check)
+		}
+		name, ok := ConstraintName(tt.err)
+		if name != tt.constraint || ok != (tt.constraint != "") {
+			t.Errorf("ConstraintName(%#v) = %q, %v, expected %q", tt.err, name, ok, tt.constraint)
+		}
+	}
+}
+
+func TestConstraintViolationsFromServer(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`CREATE TEMP TABLE users (
+		id int PRIMARY KEY,
+		age int CONSTRAINT users_age_check CHECK (age >= 0))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tx.Exec("CREATE TEMP TABLE orders (user_id int CONSTRAINT orders_user_id_fkey REFERENCES users)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO users VALUES (1, 30)"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query      string
+		is         func(error) bool
+		constraint string
+	}{
+		{"INSERT INTO users VALUES (1, 40)", IsUniqueViolation, "users_pkey"},
+		{"INSERT INTO orders VALUES (2)", IsForeignKeyViolation, "orders_user_id_fkey"},
+		{"INSERT INTO users VALUES (2, -1)", IsCheckViolation, "users_age_check"},
+	}
+	for _, tt := range tests {
+		if _, err := tx.Exec("SAVEPOINT s"); err != nil {
+			t.Fatal(err)
+		}
+		_, err := tx.Exec(tt.query)
+		if !tt.is(err) {
+			t.Errorf("%s: unexpected error %v", tt.query, err)
+		}
+		if name, _ := ConstraintName(err); name != tt.constraint {
+			t.Errorf("%s: expected constraint %q, got %q", tt.query, tt.constraint, name)
+		}
+		if _, err := tx.Exec("ROLLBACK TO SAVEPOINT s"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
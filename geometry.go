@@ -0,0 +1,72 @@
+package pq
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"github.com/gregb/pq/oid"
+)
+
+// openPath and closedPath are what paths decode to.  Both are []float64 of
+// the coordinates, like the other geometric types, so they can still be
+// scanned into a *[]float64; Path uses the type to tell them apart.
+type openPath []float64
+type closedPath []float64
+
+// Point is a point in a geometric type.
+type Point struct {
+	X, Y float64
+}
+
+// Path is a Postgres path: a sequence of points that is either open, like
+// [(1,2),(3,4)], or closed, like ((1,2),(3,4)), with the last point joined
+// to the first.
+type Path struct {
+	Points []Point
+	Closed bool
+}
+
+// Scan implements the sql.Scanner interface.
+func (p *Path) Scan(src interface{}) error {
+	var floats []float64
+	switch v := src.(type) {
+	case openPath:
+		floats, p.Closed = v, false
+	case closedPath:
+		floats, p.Closed = v, true
+	case []byte:
+		var err error
+		floats, err = extractFloats(v)
+		if err != nil {
+			return err
+		}
+		p.Closed = len(v) == 0 || v[0] != '['
+	default:
+		return fmt.Errorf("pq: cannot convert %T to Path", src)
+	}
+
+	if len(floats)%2 != 0 {
+		return fmt.Errorf("pq: odd number of coordinates in path: %v", floats)
+	}
+	p.Points = make([]Point, len(floats)/2)
+	for i := range p.Points {
+		p.Points[i] = Point{floats[2*i], floats[2*i+1]}
+	}
+	return nil
+}
+
+// Value implements the driver.Valuer interface.
+func (p Path) Value() (driver.Value, error) {
+	floats := make([]float64, 0, 2*len(p.Points))
+	for _, pt := range p.Points {
+		floats = append(floats, pt.X, pt.Y)
+	}
+
+	b, err := encodeGeometry(floats, oid.T_path)
+	if err != nil {
+		return nil, err
+	}
+	if !p.Closed {
+		b[0], b[len(b)-1] = '[', ']'
+	}
+	return b, nil
+}
@@ -0,0 +1,82 @@
+package pq
+
+import (
+	"github.com/gregb/pq/oid"
+	"reflect"
+	"testing"
+)
+
+func TestDecodePath(t *testing.T) {
+	open := decode(&parameterStatus{}, []byte("[(1,2),(3,4)]"), oid.T_path)
+	closed := decode(&parameterStatus{}, []byte("((1,2),(3,4))"), oid.T_path)
+
+	expected := []Point{{1, 2}, {3, 4}}
+	var p Path
+	if err := p.Scan(open); err != nil {
+		t.Fatal(err)
+	}
+	if p.Closed || !reflect.DeepEqual(p.Points, expected) {
+		t.Errorf("Unexpected open path %+v", p)
+	}
+	if err := p.Scan(closed); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Closed || !reflect.DeepEqual(p.Points, expected) {
+		t.Errorf("Unexpected closed path %+v", p)
+	}
+
+	// paths still convert to []float64
+	if !reflect.TypeOf(open).ConvertibleTo(reflect.TypeOf([]float64{})) {
+		t.Errorf("Expected %T to convert to []float64", open)
+	}
+}
+
+func TestPathValue(t *testing.T) {
+	p := Path{Points: []Point{{1, 2}, {3.5, -4}}}
+	v, err := p.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v.([]byte)) != "[(1,2),(3.5,-4)]" {
+		t.Errorf("Unexpected open path value %s", v)
+	}
+
+	p.Closed = true
+	v, err = p.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v.([]byte)) != "((1,2),(3.5,-4))" {
+		t.Errorf("Unexpected closed path value %s", v)
+	}
+
+	if _, err := (Path{}).Value(); err == nil {
+		t.Error("Expected an error for a path without points")
+	}
+}
+
+func TestPathRoundtrip(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	for _, expected := range []Path{
+		{Points: []Point{{1, 2}, {3, 4}}},
+		{Points: []Point{{1, 2}, {3, 4}, {5, 0}}, Closed: true},
+	} {
+		var p Path
+		if err := db.QueryRow("SELECT $1::path", expected).Scan(&p); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(p, expected) {
+			t.Errorf("Expected %+v, got %+v", expected, p)
+		}
+
+		var floats []float64
+		if err := db.QueryRow("SELECT $1::path", expected).Scan(&floats); err != nil {
+			t.Fatal(err)
+		}
+		if len(floats) != 2*len(expected.Points) {
+			t.Errorf("Unexpected coordinates %v", floats)
+		}
+	}
+}
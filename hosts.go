@@ -0,0 +1,204 @@
+package pq
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// isTargetSessionAttrs holds the valid values of target_session_attrs,
+// which are libpq's.  The empty string is "any".
+var isTargetSessionAttrs = map[string]bool{
+	"":               true,
+	"any":            true,
+	"read-write":     true,
+	"read-only":      true,
+	"primary":        true,
+	"standby":        true,
+	"prefer-standby": true,
+}
+
+// hostOptions returns the settings for each host to try, in order.  host
+// and port may be comma-separated lists, with either one port for all the
+// hosts or one for each.
+func hostOptions(o values) []values {
+	hosts := strings.Split(o.Get("host"), ",")
+	ports := strings.Split(o.Get("port"), ",")
+	if len(ports) != 1 && len(ports) != len(hosts) {
+		errorf("could not match %d port numbers to %d hosts", len(ports), len(hosts))
+	}
+
+	opts := make([]values, len(hosts))
+	for i, host := range hosts {
+		ho := make(values, len(o))
+		for k, v := range o {
+			ho[k] = v
+		}
+		ho.Set("host", strings.TrimSpace(host))
+		if len(ports) == 1 {
+			ho.Set("port", strings.TrimSpace(ports[0]))
+		} else {
+			ho.Set("port", strings.TrimSpace(ports[i]))
+		}
+		opts[i] = ho
+	}
+	return opts
+}
+
+// connectTarget connects to the first host, of those in the settings of
+// proto, whose session has the attributes target_session_attrs asks for.
+// Hosts that can't be connected to are skipped.  Connecting stops with
+// ctx's error once ctx is done.  With prefer-standby, a
+// primary is only used if no standby can be connected to.  The error is
+// the last host's.
+func (proto *conn) connectTarget(ctx context.Context) (_ *conn, err error) {
+	passes := []string{proto.opts.Get("target_session_attrs")}
+	if passes[0] == "prefer-standby" {
+		passes = []string{"standby", "any"}
+	}
+
+	hosts := hostOptions(proto.opts)
+	for _, want := range passes {
+		for _, o := range hosts {
+			var cn *conn
+			cn, err = proto.dial(ctx, o)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if err != nil {
+				continue
+			}
+			var ok bool
+			if ok, err = cn.hasSessionAttrs(want); ok {
+				return cn, nil
+			}
+			if err == nil {
+				_, addr := network(o)
+				err = fmt.Errorf("pq: server at %s is not %s", addr, want)
+			}
+			cn.Close()
+		}
+	}
+	return nil, err
+}
+
+// dial connects to the host in o, with the settings of proto, unless ctx is
+// done first.
+func (proto *conn) dial(ctx context.Context, o values) (_ *conn, err error) {
+	netw, addr := network(o)
+	var d net.Dialer
+	c, err := d.DialContext(ctx, netw, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+	// the startup is given up on too if ctx is done, by closing c under it
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-stop:
+		}
+	}()
+	defer func() {
+		close(stop)
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+	defer errRecover(&err)
+	defer func() {
+		// the server refusing the connection over a bad run-time parameter
+		// is reported as is rather than as a bad connection, which
+		// database/sql would only retry; other errors, such as failed
+		// authentication, are left to errRecover
+		if e := recover(); e != nil {
+			if pqErr, ok := e.(*Error); ok && isSettingError(pqErr) {
+				err = pqErr
+				return
+			}
+			panic(e)
+		}
+	}()
+
+	cn := new(conn)
+	*cn = *proto
+	cn.c = c
+	cn.opts = o
+	cn.ssl(o)
+	cn.buf = bufio.NewReader(cn.c)
+	cn.startup(o)
+	cn.defaultApplicationName = cn.runtimeParams["application_name"]
+	if cn.setSession != "" {
+		if _, _, err = cn.simpleExec(cn.setSession); err != nil {
+			return nil, err
+		}
+	}
+	if cn.lookupHstoreType {
+		if err = cn.lookupHstore(); err != nil {
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// hasSessionAttrs reports whether cn's session has the attributes of a
+// target_session_attrs value.
+func (cn *conn) hasSessionAttrs(attrs string) (bool, error) {
+	switch attrs {
+	case "read-write", "read-only":
+		readOnly, err := cn.isReadOnly()
+		return readOnly == (attrs == "read-only"), err
+	case "primary", "standby":
+		standby, err := cn.isStandby()
+		return standby == (attrs == "standby"), err
+	}
+	return true, nil
+}
+
+// isReadOnly reports whether cn's session defaults to read-only
+// transactions, as it does on a standby.  Servers since 14 report what's
+// needed to tell at startup; older ones are asked.
+func (cn *conn) isReadOnly() (bool, error) {
+	defaultReadOnly, ok := cn.runtimeParams["default_transaction_read_only"]
+	inHotStandby, ok2 := cn.runtimeParams["in_hot_standby"]
+	if ok && ok2 {
+		return defaultReadOnly == "on" || inHotStandby == "on", nil
+	}
+	return cn.queryBool("SELECT current_setting('transaction_read_only') = 'on'")
+}
+
+// isStandby reports whether cn's server is a standby, in recovery.
+func (cn *conn) isStandby() (bool, error) {
+	if inHotStandby, ok := cn.runtimeParams["in_hot_standby"]; ok {
+		return inHotStandby == "on", nil
+	}
+	return cn.queryBool("SELECT pg_is_in_recovery()")
+}
+
+// queryBool runs a query returning a single boolean.
+func (cn *conn) queryBool(q string) (bool, error) {
+	rows, err := cn.simpleQuery(q)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		return false, err
+	}
+	b, ok := dest[0].(bool)
+	if !ok {
+		return false, fmt.Errorf("pq: unexpected result %v from %s", dest[0], q)
+	}
+	return b, nil
+}
@@ -0,0 +1,133 @@
+package pq
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHostOptions(t *testing.T) {
+	opts := hostOptions(values{"host": "a, b", "port": "5432", "user": "u"})
+	if len(opts) != 2 || opts[0].Get("host") != "a" || opts[1].Get("host") != "b" ||
+		opts[0].Get("port") != "5432" || opts[1].Get("port") != "5432" || opts[1].Get("user") != "u" {
+		t.Errorf("unexpected host settings %v", opts)
+	}
+
+	opts = hostOptions(values{"host": "a,b", "port": "1,2"})
+	if opts[0].Get("port") != "1" || opts[1].Get("port") != "2" {
+		t.Errorf("unexpected host settings %v", opts)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for mismatched ports")
+		}
+	}()
+	hostOptions(values{"host": "a,b,c", "port": "1,2"})
+}
+
+func TestHasSessionAttrs(t *testing.T) {
+	primary := &conn{runtimeParams: map[string]string{"default_transaction_read_only": "off", "in_hot_standby": "off"}}
+	readOnlyPrimary := &conn{runtimeParams: map[string]string{"default_transaction_read_only": "on", "in_hot_standby": "off"}}
+	standby := &conn{runtimeParams: map[string]string{"default_transaction_read_only": "off", "in_hot_standby": "on"}}
+
+	tests := []struct {
+		cn       *conn
+		attrs    string
+		expected bool
+	}{
+		{primary, "any", true},
+		{primary, "read-write", true},
+		{primary, "read-only", false},
+		{primary, "primary", true},
+		{primary, "standby", false},
+		{readOnlyPrimary, "read-write", false},
+		{readOnlyPrimary, "read-only", true},
+		{readOnlyPrimary, "primary", true},
+		{standby, "read-write", false},
+		{standby, "read-only", true},
+		{standby, "primary", false},
+		{standby, "standby", true},
+	}
+	for i, tt := range tests {
+		ok, err := tt.cn.hasSessionAttrs(tt.attrs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.expected {
+			t.Errorf("%d: %s: expected %v, got %v", i, tt.attrs, tt.expected, ok)
+		}
+	}
+}
+
+func TestInvalidTargetSessionAttrs(t *testing.T) {
+	_, err := open(context.Background(), "host=127.0.0.1 port=1 target_session_attrs=writable", nil)
+	if err == nil || !strings.Contains(err.Error(), "target_session_attrs") {
+		t.Errorf("expected a target_session_attrs error, got %v", err)
+	}
+}
+
+func TestOpenCanceled(t *testing.T) {
+	// a server that accepts the connection, but never answers the startup
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	_, err = open(ctx, "host=127.0.0.1 sslmode=disable user=u port="+port, nil)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestTargetSessionAttrs(t *testing.T) {
+	ping := func(conninfo string) error {
+		db, err := openTestConnConninfo("user=pqgotest password=pqgotest " + conninfo)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return db.Ping()
+	}
+	var inRecovery bool
+	db := openTestConn(t)
+	err := db.QueryRow("SELECT pg_is_in_recovery()").Scan(&inRecovery)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inRecovery {
+		t.Skip("the test server is a standby")
+	}
+
+	// an unreachable host is skipped
+	if err := ping("host=127.0.0.1,localhost port=1,5432"); err != nil {
+		t.Errorf("expected to connect to the second host, got %v", err)
+	}
+
+	for _, attrs := range []string{"any", "read-write", "primary", "prefer-standby"} {
+		if err := ping("target_session_attrs=" + attrs); err != nil {
+			t.Errorf("%s: expected to connect to the primary, got %v", attrs, err)
+		}
+	}
+	for _, attrs := range []string{"read-only", "standby"} {
+		if err := ping("target_session_attrs=" + attrs); err == nil {
+			t.Errorf("%s: expected no server to qualify", attrs)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package pq
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/gregb/pq/oid"
+	"sort"
+	"strings"
+)
+
+// hstoreValue returns the text of a map[string]string or
+// map[string]sql.NullString parameter, as an hstore, with its keys in order.
+// A nil map is NULL.  ok is false for values of other types.
+func hstoreValue(x interface{}) (v driver.Value, ok bool, err error) {
+	var m map[string]sql.NullString
+	switch x := x.(type) {
+	case map[string]sql.NullString:
+		if x == nil {
+			return nil, true, nil
+		}
+		m = x
+	case map[string]string:
+		if x == nil {
+			return nil, true, nil
+		}
+		m = make(map[string]sql.NullString, len(x))
+		for k, s := range x {
+			m[k] = sql.NullString{String: s, Valid: true}
+		}
+	default:
+		return nil, false, nil
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		val := "NULL"
+		if m[k].Valid {
+			val = quoteHstore(m[k].String)
+		}
+		parts[i] = quoteHstore(k) + "=>" + val
+	}
+	return strings.Join(parts, ","), true, nil
+}
+
+// quoteHstore quotes a key or value of an hstore.
+func quoteHstore(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+// parseHstore parses the text of an hstore, such as "a"=>"1", "b"=>NULL.
+func parseHstore(s []byte) (map[string]sql.NullString, error) {
+	m := make(map[string]sql.NullString)
+	p := hstoreParser{s: s}
+	for p.skipSpace(); p.i < len(s); p.skipSpace() {
+		key, quoted, err := p.token('=')
+		if err != nil {
+			return nil, err
+		}
+		if key == "" && !quoted {
+			return nil, p.error("expected a key")
+		}
+		p.skipSpace()
+		if !strings.HasPrefix(string(s[p.i:]), "=>") {
+			return nil, p.error(`expected "=>"`)
+		}
+		p.i += 2
+		p.skipSpace()
+		val, quoted, err := p.token(',')
+		if err != nil {
+			return nil, err
+		}
+		if val == "" && !quoted {
+			return nil, p.error("expected a value")
+		}
+		if !quoted && strings.EqualFold(val, "NULL") {
+			m[key] = sql.NullString{}
+		} else {
+			m[key] = sql.NullString{String: val, Valid: true}
+		}
+		p.skipSpace()
+		if p.i < len(s) {
+			if s[p.i] != ',' {
+				return nil, p.error(`expected ","`)
+			}
+			p.i++
+		}
+	}
+	return m, nil
+}
+
+type hstoreParser struct {
+	s []byte
+	i int
+}
+
+func (p *hstoreParser) skipSpace() {
+	for p.i < len(p.s) && isHstoreSpace(p.s[p.i]) {
+		p.i++
+	}
+}
+
+// token reads a key or value, either in double quotes or up to a space or
+// the end byte, with backslash escapes.  quoted reports whether it was in
+// quotes.
+func (p *hstoreParser) token(end byte) (tok string, quoted bool, err error) {
+	quoted = p.i < len(p.s) && p.s[p.i] == '"'
+	if quoted {
+		p.i++
+	}
+	var b []byte
+	for ; p.i < len(p.s); p.i++ {
+		c := p.s[p.i]
+		if quoted && c == '"' {
+			p.i++
+			return string(b), true, nil
+		}
+		if !quoted && (c == end || isHstoreSpace(c)) {
+			break
+		}
+		if c == '\\' && p.i+1 < len(p.s) {
+			p.i++
+			c = p.s[p.i]
+		}
+		b = append(b, c)
+	}
+	if quoted {
+		return "", true, p.error("unterminated quoted string")
+	}
+	return string(b), false, nil
+}
+
+func (p *hstoreParser) error(msg string) error {
+	return fmt.Errorf("pq: invalid hstore %q: %s at offset %d", p.s, msg, p.i)
+}
+
+func isHstoreSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// lookupHstore finds the OID of the hstore extension's type, as the
+// session's search_path resolves it, so that decode can parse its values.
+// It stays 0 if the extension isn't installed.
+func (cn *conn) lookupHstore() error {
+	q := "SELECT coalesce(to_regtype('hstore')::oid::int8, 0)"
+	rows, err := cn.simpleQuery(q)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		return err
+	}
+	n, ok := dest[0].(int64)
+	if !ok {
+		return fmt.Errorf("pq: unexpected result %v from %s", dest[0], q)
+	}
+	cn.parameterStatus.hstoreOid = oid.Oid(n)
+	return nil
+}
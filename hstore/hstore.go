@@ -3,6 +3,7 @@ package hstore
 import (
 	"database/sql"
 	"database/sql/driver"
+	"fmt"
 	"strings"
 )
 
@@ -27,7 +28,6 @@ func hQuote(s interface{}) string {
 		panic("not a string or sql.NullString")
 	}
 
-	str = strings.Replace(str, "\n", "\\n", -1)
 	str = strings.Replace(str, "\\", "\\\\", -1)
 	return `"` + strings.Replace(str, "\"", "\\\"", -1) + `"`
 }
@@ -36,10 +36,20 @@ func hQuote(s interface{}) string {
 //
 // Note h.Map is reallocated before the scan to clear existing values. If the
 // hstore column's database value is NULL, then h.Map is set to nil instead.
+// A map already decoded by pq, with the hstore setting on, is taken as is.
 func (h *Hstore) Scan(value interface{}) error {
-	if value == nil {
+	switch v := value.(type) {
+	case nil:
 		h.Map = nil
 		return nil
+	case map[string]sql.NullString:
+		h.Map = v
+		return nil
+	case string:
+		value = []byte(v)
+	case []byte:
+	default:
+		return fmt.Errorf("pq: cannot convert %T to Hstore", value)
 	}
 	h.Map = make(map[string]sql.NullString)
 	var b byte
@@ -149,3 +149,23 @@ func TestHstore(t *testing.T) {
 	testBidirectional(hsThreePairs)
 	testBidirectional(hsSmorgasbord)
 }
+
+func TestHstoreScanDecoded(t *testing.T) {
+	m := map[string]sql.NullString{"a": {String: "1", Valid: true}}
+	var hs Hstore
+	if err := hs.Scan(m); err != nil {
+		t.Fatal(err)
+	}
+	if len(hs.Map) != 1 || hs.Map["a"] != m["a"] {
+		t.Errorf("unexpected map %v", hs.Map)
+	}
+	if err := hs.Scan(`"b"=>NULL`); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := hs.Map["b"]; !ok || v.Valid {
+		t.Errorf("unexpected map %v", hs.Map)
+	}
+	if err := hs.Scan(42); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,112 @@
+package pq
+
+import (
+	"database/sql"
+	"github.com/gregb/pq/oid"
+	"reflect"
+	"testing"
+)
+
+func TestHstoreValue(t *testing.T) {
+	tests := []struct {
+		in       interface{}
+		expected interface{}
+	}{
+		{map[string]string{"b": "2", "a": `say "hi"\`}, `"a"=>"say \"hi\"\\","b"=>"2"`},
+		{map[string]sql.NullString{"k": {}, "e": {Valid: true}}, `"e"=>"","k"=>NULL`},
+		{map[string]string{}, ""},
+		{map[string]string(nil), nil},
+		{map[string]sql.NullString(nil), nil},
+	}
+	for _, tt := range tests {
+		v, ok, err := hstoreValue(tt.in)
+		if !ok || err != nil {
+			t.Errorf("%v: unexpected result %v, %v", tt.in, ok, err)
+			continue
+		}
+		if v != tt.expected {
+			t.Errorf("%v: expected %v, got %v", tt.in, tt.expected, v)
+		}
+	}
+	if _, ok, _ := hstoreValue(map[string]int{}); ok {
+		t.Error("expected map[string]int not to be an hstore")
+	}
+}
+
+func TestParseHstore(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected map[string]sql.NullString
+	}{
+		{``, map[string]sql.NullString{}},
+		{`"a"=>"1", "b"=>NULL`, map[string]sql.NullString{"a": {String: "1", Valid: true}, "b": {}}},
+		{`"NULL"=>"NULL"`, map[string]sql.NullString{"NULL": {String: "NULL", Valid: true}}},
+		{`"q\"uo\\te"=>"a,b=>c", "nl"=>"x
+y"`, map[string]sql.NullString{`q"uo\te`: {String: "a,b=>c", Valid: true}, "nl": {String: "x\ny", Valid: true}}},
+		{` a => b ,c=>null `, map[string]sql.NullString{"a": {String: "b", Valid: true}, "c": {}}},
+	}
+	for _, tt := range tests {
+		m, err := parseHstore([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%q: %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(m, tt.expected) {
+			t.Errorf("%q: expected %v, got %v", tt.in, tt.expected, m)
+		}
+	}
+
+	for _, in := range []string{`"a"`, `"a"=>`, `"a"=>"1" "b"=>"2"`, `"a"=>"1`, `=>"1"`} {
+		if _, err := parseHstore([]byte(in)); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestDecodeHstore(t *testing.T) {
+	ps := &parameterStatus{hstoreOid: 16400}
+	v := decode(ps, []byte(`"a"=>"1"`), 16400)
+	if !reflect.DeepEqual(v, map[string]sql.NullString{"a": {String: "1", Valid: true}}) {
+		t.Errorf("unexpected value %#v", v)
+	}
+
+	// other types, and any type until the OID is known, are left alone
+	if v := decode(ps, []byte(`"a"=>"1"`), 16401); !reflect.DeepEqual(v, []byte(`"a"=>"1"`)) {
+		t.Errorf("unexpected value %#v", v)
+	}
+	if v := decode(&parameterStatus{}, []byte(`"a"=>"1"`), 16400); !reflect.DeepEqual(v, []byte(`"a"=>"1"`)) {
+		t.Errorf("unexpected value %#v", v)
+	}
+	if v := decode(&parameterStatus{}, []byte(""), oid.Oid(0)); !reflect.DeepEqual(v, []byte("")) {
+		t.Errorf("unexpected value %#v", v)
+	}
+}
+
+func TestHstoreRoundtrip(t *testing.T) {
+	db, err := openTestConnConninfo("hstore=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var installed bool
+	if err := db.QueryRow("SELECT to_regtype('hstore') IS NOT NULL").Scan(&installed); err != nil {
+		t.Fatal(err)
+	}
+	if !installed {
+		t.Skip("the hstore extension is not installed")
+	}
+
+	in := map[string]sql.NullString{
+		"plain":    {String: "value", Valid: true},
+		"null":     {},
+		`"quoted"`: {String: "a,\nb=>\\c", Valid: true},
+	}
+	var out map[string]sql.NullString
+	if err := db.QueryRow("SELECT $1::hstore", in).Scan(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("expected %v, got %v", in, out)
+	}
+}
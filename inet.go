@@ -0,0 +1,124 @@
+package pq
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// inetValue returns the text of a net.IP, net.IPNet or *net.IPNet parameter,
+// as an inet: the bare address for a net.IP, or the address and its netmask
+// for a net.IPNet.  ok is false for values of other types.
+func inetValue(x interface{}) (v driver.Value, ok bool, err error) {
+	switch x := x.(type) {
+	case net.IP:
+		if x == nil {
+			return nil, true, nil
+		}
+		if x.To16() == nil {
+			return nil, true, fmt.Errorf("pq: invalid IP address %v", []byte(x))
+		}
+		return x.String(), true, nil
+	case *net.IPNet:
+		if x == nil {
+			return nil, true, nil
+		}
+		return inetValue(*x)
+	case net.IPNet:
+		s := x.String()
+		if _, _, err := net.ParseCIDR(s); err != nil {
+			return nil, true, fmt.Errorf("pq: invalid IP network %s", s)
+		}
+		return s, true, nil
+	}
+	return nil, false, nil
+}
+
+// Inet returns a sql.Scanner for scanning an inet or cidr into dest, which
+// must be a *net.IP or a *net.IPNet.  A net.IP takes an address without a
+// netmask, or with one that covers the whole address; a net.IPNet takes
+// either, keeping the address as it is rather than masking it, as inet
+// does.  IPv4 addresses are 4 bytes long.
+//
+//	var ip net.IP
+//	err := db.QueryRow("SELECT addr FROM hosts WHERE id = $1", id).Scan(pq.Inet(&ip))
+//
+// net.IP and net.IPNet values are passed as parameters as they are.
+func Inet(dest interface{}) sql.Scanner {
+	return inetScanner{dest}
+}
+
+type inetScanner struct {
+	dest interface{}
+}
+
+// Scan implements the sql.Scanner interface.
+func (s inetScanner) Scan(src interface{}) error {
+	var text string
+	switch src := src.(type) {
+	case []byte:
+		text = string(src)
+	case string:
+		text = src
+	case nil:
+		switch d := s.dest.(type) {
+		case *net.IP:
+			*d = nil
+			return nil
+		case *net.IPNet:
+			*d = net.IPNet{}
+			return nil
+		}
+		return fmt.Errorf("pq: cannot scan an IP address into %T", s.dest)
+	default:
+		return fmt.Errorf("pq: cannot convert %T to an IP address", src)
+	}
+
+	ipNet, err := parseInet(text)
+	if err != nil {
+		return err
+	}
+	switch d := s.dest.(type) {
+	case *net.IP:
+		if ones, bits := ipNet.Mask.Size(); ones != bits {
+			return fmt.Errorf("pq: cannot scan %s, which has a netmask, into a net.IP", text)
+		}
+		*d = ipNet.IP
+	case *net.IPNet:
+		*d = *ipNet
+	default:
+		return fmt.Errorf("pq: cannot scan an IP address into %T", s.dest)
+	}
+	return nil
+}
+
+// parseInet parses the text of an inet or cidr.  The netmask of an address
+// without one covers the whole address.
+func parseInet(s string) (*net.IPNet, error) {
+	var ip net.IP
+	var mask net.IPMask
+	if strings.IndexByte(s, '/') >= 0 {
+		var n *net.IPNet
+		var err error
+		ip, n, err = net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("pq: invalid inet %q", s)
+		}
+		mask = n.Mask
+	} else {
+		ip = net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("pq: invalid inet %q", s)
+		}
+	}
+
+	if ip4 := ip.To4(); ip4 != nil && len(mask) != net.IPv6len {
+		ip = ip4
+	}
+	if mask == nil {
+		mask = net.CIDRMask(len(ip)*8, len(ip)*8)
+	}
+	return &net.IPNet{IP: ip, Mask: mask}, nil
+}
@@ -0,0 +1,187 @@
+package pq
+
+import (
+	"database/sql/driver"
+	"github.com/gregb/pq/oid"
+	"net"
+	"testing"
+)
+
+func TestInetValue(t *testing.T) {
+	_, ipv4Net, _ := net.ParseCIDR("192.168.0.0/24")
+	tests := []struct {
+		in       interface{}
+		expected driver.Value
+	}{
+		{net.ParseIP("192.168.0.1"), "192.168.0.1"},
+		{net.IPv4(10, 0, 0, 1).To4(), "10.0.0.1"},
+		{net.ParseIP("::1"), "::1"},
+		{net.IP(nil), nil},
+		{ipv4Net, "192.168.0.0/24"},
+		{*ipv4Net, "192.168.0.0/24"},
+		{net.IPNet{IP: net.ParseIP("192.168.0.1"), Mask: net.CIDRMask(24, 32)}, "192.168.0.1/24"},
+		{net.IPNet{IP: net.ParseIP("2001:db8::1"), Mask: net.CIDRMask(64, 128)}, "2001:db8::1/64"},
+		{(*net.IPNet)(nil), nil},
+	}
+	for _, tt := range tests {
+		v, ok, err := inetValue(tt.in)
+		if !ok || err != nil {
+			t.Errorf("%v: unexpected result %v, %v", tt.in, ok, err)
+			continue
+		}
+		if v != tt.expected {
+			t.Errorf("%v: expected %v, got %v", tt.in, tt.expected, v)
+		}
+	}
+
+	for _, in := range []interface{}{net.IP{1, 2, 3}, net.IPNet{IP: net.ParseIP("10.0.0.1"), Mask: net.IPMask{255, 0, 255, 0}}} {
+		if _, _, err := inetValue(in); err == nil {
+			t.Errorf("%v: expected an error", in)
+		}
+	}
+	if _, ok, _ := inetValue([]byte{1, 2, 3, 4}); ok {
+		t.Error("expected []byte not to be an inet")
+	}
+}
+
+func TestInetScan(t *testing.T) {
+	var ip net.IP
+	if err := Inet(&ip).Scan([]byte("192.168.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(net.IPv4(192, 168, 0, 1)) || len(ip) != net.IPv4len {
+		t.Errorf("unexpected IP %v", ip)
+	}
+	if err := Inet(&ip).Scan("10.0.0.1/32"); err != nil || !ip.Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Errorf("unexpected IP %v, %v", ip, err)
+	}
+	if err := Inet(&ip).Scan([]byte("10.0.0.1/8")); err == nil {
+		t.Error("expected an error scanning an address with a netmask into a net.IP")
+	}
+	if err := Inet(&ip).Scan(nil); err != nil || ip != nil {
+		t.Errorf("unexpected IP %v, %v", ip, err)
+	}
+
+	var ipNet net.IPNet
+	if err := Inet(&ipNet).Scan([]byte("192.168.0.1/24")); err != nil {
+		t.Fatal(err)
+	}
+	if ipNet.String() != "192.168.0.1/24" {
+		t.Errorf("unexpected network %v", ipNet)
+	}
+	if err := Inet(&ipNet).Scan([]byte("::1")); err != nil || ipNet.String() != "::1/128" {
+		t.Errorf("unexpected network %v, %v", ipNet, err)
+	}
+
+	if err := Inet(&ip).Scan([]byte("not an address")); err == nil {
+		t.Error("expected an error for an invalid address")
+	}
+	var s string
+	if err := Inet(&s).Scan([]byte("::1")); err == nil {
+		t.Error("expected an error scanning into a string")
+	}
+}
+
+func TestInetRoundtrip(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	for _, addr := range []string{"192.168.0.1", "::1", "2001:db8::8a2e:370:7334"} {
+		in := net.ParseIP(addr)
+		var text string
+		var out net.IP
+		err := db.QueryRow("SELECT $1::inet::text, $1::inet", in).Scan(&text, Inet(&out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text != addr+"/32" && text != addr+"/128" {
+			t.Errorf("%s: unexpected text %q", addr, text)
+		}
+		if !out.Equal(in) {
+			t.Errorf("%s: got %v back", addr, out)
+		}
+	}
+
+	for _, cidr := range []string{"192.168.0.1/24", "2001:db8::1/64"} {
+		ip, n, _ := net.ParseCIDR(cidr)
+		in := net.IPNet{IP: ip, Mask: n.Mask}
+		var out net.IPNet
+		err := db.QueryRow("SELECT $1::inet", in).Scan(Inet(&out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != cidr {
+			t.Errorf("%s: got %v back", cidr, out)
+		}
+	}
+}
+
+func TestInetArrayValue(t *testing.T) {
+	_, n, _ := net.ParseCIDR("10.0.0.0/8")
+	tests := []struct {
+		in       interface{}
+		expected string
+	}{
+		{[]net.IP{net.ParseIP("192.168.0.1"), net.ParseIP("::1"), nil}, `{192.168.0.1,::1,NULL}`},
+		{[]net.IPNet{*n}, `{10.0.0.0/8}`},
+		{[]*net.IPNet{n, nil}, `{10.0.0.0/8,NULL}`},
+	}
+	for _, tt := range tests {
+		v, err := Array(tt.in).Value()
+		if err != nil {
+			t.Errorf("%v: %v", tt.in, err)
+			continue
+		}
+		if string(v.([]byte)) != tt.expected {
+			t.Errorf("%v: expected %s, got %s", tt.in, tt.expected, v)
+		}
+	}
+	if _, err := Array([]net.IP{{1, 2, 3}}).Value(); err == nil {
+		t.Error("expected an error for an invalid address")
+	}
+}
+
+func TestInetArrayScan(t *testing.T) {
+	src, err := DecodeArray([]byte(`{192.168.0.1,::1/128,NULL}`), oid.T__inet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ips []net.IP
+	if err := Array(&ips).Scan(src); err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 3 || !ips[0].Equal(net.IPv4(192, 168, 0, 1)) || len(ips[0]) != net.IPv4len || !ips[1].Equal(net.IPv6loopback) || ips[2] != nil {
+		t.Errorf("unexpected addresses %v", ips)
+	}
+
+	src, err = DecodeArray([]byte(`{10.0.0.0/8,NULL}`), oid.T__cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nets []*net.IPNet
+	if err := Array(&nets).Scan(src); err != nil {
+		t.Fatal(err)
+	}
+	if len(nets) != 2 || nets[0].String() != "10.0.0.0/8" || nets[1] != nil {
+		t.Errorf("unexpected networks %v", nets)
+	}
+
+	// an address with a netmask doesn't fit a net.IP
+	if err := Array(&ips).Scan([]byte(`{10.0.0.0/8}`)); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestInetArrayRoundtrip(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	in := []net.IP{net.ParseIP("192.168.0.1").To4(), net.ParseIP("2001:db8::1")}
+	var out []net.IP
+	if err := db.QueryRow("SELECT $1::inet[]", Array(in)).Scan(Array(&out)); err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || !out[0].Equal(in[0]) || !out[1].Equal(in[1]) {
+		t.Errorf("expected %v, got %v", in, out)
+	}
+}
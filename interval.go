@@ -0,0 +1,174 @@
+package pq
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Interval is a Postgres interval.  Months and days are kept apart from the
+// rest of the interval, as Postgres keeps them, since their length in time
+// depends on the date they are added to.
+type Interval struct {
+	Months       int32
+	Days         int32
+	Microseconds int64
+}
+
+// Scan implements the sql.Scanner interface.
+func (iv *Interval) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case Interval:
+		*iv = v
+		return nil
+	case []byte:
+		var err error
+		*iv, err = parseInterval(string(v))
+		return err
+	case string:
+		var err error
+		*iv, err = parseInterval(v)
+		return err
+	}
+	return fmt.Errorf("pq: cannot convert %T to Interval", src)
+}
+
+// Value implements the driver.Valuer interface.
+func (iv Interval) Value() (driver.Value, error) {
+	return iv.String(), nil
+}
+
+// String returns the interval as Postgres shows it with the default
+// IntervalStyle, such as "1 year 2 mons -3 days +04:05:06.5".
+func (iv Interval) String() string {
+	var b []byte
+	isZero, isBefore := true, false
+	appendPart := func(n int64, unit string) {
+		if n == 0 {
+			return
+		}
+		if !isZero {
+			b = append(b, ' ')
+		}
+		if isBefore && n > 0 {
+			b = append(b, '+')
+		}
+		b = strconv.AppendInt(b, n, 10)
+		b = append(b, ' ')
+		b = append(b, unit...)
+		if n != 1 {
+			b = append(b, 's')
+		}
+		isBefore, isZero = n < 0, false
+	}
+	appendPart(int64(iv.Months/12), "year")
+	appendPart(int64(iv.Months%12), "mon")
+	appendPart(int64(iv.Days), "day")
+
+	if isZero || iv.Microseconds != 0 {
+		if !isZero {
+			b = append(b, ' ')
+		}
+		us := iv.Microseconds
+		if us < 0 {
+			b = append(b, '-')
+			us = -us
+		} else if isBefore {
+			b = append(b, '+')
+		}
+		secs := us / 1000000
+		b = append(b, fmt.Sprintf("%02d:%02d:%02d", secs/3600, secs/60%60, secs%60)...)
+		if frac := us % 1000000; frac != 0 {
+			b = append(b, strings.TrimRight(fmt.Sprintf(".%06d", frac), "0")...)
+		}
+	}
+	return string(b)
+}
+
+// parseInterval parses an interval in the default postgres IntervalStyle,
+// which is the one pq connects with.
+func parseInterval(s string) (iv Interval, err error) {
+	fail := func() (Interval, error) {
+		return Interval{}, fmt.Errorf("pq: invalid interval %q", s)
+	}
+
+	fields := strings.Fields(s)
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if strings.Contains(f, ":") {
+			us, ok := parseIntervalTime(f)
+			if !ok {
+				return fail()
+			}
+			iv.Microseconds += us
+			continue
+		}
+
+		if i+1 == len(fields) {
+			return fail()
+		}
+		n, err := strconv.ParseInt(f, 10, 32)
+		if err != nil {
+			return fail()
+		}
+		i++
+		switch strings.TrimSuffix(fields[i], "s") {
+		case "year":
+			iv.Months += int32(n) * 12
+		case "mon":
+			iv.Months += int32(n)
+		case "day":
+			iv.Days += int32(n)
+		default:
+			return fail()
+		}
+	}
+	return iv, nil
+}
+
+// parseIntervalTime parses the [-]hh:mm:ss[.ffffff] time of an interval into
+// microseconds.
+func parseIntervalTime(s string) (int64, bool) {
+	sign := int64(1)
+	switch s[0] {
+	case '-':
+		sign = -1
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	frac := ""
+	if i := strings.IndexByte(parts[2], '.'); i >= 0 {
+		parts[2], frac = parts[2][:i], parts[2][i+1:]
+	}
+	if len(frac) > 6 {
+		return 0, false
+	}
+
+	var us int64
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 63)
+		if err != nil {
+			return 0, false
+		}
+		if i > 0 && n >= 60 {
+			return 0, false
+		}
+		us = us*60 + int64(n)
+	}
+	us *= 1000000
+	if frac != "" {
+		n, err := strconv.ParseUint(frac+strings.Repeat("0", 6-len(frac)), 10, 63)
+		if err != nil {
+			return 0, false
+		}
+		us += int64(n)
+	}
+	return sign * us, true
+}
@@ -0,0 +1,100 @@
+package pq
+
+import (
+	"github.com/gregb/pq/oid"
+	"reflect"
+	"testing"
+)
+
+var intervalTests = []struct {
+	text string
+	iv   Interval
+}{
+	{"00:00:00", Interval{}},
+	{"1 day 02:03:04", Interval{Days: 1, Microseconds: (2*3600 + 3*60 + 4) * 1000000}},
+	{"-00:00:01", Interval{Microseconds: -1000000}},
+	{"1 year 2 mons", Interval{Months: 14}},
+	{"-1 years -2 mons +3 days -00:00:00.5", Interval{Months: -14, Days: 3, Microseconds: -500000}},
+	{"1 mon -1 days", Interval{Months: 1, Days: -1}},
+	{"-3 days +100:00:00.000001", Interval{Days: -3, Microseconds: 100*3600*1000000 + 1}},
+}
+
+func TestIntervalString(t *testing.T) {
+	for _, tt := range intervalTests {
+		if s := tt.iv.String(); s != tt.text {
+			t.Errorf("%#v: expected %q, got %q", tt.iv, tt.text, s)
+		}
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	for _, tt := range intervalTests {
+		iv, err := parseInterval(tt.text)
+		if err != nil {
+			t.Errorf("%q: %v", tt.text, err)
+			continue
+		}
+		if iv != tt.iv {
+			t.Errorf("%q: expected %#v, got %#v", tt.text, tt.iv, iv)
+		}
+	}
+
+	for _, s := range []string{"1", "1 week", "1:2", "00:61:00", "00:00:00.1234567", "P1D"} {
+		if _, err := parseInterval(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestDecodeInterval(t *testing.T) {
+	// left as text, which Interval parses
+	v := decode(&parameterStatus{}, []byte("1 day 02:03:04"), oid.T_interval)
+	if !reflect.DeepEqual(v, []byte("1 day 02:03:04")) {
+		t.Fatalf("Unexpected decoding: %#v", v)
+	}
+	var iv Interval
+	if err := iv.Scan(v); err != nil || iv != intervalTests[1].iv {
+		t.Errorf("Unexpected interval %v, %v", iv, err)
+	}
+	if err := iv.Scan([]byte("not an interval")); err == nil {
+		t.Error("Expected an error")
+	}
+}
+
+// Does not access database, simply tests the parser
+func TestDecodeIntervalArray(t *testing.T) {
+	v, err := DecodeArray([]byte(`{"1 day 02:03:04",-00:00:01,"1 mon -1 days",NULL}`), oid.T__interval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := v.([]*Interval)
+	if len(got) != 4 || *got[0] != intervalTests[1].iv || *got[1] != intervalTests[2].iv || *got[2] != intervalTests[5].iv || got[3] != nil {
+		t.Errorf("Unexpected decoding: %v", got)
+	}
+}
+
+func TestIntervalArrayRoundtrip(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	expected := []Interval{intervalTests[1].iv, intervalTests[2].iv, intervalTests[4].iv}
+
+	var got []Interval
+	err := db.QueryRow("SELECT $1::interval[]", Array(expected)).Scan(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	var iv Interval
+	var s string
+	err = db.QueryRow("SELECT '-1 mon +2 days 03:00:00'::interval, '1 year'::interval").Scan(&iv, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iv != (Interval{Months: -1, Days: 2, Microseconds: 3 * 3600 * 1000000}) || s != "1 year" {
+		t.Errorf("Unexpected intervals %v, %q", iv, s)
+	}
+}
@@ -0,0 +1,51 @@
+package pq
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// jsonValue returns the JSON text of a json.RawMessage parameter, or of a
+// struct, map or slice, or a pointer to one, that implements json.Marshaler
+// but not driver.Valuer, and that isn't a value database/sql passes on as
+// is, such as a time.Time.  Without it, a json.RawMessage would be sent as
+// a bytea and such a json.Marshaler rejected.  Named scalar types keep
+// going through the default conversion, as the integer or string they are,
+// even if they implement json.Marshaler.  A nil json.RawMessage or pointer
+// is NULL.  ok is false for values of other types.
+func jsonValue(x interface{}) (v driver.Value, ok bool, err error) {
+	switch x := x.(type) {
+	case json.RawMessage:
+		if x == nil {
+			return nil, true, nil
+		}
+		return string(x), true, nil
+	case driver.Valuer:
+		return nil, false, nil
+	case json.Marshaler:
+		if driver.IsValue(x) {
+			return nil, false, nil
+		}
+		rv := reflect.ValueOf(x)
+		t := rv.Type()
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Struct, reflect.Map, reflect.Slice:
+		default:
+			return nil, false, nil
+		}
+		if rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return nil, true, nil
+		}
+		b, err := json.Marshal(x)
+		if err != nil {
+			return nil, true, fmt.Errorf("pq: cannot marshal %T as JSON: %v", x, err)
+		}
+		return string(b), true, nil
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,99 @@
+package pq
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type jsonPoint struct {
+	X, Y int
+}
+
+func (p jsonPoint) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("[%d,%d]", p.X, p.Y)), nil
+}
+
+type jsonStatus string
+
+func (s jsonStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strings.ToUpper(string(s)))
+}
+
+type jsonCount int
+
+func (n jsonCount) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`{"count": %d}`, n)), nil
+}
+
+type badJSON struct{}
+
+func (badJSON) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("no JSON here")
+}
+
+func TestJSONValue(t *testing.T) {
+	tests := []struct {
+		in       interface{}
+		expected interface{}
+	}{
+		{json.RawMessage(`{"a": 1}`), `{"a": 1}`},
+		{json.RawMessage(nil), nil},
+		{jsonPoint{1, 2}, `[1,2]`},
+		{&jsonPoint{3, 4}, `[3,4]`},
+		{(*jsonPoint)(nil), nil},
+	}
+	for _, tt := range tests {
+		v, ok, err := jsonValue(tt.in)
+		if !ok || err != nil {
+			t.Errorf("%v: unexpected result %v, %v", tt.in, ok, err)
+			continue
+		}
+		if v != tt.expected {
+			t.Errorf("%v: expected %v, got %v", tt.in, tt.expected, v)
+		}
+	}
+
+	// values database/sql handles itself, Valuers, and named scalar types,
+	// which the default conversion sends as what they are, are left alone
+	status := jsonStatus("active")
+	for _, in := range []interface{}{time.Now(), NullTime{}, "x", []byte("x"), status, &status, jsonCount(3)} {
+		if _, ok, _ := jsonValue(in); ok {
+			t.Errorf("%T: expected no conversion", in)
+		}
+	}
+
+	if _, ok, err := jsonValue(badJSON{}); !ok || err == nil {
+		t.Errorf("expected an error, got %v, %v", ok, err)
+	}
+}
+
+func TestJSONCheckNamedValue(t *testing.T) {
+	cn := &conn{}
+	nv := &driver.NamedValue{Ordinal: 1, Value: json.RawMessage(`[1]`)}
+	if err := cn.CheckNamedValue(nv); err != nil || nv.Value != "[1]" {
+		t.Errorf("unexpected result %v, %v", nv.Value, err)
+	}
+}
+
+func TestJSONRoundtrip(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	var raw json.RawMessage
+	var point string
+	err := db.QueryRow("SELECT $1::jsonb, $2::json", json.RawMessage(`{"a": [1, 2]}`), jsonPoint{1, 2}).Scan(&raw, &point)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != `{"a": [1, 2]}` {
+		t.Errorf("unexpected jsonb %s", raw)
+	}
+	if point != "[1,2]" {
+		t.Errorf("unexpected json %s", point)
+	}
+}
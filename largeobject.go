@@ -0,0 +1,150 @@
+package pq
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/gregb/pq/oid"
+	"io"
+)
+
+// Modes for opening a large object, combined with |.
+const (
+	LargeObjectModeWrite = 0x20000
+	LargeObjectModeRead  = 0x40000
+)
+
+const defaultLargeObjectBlockSize = 64 * 1024
+
+// LargeObjects gives access to the large objects in a database through the
+// server's lo_* functions.  Large object descriptors only live as long as
+// the transaction they were opened in, so LargeObjects works on a *sql.Tx.
+type LargeObjects struct {
+	tx *sql.Tx
+
+	// BlockSize is the most data read or written in a single query, which
+	// bounds the memory used for large reads and writes.
+	BlockSize int
+}
+
+// NewLargeObjects returns a LargeObjects for the transaction tx.
+func NewLargeObjects(tx *sql.Tx) *LargeObjects {
+	return &LargeObjects{tx: tx, BlockSize: defaultLargeObjectBlockSize}
+}
+
+// call runs a large object function, returning its result in dest.
+func (lo *LargeObjects) call(dest interface{}, q string, args ...interface{}) error {
+	err := lo.tx.QueryRow(q, args...).Scan(dest)
+	if err == sql.ErrTxDone {
+		return errors.New("pq: large object operations must run in a transaction, and this one has finished")
+	}
+	return err
+}
+
+// Create creates a new, empty large object and returns its OID.
+func (lo *LargeObjects) Create() (oid.Oid, error) {
+	var o oid.Oid
+	err := lo.call(&o, "SELECT lo_create(0)")
+	return o, err
+}
+
+// Open opens the large object o with mode, a combination of
+// LargeObjectModeRead and LargeObjectModeWrite.  The large object is closed
+// at the end of the transaction, if not before.
+func (lo *LargeObjects) Open(o oid.Oid, mode int) (*LargeObject, error) {
+	var fd int32
+	if err := lo.call(&fd, "SELECT lo_open($1, $2)", int64(o), int64(mode)); err != nil {
+		return nil, err
+	}
+	return &LargeObject{lo: lo, fd: fd}, nil
+}
+
+// Remove deletes the large object o.
+func (lo *LargeObjects) Remove(o oid.Oid) error {
+	var result int32
+	return lo.call(&result, "SELECT lo_unlink($1)", int64(o))
+}
+
+// LargeObject is an open large object.  It implements io.ReadWriteSeeker
+// and io.Closer.
+type LargeObject struct {
+	lo *LargeObjects
+	fd int32
+}
+
+func (o *LargeObject) blockSize() int {
+	if o.lo.BlockSize > 0 {
+		return o.lo.BlockSize
+	}
+	return defaultLargeObjectBlockSize
+}
+
+// Read implements io.Reader.
+func (o *LargeObject) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		size := len(p) - n
+		if size > o.blockSize() {
+			size = o.blockSize()
+		}
+
+		var data []byte
+		if err := o.lo.call(&data, "SELECT loread($1, $2)", int64(o.fd), int64(size)); err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data)
+		if len(data) < size {
+			if n == 0 {
+				return 0, io.EOF
+			}
+			break
+		}
+	}
+	return n, nil
+}
+
+// Write implements io.Writer.
+func (o *LargeObject) Write(p []byte) (n int, err error) {
+	for n < len(p) {
+		block := p[n:]
+		if len(block) > o.blockSize() {
+			block = block[:o.blockSize()]
+		}
+
+		var written int
+		if err := o.lo.call(&written, "SELECT lowrite($1, $2)", int64(o.fd), block); err != nil {
+			return n, err
+		}
+		n += written
+		if written < len(block) {
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, nil
+}
+
+// Seek implements io.Seeker.  Offsets are 64 bits, which needs PostgreSQL
+// 9.3 or later.
+func (o *LargeObject) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart, io.SeekCurrent, io.SeekEnd:
+		// the same values as the server's SEEK_SET, SEEK_CUR and SEEK_END
+	default:
+		return 0, fmt.Errorf("pq: invalid whence %d", whence)
+	}
+
+	var pos int64
+	err := o.lo.call(&pos, "SELECT lo_lseek64($1, $2, $3)", int64(o.fd), offset, int64(whence))
+	return pos, err
+}
+
+// Truncate sets the size of the large object.
+func (o *LargeObject) Truncate(size int64) error {
+	var result int32
+	return o.lo.call(&result, "SELECT lo_truncate64($1, $2)", int64(o.fd), size)
+}
+
+// Close implements io.Closer.
+func (o *LargeObject) Close() error {
+	var result int32
+	return o.lo.call(&result, "SELECT lo_close($1)", int64(o.fd))
+}
@@ -0,0 +1,78 @@
+package pq
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestLargeObject(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	lo := NewLargeObjects(tx)
+	lo.BlockSize = 7 // exercise the chunking
+
+	o, err := lo.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := lo.Open(o, LargeObjectModeRead|LargeObjectModeWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("0123456789\x00\xff"), 10)
+	n, err := obj.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data) {
+		t.Fatalf("expected to write %d bytes, wrote %d", len(data), n)
+	}
+
+	pos, err := obj.Seek(2, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != 2 {
+		t.Fatalf("expected position 2, got %d", pos)
+	}
+
+	got, err := ioutil.ReadAll(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data[2:]) {
+		t.Errorf("expected %q, got %q", data[2:], got)
+	}
+
+	if err := obj.Truncate(5); err != nil {
+		t.Fatal(err)
+	}
+	if pos, err = obj.Seek(0, io.SeekEnd); err != nil || pos != 5 {
+		t.Errorf("expected the truncated size to be 5, got %d (%v)", pos, err)
+	}
+
+	if err := obj.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := lo.Remove(o); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lo.Create(); err == nil {
+		t.Error("expected an error using large objects after the transaction")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build go1.26
+// +build go1.26
+
+package pq
+
+import "crypto/fips140"
+
+// md5Forbidden reports whether crypto/md5 refuses to hash, as it does when
+// the program runs with GODEBUG=fips140=only.
+var md5Forbidden = fips140.Enforced
@@ -0,0 +1,8 @@
+//go:build !go1.26
+// +build !go1.26
+
+package pq
+
+// md5Forbidden reports whether crypto/md5 refuses to hash.  Before Go 1.26
+// there's no telling, so md5 is tried.
+var md5Forbidden = func() bool { return false }
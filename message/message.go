@@ -21,6 +21,11 @@ const (
 	ParseComplete        Backend = '1'
 	BindComplete         Backend = '2'
 	CloseComplete        Backend = '3'
+	EmptyQueryResponse   Backend = 'I'
+	CopyInResponse       Backend = 'G'
+	CopyOutResponse      Backend = 'H'
+	CopyData             Backend = 'd'
+	CopyDone             Backend = 'c'
 )
 
 const (
@@ -0,0 +1,419 @@
+package pq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gregb/pq/message"
+	"sync"
+	"time"
+)
+
+// Notification is an asynchronous notification, sent with NOTIFY or
+// pg_notify to a channel that is listened to.
+type Notification struct {
+	// BePid is the process ID of the server backend that sent it.
+	BePid int
+	// Channel is the channel it was sent to.
+	Channel string
+	// Extra is its payload, which is empty if none was given.
+	Extra string
+}
+
+// recvNotification reads a NotificationResponse.
+func recvNotification(r *readBuf) *Notification {
+	bePid := r.int32()
+	channel := r.string()
+	extra := r.string()
+	return &Notification{BePid: bePid, Channel: channel, Extra: extra}
+}
+
+var (
+	ErrChannelAlreadyOpen = errors.New("pq: channel is already open")
+	ErrChannelNotOpen     = errors.New("pq: channel is not open")
+	ErrListenerClosed     = errors.New("pq: Listener has been closed")
+)
+
+// listenerConn is a connection that a Listener listens on.  Its read loop
+// receives every message from the server, handing notifications on to
+// notify and the outcome of each command to exec, which sends one at a time.
+type listenerConn struct {
+	cn     *conn
+	notify chan<- *Notification
+
+	// cmdMu is held while a command is in flight; the read loop sends its
+	// outcome on replies
+	cmdMu   sync.Mutex
+	replies chan error
+
+	// closing is closed by close, and done by the read loop once it ends,
+	// after setting err to why
+	closing chan struct{}
+	done    chan struct{}
+	err     error
+}
+
+func newListenerConn(ctx context.Context, name string, notify chan<- *Notification) (*listenerConn, error) {
+	c, err := open(ctx, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	lc := &listenerConn{
+		cn:      c.(*conn),
+		notify:  notify,
+		replies: make(chan error, 1),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go lc.readLoop()
+	return lc, nil
+}
+
+func (lc *listenerConn) readLoop() {
+	defer close(lc.done)
+	lc.err = lc.read()
+}
+
+// read receives messages until the connection fails or is closed.
+func (lc *listenerConn) read() (err error) {
+	defer errRecover(&err)
+
+	var cmdErr error
+	for {
+		t, r, err := lc.cn.recvMessage()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case message.NotificationResponse:
+			n := recvNotification(r)
+			select {
+			case lc.notify <- n:
+			case <-lc.closing:
+			}
+		case message.Notice:
+			lc.cn.hooks.notice(parseError(r))
+		case message.ParameterStatus:
+			lc.cn.processParameterStatus(r)
+		case message.Error:
+			cmdErr = parseError(r)
+		case message.CommandComplete, message.EmptyQueryResponse:
+			// ignore
+		case message.ReadyForQuery:
+			lc.cn.processReadyForQuery(r)
+			lc.replies <- cmdErr
+			cmdErr = nil
+		default:
+			lc.cn.c.Close()
+			return fmt.Errorf("pq: unexpected message %q on a listener connection", t)
+		}
+	}
+}
+
+// exec runs q and waits for its outcome.
+func (lc *listenerConn) exec(q string) error {
+	lc.cmdMu.Lock()
+	defer lc.cmdMu.Unlock()
+
+	// writeBuf rather than writeMessageType, which would touch the state
+	// of the read loop
+	b := lc.cn.writeBuf(byte(message.Query))
+	b.string(q)
+	if err := lc.send(b); err != nil {
+		return err
+	}
+	select {
+	case err := <-lc.replies:
+		return err
+	case <-lc.done:
+		return lc.err
+	}
+}
+
+func (lc *listenerConn) send(b *writeBuf) (err error) {
+	defer errRecover(&err)
+	lc.cn.send(b)
+	return nil
+}
+
+// close closes the connection and waits for the read loop to end.
+func (lc *listenerConn) close() {
+	close(lc.closing)
+	lc.cn.c.Close()
+	<-lc.done
+}
+
+// ListenerEventType is the kind of event a Listener reports to its
+// EventCallbackType.
+type ListenerEventType int
+
+const (
+	// ListenerEventConnected is reported once a Listener has first
+	// connected.
+	ListenerEventConnected ListenerEventType = iota
+	// ListenerEventDisconnected is reported when a Listener's connection
+	// fails, with the error that ended it.
+	ListenerEventDisconnected
+	// ListenerEventReconnected is reported once a Listener has connected
+	// again after losing its connection.  Notifications sent in between
+	// were missed.
+	ListenerEventReconnected
+	// ListenerEventConnectionAttemptFailed is reported, with the error,
+	// after each failed attempt to connect.
+	ListenerEventConnectionAttemptFailed
+)
+
+// EventCallbackType is a function a Listener calls as its connection
+// changes state.  It is called from the Listener's own goroutine, one event
+// at a time, and should return quickly.
+type EventCallbackType func(event ListenerEventType, err error)
+
+// Listener listens for notifications on a connection of its own.  If the
+// connection fails, it connects again, waiting minReconnectInterval before
+// the first attempt and twice as long after each failed one, up to
+// maxReconnectInterval, and listens to its channels again.
+type Listener struct {
+	// Notify receives the notifications sent to the channels listened to.
+	// A nil *Notification is sent once the Listener has connected again
+	// after losing its connection, since notifications sent in between were
+	// missed.  It is closed by Close.
+	//
+	// Notify should be read from all the time: Listen and Unlisten wait
+	// while the notifications that arrived before the server's reply are
+	// delivered.
+	Notify chan *Notification
+
+	name                 string
+	minReconnectInterval time.Duration
+	maxReconnectInterval time.Duration
+	eventCallback        EventCallbackType
+
+	// ctx is canceled by Close, to give up on connecting
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// mu guards the fields below.  It is held while a command runs on lc.
+	mu       sync.Mutex
+	lc       *listenerConn // nil while not connected
+	channels map[string]bool
+	closed   bool
+
+	// closing is closed by Close, and stopped once run has ended
+	closing chan struct{}
+	stopped chan struct{}
+}
+
+// NewListener returns a Listener that connects to the database with the
+// connection settings in name, as given to sql.Open.  eventCallback, if not
+// nil, is called as the Listener connects, loses its connection and fails to
+// connect.  minReconnectInterval must be positive, and maxReconnectInterval
+// no shorter.
+func NewListener(name string, minReconnectInterval, maxReconnectInterval time.Duration, eventCallback EventCallbackType) (*Listener, error) {
+	if minReconnectInterval <= 0 {
+		return nil, fmt.Errorf("pq: minReconnectInterval must be positive, not %v", minReconnectInterval)
+	}
+	if maxReconnectInterval < minReconnectInterval {
+		return nil, fmt.Errorf("pq: maxReconnectInterval %v is shorter than minReconnectInterval %v", maxReconnectInterval, minReconnectInterval)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &Listener{
+		Notify:               make(chan *Notification, 32),
+		name:                 name,
+		minReconnectInterval: minReconnectInterval,
+		maxReconnectInterval: maxReconnectInterval,
+		eventCallback:        eventCallback,
+		ctx:                  ctx,
+		cancel:               cancel,
+		channels:             make(map[string]bool),
+		closing:              make(chan struct{}),
+		stopped:              make(chan struct{}),
+	}
+	go l.run()
+	return l, nil
+}
+
+// Listen starts listening to channel.  If the Listener isn't connected at
+// the moment, it listens once it is.  An error from the server, such as for
+// an invalid channel name, is returned, and the channel isn't listened to.
+func (l *Listener) Listen(channel string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrListenerClosed
+	}
+	if l.channels[channel] {
+		return ErrChannelAlreadyOpen
+	}
+	if err := l.execLocked("LISTEN " + QuoteIdentifier(channel)); err != nil {
+		return err
+	}
+	l.channels[channel] = true
+	return nil
+}
+
+// Unlisten stops listening to channel.
+func (l *Listener) Unlisten(channel string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrListenerClosed
+	}
+	if !l.channels[channel] {
+		return ErrChannelNotOpen
+	}
+	if err := l.execLocked("UNLISTEN " + QuoteIdentifier(channel)); err != nil {
+		return err
+	}
+	delete(l.channels, channel)
+	return nil
+}
+
+// UnlistenAll stops listening to every channel.
+func (l *Listener) UnlistenAll() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrListenerClosed
+	}
+	if err := l.execLocked("UNLISTEN *"); err != nil {
+		return err
+	}
+	l.channels = make(map[string]bool)
+	return nil
+}
+
+// execLocked runs q on the connection, if there is one.  Only errors from
+// the server are returned: if the connection has failed, run connects
+// again and brings the channels listened to up to date.
+func (l *Listener) execLocked(q string) error {
+	if l.lc == nil {
+		return nil
+	}
+	err := l.lc.exec(q)
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return nil
+}
+
+// Close closes the Listener's connection, and Notify.  An attempt to connect
+// that is under way is given up on.
+func (l *Listener) Close() error {
+	// before taking mu, which connect holds while it listens
+	l.cancel()
+
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return ErrListenerClosed
+	}
+	l.closed = true
+	close(l.closing)
+	l.mu.Unlock()
+
+	<-l.stopped
+	return nil
+}
+
+// run keeps the Listener connected until it is closed.
+func (l *Listener) run() {
+	defer close(l.stopped)
+	defer close(l.Notify)
+
+	interval := l.minReconnectInterval
+	connected := false
+	for {
+		lc, err := l.connect()
+		if err != nil {
+			if l.ctx.Err() != nil {
+				// closing
+				return
+			}
+			l.event(ListenerEventConnectionAttemptFailed, err)
+			select {
+			case <-l.closing:
+				return
+			case <-time.After(interval):
+			}
+			if interval *= 2; interval > l.maxReconnectInterval {
+				interval = l.maxReconnectInterval
+			}
+			continue
+		}
+		interval = l.minReconnectInterval
+
+		if connected {
+			l.event(ListenerEventReconnected, nil)
+			select {
+			case l.Notify <- nil:
+			case <-l.closing:
+			}
+		} else {
+			l.event(ListenerEventConnected, nil)
+		}
+		connected = true
+
+		select {
+		case <-lc.done:
+			l.mu.Lock()
+			l.lc = nil
+			l.mu.Unlock()
+			if l.ctx.Err() != nil {
+				// closing
+				return
+			}
+			l.event(ListenerEventDisconnected, lc.err)
+			select {
+			case <-l.closing:
+				return
+			case <-time.After(interval):
+			}
+		case <-l.closing:
+			l.mu.Lock()
+			l.lc = nil
+			l.mu.Unlock()
+			lc.close()
+			return
+		}
+	}
+}
+
+// event reports event to the event callback, if there is one.
+func (l *Listener) event(event ListenerEventType, err error) {
+	if l.eventCallback != nil {
+		l.eventCallback(event, err)
+	}
+}
+
+// connect connects and listens to the channels.  Both are given up on once
+// Close cancels l.ctx.
+func (l *Listener) connect() (*listenerConn, error) {
+	lc, err := newListenerConn(l.ctx, l.name, l.Notify)
+	if err != nil {
+		return nil, err
+	}
+	listening := make(chan struct{})
+	defer close(listening)
+	go func() {
+		select {
+		case <-l.ctx.Done():
+			lc.cn.c.Close()
+		case <-listening:
+		}
+	}()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for channel := range l.channels {
+		if err := lc.exec("LISTEN " + QuoteIdentifier(channel)); err != nil {
+			lc.close()
+			return nil, err
+		}
+	}
+	l.lc = lc
+	return lc, nil
+}
@@ -0,0 +1,273 @@
+package pq
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecvNotification(t *testing.T) {
+	r := readBuf("\x00\x00\x04\xd2jobs\x0042\x00")
+	n := recvNotification(&r)
+	if n.BePid != 1234 || n.Channel != "jobs" || n.Extra != "42" {
+		t.Errorf("unexpected notification %+v", n)
+	}
+}
+
+// Does not access database, simply tests that recv1 hands notifications to
+// the handler
+func TestNotificationHandler(t *testing.T) {
+	cn := fakeConn(backendMessage('A', "\x00\x00\x00\x07jobs\x0042\x00")+backendMessage('Z', "I"), 0)
+
+	// without a handler, the notification is dropped
+	if typ, _ := cn.recv1(); typ != 'Z' {
+		t.Fatalf("expected ReadyForQuery, got %q", typ)
+	}
+
+	var got []*Notification
+	cn.SetNotificationHandler(func(n *Notification) { got = append(got, n) })
+	if typ, _ := cn.recv1(); typ != 'Z' {
+		t.Fatalf("expected ReadyForQuery, got %q", typ)
+	}
+	if len(got) != 1 || got[0].BePid != 7 || got[0].Channel != "jobs" || got[0].Extra != "42" {
+		t.Errorf("unexpected notifications %+v", got)
+	}
+}
+
+// Does not access database, simply tests the read loop of a listener
+// connection against a fake server
+func TestListenerConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	notify := make(chan *Notification, 1)
+	lc := &listenerConn{
+		cn:      &conn{c: client, buf: bufio.NewReader(client)},
+		notify:  notify,
+		replies: make(chan error, 1),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go lc.readLoop()
+
+	go func() {
+		// a notification, then the replies to LISTEN and to a bad LISTEN
+		server.Write([]byte(backendMessage('A', "\x00\x00\x00\x07jobs\x00\x00")))
+		buf := make([]byte, 64)
+		server.Read(buf)
+		server.Write([]byte(backendMessage('C', "LISTEN\x00") + backendMessage('Z', "I")))
+		server.Read(buf)
+		server.Write([]byte(errorResponse("42601", "") + backendMessage('Z', "I")))
+	}()
+
+	select {
+	case n := <-notify:
+		if n.BePid != 7 || n.Channel != "jobs" || n.Extra != "" {
+			t.Errorf("unexpected notification %+v", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification")
+	}
+	if err := lc.exec("LISTEN jobs"); err != nil {
+		t.Fatal(err)
+	}
+	err := lc.exec("LISTEN")
+	if pqErr, ok := err.(*Error); !ok || pqErr.Code != "42601" {
+		t.Errorf("expected a syntax error, got %v", err)
+	}
+
+	lc.close()
+	if lc.exec("LISTEN jobs") == nil {
+		t.Error("expected an error on a closed connection")
+	}
+}
+
+// Does not access database, simply tests closing a Listener that can't
+// connect
+func TestListenerUnreachable(t *testing.T) {
+	var mu sync.Mutex
+	var failures int
+	l, err := NewListener("host=127.0.0.1 port=1", time.Millisecond, 10*time.Millisecond, func(event ListenerEventType, err error) {
+		if event != ListenerEventConnectionAttemptFailed || err == nil {
+			t.Errorf("unexpected event %d, %v", event, err)
+		}
+		mu.Lock()
+		failures++
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Listen("pq_test"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if failures == 0 {
+		t.Error("expected failed connection attempts to be reported")
+	}
+	mu.Unlock()
+	if err := l.Close(); err != ErrListenerClosed {
+		t.Errorf("expected ErrListenerClosed, got %v", err)
+	}
+	if _, ok := <-l.Notify; ok {
+		t.Error("expected Notify to be closed")
+	}
+}
+
+// Does not access database, simply tests closing a Listener while it waits
+// for a server that never answers
+func TestListenerCloseWhileConnecting(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	l, err := NewListener("host=127.0.0.1 sslmode=disable user=u port="+port, time.Millisecond, 10*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case c := <-accepted:
+		defer c.Close()
+	case <-time.After(10 * time.Second):
+		t.Fatal("did not connect")
+	}
+
+	closed := make(chan error)
+	go func() { closed <- l.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Close did not stop the connection attempt")
+	}
+}
+
+// Does not access database, simply tests the reconnect intervals
+func TestNewListenerIntervals(t *testing.T) {
+	for _, tt := range []struct{ min, max time.Duration }{
+		{0, time.Second},
+		{-time.Second, time.Second},
+		{time.Second, time.Millisecond},
+	} {
+		if _, err := NewListener("host=127.0.0.1 port=1", tt.min, tt.max, nil); err == nil {
+			t.Errorf("%v, %v: expected an error", tt.min, tt.max)
+		}
+	}
+}
+
+func TestListener(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	events := make(chan ListenerEventType, 10)
+	l, err := NewListener("user=pqgotest password=pqgotest", 10*time.Millisecond, time.Second, func(event ListenerEventType, err error) {
+		if event != ListenerEventConnectionAttemptFailed {
+			events <- event
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// listenerPID waits for the Listener to be connected and returns the
+	// process ID of its backend
+	listenerPID := func() int {
+		t.Helper()
+		for i := 0; i < 1000; i++ {
+			l.mu.Lock()
+			lc := l.lc
+			l.mu.Unlock()
+			if lc != nil {
+				return lc.cn.processID
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("did not connect")
+		return 0
+	}
+	listenerPID()
+
+	if err := l.Listen("pq_test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Listen("pq_test"); err != ErrChannelAlreadyOpen {
+		t.Errorf("expected ErrChannelAlreadyOpen, got %v", err)
+	}
+
+	expectNotification := func(extra string) {
+		t.Helper()
+		if _, err := db.Exec("SELECT pg_notify('pq_test', $1)", extra); err != nil {
+			t.Fatal(err)
+		}
+		for {
+			select {
+			case n := <-l.Notify:
+				if n != nil {
+					if n.Channel != "pq_test" || n.Extra != extra {
+						t.Errorf("unexpected notification %+v", n)
+					}
+					return
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("no notification")
+			}
+		}
+	}
+	expectNotification("first")
+
+	// once its backend is terminated, the Listener connects again, sends a
+	// nil notification and listens to the channel again
+	if _, err := db.Exec("SELECT pg_terminate_backend($1)", listenerPID()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case n := <-l.Notify:
+		if n != nil {
+			t.Fatalf("expected nil, got %+v", n)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("did not reconnect")
+	}
+	for _, expected := range []ListenerEventType{ListenerEventConnected, ListenerEventDisconnected, ListenerEventReconnected} {
+		if event := <-events; event != expected {
+			t.Errorf("expected event %d, got %d", expected, event)
+		}
+	}
+	expectNotification("second")
+
+	if err := l.Unlisten("pq_test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Unlisten("pq_test"); err != ErrChannelNotOpen {
+		t.Errorf("expected ErrChannelNotOpen, got %v", err)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-l.Notify; ok {
+		t.Error("expected Notify to be closed")
+	}
+	if err := l.Listen("pq_test"); err != ErrListenerClosed {
+		t.Errorf("expected ErrListenerClosed, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package pq
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Numeric is a numeric value kept as its decimal text, such as "-12.340", so
+// that none of its digits are lost, as they would be in a float64.  It can
+// also be "NaN", and from Postgres 14 on "Infinity" or "-Infinity".
+//
+// Numeric implements the sql.Scanner interface, taking a numeric or any
+// other number, and the driver.Valuer interface, sending its text.  A
+// big.Rat r can be passed as Numeric(r.FloatString(scale)).
+type Numeric string
+
+// Scan implements the sql.Scanner interface.  NULL can't be scanned into a
+// Numeric; use a NullNumeric.
+func (n *Numeric) Scan(src interface{}) error {
+	var text string
+	switch src := src.(type) {
+	case []byte:
+		text = string(src)
+	case string:
+		text = src
+	case int64:
+		*n = Numeric(strconv.FormatInt(src, 10))
+		return nil
+	case float64:
+		*n = Numeric(formatFloat(src))
+		return nil
+	case nil:
+		return fmt.Errorf("pq: cannot scan NULL into a Numeric; use a NullNumeric")
+	default:
+		return fmt.Errorf("pq: cannot convert %T to a Numeric", src)
+	}
+	if !isNumericText(text) {
+		return fmt.Errorf("pq: cannot scan %q into a Numeric", text)
+	}
+	*n = Numeric(text)
+	return nil
+}
+
+// Value implements the driver.Valuer interface.
+func (n Numeric) Value() (driver.Value, error) {
+	if !isNumericText(string(n)) {
+		return nil, fmt.Errorf("pq: invalid numeric %q", string(n))
+	}
+	return string(n), nil
+}
+
+// Rat returns n as a big.Rat, exactly.  NaN and the infinities have none.
+func (n Numeric) Rat() (*big.Rat, error) {
+	if !isDecimalText(string(n)) {
+		return nil, fmt.Errorf("pq: cannot convert numeric %q to a big.Rat", string(n))
+	}
+	r, ok := new(big.Rat).SetString(string(n))
+	if !ok {
+		return nil, fmt.Errorf("pq: cannot convert numeric %q to a big.Rat", string(n))
+	}
+	return r, nil
+}
+
+// Float64 returns the float64 nearest to n, which is an infinity or zero
+// for values beyond the range of a float64.
+func (n Numeric) Float64() (float64, error) {
+	if !isNumericText(string(n)) {
+		return 0, fmt.Errorf("pq: invalid numeric %q", string(n))
+	}
+	// the text is valid, so the only error is for a value out of range
+	f, _ := strconv.ParseFloat(string(n), 64)
+	return f, nil
+}
+
+// NullNumeric represents a Numeric that may be null. NullNumeric implements
+// the sql.Scanner interface so it can be used as a scan destination, similar
+// to sql.NullString.
+type NullNumeric struct {
+	Numeric Numeric
+	Valid   bool // Valid is true if Numeric is not NULL
+}
+
+// Scan implements the sql.Scanner interface.
+func (nn *NullNumeric) Scan(src interface{}) error {
+	if src == nil {
+		nn.Numeric, nn.Valid = "", false
+		return nil
+	}
+	nn.Valid = true
+	return nn.Numeric.Scan(src)
+}
+
+// Value implements the driver.Valuer interface.
+func (nn NullNumeric) Value() (driver.Value, error) {
+	if !nn.Valid {
+		return nil, nil
+	}
+	return nn.Numeric.Value()
+}
+
+// isNumericText reports whether s is the text of a numeric, as the server
+// accepts it: a decimal number, optionally with an exponent, or NaN or an
+// infinity, without surrounding spaces.
+func isNumericText(s string) bool {
+	switch strings.ToLower(s) {
+	case "nan", "infinity", "+infinity", "-infinity", "inf", "+inf", "-inf":
+		return true
+	}
+	return isDecimalText(s)
+}
+
+// isDecimalText reports whether s is a decimal number, such as -1.5 or
+// .25e3.
+func isDecimalText(s string) bool {
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		s = s[1:]
+	}
+	digits := 0
+	for s != "" && s[0] >= '0' && s[0] <= '9' {
+		s, digits = s[1:], digits+1
+	}
+	if s != "" && s[0] == '.' {
+		s = s[1:]
+		for s != "" && s[0] >= '0' && s[0] <= '9' {
+			s, digits = s[1:], digits+1
+		}
+	}
+	if digits == 0 {
+		return false
+	}
+	if s != "" && (s[0] == 'e' || s[0] == 'E') {
+		s = s[1:]
+		if s != "" && (s[0] == '+' || s[0] == '-') {
+			s = s[1:]
+		}
+		if s == "" {
+			return false
+		}
+		for s != "" && s[0] >= '0' && s[0] <= '9' {
+			s = s[1:]
+		}
+	}
+	return s == ""
+}
@@ -0,0 +1,127 @@
+package pq
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNumericScan(t *testing.T) {
+	tests := []struct {
+		src      interface{}
+		expected Numeric
+	}{
+		{[]byte("123456789012345678901234567890.123456789"), "123456789012345678901234567890.123456789"},
+		{[]byte("-12.340"), "-12.340"},
+		{"NaN", "NaN"},
+		{[]byte("-Infinity"), "-Infinity"},
+		{int64(-7), "-7"},
+		{1.5, "1.5"},
+	}
+	for _, tt := range tests {
+		var n Numeric
+		if err := n.Scan(tt.src); err != nil {
+			t.Errorf("%v: %v", tt.src, err)
+			continue
+		}
+		if n != tt.expected {
+			t.Errorf("%v: expected %s, got %s", tt.src, tt.expected, n)
+		}
+	}
+
+	var n Numeric
+	for _, src := range []interface{}{nil, []byte("abc"), []byte("1.2.3"), []byte(" 1"), true} {
+		if err := n.Scan(src); err == nil {
+			t.Errorf("%v: expected an error", src)
+		}
+	}
+}
+
+func TestNumericValue(t *testing.T) {
+	for _, s := range []string{"0", "-1.5", ".5", "1e-3", "+2E+10", "nan", "Infinity", "-inf"} {
+		v, err := Numeric(s).Value()
+		if err != nil || v != s {
+			t.Errorf("%s: unexpected result %v, %v", s, v, err)
+		}
+	}
+	for _, s := range []string{"", "-", ".", "1e", "e5", "1/2", "0x10", "1,5", "-nan"} {
+		if _, err := Numeric(s).Value(); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestNumericRat(t *testing.T) {
+	r, err := Numeric("-0.125").Rat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.String() != "-1/8" {
+		t.Errorf("expected -1/8, got %v", r)
+	}
+	if r, err = Numeric("1.5e3").Rat(); err != nil || r.String() != "1500/1" {
+		t.Errorf("unexpected result %v, %v", r, err)
+	}
+	for _, s := range []Numeric{"NaN", "Infinity", "abc"} {
+		if _, err := s.Rat(); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
+func TestNumericFloat64(t *testing.T) {
+	tests := []struct {
+		in       Numeric
+		expected float64
+	}{
+		{"1.25", 1.25},
+		{"-Infinity", math.Inf(-1)},
+		{"1e400", math.Inf(1)},
+	}
+	for _, tt := range tests {
+		f, err := tt.in.Float64()
+		if err != nil || f != tt.expected {
+			t.Errorf("%s: unexpected result %v, %v", tt.in, f, err)
+		}
+	}
+	if f, err := Numeric("NaN").Float64(); err != nil || !math.IsNaN(f) {
+		t.Errorf("unexpected result %v, %v", f, err)
+	}
+	if _, err := Numeric("abc").Float64(); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestNullNumeric(t *testing.T) {
+	nn := NullNumeric{Numeric: "1", Valid: true}
+	if err := nn.Scan(nil); err != nil || nn.Valid || nn.Numeric != "" {
+		t.Errorf("unexpected result %+v, %v", nn, err)
+	}
+	if v, err := nn.Value(); v != nil || err != nil {
+		t.Errorf("unexpected value %v, %v", v, err)
+	}
+	if err := nn.Scan([]byte("2.50")); err != nil || !nn.Valid || nn.Numeric != "2.50" {
+		t.Errorf("unexpected result %+v, %v", nn, err)
+	}
+	if v, err := nn.Value(); v != "2.50" || err != nil {
+		t.Errorf("unexpected value %v, %v", v, err)
+	}
+}
+
+func TestNumericRoundtrip(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	in := Numeric("123456789012345678901234567890.000000000000000000001")
+	var out Numeric
+	var null NullNumeric
+	err := db.QueryRow("SELECT $1::numeric, $2::numeric", in, NullNumeric{}).Scan(&out, &null)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != in {
+		t.Errorf("expected %s, got %s", in, out)
+	}
+	if null.Valid {
+		t.Errorf("expected NULL, got %s", null.Numeric)
+	}
+}
@@ -156,6 +156,8 @@ const (
 	T__regconfig       Oid = 3735
 	T_regdictionary    Oid = 3769
 	T__regdictionary   Oid = 3770
+	T_jsonb            Oid = 3802
+	T__jsonb           Oid = 3807
 	T_anyrange         Oid = 3831
 	T_int4range        Oid = 3904
 	T__int4range       Oid = 3905
@@ -195,7 +197,8 @@ var elementType = make(map[Oid]Oid)
 var category = make(map[Oid]Category)
 var goTypes = make(map[Oid]reflect.Type)
 
-// GetArrayElementDelimiter gets the delimiter between array elements for the element type.
+// Delimiter gets the delimiter between array elements for the element type,
+// i.e. the typdelim of this type in pg_type.
 func (typ Oid) Delimiter() byte {
 	if typ == T_box {
 		return ';'
@@ -204,6 +207,14 @@ func (typ Oid) Delimiter() byte {
 	return ','
 }
 
+// IsBuiltin reports whether typ is one of the built-in types enumerated in
+// this package.  Other types, such as enums and the types of extensions,
+// get their OIDs when they are created.
+func (typ Oid) IsBuiltin() bool {
+	_, ok := category[typ]
+	return ok
+}
+
 func (typ Oid) IsArray() bool {
 	return category[typ] == C_array
 }
@@ -241,7 +252,23 @@ func init() {
 	goTypes[T_float8] = reflect.TypeOf(*new(float64))
 	goTypes[T_varchar] = reflect.TypeOf(*new(string))
 	goTypes[T_char] = reflect.TypeOf(*new(string))
+	goTypes[T_name] = reflect.TypeOf(*new(string))
 	goTypes[T_text] = reflect.TypeOf(*new(string))
+	// json and jsonb array elements are the JSON text of each value
+	goTypes[T_json] = reflect.TypeOf(*new(string))
+	goTypes[T_jsonb] = reflect.TypeOf(*new(string))
+	// numeric and money array elements keep their text, every digit and
+	// the currency symbol with it
+	goTypes[T_numeric] = reflect.TypeOf(*new(string))
+	goTypes[T_money] = reflect.TypeOf(*new(string))
+	goTypes[T_uuid] = reflect.TypeOf(*new(string))
+	goTypes[T_point] = reflect.TypeOf(*new([]float64))
+	goTypes[T_lseg] = reflect.TypeOf(*new([]float64))
+	goTypes[T_line] = reflect.TypeOf(*new([]float64))
+	goTypes[T_box] = reflect.TypeOf(*new([]float64))
+	goTypes[T_circle] = reflect.TypeOf(*new([]float64))
+	goTypes[T_path] = reflect.TypeOf(*new([]float64))
+	goTypes[T_polygon] = reflect.TypeOf(*new([]float64))
 
 	// anything else ends up as a []byte
 
@@ -306,6 +333,7 @@ func init() {
 	ArrayType[T_gtsvector] = T__gtsvector
 	ArrayType[T_regconfig] = T__regconfig
 	ArrayType[T_regdictionary] = T__regdictionary
+	ArrayType[T_jsonb] = T__jsonb
 	ArrayType[T_int4range] = T__int4range
 	ArrayType[T_numrange] = T__numrange
 	ArrayType[T_tsrange] = T__tsrange
@@ -381,6 +409,7 @@ func init() {
 	elementType[T__tsquery] = T_tsquery
 	elementType[T__regconfig] = T_regconfig
 	elementType[T__regdictionary] = T_regdictionary
+	elementType[T__jsonb] = T_jsonb
 	elementType[T__int4range] = T_int4range
 	elementType[T__numrange] = T_numrange
 	elementType[T__tsrange] = T_tsrange
@@ -530,6 +559,8 @@ func init() {
 	category[T__regconfig] = 'A'
 	category[T_regdictionary] = 'N'
 	category[T__regdictionary] = 'A'
+	category[T_jsonb] = 'U'
+	category[T__jsonb] = 'A'
 	category[T_anyrange] = 'P'
 	category[T_int4range] = 'R'
 	category[T__int4range] = 'A'
@@ -1,8 +1,12 @@
 package pq
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"unicode"
 )
@@ -126,3 +130,72 @@ func parseOpts(name string, o values) error {
 	}
 	return nil
 }
+
+// serviceOptions returns the settings of a connection service, from the
+// first service file that defines it: the one PGSERVICEFILE names, or else
+// ~/.pg_service.conf, and then pg_service.conf in PGSYSCONFDIR, as libpq
+// looks for them.  Files that don't exist are skipped.
+func serviceOptions(service string) (values, error) {
+	var files []string
+	if file := os.Getenv("PGSERVICEFILE"); file != "" {
+		files = append(files, file)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".pg_service.conf"))
+	}
+	if dir := os.Getenv("PGSYSCONFDIR"); dir != "" {
+		files = append(files, filepath.Join(dir, "pg_service.conf"))
+	}
+
+	for _, file := range files {
+		f, err := os.Open(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		o, err := readService(f, file, service)
+		f.Close()
+		if err != nil || o != nil {
+			return o, err
+		}
+	}
+	return nil, fmt.Errorf("pq: definition of service %q not found", service)
+}
+
+// readService reads the settings of service from a service file, which has
+// a section for each service, headed by its name in brackets, of key=value
+// lines; lines starting with # are comments.  It returns nil if the file
+// doesn't define service.
+func readService(r io.Reader, file, service string) (values, error) {
+	var o values
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "" || line[0] == '#':
+		case line[0] == '[':
+			if o != nil {
+				return o, nil
+			}
+			if line == "["+service+"]" {
+				o = make(values)
+			}
+		case o == nil:
+		default:
+			i := strings.IndexByte(line, '=')
+			if i < 0 {
+				return nil, fmt.Errorf("pq: syntax error in service file %q, line %d", file, n)
+			}
+			key := strings.TrimSpace(line[:i])
+			if key == "service" {
+				return nil, fmt.Errorf("pq: nested service specifications not supported in service file %q, line %d", file, n)
+			}
+			o.Set(key, strings.TrimSpace(line[i+1:]))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
@@ -0,0 +1,179 @@
+package pq
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Error codes of failures that are expected under SERIALIZABLE isolation and
+// that can be resolved by running the transaction again.
+const (
+	ErrCodeSerializationFailure ErrorCode = "40001"
+	ErrCodeDeadlockDetected     ErrorCode = "40P01"
+)
+
+// IsSerializationFailure reports whether err is a serialization failure or a
+// deadlock, either of which means the transaction should be retried.
+func IsSerializationFailure(err error) bool {
+	e := asError(err)
+	return e != nil && (e.Code == ErrCodeSerializationFailure || e.Code == ErrCodeDeadlockDetected)
+}
+
+// RunInTx runs fn in a transaction begun with opts, and commits it if fn
+// returns nil.  If fn or the commit fails with a serialization failure, the
+// transaction is rolled back and run again, up to maxRetries more times,
+// waiting a little longer before each attempt.  Any other error from fn rolls
+// back the transaction and is returned as is.
+//
+// fn may be called more than once, so it should not have side effects
+// outside the transaction.
+func RunInTx(db *sql.DB, opts *sql.TxOptions, fn func(*sql.Tx) error, maxRetries int) (err error) {
+	backoff := 10 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err = runTx(db, opts, fn)
+		if err == nil || !IsSerializationFailure(err) || attempt >= maxRetries {
+			return err
+		}
+
+		time.Sleep(backoff)
+		if backoff < time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func runTx(db *sql.DB, opts *sql.TxOptions, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// RetryQuery runs a read-only query like db.QueryContext does, but if the
+// query fails because the connection failed, it is run again on another
+// connection, up to maxRetries more times, waiting a little longer before
+// each attempt.  database/sql only retries a query itself when it knows
+// nothing was sent; RetryQuery also retries one whose connection was lost
+// while the server may have been running it, which is only safe because it
+// reads and nothing else.  Failures once the rows have started to arrive
+// are not retried.
+//
+// To hold to that, RetryQuery refuses statements other than SELECT, VALUES,
+// TABLE and SHOW, and ones that mention INSERT, UPDATE, DELETE, MERGE or
+// INTO, such as a WITH of a data-modifying statement or a SELECT INTO.  It
+// can't see into the functions a query calls, though: a query calling a
+// function that has side effects, such as nextval, must not be run with
+// RetryQuery.
+//
+// The connection-level errors are driver.ErrBadConn, network errors, an
+// unexpected end of the connection, and *pq.Errors of class 08 (connection
+// exception) or of the server shutting down.
+func RetryQuery(db *sql.DB, ctx context.Context, maxRetries int, query string, args ...interface{}) (*sql.Rows, error) {
+	if !isReadOnlyQuery(query) {
+		return nil, fmt.Errorf("pq: RetryQuery only runs read-only SELECT, VALUES, TABLE and SHOW statements")
+	}
+
+	backoff := 10 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err == nil || !isConnectionError(err) || attempt >= maxRetries {
+			return rows, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		if backoff < time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// isConnectionError reports whether err means that the connection failed,
+// rather than the statement.
+func isConnectionError(err error) bool {
+	switch err {
+	case driver.ErrBadConn, io.EOF, io.ErrUnexpectedEOF:
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	e := asError(err)
+	if e == nil {
+		return false
+	}
+	switch e.Code {
+	case "57P01", "57P02", "57P03":
+		// admin_shutdown, crash_shutdown, cannot_connect_now
+		return true
+	}
+	return strings.HasPrefix(string(e.Code), "08")
+}
+
+// readOnlyKeywords are the keywords a statement run by RetryQuery may start
+// with, and writeKeywords those it may not mention anywhere.
+var (
+	readOnlyKeywords = map[string]bool{"SELECT": true, "VALUES": true, "TABLE": true, "SHOW": true, "WITH": true}
+	writeKeywords    = map[string]bool{"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "INTO": true}
+)
+
+// isReadOnlyQuery reports whether q is a single statement that only reads,
+// going by its keywords.  Strings, quoted identifiers and comments are
+// skipped.
+func isReadOnlyQuery(q string) bool {
+	first := true
+	for i := 0; i < len(q); {
+		c := q[i]
+		switch {
+		case c == '\'':
+			i = skipQuoted(q, i, isEscapeString(q, i))
+		case c == '"':
+			i = skipQuoted(q, i, false)
+		case c == '-' && strings.HasPrefix(q[i:], "--"):
+			j := strings.IndexByte(q[i:], '\n')
+			if j < 0 {
+				return !first
+			}
+			i += j
+		case c == '/' && strings.HasPrefix(q[i:], "/*"):
+			i = skipBlockComment(q, i)
+		case c == '$' && !isIdentChar(q, i-1):
+			i = skipDollarQuoted(q, i)
+		case c == ';':
+			// a second statement could be anything
+			if strings.TrimSpace(q[i+1:]) != "" {
+				return false
+			}
+			i++
+		case isIdentChar(q, i) && !isIdentChar(q, i-1):
+			j := i
+			for j < len(q) && isIdentChar(q, j) {
+				j++
+			}
+			word := strings.ToUpper(q[i:j])
+			if first && !readOnlyKeywords[word] || writeKeywords[word] {
+				return false
+			}
+			first = false
+			i = j
+		default:
+			i++
+		}
+	}
+	return !first
+}
@@ -0,0 +1,161 @@
+package pq
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestIsSerializationFailure(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{&Error{Code: "40001"}, true},
+		{&Error{Code: "40P01"}, true},
+		{Error{Code: "40001"}, true},
+		{&Error{Code: "23505"}, false},
+		{errors.New("40001"), false},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := IsSerializationFailure(tt.err); got != tt.expected {
+			t.Errorf("IsSerializationFailure(%#v) = %v, expected %v", tt.err, got, tt.expected)
+		}
+	}
+}
+
+func TestRunInTx(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	attempts := 0
+	err := RunInTx(db, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(tx *sql.Tx) error {
+		attempts++
+		if attempts < 3 {
+			return &Error{Code: ErrCodeSerializationFailure}
+		}
+		var level string
+		if err := tx.QueryRow("SHOW transaction_isolation").Scan(&level); err != nil {
+			return err
+		}
+		if level != "serializable" {
+			t.Errorf("Expected a serializable transaction, got %s", level)
+		}
+		return nil
+	}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+
+	// other errors are not retried, and neither is the last failure
+	for _, fail := range []error{errors.New("boom"), &Error{Code: ErrCodeDeadlockDetected}} {
+		attempts = 0
+		err = RunInTx(db, nil, func(tx *sql.Tx) error {
+			attempts++
+			return fail
+		}, 1)
+		if err != fail {
+			t.Errorf("Expected %v, got %v", fail, err)
+		}
+		if expected := map[bool]int{false: 1, true: 2}[IsSerializationFailure(fail)]; attempts != expected {
+			t.Errorf("Expected %d attempts for %v, got %d", expected, fail, attempts)
+		}
+	}
+}
+
+func TestIsReadOnlyQuery(t *testing.T) {
+	tests := []struct {
+		q        string
+		expected bool
+	}{
+		{"SELECT 1", true},
+		{"  select * from users where id = $1;", true},
+		{"(SELECT 1) UNION (SELECT 2)", true},
+		{"VALUES (1), (2)", true},
+		{"TABLE users", true},
+		{"SHOW TimeZone", true},
+		{"WITH t AS (SELECT 1) SELECT * FROM t", true},
+		{"SELECT 'delete', \"update\", $$insert$$ -- into\n/* merge */", true},
+		{"SELECT updated_at FROM users", true},
+		{"INSERT INTO users VALUES (1)", false},
+		{"WITH d AS (DELETE FROM users RETURNING *) SELECT * FROM d", false},
+		{"SELECT * INTO copy FROM users", false},
+		{"SELECT * FROM users FOR UPDATE", false},
+		{"SELECT 1; DROP TABLE users", false},
+		{"CALL p()", false},
+		{"-- SELECT", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isReadOnlyQuery(tt.q); got != tt.expected {
+			t.Errorf("%q: expected %v, got %v", tt.q, tt.expected, got)
+		}
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{driver.ErrBadConn, true},
+		{io.ErrUnexpectedEOF, true},
+		{&net.OpError{Op: "read", Err: errors.New("connection reset")}, true},
+		{&Error{Code: "08006"}, true},
+		{&Error{Code: "57P01"}, true},
+		{&Error{Code: "23505"}, false},
+		{errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		if got := isConnectionError(tt.err); got != tt.expected {
+			t.Errorf("%v: expected %v, got %v", tt.err, tt.expected, got)
+		}
+	}
+}
+
+func TestRetryQuery(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+	if _, err := RetryQuery(db, ctx, 3, "DELETE FROM users"); err == nil {
+		t.Error("Expected a data-modifying statement to be refused")
+	}
+
+	var pid int
+	if err := db.QueryRow("SELECT pg_backend_pid()").Scan(&pid); err != nil {
+		t.Fatal(err)
+	}
+	other := openTestConn(t)
+	defer other.Close()
+	if _, err := other.Exec("SELECT pg_terminate_backend($1)", pid); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pooled connection is gone; the query runs on a new one
+	rows, err := RetryQuery(db, ctx, 3, "SELECT pg_backend_pid()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var newPid int
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+	if err := rows.Scan(&newPid); err != nil {
+		t.Fatal(err)
+	}
+	if newPid == pid {
+		t.Error("Expected the query to run on a new connection")
+	}
+}
@@ -0,0 +1,41 @@
+package pq
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+)
+
+// QueryScalars reads a single-column result into the slice that dest points
+// to, appending each value to it.  The values are converted just as
+// rows.Scan would convert them into a variable of the slice's element type,
+// so, for example, *[]int64 and *[]sql.NullString both work.  It returns an
+// error if the result has more than one column.  rows is closed once it has
+// been read, or on error.
+func QueryScalars(rows *sql.Rows, dest interface{}) error {
+	defer rows.Close()
+
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("pq: QueryScalars needs a pointer to a slice, not %T", dest)
+	}
+	slice := v.Elem()
+	elemType := slice.Type().Elem()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if len(cols) != 1 {
+		return fmt.Errorf("pq: QueryScalars needs a single-column result, got %d columns", len(cols))
+	}
+
+	for rows.Next() {
+		elem := reflect.New(elemType)
+		if err := rows.Scan(elem.Interface()); err != nil {
+			return err
+		}
+		slice.Set(reflect.Append(slice, elem.Elem()))
+	}
+	return rows.Err()
+}
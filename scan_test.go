@@ -0,0 +1,52 @@
+package pq
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestQueryScalars(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	rows, err := db.Query("SELECT generate_series(1, 3)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	if err := QueryScalars(rows, &ids); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 2, 3}) {
+		t.Errorf("expected [1 2 3], got %v", ids)
+	}
+
+	rows, err = db.Query("SELECT 'a' UNION ALL SELECT NULL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []sql.NullString{{String: "first", Valid: true}}
+	if err := QueryScalars(rows, &names); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 3 || names[1].String != "a" || names[2].Valid {
+		t.Errorf("unexpected result %v", names)
+	}
+
+	rows, err = db.Query("SELECT 1, 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := QueryScalars(rows, &ids); err == nil {
+		t.Error("expected an error for a two-column result")
+	}
+
+	rows, err = db.Query("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := QueryScalars(rows, ids); err == nil {
+		t.Error("expected an error for a non-pointer destination")
+	}
+}
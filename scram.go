@@ -0,0 +1,195 @@
+package pq
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+const (
+	scramSHA256     = "SCRAM-SHA-256"
+	scramSHA256Plus = "SCRAM-SHA-256-PLUS"
+)
+
+// scramClient runs the client side of a SCRAM-SHA-256 exchange (RFC 5802,
+// RFC 7677), with tls-server-end-point channel binding (RFC 5929) when it
+// has binding data.
+type scramClient struct {
+	user     string
+	password string
+	nonce    string
+
+	// gs2Header is the GS2 header of the client-first message, whose flag
+	// tells the server whether the client binds to the channel.
+	gs2Header string
+	cbindData []byte
+
+	clientFirstBare string
+	serverSignature []byte
+}
+
+// newSCRAMClient picks the mechanism to use from those the server offered.
+// SCRAM-SHA-256-PLUS is used when the connection is over TLS and the server
+// offers it; otherwise SCRAM-SHA-256 is used, telling the server whether
+// the client could have bound to the channel so it can detect a downgrade.
+func newSCRAMClient(mechanisms []string, password string, tlsConn *tls.Conn) (*scramClient, string, error) {
+	var plain, plus bool
+	for _, m := range mechanisms {
+		switch m {
+		case scramSHA256:
+			plain = true
+		case scramSHA256Plus:
+			plus = true
+		}
+	}
+
+	sc := &scramClient{password: password, gs2Header: "n,,"}
+	if tlsConn != nil {
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			sc.cbindData = tlsServerEndPoint(certs[0])
+		}
+	}
+
+	switch {
+	case plus && sc.cbindData != nil:
+		sc.gs2Header = "p=tls-server-end-point,,"
+		return sc, scramSHA256Plus, nil
+	case plain:
+		sc.cbindData = nil
+		if tlsConn != nil {
+			sc.gs2Header = "y,,"
+		}
+		return sc, scramSHA256, nil
+	}
+	return nil, "", fmt.Errorf("pq: no supported SASL mechanism in %v", mechanisms)
+}
+
+// tlsServerEndPoint returns the tls-server-end-point channel binding data
+// for the server's certificate: its hash, using the hash function of its
+// signature algorithm, or SHA-256 in place of MD5 and SHA-1.
+func tlsServerEndPoint(cert *x509.Certificate) []byte {
+	var h hash.Hash
+	switch cert.SignatureAlgorithm {
+	case x509.SHA384WithRSA, x509.SHA384WithRSAPSS, x509.ECDSAWithSHA384:
+		h = sha512.New384()
+	case x509.SHA512WithRSA, x509.SHA512WithRSAPSS, x509.ECDSAWithSHA512:
+		h = sha512.New()
+	default:
+		h = sha256.New()
+	}
+	h.Write(cert.Raw)
+	return h.Sum(nil)
+}
+
+// clientFirst returns the client-first message.
+func (sc *scramClient) clientFirst() ([]byte, error) {
+	if sc.nonce == "" {
+		b := make([]byte, 18)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		sc.nonce = base64.StdEncoding.EncodeToString(b)
+	}
+	sc.clientFirstBare = "n=" + sc.user + ",r=" + sc.nonce
+	return []byte(sc.gs2Header + sc.clientFirstBare), nil
+}
+
+// clientFinal returns the client-final message, with the proof of the
+// password, in answer to the server-first message.
+func (sc *scramClient) clientFinal(serverFirst []byte) ([]byte, error) {
+	var nonce, salt string
+	var iterations int
+	for _, attr := range strings.Split(string(serverFirst), ",") {
+		if len(attr) < 2 || attr[1] != '=' {
+			return nil, fmt.Errorf("pq: invalid SCRAM server-first message %q", serverFirst)
+		}
+		switch v := attr[2:]; attr[0] {
+		case 'r':
+			nonce = v
+		case 's':
+			salt = v
+		case 'i':
+			iterations, _ = strconv.Atoi(v)
+		}
+	}
+	if !strings.HasPrefix(nonce, sc.nonce) || len(nonce) == len(sc.nonce) {
+		return nil, fmt.Errorf("pq: invalid SCRAM server nonce %q", nonce)
+	}
+	saltBytes, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil || iterations < 1 {
+		return nil, fmt.Errorf("pq: invalid SCRAM server-first message %q", serverFirst)
+	}
+
+	cbind := append([]byte(sc.gs2Header), sc.cbindData...)
+	withoutProof := "c=" + base64.StdEncoding.EncodeToString(cbind) + ",r=" + nonce
+	authMessage := []byte(sc.clientFirstBare + "," + string(serverFirst) + "," + withoutProof)
+
+	saltedPassword := scramHi([]byte(sc.password), saltBytes, iterations)
+	clientKey := scramHMAC(saltedPassword, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	proof := scramHMAC(storedKey[:], authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	sc.serverSignature = scramHMAC(scramHMAC(saltedPassword, []byte("Server Key")), authMessage)
+
+	return []byte(withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+// verifyServerFinal checks the server's signature in the server-final
+// message, which proves that the server knows the password too.
+func (sc *scramClient) verifyServerFinal(serverFinal []byte) error {
+	s := string(serverFinal)
+	if strings.HasPrefix(s, "e=") {
+		return fmt.Errorf("pq: SCRAM authentication failed: %s", s[2:])
+	}
+	if !strings.HasPrefix(s, "v=") {
+		return fmt.Errorf("pq: invalid SCRAM server-final message %q", s)
+	}
+	sig, err := base64.StdEncoding.DecodeString(s[2:])
+	if err != nil || !hmac.Equal(sig, sc.serverSignature) {
+		return fmt.Errorf("pq: invalid SCRAM server signature")
+	}
+	return nil
+}
+
+func scramHMAC(key, msg []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// scramHi is PBKDF2 with HMAC-SHA-256, for a single block of output.
+func scramHi(password, salt []byte, iterations int) []byte {
+	u := scramHMAC(password, append(append([]byte{}, salt...), 0, 0, 0, 1))
+	result := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		u = scramHMAC(password, u)
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
+
+// saslMechanisms reads the list of mechanisms in an AuthenticationSASL
+// message.
+func saslMechanisms(r *readBuf) []string {
+	var mechanisms []string
+	for len(*r) > 0 {
+		m := r.string()
+		if m == "" {
+			break
+		}
+		mechanisms = append(mechanisms, m)
+	}
+	return mechanisms
+}
@@ -0,0 +1,144 @@
+package pq
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The example exchange from RFC 7677
+func TestSCRAMExchange(t *testing.T) {
+	sc := &scramClient{user: "user", password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO", gs2Header: "n,,"}
+
+	clientFirst, err := sc.clientFirst()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(clientFirst); s != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Errorf("unexpected client-first message %q", s)
+	}
+
+	clientFinal, err := sc.clientFinal([]byte("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	if s := string(clientFinal); s != expected {
+		t.Errorf("expected client-final message %q, got %q", expected, s)
+	}
+
+	if err := sc.verifyServerFinal([]byte("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")); err != nil {
+		t.Error(err)
+	}
+	if err := sc.verifyServerFinal([]byte("v=AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")); err == nil {
+		t.Error("expected an error for a wrong server signature")
+	}
+	if err := sc.verifyServerFinal([]byte("e=invalid-proof")); err == nil {
+		t.Error("expected an error for a server error")
+	}
+}
+
+func TestSCRAMBadServerNonce(t *testing.T) {
+	sc := &scramClient{password: "pencil", nonce: "abc", gs2Header: "n,,"}
+	if _, err := sc.clientFirst(); err != nil {
+		t.Fatal(err)
+	}
+	for _, serverFirst := range []string{"r=xyz,s=QUJD,i=4096", "r=abc,s=QUJD,i=4096", "r=abcd,s=QUJD,i=0"} {
+		if _, err := sc.clientFinal([]byte(serverFirst)); err == nil {
+			t.Errorf("%q: expected an error", serverFirst)
+		}
+	}
+}
+
+func TestSCRAMMechanism(t *testing.T) {
+	tests := []struct {
+		mechanisms []string
+		tls        bool
+		mechanism  string
+		gs2Header  string
+	}{
+		{[]string{scramSHA256}, false, scramSHA256, "n,,"},
+		{[]string{scramSHA256Plus, scramSHA256}, false, scramSHA256, "n,,"},
+		{[]string{scramSHA256}, true, scramSHA256, "y,,"},
+		{[]string{scramSHA256Plus, scramSHA256}, true, scramSHA256Plus, "p=tls-server-end-point,,"},
+	}
+
+	for _, tt := range tests {
+		var tlsConn *tls.Conn
+		if tt.tls {
+			tlsConn = handshakeTLS(t)
+		}
+		sc, mechanism, err := newSCRAMClient(tt.mechanisms, "pencil", tlsConn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mechanism != tt.mechanism || sc.gs2Header != tt.gs2Header {
+			t.Errorf("%v, tls %v: expected %s with %q, got %s with %q", tt.mechanisms, tt.tls, tt.mechanism, tt.gs2Header, mechanism, sc.gs2Header)
+		}
+
+		// the binding data is sent only with the -PLUS mechanism
+		sc.nonce = "abc"
+		if _, err := sc.clientFirst(); err != nil {
+			t.Fatal(err)
+		}
+		clientFinal, err := sc.clientFinal([]byte("r=abcd,s=QUJD,i=1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cbind, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.Split(string(clientFinal), ",")[0], "c="))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cbindLen := len(tt.gs2Header)
+		if tt.mechanism == scramSHA256Plus {
+			cbindLen += 32
+		}
+		if !strings.HasPrefix(string(cbind), tt.gs2Header) || len(cbind) != cbindLen {
+			t.Errorf("%v, tls %v: unexpected channel binding %q", tt.mechanisms, tt.tls, cbind)
+		}
+	}
+
+	if _, _, err := newSCRAMClient([]string{"OTHER"}, "pencil", nil); err == nil {
+		t.Error("expected an error without a supported mechanism")
+	}
+}
+
+// handshakeTLS returns the client end of a TLS connection to a server with
+// a self-signed certificate.
+func handshakeTLS(t *testing.T) *tls.Conn {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "db.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go tls.Server(server, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}).Handshake()
+
+	tlsConn := tls.Client(client, &tls.Config{InsecureSkipVerify: true})
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	return tlsConn
+}
@@ -0,0 +1,274 @@
+package pq
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"github.com/gregb/pq/oid"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// QuoteLiteral quotes a string for use as a literal in an SQL statement,
+// doubling any single quotes.  A string with backslashes is quoted as an
+// escape string (E'...') with the backslashes doubled, so the literal means
+// the same whatever standard_conforming_strings is set to.
+func QuoteLiteral(literal string) string {
+	literal = strings.Replace(literal, `'`, `''`, -1)
+	if strings.Contains(literal, `\`) {
+		return ` E'` + strings.Replace(literal, `\`, `\\`, -1) + `'`
+	}
+	return `'` + literal + `'`
+}
+
+// QuoteIdentifier quotes an identifier, such as a table or schema name, for
+// use in an SQL statement, doubling any double quotes.  The identifier is
+// cut short at a NUL byte, as the server would.
+func QuoteIdentifier(name string) string {
+	if end := strings.IndexByte(name, 0); end >= 0 {
+		name = name[:end]
+	}
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// simpleQuery runs a statement prepared with prefer_simple_protocol, which
+// was never sent to the server, with its parameters interpolated.
+func (st *stmt) simpleQuery(v []driver.Value) (driver.Rows, error) {
+	q, err := st.cn.interpolate(st.query, v)
+	if err != nil {
+		return nil, err
+	}
+	return st.cn.simpleQuery(q)
+}
+
+func (st *stmt) simpleExec(v []driver.Value) (driver.Result, error) {
+	q, err := st.cn.interpolate(st.query, v)
+	if err != nil {
+		return nil, err
+	}
+	res, _, err := st.cn.simpleExec(q)
+	return res, err
+}
+
+// interpolate replaces the $n parameter markers in q with the literals of
+// the corresponding values, for sending q with the simple query protocol.
+// Markers in string literals, quoted identifiers, dollar-quoted strings and
+// comments are left alone.
+func (cn *conn) interpolate(q string, v []driver.Value) (_ string, err error) {
+	defer errRecover(&err)
+
+	var b strings.Builder
+	used := 0
+	for i := 0; i < len(q); {
+		c := q[i]
+		switch {
+		case c == '\'':
+			j := skipQuoted(q, i, isEscapeString(q, i))
+			b.WriteString(q[i:j])
+			i = j
+		case c == '"':
+			j := skipQuoted(q, i, false)
+			b.WriteString(q[i:j])
+			i = j
+		case c == '-' && strings.HasPrefix(q[i:], "--"):
+			j := strings.IndexByte(q[i:], '\n')
+			if j < 0 {
+				j = len(q) - i
+			}
+			b.WriteString(q[i : i+j])
+			i += j
+		case c == '/' && strings.HasPrefix(q[i:], "/*"):
+			j := skipBlockComment(q, i)
+			b.WriteString(q[i:j])
+			i = j
+		case c == '$' && i+1 < len(q) && q[i+1] >= '0' && q[i+1] <= '9' && !isIdentChar(q, i-1):
+			j := i + 1
+			for j < len(q) && q[j] >= '0' && q[j] <= '9' {
+				j++
+			}
+			n, err := strconv.Atoi(q[i+1 : j])
+			if err != nil || n < 1 || n > len(v) {
+				errorf("got %d parameters but the statement uses $%s", len(v), q[i+1:j])
+			}
+			if n > used {
+				used = n
+			}
+			// spaces keep the literal from running into what's around it
+			b.WriteByte(' ')
+			b.WriteString(cn.literal(v[n-1]))
+			b.WriteByte(' ')
+			i = j
+		case c == '$' && !isIdentChar(q, i-1):
+			j := skipDollarQuoted(q, i)
+			b.WriteString(q[i:j])
+			i = j
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	if used != len(v) {
+		errorf("got %d parameters but the statement requires %d", len(v), used)
+	}
+	return b.String(), nil
+}
+
+// textValue is a parameter that is sent as text, not as a bytea, though
+// it was a []byte: the array text of a slice, or what a driver.Valuer
+// returned, which is usually text such as JSON or an array.
+type textValue string
+
+// checkSimpleValue is CheckNamedValue for prefer_simple_protocol, where
+// there are no parameter types from the server to tell how to encode a
+// value.  Slices are encoded as arrays of the type their elements suggest,
+// and []byte from a driver.Valuer is kept apart from []byte parameters,
+// which are bytea.
+func (cn *conn) checkSimpleValue(nv *driver.NamedValue) error {
+	if vr, ok := nv.Value.(driver.Valuer); ok {
+		if rv := reflect.ValueOf(vr); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			// database/sql knows what a nil pointer's value is
+			return driver.ErrSkip
+		}
+		v, err := vr.Value()
+		if err != nil {
+			return err
+		}
+		if b, ok := v.([]byte); ok && b != nil {
+			nv.Value = textValue(b)
+			return nil
+		}
+		if !driver.IsValue(v) {
+			return fmt.Errorf("pq: non-subset type %T returned from Value", v)
+		}
+		nv.Value = v
+		return nil
+	}
+	if driver.IsValue(nv.Value) {
+		return nil
+	}
+
+	rv := reflect.ValueOf(nv.Value)
+	if rv.Kind() != reflect.Slice {
+		return driver.ErrSkip
+	}
+	if rv.IsNil() {
+		nv.Value = nil
+		return nil
+	}
+	typ, ok := arrayTypeOf(rv.Type().Elem())
+	if !ok {
+		return fmt.Errorf("pq: cannot determine the array type of %T for parameter $%d", nv.Value, nv.Ordinal)
+	}
+	ac := &arrayConverter{ArrayTyp: typ, parameterStatus: &cn.parameterStatus}
+	b, err := ac.encode(nv.Value)
+	if err != nil {
+		return err
+	}
+	nv.Value = textValue(b)
+	return nil
+}
+
+// literal returns the SQL literal for a parameter value.  Everything but
+// NULL is a quoted string, whose type the server infers as it would a
+// parameter's; []byte is a bytea.
+func (cn *conn) literal(x driver.Value) string {
+	var s string
+	switch v := x.(type) {
+	case textValue:
+		s = string(v)
+	case []byte:
+		if v == nil {
+			return "NULL"
+		}
+		return QuoteLiteral(string(encodeBytea(&cn.parameterStatus, v))) + "::bytea"
+	case nil:
+		return "NULL"
+	default:
+		s = string(encode(&cn.parameterStatus, x, oid.T_unknown))
+	}
+	if strings.IndexByte(s, 0) >= 0 {
+		// the server would cut the query short at the NUL
+		errorf("cannot send a parameter containing a NUL byte with the simple protocol")
+	}
+	return QuoteLiteral(s)
+}
+
+// isIdentChar reports whether q[i] could be part of an identifier or
+// keyword, so that a $ or ' after it doesn't start a marker or a string of
+// its own.
+func isIdentChar(q string, i int) bool {
+	if i < 0 {
+		return false
+	}
+	c := q[i]
+	return c == '_' || c == '$' || c >= 0x80 ||
+		c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// isEscapeString reports whether the string literal starting at q[i] is an
+// escape string, E'...', in which backslashes escape quotes.
+func isEscapeString(q string, i int) bool {
+	return i > 0 && (q[i-1] == 'E' || q[i-1] == 'e') && !isIdentChar(q, i-2)
+}
+
+// skipQuoted returns the index after the string literal or quoted
+// identifier starting at q[i].
+func skipQuoted(q string, i int, backslashEscapes bool) int {
+	quote := q[i]
+	for j := i + 1; j < len(q); j++ {
+		switch q[j] {
+		case '\\':
+			if backslashEscapes {
+				j++
+			}
+		case quote:
+			if j+1 < len(q) && q[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return len(q)
+}
+
+// skipBlockComment returns the index after the block comment starting at
+// q[i].  Block comments nest.
+func skipBlockComment(q string, i int) int {
+	depth := 0
+	for j := i; j < len(q)-1; j++ {
+		switch q[j : j+2] {
+		case "/*":
+			depth++
+			j++
+		case "*/":
+			depth--
+			j++
+			if depth == 0 {
+				return j + 1
+			}
+		}
+	}
+	return len(q)
+}
+
+// skipDollarQuoted returns the index after the dollar-quoted string starting
+// at q[i], or i+1 if the $ doesn't start one.
+func skipDollarQuoted(q string, i int) int {
+	j := i + 1
+	for j < len(q) && q[j] != '$' {
+		if !isIdentChar(q, j) {
+			return i + 1
+		}
+		j++
+	}
+	if j == len(q) {
+		return i + 1
+	}
+	tag := q[i : j+1]
+	end := strings.Index(q[j+1:], tag)
+	if end < 0 {
+		return len(q)
+	}
+	return j + 1 + end + len(tag)
+}
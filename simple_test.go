@@ -0,0 +1,221 @@
+package pq
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQuoteLiteral(t *testing.T) {
+	tests := []struct {
+		in, expected string
+	}{
+		{`abc`, `'abc'`},
+		{`a'b`, `'a''b'`},
+		{`'; DROP TABLE t; --`, `'''; DROP TABLE t; --'`},
+		{`a\b`, ` E'a\\b'`},
+		{`\'`, ` E'\\'''`},
+	}
+	for _, tt := range tests {
+		if got := QuoteLiteral(tt.in); got != tt.expected {
+			t.Errorf("QuoteLiteral(%q) = %q, expected %q", tt.in, got, tt.expected)
+		}
+	}
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	tests := []struct {
+		in, expected string
+	}{
+		{`abc`, `"abc"`},
+		{`Tenant A`, `"Tenant A"`},
+		{`a"b`, `"a""b"`},
+		{"a\x00b", `"a"`},
+	}
+	for _, tt := range tests {
+		if got := QuoteIdentifier(tt.in); got != tt.expected {
+			t.Errorf("QuoteIdentifier(%q) = %q, expected %q", tt.in, got, tt.expected)
+		}
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	cn := &conn{parameterStatus: parameterStatus{serverVersion: 90000}}
+	tests := []struct {
+		q        string
+		args     []driver.Value
+		expected string
+	}{
+		{"SELECT $1", []driver.Value{int64(1)}, "SELECT  '1' "},
+		{"SELECT $2, $1, $1", []driver.Value{"a", nil}, "SELECT  NULL ,  'a' ,  'a' "},
+		{"SELECT -$1", []driver.Value{int64(-1)}, "SELECT - '-1' "},
+		{"SELECT $1", []driver.Value{[]byte{0xde, 0xad}}, `SELECT   E'\\xdead'::bytea `},
+		{"SELECT $1", []driver.Value{time.Date(2012, 11, 6, 10, 23, 42, 0, time.UTC)}, "SELECT  '2012-11-06T10:23:42Z' "},
+		{"SELECT $1, '$1', \"$1\", E'\\'$1', $$ $1 $$, $tag$ $1 $tag$", []driver.Value{true},
+			"SELECT  'true' , '$1', \"$1\", E'\\'$1', $$ $1 $$, $tag$ $1 $tag$"},
+		{"SELECT a$1, $1 -- $1\n/* $1 /* $1 */ $1 */", []driver.Value{"x"},
+			"SELECT a$1,  'x'  -- $1\n/* $1 /* $1 */ $1 */"},
+		{"SELECT 'it''s $1', $1", []driver.Value{"x"}, "SELECT 'it''s $1',  'x' "},
+	}
+	for _, tt := range tests {
+		got, err := cn.interpolate(tt.q, tt.args)
+		if err != nil {
+			t.Errorf("%q: %v", tt.q, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.q, tt.expected, got)
+		}
+	}
+
+	errTests := []struct {
+		q    string
+		args []driver.Value
+	}{
+		{"SELECT $1", nil},
+		{"SELECT $2", []driver.Value{"a", "b", "c"}},
+		{"SELECT $1", []driver.Value{"a", "b"}},
+		{"SELECT $0", []driver.Value{"a"}},
+		{"SELECT $1", []driver.Value{"a\x00b"}},
+	}
+	for _, tt := range errTests {
+		if _, err := cn.interpolate(tt.q, tt.args); err == nil {
+			t.Errorf("%q with %v: expected an error", tt.q, tt.args)
+		}
+	}
+}
+
+func TestSimpleProtocol(t *testing.T) {
+	db, err := openTestConnConninfo("user=pqgotest password=pqgotest prefer_simple_protocol=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("CREATE TEMP TABLE temp (a int, b text, c bytea)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := tx.Exec("INSERT INTO temp VALUES ($1, $2, $3), ($1 + 1, NULL, NULL)", 1, `'); DROP TABLE temp; --\`, []byte{0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 2 {
+		t.Fatalf("expected 2 rows affected, not %d", n)
+	}
+
+	st, err := tx.Prepare("SELECT b, c FROM temp WHERE a = $1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	var b string
+	var c []byte
+	if err := st.QueryRow(1).Scan(&b, &c); err != nil {
+		t.Fatal(err)
+	}
+	if b != `'); DROP TABLE temp; --\` || string(c) != "\x00\x01" {
+		t.Errorf("unexpected values %q, %q", b, c)
+	}
+
+	// nothing was prepared on the server
+	var n int
+	if err := tx.QueryRow("SELECT count(*) FROM pg_prepared_statements").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("expected no prepared statements, not %d", n)
+	}
+}
+
+func TestCheckSimpleValue(t *testing.T) {
+	cn := &conn{simpleProtocol: true, parameterStatus: parameterStatus{serverVersion: 90000}}
+	tests := []struct {
+		in       interface{}
+		expected driver.Value
+	}{
+		{int64(1), int64(1)},
+		{[]byte{1}, []byte{1}},
+		{[]int64{1, 2}, textValue("{1,2}")},
+		{[]string{"a", "b,c"}, textValue(`{a,"b,c"}`)},
+		{[]string(nil), nil},
+		{Array([]float64{1.5}), textValue("{1.5}")},
+		{ByteaArray{{0xff}}, textValue(`{"\\377"}`)},
+		{ByteaArray(nil), nil},
+		{Interval{Days: 1}, "1 day"},
+	}
+	for _, tt := range tests {
+		nv := &driver.NamedValue{Ordinal: 1, Value: tt.in}
+		if err := cn.CheckNamedValue(nv); err != nil {
+			t.Errorf("%#v: %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(nv.Value, tt.expected) {
+			t.Errorf("%#v: expected %#v, got %#v", tt.in, tt.expected, nv.Value)
+		}
+	}
+
+	// left to the default conversion
+	for _, in := range []interface{}{1, (*Interval)(nil)} {
+		if err := cn.CheckNamedValue(&driver.NamedValue{Ordinal: 1, Value: in}); err != driver.ErrSkip {
+			t.Errorf("%#v: expected driver.ErrSkip, got %v", in, err)
+		}
+	}
+
+	if got := cn.literal(textValue("{1,2}")); got != "'{1,2}'" {
+		t.Errorf("unexpected literal %s", got)
+	}
+}
+
+// The same queries and parameters give the same results through the
+// prepared and the interpolated paths.
+func TestSimpleProtocolMatchesPrepared(t *testing.T) {
+	prepared := openTestConn(t)
+	defer prepared.Close()
+	simple, err := openTestConnConninfo("user=pqgotest password=pqgotest prefer_simple_protocol=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer simple.Close()
+
+	tests := []struct {
+		q    string
+		args []interface{}
+	}{
+		{"SELECT $1::int8 - $2::float8", []interface{}{int64(-42), 1.5}},
+		{"SELECT $1::bool", []interface{}{true}},
+		{"SELECT $1::text || $2::text", []interface{}{`it's a \ "test"`, "$1 -- /*"}},
+		{"SELECT $1::bytea", []interface{}{[]byte{0, '\\', '\'', 0xff}}},
+		{"SELECT $1::bytea", []interface{}{[]byte{}}},
+		{"SELECT $1::timestamptz", []interface{}{time.Date(2012, 11, 6, 10, 23, 42, 123456789, time.UTC)}},
+		{"SELECT $1::text", []interface{}{nil}},
+		{"SELECT $1::int8[]", []interface{}{[]int64{1, 2, 3}}},
+		{"SELECT $1::text[]", []interface{}{[]string{"a", `b"c`, `d\e`, "NULL"}}},
+		{"SELECT $1::text[]", []interface{}{Array([]string{"x", "y z"})}},
+		{"SELECT $1::bytea[]", []interface{}{ByteaArray{{1, 2}, nil}}},
+		{"SELECT $1::interval", []interface{}{Interval{Months: 1, Days: -2, Microseconds: 3}}},
+		{"SELECT $1::int + $1::int", []interface{}{int64(21)}},
+	}
+	for _, tt := range tests {
+		var fromPrepared, fromSimple interface{}
+		if err := prepared.QueryRow(tt.q, tt.args...).Scan(&fromPrepared); err != nil {
+			t.Errorf("%s (prepared): %v", tt.q, err)
+			continue
+		}
+		if err := simple.QueryRow(tt.q, tt.args...).Scan(&fromSimple); err != nil {
+			t.Errorf("%s (simple): %v", tt.q, err)
+			continue
+		}
+		if !reflect.DeepEqual(fromPrepared, fromSimple) {
+			t.Errorf("%s: prepared gave %#v, simple %#v", tt.q, fromPrepared, fromSimple)
+		}
+	}
+}
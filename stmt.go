@@ -1,11 +1,16 @@
 package pq
 
 import (
+	"context"
+	"database/sql"
 	"database/sql/driver"
 	"errors"
+	"fmt"
 	"github.com/gregb/pq/message"
 	"github.com/gregb/pq/oid"
 	"io"
+	"reflect"
+	"runtime"
 	"strconv"
 	"strings"
 )
@@ -19,7 +24,139 @@ type stmt struct {
 	paramTyps []oid.Oid
 	closed    bool
 	lasterr   error
-	rowData   []driver.Value
+
+	// returned are the rows the statement being run with Exec has returned
+	// so far, such as with RETURNING, for its result
+	returned [][]driver.Value
+
+	// rowFmts are the format codes of the columns of the rows, if any are
+	// in binary; see writeResultFormats
+	rowFmts []int
+
+	// paramTypHints are the parameter types the statement is prepared with,
+	// from WithParameterTypes; the server infers the rest
+	paramTypHints []oid.Oid
+
+	// stale is set when the server-side statement no longer matches the
+	// schema, or has been closed by flushStatements or deallocated by
+	// DISCARD ALL, so that it is prepared again before its next execution
+	stale bool
+
+	// simple is set for statements of connections with
+	// prefer_simple_protocol, which aren't prepared on the server but run
+	// with the simple query protocol and their parameters interpolated
+	simple bool
+}
+
+// prepare parses st.query into the server-side statement st.name, and reads
+// its parameter and result types.  A stale statement is closed first, since
+// its name may still be in use on the server.
+func (st *stmt) prepare() {
+	cn := st.cn
+	if st.stale {
+		w := cn.writeMessageType(message.Close)
+		w.byte('S')
+		w.string(st.name)
+		cn.send(w)
+	}
+
+	b := cn.writeMessageType(message.Parse)
+	b.string(st.name)
+	b.string(st.query)
+	b.int16(len(st.paramTypHints))
+	for _, typ := range st.paramTypHints {
+		b.int32(int(typ))
+	}
+	cn.send(b)
+
+	b = cn.writeMessageType(message.Describe)
+	b.byte('S') // statement
+	b.string(st.name)
+	cn.send(b)
+
+	cn.send(cn.writeMessageType(message.Sync))
+
+	st.paramTyps = nil
+	st.cols = nil
+	st.rowTyps = nil
+
+	// The statement description is a ParameterDescription followed by a
+	// RowDescription, or NoData for statements such as DO blocks and CALLs of
+	// procedures without output parameters.  They're accepted in any order,
+	// but both have to arrive before the statement is usable.
+	var err error
+	var described, describedRows bool
+	for {
+		t, r := cn.recv1()
+		switch t {
+		case message.CloseComplete, message.ParseComplete:
+			// ignore
+		case message.ParameterDescription:
+			nparams := int(r.int16())
+			st.paramTyps = make([]oid.Oid, nparams)
+
+			for i := range st.paramTyps {
+				st.paramTyps[i] = r.oid()
+			}
+			described = true
+		case message.RowDescription:
+			st.parseRowDesciption(r)
+			describedRows = true
+		case message.NoData:
+			describedRows = true
+		case message.ReadyForQuery:
+			cn.processReadyForQuery(r)
+			if err != nil {
+				panic(err)
+			}
+			if !described || !describedRows {
+				errorf("incomplete description of statement %q", st.query)
+			}
+			st.stale = false
+			return
+		case message.Error:
+			err = parseError(r)
+		default:
+			errorf("unexpected describe rows response: %q", t)
+		}
+	}
+}
+
+type parameterTypesKey struct{}
+
+// WithParameterTypes returns a copy of ctx that makes statements prepared
+// with it, as by DB.PrepareContext, declare their parameters to be of the
+// given types rather than leave the server to infer them from the query.
+// This pins the types of hot statements whose parameters' types are stable,
+// and settles ambiguities such as whether a [][]byte is a bytea[] or a
+// text[].  A zero type, or a parameter past the end of types, is still
+// inferred.
+func WithParameterTypes(ctx context.Context, types ...oid.Oid) context.Context {
+	return context.WithValue(ctx, parameterTypesKey{}, types)
+}
+
+func parameterTypes(ctx context.Context) []oid.Oid {
+	types, _ := ctx.Value(parameterTypesKey{}).([]oid.Oid)
+	return types
+}
+
+// isCachedPlanChange reports whether err is the server refusing to run a
+// prepared statement whose result type was changed by DDL since it was
+// prepared.  The message may be translated, so the routine is checked too.
+func isCachedPlanChange(err error) bool {
+	pqErr, ok := err.(*Error)
+	return ok && pqErr.Code == "0A000" &&
+		(pqErr.Routine == "RevalidateCachedQuery" ||
+			strings.Contains(pqErr.Message, "cached plan must not change result type"))
+}
+
+// fail panics with err, first marking the statement to be prepared again if
+// err says its plan is out of date.
+func (st *stmt) fail(err error) {
+	if isCachedPlanChange(err) {
+		st.stale = true
+	}
+	panic(err)
 }
 
 // ColumnConverter returns a ValueConverter for the provided
@@ -28,6 +165,9 @@ type stmt struct {
 // can be returned.
 // Implements driver.ColumnConverter: ColumnConverter(idx int) ValueConverter
 func (st *stmt) ColumnConverter(idx int) driver.ValueConverter {
+	if st.simple {
+		return driver.DefaultParameterConverter
+	}
 	paramTyp := st.paramTyps[idx]
 
 	// TODO: If oid.Oid could implement ConvertValue directly, we wouldn't have to keep creating new ones?
@@ -42,6 +182,10 @@ func (st *stmt) Close() (err error) {
 	if st.closed {
 		return nil
 	}
+	if st.simple {
+		st.closed = true
+		return nil
+	}
 
 	defer errRecover(&err)
 
@@ -52,35 +196,96 @@ func (st *stmt) Close() (err error) {
 
 	st.cn.send(st.cn.writeMessageType(message.Sync))
 
-	t, r := st.cn.recv1()
-	if t != message.CloseComplete {
-		errorf("unexpected close response: %q", t)
-	}
-	st.closed = true
-
-	t, r = st.cn.recv1()
-	if t != message.ReadyForQuery {
-		errorf("expected ready for query, but got: %q", t)
+	// an error still ends with ReadyForQuery, which has to be read to keep
+	// the connection in step with the server
+	for {
+		t, r := st.cn.recv1()
+		switch t {
+		case message.CloseComplete:
+			st.closed = true
+			delete(st.cn.stmts, st.name)
+		case message.Error:
+			err = parseError(r)
+		case message.ReadyForQuery:
+			st.cn.processReadyForQuery(r)
+			return err
+		default:
+			errorf("unexpected close response: %q", t)
+		}
 	}
-	st.cn.processReadyForQuery(r)
-
-	return nil
 }
 
 func (st *stmt) Query(v []driver.Value) (_ driver.Rows, err error) {
+	if st.simple {
+		return st.simpleQuery(v)
+	}
+	defer st.cn.hooks.queryEnd(st.query, st.cn.hooks.queryStart(st.query), &err)
 	defer errRecover(&err)
+	startBytes := st.cn.bytesReceived
 	st.exec(v)
-	return &rows{st: st}, nil
+	return &rows{st: st, startBytes: startBytes}, nil
 }
 
-func (st *stmt) Exec(v []driver.Value) (res driver.Result, err error) {
-	defer errRecover(&err)
+// QueryContext implements driver.StmtQueryContext.  If ctx is done before
+// the rows have been read, the query is cancelled.
+func (st *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (_ driver.Rows, err error) {
+	v, err := namedValuesToValues(args)
+	if err != nil {
+		return nil, err
+	}
+
+	finish := st.cn.watchCancel(ctx)
+	r, err := st.Query(v)
+	if err != nil {
+		if ctxErr := finish(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	r.(*rows).finish = finish
+	return r, nil
+}
+
+// ExecContext implements driver.StmtExecContext.  If ctx is done before the
+// statement has completed, it is cancelled.
+func (st *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (_ driver.Result, err error) {
+	v, err := namedValuesToValues(args)
+	if err != nil {
+		return nil, err
+	}
 
+	finish := st.cn.watchCancel(ctx)
+	res, err := st.Exec(v)
+	if ctxErr := finish(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	return res, err
+}
+
+func namedValuesToValues(named []driver.NamedValue) ([]driver.Value, error) {
+	v := make([]driver.Value, len(named))
+	for i, nv := range named {
+		if nv.Name != "" {
+			return nil, fmt.Errorf("pq: named parameters are not supported; got %q", nv.Name)
+		}
+		v[i] = nv.Value
+	}
+	return v, nil
+}
+
+func (st *stmt) Exec(v []driver.Value) (res driver.Result, err error) {
+	if st.simple {
+		return st.simpleExec(v)
+	}
 	if len(v) == 0 {
 		// ignore commandTag, our caller doesn't care
 		r, _, err := st.cn.simpleExec(st.query)
 		return r, err
 	}
+
+	defer st.cn.hooks.queryEnd(st.query, st.cn.hooks.queryStart(st.query), &err)
+	defer errRecover(&err)
+	st.returned = nil
 	st.exec(v)
 
 	for {
@@ -90,15 +295,16 @@ func (st *stmt) Exec(v []driver.Value) (res driver.Result, err error) {
 
 		case message.Error:
 			err = parseError(r)
+			if isCachedPlanChange(err) {
+				st.stale = true
+			}
 		case message.CommandComplete:
 
-			rowsAffected, _ := parseComplete(r.string())
+			rowsAffected, commandTag := parseComplete(r.string())
+			st.cn.checkDeallocated(commandTag)
 
-			if st.rowData != nil {
-				res = createResult(rowsAffected, st.rowData)
-			} else {
-				res = driver.RowsAffected(rowsAffected)
-			}
+			res = createResult(commandTag, rowsAffected, st.cols, st.returned)
+			st.returned = nil
 		case message.ReadyForQuery:
 			// done
 			return
@@ -108,10 +314,11 @@ func (st *stmt) Exec(v []driver.Value) (res driver.Result, err error) {
 			st.parseRowDesciption(r)
 		case message.DataRow:
 			if st.cols != nil {
-				st.rowData = make([]driver.Value, len(st.cols), len(st.cols))
+				row := make([]driver.Value, len(st.cols))
 				// we received a m_rowDescription at some point
 				// so parse this now
-				st.parseDataRow(r, st.rowData)
+				st.parseDataRow(r, row)
+				st.returned = append(st.returned, row)
 			}
 		default:
 			errorf("unknown exec response: %q", t)
@@ -121,7 +328,56 @@ func (st *stmt) Exec(v []driver.Value) (res driver.Result, err error) {
 	panic("not reached")
 }
 
+// exec binds v to the statement and executes it.  If the server rejects the
+// statement because DDL has changed its result type since it was prepared,
+// it is prepared again and executed once more, unless that happened in a
+// transaction, which the error has aborted.  If the retry fails as well, the
+// original error is reported.
 func (st *stmt) exec(v []driver.Value) {
+	err := st.tryExec(v)
+	if err == nil {
+		return
+	}
+	if st.cn.txnStatus == TxnStatusInFailedTransaction {
+		panic(err)
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			if _, ok := e.(error); !ok {
+				panic(e)
+			}
+			if _, ok := e.(runtime.Error); ok {
+				panic(e)
+			}
+			panic(err)
+		}
+	}()
+	st.execOnce(v)
+}
+
+// tryExec is execOnce, but returns the error of a statement whose plan was
+// invalidated instead of panicking.
+func (st *stmt) tryExec(v []driver.Value) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			if planErr, ok := e.(error); ok && isCachedPlanChange(planErr) {
+				err = planErr
+				return
+			}
+			panic(e)
+		}
+	}()
+	st.execOnce(v)
+	return nil
+}
+
+func (st *stmt) execOnce(v []driver.Value) {
+	st.cn.checkBad()
+	st.lasterr = nil
+	if st.stale {
+		st.prepare()
+	}
 	if len(v) != len(st.paramTyps) {
 		errorf("got %d parameters but the statement requires %d", len(v), len(st.paramTyps))
 	}
@@ -129,18 +385,27 @@ func (st *stmt) exec(v []driver.Value) {
 	w := st.cn.writeMessageType(message.Bind)
 	w.string("")
 	w.string(st.name)
-	w.int16(0)
+	fmts, binaryValues := st.paramFormats(v)
+	w.int16(len(fmts))
+	for _, f := range fmts {
+		w.int16(f)
+	}
 	w.int16(len(v))
 	for i, x := range v {
-		if x == nil {
+		if isNull(x) {
 			w.int32(-1)
+			continue
+		}
+		var b []byte
+		if fmts != nil && fmts[i] == formatBinary {
+			b = binaryValues[i]
 		} else {
-			b := encode(&st.cn.parameterStatus, x, st.paramTyps[i])
-			w.int32(len(b))
-			w.bytes(b)
+			b = encode(&st.cn.parameterStatus, x, st.paramTyps[i])
 		}
+		w.int32(len(b))
+		w.bytes(b)
 	}
-	w.int16(0)
+	st.writeResultFormats(w)
 	st.cn.send(w)
 
 	w = st.cn.writeMessageType(message.Execute)
@@ -158,13 +423,13 @@ func (st *stmt) exec(v []driver.Value) {
 			err = parseError(r)
 		case message.BindComplete:
 			if err != nil {
-				panic(err)
+				st.fail(err)
 			}
 			goto workaround
 		case message.ReadyForQuery:
 			st.cn.processReadyForQuery(r)
 			if err != nil {
-				panic(err)
+				st.fail(err)
 			}
 			return
 		case message.Notice:
@@ -200,14 +465,77 @@ workaround:
 			if err == nil {
 				errorf("unexpected ReadyForQuery during extended query execution")
 			}
-			panic(err)
+			st.fail(err)
 		default:
 			errorf("unexpected message during query execution: %q", t)
 		}
 	}
 }
 
+// Format codes of the values in Bind and DataRow messages.
+const (
+	formatText   = 0
+	formatBinary = 1
+)
+
+// paramFormats returns the format codes of the parameters v, for a Bind
+// message, and the binary encodings of those sent in binary format.  With
+// binary_parameters, the parameters encodeBinary can encode are sent in
+// binary format, and the rest in text; otherwise, and if there are no such
+// parameters, it returns nil, which sends them all in text.
+func (st *stmt) paramFormats(v []driver.Value) (fmts []int, binaryValues [][]byte) {
+	if !st.cn.binaryParameters {
+		return nil, nil
+	}
+	for i, x := range v {
+		b, ok := encodeBinary(x, st.paramTyps[i])
+		if !ok {
+			continue
+		}
+		if fmts == nil {
+			fmts = make([]int, len(v))
+			binaryValues = make([][]byte, len(v))
+		}
+		fmts[i] = formatBinary
+		binaryValues[i] = b
+	}
+	return fmts, binaryValues
+}
+
+// writeResultFormats writes the result format codes of a Bind message.
+// With binary_results, the columns of the types that have a binary decoder
+// are asked for in binary format, and the rest in text; otherwise, and if
+// there are no such columns, no codes are written, which asks for them all
+// in text.
+func (st *stmt) writeResultFormats(w *writeBuf) {
+	st.rowFmts = nil
+	if st.cn.binaryResults {
+		for i, typ := range st.rowTyps {
+			if !hasBinaryDecoder(typ) {
+				continue
+			}
+			if st.rowFmts == nil {
+				st.rowFmts = make([]int, len(st.rowTyps))
+			}
+			st.rowFmts[i] = formatBinary
+		}
+	}
+	w.int16(len(st.rowFmts))
+	for _, f := range st.rowFmts {
+		w.int16(f)
+	}
+}
+
+// NumInput returns the number of parameters the server described the
+// statement as having, which is the highest $n marker it uses: the server
+// refuses a statement that skips one, as it can't tell the skipped
+// parameter's type.  Markers in strings, quoted identifiers, dollar-quoted
+// bodies and comments aren't parameters.
 func (st *stmt) NumInput() int {
+	if st.simple {
+		// unknown until the parameters are interpolated
+		return -1
+	}
 	return len(st.paramTyps)
 }
 
@@ -259,6 +587,30 @@ func parseComplete(commandTag string) (int64, string) {
 	return n, commandTag
 }
 
+// resultPrecedence ranks a command tag, as returned by parseComplete, by how
+// meaningful its row count is as the result of a multi-statement Exec.
+// Commands that modify data rank highest, then other commands that report a
+// row count, then everything else.
+func resultPrecedence(commandTag string) int {
+	switch commandTag {
+	case "INSERT", "UPDATE", "DELETE", "COPY":
+		return 2
+	case "SELECT", "FETCH", "MOVE":
+		return 1
+	}
+	return 0
+}
+
+// modifiesData reports whether a command tag, as returned by parseComplete,
+// is that of a statement whose RETURNING rows may hold the last insert id.
+func modifiesData(commandTag string) bool {
+	switch commandTag {
+	case "INSERT", "UPDATE", "DELETE":
+		return true
+	}
+	return false
+}
+
 func (st *stmt) parseRowDesciption(r *readBuf) {
 	n := r.int16()
 	st.cols = make([]string, n)
@@ -275,8 +627,8 @@ func (st *stmt) parseRowDesciption(r *readBuf) {
 // Parses an m_dataRow message into a slice of driver values.
 // A decode is run on each column value, based on column types set on the
 // statement from a previous m_rowDescription message.
-// Dest is an output parameter; it will mostly be st.rowData, but is
-// provided as a parameter for reuse in Rows.Next()
+// Dest is an output parameter; it will mostly be a row of st.returned, but
+// is provided as a parameter for reuse in Rows.Next()
 func (st *stmt) parseDataRow(r *readBuf, dest []driver.Value) {
 	n := r.int16()
 	if n < len(dest) {
@@ -288,6 +640,10 @@ func (st *stmt) parseDataRow(r *readBuf, dest []driver.Value) {
 			dest[i] = nil
 			continue
 		}
+		if st.rowFmts != nil && st.rowFmts[i] == formatBinary {
+			dest[i] = decodeBinary(r.next(l), st.rowTyps[i])
+			continue
+		}
 		dest[i] = decode(&st.cn.parameterStatus, r.next(l), st.rowTyps[i])
 	}
 }
@@ -296,8 +652,27 @@ type result struct {
 	rowsAffected int64 // number of rows affected by the statement
 	lastInsertId int64 // id of provided by last RETURNING clause
 	idReturned   bool  // true if lastInserted id is valid on zero
+
+	columns  []string
+	returned [][]driver.Value
+}
+
+// Result is the interface of the results of statements run with Exec on a
+// driver connection, for the methods specific to pq.  The sql.Result of
+// DB.Exec hides them; run the statement on the driver connection
+// underneath a sql.Conn, through its Raw method, to reach them.
+type Result interface {
+	driver.Result
+
+	// Returned returns the names of the columns of the rows the statement
+	// returned, such as with RETURNING, and the rows themselves, as Query
+	// would have read them.  Both are empty for a statement that returned
+	// no rows.
+	Returned() (columns []string, rows [][]driver.Value)
 }
 
+var _ Result = (*result)(nil)
+
 func (r *result) LastInsertId() (int64, error) {
 
 	if r.idReturned {
@@ -311,21 +686,27 @@ func (r *result) RowsAffected() (int64, error) {
 	return r.rowsAffected, nil
 }
 
-func createResult(rowsAffected int64, rowData []driver.Value) driver.Result {
+func (r *result) Returned() (columns []string, rows [][]driver.Value) {
+	return r.columns, r.returned
+}
 
+// createResult returns the result of a statement with the columns cols that
+// returned the rows returned, if any.  If it modified data and returned a
+// single integer column, such as with RETURNING id, the last row's is taken
+// as the last insert id.  With more columns there is no telling which one
+// is the id: RETURNING * need not return it first.  The rows of a SELECT
+// never give an id.
+func createResult(commandTag string, rowsAffected int64, cols []string, returned [][]driver.Value) driver.Result {
 	res := new(result)
-	res.idReturned = false
 	res.rowsAffected = rowsAffected
+	if len(returned) == 0 {
+		return res
+	}
+	res.columns = cols
+	res.returned = returned
 
-	// take the first int64 as the id
-	for _, v := range rowData {
-		n, ok := v.(int64)
-
-		if ok {
-			res.idReturned = true
-			res.lastInsertId = n
-			break
-		}
+	if last := returned[len(returned)-1]; len(last) == 1 && modifiesData(commandTag) {
+		res.lastInsertId, res.idReturned = last[0].(int64)
 	}
 
 	return res
@@ -334,8 +715,101 @@ func createResult(rowsAffected int64, rowData []driver.Value) driver.Result {
 type rows struct {
 	st   *stmt
 	done bool
+
+	// finish, if set, stops watching the query's context; see watchCancel
+	finish func() error
+
+	// counters for Stats: the rows received, and the connection's
+	// bytesReceived when the query was sent and when the rows were done
+	rowCount             int64
+	startBytes, endBytes int64
+
+	// the raw values of the current row, with keep_raw_values
+	raw [][]byte
+
+	// whether the rows are those of a COPY TO in binary format
+	copyBinary bool
+}
+
+// Rows is the interface of the rows returned by the queries of a driver
+// connection, for the methods specific to pq.
+type Rows interface {
+	driver.Rows
+
+	Stats() QueryStats
+	RawValue(index int) []byte
+}
+
+var _ Rows = (*rows)(nil)
+
+// QueryStats counts what the server sent for a query that returned rows.
+type QueryStats struct {
+	// Rows is the number of rows received.
+	Rows int64
+
+	// Bytes is the number of bytes received for the query, including the
+	// messages around the rows such as their description.
+	Bytes int64
+}
+
+// Stats returns the counts for the rows received so far, or for all of
+// them once they are done.  They are passed to the OnRowsDone hook too.
+func (rs *rows) Stats() QueryStats {
+	end := rs.endBytes
+	if !rs.done {
+		end = rs.st.cn.bytesReceived
+	}
+	return QueryStats{Rows: rs.rowCount, Bytes: end - rs.startBytes}
+}
+
+// RawValue returns the bytes the server sent for the column at index of the
+// current row, before they were decoded, to help diagnose a value that is
+// read wrongly.  Values read in binary format, with binary_results, are in
+// that format.  It returns nil for NULL, and unless the keep_raw_values
+// setting is on.
+func (rs *rows) RawValue(index int) []byte {
+	if index < 0 || index >= len(rs.raw) {
+		return nil
+	}
+	return rs.raw[index]
+}
+
+// keepRaw copies the raw values of the DataRow message in r, which
+// parseDataRow reads from the connection's buffer.
+func (rs *rows) keepRaw(r readBuf) {
+	n := r.int16()
+	rs.raw = rs.raw[:0]
+	for i := 0; i < n; i++ {
+		l := r.int32()
+		if l == -1 {
+			rs.raw = append(rs.raw, nil)
+			continue
+		}
+		rs.raw = append(rs.raw, append([]byte{}, r.next(l)...))
+	}
 }
 
+// finished stops watching the query's context, if it was, once the rows
+// are done.  It returns the context's error in place of err if the context
+// cost the connection.
+func (rs *rows) finished(err error) error {
+	if rs.finish == nil {
+		return err
+	}
+	ctxErr := rs.finish()
+	rs.finish = nil
+	if ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// Close reads the rows that are left, so that the connection is ready for
+// its next query.  An error the server ends them with, such as a
+// cancellation, is returned and kept as the statement's lasterr, which Next
+// returns from then on.  If the rows can't be read to the ReadyForQuery
+// that ends them, the connection is out of step with the server and is
+// marked bad, to be discarded rather than reused.
 func (rs *rows) Close() error {
 	for {
 		err := rs.Next(nil)
@@ -344,6 +818,10 @@ func (rs *rows) Close() error {
 		case io.EOF:
 			return nil
 		default:
+			rs.st.lasterr = err
+			if !rs.done && rs.st.cn.bad == nil {
+				rs.st.cn.bad = driver.ErrBadConn
+			}
 			return err
 		}
 	}
@@ -354,14 +832,66 @@ func (rs *rows) Columns() []string {
 	return rs.st.cols
 }
 
-func (rs *rows) Next(dest []driver.Value) (err error) {
-	if rs.done {
-		return io.EOF
+var (
+	nullBoolType    = reflect.TypeOf(sql.NullBool{})
+	nullInt64Type   = reflect.TypeOf(sql.NullInt64{})
+	nullFloat64Type = reflect.TypeOf(sql.NullFloat64{})
+	nullStringType  = reflect.TypeOf(sql.NullString{})
+	nullTimeType    = reflect.TypeOf(NullTime{})
+	bytesType       = reflect.TypeOf([]byte(nil))
+	interfaceType   = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// ColumnTypeScanType returns a type that the values of a column can be
+// scanned into, NULL included: the server doesn't say which columns can be
+// NULL, so every column is taken to be nullable.  Integers, floats,
+// booleans, strings, uuids and times have the sql.Null* types and NullTime,
+// []byte holds NULL as nil, and the other types are pointers, such as
+// *Interval, which is scanned from an interval's text.  Arrays are
+// interface{}, since whether they decode to a slice of values, of pointers
+// for NULL elements, or of slices for more dimensions depends on the value.
+// So are the types that aren't built in when there's an unknown type
+// decoder; otherwise they are []byte.  hstore is interface{} too once the
+// hstore setting has looked its type up, since the map it decodes to can't
+// hold NULL.  It implements driver.RowsColumnTypeScanType.
+func (rs *rows) ColumnTypeScanType(index int) reflect.Type {
+	typ := rs.st.rowTyps[index]
+	switch {
+	case typ.IsArray(), typ == oid.T_void, typ != 0 && typ == rs.st.cn.parameterStatus.hstoreOid:
+		return interfaceType
+	case typ == oid.T_bool:
+		return nullBoolType
+	case typ == oid.T_int2, typ == oid.T_int4, typ == oid.T_int8:
+		return nullInt64Type
+	case typ == oid.T_float4, typ == oid.T_float8:
+		return nullFloat64Type
+	case typ == oid.T_timestamptz, typ == oid.T_timestamp, typ == oid.T_date, typ == oid.T_time, typ == oid.T_timetz:
+		return nullTimeType
+	case typ.Category() == oid.C_string, typ == oid.T_uuid, rs.st.cn.parameterStatus.decodesAsString(typ):
+		return nullStringType
+	case !typ.IsBuiltin() && getUnknownTypeDecoder() != nil:
+		return interfaceType
 	}
 
+	t := elementGoType(typ)
+	if t == bytesType {
+		return t
+	}
+	return reflect.PtrTo(t)
+}
+
+func (rs *rows) Next(dest []driver.Value) (err error) {
 	if rs.st.lasterr != nil {
 		return rs.st.lasterr
 	}
+	if rs.done {
+		return io.EOF
+	}
+	defer func() {
+		if err != nil {
+			err = rs.finished(err)
+		}
+	}()
 	defer errRecover(&err)
 
 	conn := rs.st.cn
@@ -375,13 +905,32 @@ func (rs *rows) Next(dest []driver.Value) (err error) {
 		case message.ReadyForQuery:
 			conn.processReadyForQuery(r)
 			rs.done = true
+			rs.endBytes = conn.bytesReceived
+			conn.hooks.rowsDone(rs.st.query, rs.Stats())
 			if err != nil {
 				return err
 			}
 			return io.EOF
 		case message.DataRow:
+			rs.rowCount++
+			if conn.keepRawValues {
+				rs.keepRaw(*r)
+			}
 			rs.st.parseDataRow(r, dest)
 			return
+		case message.CopyData:
+			// a row of a COPY TO; see copyOutStmt
+			if rs.copyBinary {
+				if !rs.st.parseCopyBinaryRow(*r, dest) {
+					continue
+				}
+			} else {
+				rs.st.parseCopyRow(*r, dest)
+			}
+			rs.rowCount++
+			return
+		case message.CopyDone:
+			continue
 		default:
 			errorf("unexpected message after execute: %q", t)
 		}
@@ -1,6 +1,17 @@
 package pq
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/gregb/pq/oid"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
 
 func TestStatment(t *testing.T) {
 	db := openTestConn(t)
@@ -99,3 +110,794 @@ func Test_StmtReturnId(t *testing.T) {
 		t.Errorf("Wrong value returned from from LastInsertId(): %d", id4)
 	}
 }
+
+func TestIsCachedPlanChange(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{&Error{Code: "0A000", Message: "cached plan must not change result type"}, true},
+		{&Error{Code: "0A000", Message: "translated", Routine: "RevalidateCachedQuery"}, true},
+		{&Error{Code: "0A000", Message: "something else"}, false},
+		{&Error{Code: "42P01", Message: "cached plan must not change result type"}, false},
+		{ErrNotSupported, false},
+	}
+
+	for _, tt := range tests {
+		if got := isCachedPlanChange(tt.err); got != tt.expected {
+			t.Errorf("isCachedPlanChange(%v) = %v, expected %v", tt.err, got, tt.expected)
+		}
+	}
+}
+
+func TestFlushStatements(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	c, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = c.ExecContext(context.Background(), "CREATE TEMP TABLE temp (a int)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := c.PrepareContext(context.Background(), "SELECT * FROM temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	countPrepared := func() (n int) {
+		err := c.QueryRowContext(context.Background(), "SELECT count(*) FROM pg_prepared_statements").Scan(&n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := countPrepared(); n != 1 {
+		t.Fatalf("expected 1 prepared statement, not %d", n)
+	}
+
+	if err := FlushStatements(c); err != nil {
+		t.Fatal(err)
+	}
+	if n := countPrepared(); n != 0 {
+		t.Fatalf("expected no prepared statements after flushing, not %d", n)
+	}
+
+	// the flushed statement is prepared again, against the new schema
+	_, err = c.ExecContext(context.Background(), "ALTER TABLE temp ADD COLUMN b int")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := st.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cols, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cols) != 2 {
+		t.Errorf("expected 2 columns, got %v", cols)
+	}
+}
+
+func TestStatementNamePrefix(t *testing.T) {
+	cn := &conn{stmtNamePrefix: "app_"}
+	if name := cn.gname(); name != "app_1" {
+		t.Errorf("expected app_1, got %q", name)
+	}
+
+	long := strings.Repeat("x", maxStmtNamePrefixLen+1)
+	for _, prefix := range []string{long, "a\x00b"} {
+		_, err := open(context.Background(), "host=127.0.0.1 port=1 statement_name_prefix='"+prefix+"'", nil)
+		if err == nil || !strings.Contains(err.Error(), "invalid statement_name_prefix") {
+			t.Errorf("expected an invalid statement_name_prefix error, got %v", err)
+		}
+	}
+}
+
+// Does not access database, simply tests that an error closing a statement
+// leaves the connection ready for the next query
+func TestStmtCloseError(t *testing.T) {
+	cn := fakeConn(errorResponse("XX000", "")+"Z\x00\x00\x00\x05I", 0)
+	st := &stmt{cn: cn, name: "1"}
+	cn.stmts = map[string]*stmt{st.name: st}
+	if err := st.Close(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if st.closed || cn.stmts[st.name] == nil {
+		t.Error("expected the statement to stay open")
+	}
+	if cn.bad != nil || cn.txnStatus != TxnStatusIdle {
+		t.Errorf("expected the connection to be ready, got %v, %v", cn.bad, cn.txnStatus)
+	}
+}
+
+func TestStmtCloseDeallocates(t *testing.T) {
+	db, err := openTestConnConninfo("user=pqgotest password=pqgotest statement_name_prefix=pqtest_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	c, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	countPrepared := func() (n int) {
+		err := c.QueryRowContext(context.Background(), "SELECT count(*) FROM pg_prepared_statements WHERE name LIKE 'pqtest\\_%'").Scan(&n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	for i := 0; i < 10; i++ {
+		st, err := c.PrepareContext(context.Background(), "SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := countPrepared(); n != 1 {
+			t.Fatalf("expected 1 prepared statement, not %d", n)
+		}
+		if err := st.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countPrepared(); n != 0 {
+		t.Errorf("expected no prepared statements after closing them, not %d", n)
+	}
+}
+
+func TestDiscardAllMarksStatementsStale(t *testing.T) {
+	for _, tag := range []string{"DISCARD ALL", "DEALLOCATE ALL"} {
+		complete := "C\x00\x00\x00" + string(rune(len(tag)+5)) + tag + "\x00"
+		cn := fakeConn(complete+"Z\x00\x00\x00\x05I", 0)
+		st := &stmt{cn: cn, name: "1"}
+		cn.stmts = map[string]*stmt{st.name: st}
+		if _, _, err := cn.simpleExec(tag); err != nil {
+			t.Fatal(err)
+		}
+		if !st.stale {
+			t.Errorf("%s: expected the statement to be stale", tag)
+		}
+	}
+
+	cn := fakeConn("C\x00\x00\x00\x08SET\x00Z\x00\x00\x00\x05I", 0)
+	st := &stmt{cn: cn, name: "1"}
+	cn.stmts = map[string]*stmt{st.name: st}
+	if _, _, err := cn.simpleExec("SET work_mem TO '4MB'"); err != nil {
+		t.Fatal(err)
+	}
+	if st.stale {
+		t.Error("SET: expected the statement not to be stale")
+	}
+}
+
+func TestDiscardOnReset(t *testing.T) {
+	db, err := openTestConnConninfo("user=pqgotest password=pqgotest discard_on_reset=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	st, err := db.Prepare("SELECT $1::int")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	// each use of the statement takes the connection from the pool, which
+	// deallocates it on the server; it is prepared again every time
+	for i := 0; i < 3; i++ {
+		var n int
+		if err := st.QueryRow(i).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != i {
+			t.Errorf("expected %d, got %d", i, n)
+		}
+	}
+
+	if _, err := db.Exec("CREATE TEMP TABLE discarded (a int)"); err != nil {
+		t.Fatal(err)
+	}
+	var exists bool
+	if err := db.QueryRow("SELECT to_regclass('pg_temp.discarded') IS NOT NULL").Scan(&exists); err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("expected the temporary table to be dropped when the connection was reused")
+	}
+}
+
+func TestStaleStatementIsPrepared(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	c, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = c.ExecContext(context.Background(), "CREATE TEMP TABLE temp (a int)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := c.PrepareContext(context.Background(), "SELECT * FROM temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	_, err = c.ExecContext(context.Background(), "ALTER TABLE temp ADD COLUMN b int")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the statement is prepared again and the query retried
+	rows, err := st.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cols, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cols) != 2 {
+		t.Errorf("expected 2 columns, got %v", cols)
+	}
+
+	// in a transaction, the error has aborted it, so there's no retry
+	tx, err := c.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("ALTER TABLE temp ADD COLUMN c int")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err = tx.Stmt(st).Query()
+	if err == nil {
+		rows.Close()
+		t.Fatal("expected a cached plan error")
+	}
+	if !isCachedPlanChange(err) {
+		t.Fatalf("expected a cached plan error, got %v", err)
+	}
+}
+
+func TestWithParameterTypes(t *testing.T) {
+	if types := parameterTypes(context.Background()); types != nil {
+		t.Fatalf("expected no parameter types, got %v", types)
+	}
+
+	db := openTestConn(t)
+	defer db.Close()
+
+	// without a type, the server can't tell what $1 is
+	if _, err := db.Prepare("SELECT $1"); err == nil {
+		t.Fatal("expected an error preparing an untyped parameter")
+	}
+
+	// the second parameter's type is still inferred
+	ctx := WithParameterTypes(context.Background(), oid.T_int8, 0)
+	st, err := db.PrepareContext(ctx, "SELECT $1, $2::text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	var n int64
+	var s string
+	err = st.QueryRow(42, "x").Scan(&n, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 42 || s != "x" {
+		t.Errorf("unexpected result %d, %q", n, s)
+	}
+}
+
+// Does not access database, simply tests the handling of the description
+func TestPrepareDescriptionOrder(t *testing.T) {
+	const (
+		parseComplete = "1\x00\x00\x00\x04"
+		paramDesc     = "t\x00\x00\x00\x0a\x00\x01\x00\x00\x00\x17"
+		noData        = "n\x00\x00\x00\x04"
+		ready         = "Z\x00\x00\x00\x05I"
+	)
+
+	cn := fakeConn(parseComplete+noData+paramDesc+ready, 0)
+	st, err := cn.prepareToSimpleStmt("CALL p($1)", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.paramTyps) != 1 || st.paramTyps[0] != oid.T_int4 || st.cols != nil {
+		t.Errorf("unexpected description %v, %v", st.paramTyps, st.cols)
+	}
+
+	cn = fakeConn(parseComplete+paramDesc+ready, 0)
+	if _, err := cn.prepareToSimpleStmt("CALL p($1)", "", nil); err == nil {
+		t.Error("expected an error for an incomplete description")
+	}
+}
+
+// Does not access database, simply tests that NumInput is the number of
+// parameters the server describes
+func TestNumInputFromDescription(t *testing.T) {
+	const (
+		parseComplete = "1\x00\x00\x00\x04"
+		paramDesc     = "t\x00\x00\x00\x12\x00\x03\x00\x00\x00\x17\x00\x00\x00\x19\x00\x00\x00\x17"
+		noData        = "n\x00\x00\x00\x04"
+		ready         = "Z\x00\x00\x00\x05I"
+	)
+
+	cn := fakeConn(parseComplete+paramDesc+noData+ready, 0)
+	st, err := cn.prepareToSimpleStmt("SELECT $3::int, $1::int, $2::text", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := st.NumInput(); n != 3 {
+		t.Errorf("expected 3 inputs, got %d", n)
+	}
+}
+
+func TestNumInput(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	tests := []struct {
+		query    string
+		numInput int
+	}{
+		{"SELECT 1", 0},
+		{"SELECT $2::int, $1::int, $2::int + 1", 2},
+		{"SELECT $1::text, '$2', \"$3\" FROM (SELECT 1 AS \"$3\") s", 1},
+		{"SELECT $1::int, $$ $2 $3 $$, $body$ $4 $body$ -- $5\n/* $6 */", 1},
+	}
+	for _, tt := range tests {
+		st, err := db.Prepare(tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		args := make([]interface{}, tt.numInput)
+		for i := range args {
+			args[i] = i
+		}
+		// database/sql checks the number of arguments against NumInput
+		rows, err := st.Query(args...)
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+		} else {
+			rows.Close()
+		}
+		if _, err := st.Query(append(args, 0)...); err == nil {
+			t.Errorf("%s: expected an error for too many arguments", tt.query)
+		}
+		st.Close()
+	}
+
+	// the server can't tell the type of a skipped parameter
+	_, err := db.Prepare("SELECT $1::int, $3::int")
+	if pqErr, ok := err.(*Error); !ok || pqErr.Code != ErrCodeIndeterminateDatatype {
+		t.Errorf("expected an indeterminate datatype error, got %v", err)
+	}
+}
+
+func TestPrepareCall(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("CREATE PROCEDURE pg_temp.pqgotest_proc(a int) LANGUAGE sql AS $$ SELECT a $$")
+	if err != nil {
+		t.Skipf("server does not support procedures: %v", err)
+	}
+
+	st, err := tx.Prepare("CALL pg_temp.pqgotest_proc($1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	if _, err := st.Exec(42); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Does not access database, simply tests the counting
+// All the rows of a statement are read through Next, including the first,
+// which exec reads ahead of them.
+func TestQueryReturnsAllRows(t *testing.T) {
+	cn := fakeConn("2\x00\x00\x00\x04"+
+		"D\x00\x00\x00\x0b\x00\x01\x00\x00\x00\x011"+
+		"D\x00\x00\x00\x0b\x00\x01\x00\x00\x00\x012"+
+		"D\x00\x00\x00\x0b\x00\x01\x00\x00\x00\x013"+
+		"C\x00\x00\x00\x0fINSERT 0 3\x00"+
+		"Z\x00\x00\x00\x05I", 0)
+	st := &stmt{cn: cn, query: "INSERT INTO t VALUES (1), (2), (3) RETURNING id", cols: []string{"id"}, rowTyps: []oid.Oid{oid.T_int4}}
+
+	rs, err := st.Query(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	dest := make([]driver.Value, 1)
+	for {
+		err := rs.Next(dest)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, dest[0].(int64))
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 2, 3}) {
+		t.Errorf("expected ids [1 2 3], got %v", ids)
+	}
+}
+
+func TestBinaryResultFormats(t *testing.T) {
+	cn := fakeConn("D\x00\x00\x00\x14\x00\x02\x00\x00\x00\x04\x00\x00\x00\x2a\x00\x00\x00\x0242", 0)
+	st := &stmt{cn: cn, rowTyps: []oid.Oid{oid.T_int4, oid.T_numeric}}
+
+	w := writeBuf{}
+	st.writeResultFormats(&w)
+	if string(w) != "\x00\x00" || st.rowFmts != nil {
+		t.Errorf("expected no format codes without binary_results, got %q", []byte(w))
+	}
+
+	cn.binaryResults = true
+	w = writeBuf{}
+	st.writeResultFormats(&w)
+	if string(w) != "\x00\x02\x00\x01\x00\x00" {
+		t.Errorf("unexpected format codes %q", []byte(w))
+	}
+
+	// the int4 is in binary and the numeric in text
+	_, r := cn.recv1()
+	dest := make([]driver.Value, 2)
+	st.parseDataRow(r, dest)
+	if dest[0] != int64(42) || string(dest[1].([]byte)) != "42" {
+		t.Errorf("unexpected row %v", dest)
+	}
+
+	// without columns that have a binary decoder, all are text
+	st.rowTyps = []oid.Oid{oid.T_numeric, oid.T_text}
+	w = writeBuf{}
+	st.writeResultFormats(&w)
+	if string(w) != "\x00\x00" || st.rowFmts != nil {
+		t.Errorf("expected no format codes, got %q", []byte(w))
+	}
+}
+
+func TestBinaryParameterFormats(t *testing.T) {
+	st := &stmt{cn: &conn{}, paramTyps: []oid.Oid{oid.T_int4, oid.T_text, oid.T_int2}}
+	v := []driver.Value{int64(1), "x", int64(1 << 20)}
+	if fmts, _ := st.paramFormats(v); fmts != nil {
+		t.Errorf("expected no format codes without binary_parameters, got %v", fmts)
+	}
+
+	// the int2 doesn't fit, so it goes as text for the server to reject
+	st.cn.binaryParameters = true
+	fmts, binaryValues := st.paramFormats(v)
+	if !reflect.DeepEqual(fmts, []int{formatBinary, formatText, formatText}) {
+		t.Errorf("unexpected format codes %v", fmts)
+	}
+	if !reflect.DeepEqual(binaryValues, [][]byte{{0, 0, 0, 1}, nil, nil}) {
+		t.Errorf("unexpected binary values %v", binaryValues)
+	}
+	if fmts, _ := st.paramFormats([]driver.Value{"1", "x", nil}); fmts != nil {
+		t.Errorf("expected no format codes, got %v", fmts)
+	}
+}
+
+func TestBinaryResults(t *testing.T) {
+	db, err := openTestConnConninfo("user=pqgotest password=pqgotest binary_results=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var (
+		b       []byte
+		ok      bool
+		i2, i8  int64
+		f4, f8  float64
+		n, text string
+		null    sql.NullInt64
+	)
+	err = db.QueryRow("SELECT $1::bytea, true, -2::int2, $2::int8, 1.5::float4, 0.1::float8, 1.5::numeric, 'x'::text, NULL::int4",
+		[]byte{0, 1, 0xff}, int64(math.MinInt64)).Scan(&b, &ok, &i2, &i8, &f4, &f8, &n, &text, &null)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, []byte{0, 1, 0xff}) || !ok || i2 != -2 || i8 != math.MinInt64 ||
+		f4 != 1.5 || f8 != 0.1 || n != "1.5" || text != "x" || null.Valid {
+		t.Errorf("unexpected values %v %v %v %v %v %v %v %v %v", b, ok, i2, i8, f4, f8, n, text, null)
+	}
+}
+
+func TestRowsCloseError(t *testing.T) {
+	// the query is cancelled while the rows are being drained
+	const dataRow = "D\x00\x00\x00\x0b\x00\x01\x00\x00\x00\x011"
+	cn := fakeConn(dataRow+dataRow+errorResponse("57014", "")+"Z\x00\x00\x00\x05I", 0)
+	st := &stmt{cn: cn, query: "SELECT 1", cols: []string{"a"}, rowTyps: []oid.Oid{oid.T_int4}}
+	rs := &rows{st: st}
+	if err := rs.Next(make([]driver.Value, 1)); err != nil {
+		t.Fatal(err)
+	}
+	err := rs.Close()
+	if e, ok := err.(*Error); !ok || e.Code != "57014" {
+		t.Fatalf("expected a query_canceled error, got %v", err)
+	}
+	if err := rs.Next(make([]driver.Value, 1)); err != st.lasterr || err == nil {
+		t.Errorf("expected Next to return the error, got %v", err)
+	}
+	if !cn.IsValid() {
+		t.Error("expected the connection to be ready for its next query")
+	}
+
+	// rows that can't be read to the end leave the connection out of step
+	cn = fakeConn(dataRow+"?\x00\x00\x00\x04", 0)
+	rs = &rows{st: &stmt{cn: cn, query: "SELECT 1", cols: []string{"a"}, rowTyps: []oid.Oid{oid.T_int4}}}
+	if err := rs.Close(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if cn.IsValid() {
+		t.Error("expected the connection to be discarded")
+	}
+}
+
+func TestInsertReturningRows(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("CREATE TEMP TABLE items (id serial PRIMARY KEY, name text)"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]interface{}{nil, {"a", "b", "c"}} {
+		q := "INSERT INTO items (name) VALUES ('a'), ('b'), ('c') RETURNING id"
+		if args != nil {
+			q = "INSERT INTO items (name) VALUES ($1), ($2), ($3) RETURNING id"
+		}
+		rows, err := tx.Query(q, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 3 || ids[1] != ids[0]+1 || ids[2] != ids[1]+1 {
+			t.Errorf("%d parameters: expected 3 consecutive ids, got %v", len(args), ids)
+		}
+	}
+}
+
+func TestCallProcedure(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	if getServerVersion(t, db) < 110000 {
+		// procedures are new in 11
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`CREATE PROCEDURE pg_temp.scale(INOUT n int, factor int)
+		LANGUAGE plpgsql AS $$ BEGIN n := n * factor; END $$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]interface{}{nil, {21, 2}} {
+		q := "CALL pg_temp.scale(21, 2)"
+		if args != nil {
+			q = "CALL pg_temp.scale($1, $2)"
+		}
+		var n int
+		if err := tx.QueryRow(q, args...).Scan(&n); err != nil {
+			t.Fatalf("%d parameters: %v", len(args), err)
+		}
+		if n != 42 {
+			t.Errorf("%d parameters: expected 42, got %d", len(args), n)
+		}
+	}
+
+	// a procedure without OUT parameters returns no rows
+	if _, err := tx.Exec("CREATE PROCEDURE pg_temp.noop() LANGUAGE sql AS 'SELECT 1'"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := tx.Exec("CALL pg_temp.noop()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 0 {
+		t.Errorf("expected no rows affected, got %d", n)
+	}
+}
+
+func TestColumnTypeScanType(t *testing.T) {
+	typs := []oid.Oid{oid.T_int4, oid.T_varchar, oid.T_timestamptz, oid.T_bool, oid.T_float8, oid.T_text,
+		oid.T_interval, oid.T_bytea, oid.T_numeric, oid.T__int4, oid.T_void, oid.Oid(16384)}
+	expected := []reflect.Type{
+		reflect.TypeOf(sql.NullInt64{}),
+		reflect.TypeOf(sql.NullString{}),
+		reflect.TypeOf(NullTime{}),
+		reflect.TypeOf(sql.NullBool{}),
+		reflect.TypeOf(sql.NullFloat64{}),
+		reflect.TypeOf(sql.NullString{}),
+		reflect.TypeOf(&Interval{}),
+		reflect.TypeOf([]byte{}),
+		reflect.TypeOf(new(string)),
+		reflect.TypeOf((*interface{})(nil)).Elem(),
+		reflect.TypeOf((*interface{})(nil)).Elem(),
+		reflect.TypeOf([]byte{}),
+	}
+	rs := &rows{st: &stmt{cn: &conn{}, rowTyps: typs}}
+	for i, typ := range typs {
+		if got := rs.ColumnTypeScanType(i); got != expected[i] {
+			t.Errorf("%d: expected %v, got %v", typ, expected[i], got)
+		}
+	}
+
+	// with text_as_string, or an unknown type decoder, unknown types are
+	// whatever they decode to
+	rs.st.cn.parameterStatus.textAsString = true
+	if got := rs.ColumnTypeScanType(len(typs) - 1); got != reflect.TypeOf(sql.NullString{}) {
+		t.Errorf("expected sql.NullString with text_as_string, got %v", got)
+	}
+	rs.st.cn.parameterStatus.textAsString = false
+	SetUnknownTypeDecoder(func(o oid.Oid, raw []byte) (interface{}, error) { return raw, nil })
+	defer SetUnknownTypeDecoder(nil)
+	if got := rs.ColumnTypeScanType(len(typs) - 1); got != reflect.TypeOf((*interface{})(nil)).Elem() {
+		t.Errorf("expected interface{} with an unknown type decoder, got %v", got)
+	}
+}
+
+func TestColumnTypeScanTypeFromDb(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	rows, err := db.Query("SELECT 1::int4, NULL::varchar, now(), NULL::int4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := make([]interface{}, len(cols))
+	for i, col := range cols {
+		dest[i] = reflect.New(col.ScanType()).Interface()
+	}
+	if !rows.Next() {
+		t.Fatal("expected a row")
+	}
+	if err := rows.Scan(dest...); err != nil {
+		t.Fatal(err)
+	}
+	if n := dest[0].(*sql.NullInt64); !n.Valid || n.Int64 != 1 {
+		t.Errorf("unexpected int4 %v", n)
+	}
+	if s := dest[1].(*sql.NullString); s.Valid {
+		t.Errorf("unexpected varchar %v", s)
+	}
+	if tm := dest[2].(*NullTime); !tm.Valid {
+		t.Errorf("unexpected timestamptz %v", tm)
+	}
+	if n := dest[3].(*sql.NullInt64); n.Valid {
+		t.Errorf("unexpected int4 %v", n)
+	}
+}
+
+func TestRowsStats(t *testing.T) {
+	const dataRow = "D\x00\x00\x00\x0b\x00\x01\x00\x00\x00\x011"
+	cn := fakeConn(dataRow+dataRow+"C\x00\x00\x00\x0dSELECT 2\x00"+"Z\x00\x00\x00\x05I", 0)
+	var doneQuery string
+	var doneStats QueryStats
+	cn.hooks = &Hooks{OnRowsDone: func(q string, stats QueryStats) { doneQuery, doneStats = q, stats }}
+
+	st := &stmt{cn: cn, query: "SELECT 1", cols: []string{"a"}, rowTyps: []oid.Oid{oid.T_int4}}
+	rs := &rows{st: st}
+	dest := make([]driver.Value, 1)
+	if err := rs.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if stats := rs.Stats(); stats != (QueryStats{Rows: 1, Bytes: 12}) {
+		t.Errorf("unexpected stats after a row: %+v", stats)
+	}
+	if err := rs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := QueryStats{Rows: 2, Bytes: 44}
+	if stats := rs.Stats(); stats != expected {
+		t.Errorf("expected %+v, got %+v", expected, stats)
+	}
+	if doneQuery != "SELECT 1" || doneStats != expected {
+		t.Errorf("unexpected OnRowsDone call with %q, %+v", doneQuery, doneStats)
+	}
+}
+
+func TestRawValue(t *testing.T) {
+	const dataRow = "D\x00\x00\x00\x11\x00\x02\x00\x00\x00\x03abc\xff\xff\xff\xff"
+	cn := fakeConn(dataRow+"C\x00\x00\x00\x0dSELECT 1\x00"+"Z\x00\x00\x00\x05I", 0)
+	cn.keepRawValues = true
+
+	st := &stmt{cn: cn, query: "SELECT 1", cols: []string{"a", "b"}, rowTyps: []oid.Oid{oid.T_text, oid.T_text}}
+	var rs Rows = &rows{st: st}
+	dest := make([]driver.Value, 2)
+	if err := rs.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if raw := rs.RawValue(0); string(raw) != "abc" {
+		t.Errorf("unexpected raw value %q", raw)
+	}
+	if raw := rs.RawValue(1); raw != nil {
+		t.Errorf("expected nil for NULL, got %q", raw)
+	}
+	if raw := rs.RawValue(2); raw != nil {
+		t.Errorf("expected nil past the last column, got %q", raw)
+	}
+
+	// the raw values are copies, not the connection's buffer
+	raw := rs.RawValue(0)
+	if err := rs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "abc" {
+		t.Errorf("raw value changed to %q", raw)
+	}
+
+	cn = fakeConn(dataRow, 0)
+	rs = &rows{st: &stmt{cn: cn, cols: []string{"a", "b"}, rowTyps: []oid.Oid{oid.T_text, oid.T_text}}}
+	if err := rs.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if raw := rs.RawValue(0); raw != nil {
+		t.Errorf("expected nil without keep_raw_values, got %q", raw)
+	}
+}
@@ -34,6 +34,10 @@ type kvs []string
 func ParseURL(url string) (string, error) {
 	u, err := nurl.Parse(url)
 	if err != nil {
+		// url.Error echoes the whole input, password included
+		if uerr, ok := err.(*nurl.Error); ok {
+			uerr.URL = redactURL(uerr.URL)
+		}
 		return "", err
 	}
 
@@ -71,6 +75,30 @@ func ParseURL(url string) (string, error) {
 	return params.String(), nil
 }
 
+// redactURL masks the password in the userinfo part of a connection URL,
+// so the URL can safely be included in error messages and logs.  It works
+// on the raw string, since it is mostly needed for URLs that failed to parse.
+func redactURL(url string) string {
+	i := strings.Index(url, "://")
+	if i < 0 {
+		return url
+	}
+	rest := url[i+3:]
+	if j := strings.IndexAny(rest, "/?#"); j >= 0 {
+		rest = rest[:j]
+	}
+	at := strings.LastIndex(rest, "@")
+	if at < 0 {
+		return url
+	}
+	colon := strings.Index(rest[:at], ":")
+	if colon < 0 {
+		return url
+	}
+	start := i + 3 + colon + 1
+	return url[:start] + "xxxxx" + url[i+3+at:]
+}
+
 func (kvs *kvs) accrue(k string, v interface{}) {
 
 	if v != "" {
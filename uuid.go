@@ -0,0 +1,120 @@
+package pq
+
+import (
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// UUID is a uuid value.  It implements the sql.Scanner interface, taking a
+// uuid's text, and the driver.Valuer interface, sending its text in the
+// standard form, such as a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11.
+//
+// uuid values are read as strings, checked with ParseUUID, since other UUID
+// types, such as those of github.com/google/uuid, scan from them; scan them
+// into a UUID to have them parsed.
+type UUID [16]byte
+
+// ParseUUID parses the text of a uuid in any of the forms the server
+// accepts: 32 hexadecimal digits, in upper or lower case, optionally in
+// braces, with a hyphen optionally after any group of four digits.
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	text := s
+	if strings.HasPrefix(text, "{") && strings.HasSuffix(text, "}") {
+		text = text[1 : len(text)-1]
+	}
+	digits := make([]byte, 0, 32)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c == '-' && len(digits) > 0 && len(digits)%4 == 0 && i+1 < len(text) && text[i+1] != '-' {
+			continue
+		}
+		digits = append(digits, c)
+	}
+	if len(digits) != 32 {
+		return u, fmt.Errorf("pq: invalid UUID %q", s)
+	}
+	if _, err := hex.Decode(u[:], digits); err != nil {
+		return u, fmt.Errorf("pq: invalid UUID %q", s)
+	}
+	return u, nil
+}
+
+// String returns u in the standard form.
+func (u UUID) String() string {
+	b := make([]byte, 36)
+	hex.Encode(b[0:8], u[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+	return string(b)
+}
+
+// Scan implements the sql.Scanner interface.  NULL can't be scanned into a
+// UUID; use a NullUUID.
+func (u *UUID) Scan(src interface{}) error {
+	var text string
+	switch src := src.(type) {
+	case []byte:
+		text = string(src)
+	case string:
+		text = src
+	case nil:
+		return fmt.Errorf("pq: cannot scan NULL into a UUID; use a NullUUID")
+	default:
+		return fmt.Errorf("pq: cannot convert %T to a UUID", src)
+	}
+	parsed, err := ParseUUID(text)
+	if err != nil {
+		return err
+	}
+	*u = parsed
+	return nil
+}
+
+// Value implements the driver.Valuer interface.
+func (u UUID) Value() (driver.Value, error) {
+	return u.String(), nil
+}
+
+// NullUUID represents a UUID that may be null. NullUUID implements the
+// sql.Scanner interface so it can be used as a scan destination, similar to
+// sql.NullString.
+type NullUUID struct {
+	UUID  UUID
+	Valid bool // Valid is true if UUID is not NULL
+}
+
+// Scan implements the sql.Scanner interface.
+func (nu *NullUUID) Scan(src interface{}) error {
+	if src == nil {
+		nu.UUID, nu.Valid = UUID{}, false
+		return nil
+	}
+	nu.Valid = true
+	return nu.UUID.Scan(src)
+}
+
+// Value implements the driver.Valuer interface.
+func (nu NullUUID) Value() (driver.Value, error) {
+	if !nu.Valid {
+		return nil, nil
+	}
+	return nu.UUID.Value()
+}
+
+// uuidValue returns the text of a [16]byte parameter, as a uuid.  ok is
+// false for values of other types.
+func uuidValue(x interface{}) (v driver.Value, ok bool, err error) {
+	if b, ok := x.([16]byte); ok {
+		return UUID(b).String(), true, nil
+	}
+	return nil, false, nil
+}
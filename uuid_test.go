@@ -0,0 +1,155 @@
+package pq
+
+import (
+	"database/sql/driver"
+	"github.com/gregb/pq/oid"
+	"reflect"
+	"testing"
+)
+
+var testUUID = UUID{0xa0, 0xee, 0xbc, 0x99, 0x9c, 0x0b, 0x4e, 0xf8, 0xbb, 0x6d, 0x6b, 0xb9, 0xbd, 0x38, 0x0a, 0x11}
+
+func TestParseUUID(t *testing.T) {
+	for _, s := range []string{
+		"a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11",
+		"A0EEBC99-9C0B-4EF8-BB6D-6BB9BD380A11",
+		"{a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11}",
+		"a0eebc999c0b4ef8bb6d6bb9bd380a11",
+		"a0ee-bc99-9c0b-4ef8-bb6d-6bb9-bd38-0a11",
+		"{a0eebc99-9c0b4ef8-bb6d6bb9-bd380a11}",
+	} {
+		u, err := ParseUUID(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if u != testUUID {
+			t.Errorf("%s: expected %v, got %v", s, testUUID, u)
+		}
+	}
+
+	for _, s := range []string{
+		"",
+		"a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a1",
+		"a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a111",
+		"a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a1g",
+		"-a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11",
+		"a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11-",
+		"a0eebc99--9c0b-4ef8-bb6d-6bb9bd380a11",
+		"a0eeb-c99-9c0b-4ef8-bb6d-6bb9bd380a11",
+		"{a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11",
+	} {
+		if _, err := ParseUUID(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestUUIDScanValue(t *testing.T) {
+	if s := testUUID.String(); s != "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11" {
+		t.Errorf("unexpected text %s", s)
+	}
+	if v, err := testUUID.Value(); err != nil || v != "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11" {
+		t.Errorf("unexpected value %v, %v", v, err)
+	}
+
+	for _, src := range []interface{}{[]byte("a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"), "A0EEBC999C0B4EF8BB6D6BB9BD380A11"} {
+		var u UUID
+		if err := u.Scan(src); err != nil || u != testUUID {
+			t.Errorf("%v: unexpected result %v, %v", src, u, err)
+		}
+	}
+	var u UUID
+	for _, src := range []interface{}{nil, []byte("abc"), int64(1)} {
+		if err := u.Scan(src); err == nil {
+			t.Errorf("%v: expected an error", src)
+		}
+	}
+}
+
+func TestNullUUID(t *testing.T) {
+	nu := NullUUID{UUID: testUUID, Valid: true}
+	if err := nu.Scan(nil); err != nil || nu.Valid || nu.UUID != (UUID{}) {
+		t.Errorf("unexpected result %+v, %v", nu, err)
+	}
+	if v, err := nu.Value(); v != nil || err != nil {
+		t.Errorf("unexpected value %v, %v", v, err)
+	}
+	if err := nu.Scan([]byte("a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11")); err != nil || !nu.Valid || nu.UUID != testUUID {
+		t.Errorf("unexpected result %+v, %v", nu, err)
+	}
+}
+
+func TestUUIDCheckNamedValue(t *testing.T) {
+	cn := &conn{}
+	nv := &driver.NamedValue{Ordinal: 1, Value: [16]byte(testUUID)}
+	if err := cn.CheckNamedValue(nv); err != nil || nv.Value != "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11" {
+		t.Errorf("unexpected result %v, %v", nv.Value, err)
+	}
+}
+
+// Does not access database, simply tests the decoder
+func TestDecodeUUID(t *testing.T) {
+	v := decode(&parameterStatus{}, []byte("a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"), oid.T_uuid)
+	if v != "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11" {
+		t.Errorf("expected the uuid as a string, got %#v", v)
+	}
+
+	v, err := DecodeArray([]byte("{a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11}"), oid.T__uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"}; !reflect.DeepEqual(v, expected) {
+		t.Errorf("expected %v, got %#v", expected, v)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic for an invalid uuid")
+			}
+		}()
+		decode(&parameterStatus{}, []byte("not-a-uuid"), oid.T_uuid)
+	}()
+}
+
+func TestUUIDRoundtrip(t *testing.T) {
+	db := openTestConn(t)
+	defer db.Close()
+
+	var u, fromArray UUID
+	var null NullUUID
+	err := db.QueryRow("SELECT $1::uuid, $2::uuid, $3::uuid", testUUID, [16]byte(testUUID), NullUUID{}).Scan(&u, &fromArray, &null)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != testUUID || fromArray != testUUID {
+		t.Errorf("expected %v, got %v and %v", testUUID, u, fromArray)
+	}
+	if null.Valid {
+		t.Errorf("expected NULL, got %v", null.UUID)
+	}
+
+	// uuid values are read as strings, so they scan into a string too
+	var s string
+	var id UUID
+	var valid NullUUID
+	err = db.QueryRow("SELECT 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11'::uuid, $1::uuid, $1::uuid", testUUID).Scan(&s, &id, &valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != testUUID.String() {
+		t.Errorf("expected %v, got %s", testUUID, s)
+	}
+	if id != testUUID || !valid.Valid || valid.UUID != testUUID {
+		t.Errorf("expected %v, got %v and %v", testUUID, id, valid)
+	}
+
+	var raw interface{}
+	if err := db.QueryRow("SELECT $1::uuid", testUUID).Scan(&raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw != testUUID.String() {
+		t.Errorf("expected the uuid as a string, got %#v", raw)
+	}
+}